@@ -0,0 +1,71 @@
+package buyte
+
+import (
+	"net/url"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+)
+
+// Payment agreement types. Apple Pay payment requests are one-off unless they request one of these.
+const (
+	PAYMENT_AGREEMENT_RECURRING        = "recurring"
+	PAYMENT_AGREEMENT_DEFERRED         = "deferred"
+	PAYMENT_AGREEMENT_AUTOMATIC_RELOAD = "automaticReload"
+)
+
+// The Apple Pay payment request member for each agreement type.
+var paymentAgreementRequests = map[string]string{
+	"recurringPaymentRequest":       PAYMENT_AGREEMENT_RECURRING,
+	"deferredPaymentRequest":        PAYMENT_AGREEMENT_DEFERRED,
+	"automaticReloadPaymentRequest": PAYMENT_AGREEMENT_AUTOMATIC_RELOAD,
+}
+
+// PaymentAgreement is what the customer agreed to when authorizing a payment the merchant may charge again later.
+// ie. subscriptions, payments deferred until a booking, or balance top ups.
+type PaymentAgreement struct {
+	Type                 string `json:"type"`
+	Description          string `json:"description,omitempty"`
+	BillingAgreement     string `json:"billingAgreement,omitempty"`
+	ManagementURL        string `json:"managementURL,omitempty" mapstructure:"managementURL"`
+	TokenNotificationURL string `json:"tokenNotificationURL,omitempty" mapstructure:"tokenNotificationURL"`
+	// The merchant token (MPAN) issued for the agreement. Only known once the payment data has been decrypted.
+	MerchantTokenIdentifier string `json:"merchantTokenIdentifier,omitempty"`
+}
+
+// NewPaymentAgreement reads the payment agreement from an Apple Pay payment request.
+// Returns nil for one-off payments.
+func NewPaymentAgreement(rawPaymentRequest map[string]interface{}) (*PaymentAgreement, error) {
+	var agreement *PaymentAgreement
+	for member, agreementType := range paymentAgreementRequests {
+		request, ok := rawPaymentRequest[member].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if agreement != nil {
+			return nil, errors.New("Payment request may only contain one of recurringPaymentRequest, deferredPaymentRequest or automaticReloadPaymentRequest")
+		}
+		agreement = &PaymentAgreement{Type: agreementType}
+		if description, ok := request["paymentDescription"].(string); ok {
+			agreement.Description = description
+		}
+		if err := mapstructure.Decode(request, agreement); err != nil {
+			return nil, errors.Wrap(err, "Could not read "+member)
+		}
+		// Apple requires a page where the customer can manage the agreement.
+		if u, err := url.Parse(agreement.ManagementURL); err != nil || u.Scheme != "https" || u.Host == "" {
+			return nil, errors.New(member + " requires an HTTPS managementURL")
+		}
+		if agreement.TokenNotificationURL != "" {
+			if u, err := url.Parse(agreement.TokenNotificationURL); err != nil || u.Scheme != "https" || u.Host == "" {
+				return nil, errors.New(member + " tokenNotificationURL must be HTTPS")
+			}
+		}
+	}
+	return agreement, nil
+}
+
+// IsMerchantInitiated checks whether a charge was made by the merchant against a payment agreement, without the customer present.
+func (c *Charge) IsMerchantInitiated() bool {
+	return c.InitialCharge != ""
+}
@@ -0,0 +1,68 @@
+package buyte
+
+import "context"
+
+type ApplePayMerchantStore interface {
+	GetApplePayMerchant(context.Context, string) (*ApplePayMerchant, error)
+	// Gets one of the user's Apple Pay identities by id.
+	GetUserApplePayMerchant(context.Context, string) (*ApplePayMerchant, error)
+}
+
+// ApplePayMerchant is a merchant owned Apple Pay identity, used in place of Buyte's when set against a checkout or as the user's default.
+type ApplePayMerchant struct {
+	ID                 string   `json:"id"`
+	MerchantIdentifier string   `json:"merchantIdentifier"`
+	DisplayName        string   `json:"displayName"`
+	Domains            []string `json:"domains"`
+	// Name of the secret holding the Merchant Identity and Payment Processing certificates.
+	CertificatesSecret string `json:"certificatesSecret"`
+	IsDefault          bool   `json:"isDefault"`
+}
+
+// ApplePayMerchantCertificates is the format of the secret holding an Apple Pay merchant's certificates, as PEM.
+// Several processing certificates may be held while they are rotated.
+type ApplePayMerchantCertificates struct {
+	MerchantCertificate    string                       `json:"merchantCertificate"`
+	MerchantKey            string                       `json:"merchantKey"`
+	ProcessingCertificates []ApplePayCertificateKeyPair `json:"processingCertificates"`
+}
+type ApplePayCertificateKeyPair struct {
+	Certificate string `json:"certificate"`
+	Key         string `json:"key"`
+}
+
+type ApplePayDomainStore interface {
+	// Creates a domain, unless it is already registered by any merchant.
+	CreateApplePayDomain(context.Context, *CreateApplePayDomainInput) (*ApplePayDomain, error)
+	// Gets a domain as registered by any merchant.
+	GetApplePayDomain(context.Context, string) (*ApplePayDomain, error)
+	// Gets a domain as registered by the user.
+	GetUserApplePayDomain(context.Context, string) (*ApplePayDomain, error)
+}
+
+// Statuses of an Apple Pay domain.
+const (
+	// Registered with Apple against Buyte's Apple Pay identity.
+	APPLE_PAY_DOMAIN_REGISTERED = "registered"
+	// Registered by the merchant against their own Apple Pay identity. Buyte hosts their association file.
+	APPLE_PAY_DOMAIN_HOSTED = "hosted"
+)
+
+// ApplePayDomain is a merchant storefront domain Apple Pay is presented on.
+type ApplePayDomain struct {
+	ID                 string `json:"id"`
+	Object             string `json:"object"`
+	Domain             string `json:"domain"`
+	Status             string `json:"status"`
+	ApplePayMerchantId string `json:"applePayMerchantId,omitempty"`
+	// Contents of the domain association file served for the domain. Only set for merchant owned identities.
+	AssociationFile string `json:"-"`
+}
+
+type CreateApplePayDomainInput struct {
+	ID                 string `json:"id"`
+	Domain             string `json:"domain"`
+	Status             string `json:"status"`
+	ApplePayMerchantId string `json:"applePayDomainApplePayMerchantId,omitempty"`
+	AssociationFile    string `json:"associationFile,omitempty"`
+}
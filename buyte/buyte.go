@@ -11,6 +11,8 @@ type Store interface {
 	CheckoutStore
 	PaymentTokenStore
 	ChargeStore
+	ApplePayMerchantStore
+	ApplePayDomainStore
 }
 
 // Some Util
@@ -5,6 +5,9 @@ import (
 	"math"
 	"os"
 	"strconv"
+
+	"github.com/rsoury/buyte/pkg/shipping"
+	"github.com/rsoury/buyte/pkg/tax"
 )
 
 type ChargeStore interface {
@@ -22,6 +25,8 @@ type CreateChargeInput struct {
 	Description string                 `json:"description"`
 	Metadata    map[string]interface{} `json:"metadata"`
 	Order       ChargeOrder            `json:"order"`
+	// The charge that set up the source's payment agreement. Set when the merchant charges the agreement again without the customer present.
+	InitialCharge string `json:"initialCharge,omitempty"`
 }
 type ChargeOrder struct {
 	Reference string                   `json:"reference,omitempty"`
@@ -29,6 +34,10 @@ type ChargeOrder struct {
 	Items     []map[string]interface{} `json:"items,omitempty"`
 	Shipping  map[string]interface{}   `json:"shipping,omitempty"`
 	Customer  map[string]interface{}   `json:"customer,omitempty"`
+	// Amount of the order before shipping, as priced. Required to charge tax not included in prices.
+	Subtotal int `json:"subtotal,omitempty"`
+	// Tax of the order, as calculated when charged.
+	Tax *tax.Breakdown `json:"tax,omitempty"`
 }
 type ChargeSource struct {
 	ID                     string                        `json:"id"`
@@ -50,11 +59,21 @@ type Charge struct {
 	Customer       *Customer              `json:"customer"`
 	Metadata       map[string]interface{} `json:"metadata"`
 	Order          *ChargeOrder           `json:"order,omitempty"`
+	Agreement      *PaymentAgreement      `json:"agreement,omitempty"`
+	InitialCharge  string                 `json:"initialCharge,omitempty"`
 	CreatedAt      string                 `json:"createdAt"`
 }
 type GatewayCharge struct {
 	Reference string `json:"reference"`
 	Type      string `json:"type"`
+	// The provider connection the charge was processed through.
+	ConnectionId string `json:"connectionId,omitempty"`
+	// Credentials stored with the gateway by a charge setting up a payment agreement, used for later merchant initiated charges.
+	// ie. Stripe's customer and source, or Adyen's shopper reference and recurring detail reference.
+	CustomerReference      string `json:"customerReference,omitempty"`
+	PaymentMethodReference string `json:"paymentMethodReference,omitempty"`
+	// Set where the gateway was asked to authorise the payment without capturing it. ie. input.Capture is false
+	AuthoriseOnly bool `json:"-"`
 }
 
 // Represent request body to GraphQL API to create a charge
@@ -70,6 +89,8 @@ type CreateChargeParams struct {
 	ProviderCharge *GatewayCharge           `json:"providerCharge"`
 	Customer       *Customer                `json:"customer"`
 	Order          *CreateChargeOrderParams `json:"order,omitempty"`
+	Agreement      *PaymentAgreement        `json:"agreement,omitempty"`
+	InitialCharge  string                   `json:"initialCharge,omitempty"`
 	CreatedAt      string                   `json:"createdAt"`
 }
 type CreateChargeOrderParams struct {
@@ -78,6 +99,8 @@ type CreateChargeOrderParams struct {
 	Items     string `json:"items,omitempty"`
 	Shipping  string `json:"shipping,omitempty"`
 	Customer  string `json:"customer,omitempty"`
+	Subtotal  int    `json:"subtotal,omitempty"`
+	Tax       string `json:"tax,omitempty"`
 }
 
 func (c *CreateChargeInput) SetFee(feeMultiplier float64, region string) {
@@ -136,13 +159,16 @@ func (c *CreateChargeParams) SetMetadata(data interface{}) error {
 	return nil
 }
 
+// Charges are recorded as captured unless the gateway only authorised the payment.
 func (c *CreateChargeParams) SetProviderCharge(gc *GatewayCharge) {
 	c.ProviderCharge = gc
+	c.Captured = !gc.AuthoriseOnly
 }
 func (c *CreateChargeParams) SetOrder(co *ChargeOrder) error {
 	params := &CreateChargeOrderParams{
 		Reference: co.Reference,
 		Platform:  co.Platform,
+		Subtotal:  co.Subtotal,
 	}
 	if len(co.Items) > 0 {
 		err := params.SetItems(co.Items)
@@ -162,6 +188,12 @@ func (c *CreateChargeParams) SetOrder(co *ChargeOrder) error {
 			return err
 		}
 	}
+	if co.Tax != nil {
+		err := params.SetTax(co.Tax)
+		if err != nil {
+			return err
+		}
+	}
 	c.Order = params
 	return nil
 }
@@ -190,7 +222,43 @@ func (c *CreateChargeOrderParams) SetCustomer(data interface{}) error {
 	c.Customer = str
 	return nil
 }
+func (c *CreateChargeOrderParams) SetTax(data interface{}) error {
+	str, err := EnsureJSON(data)
+	if err != nil {
+		return err
+	}
+	c.Tax = str
+	return nil
+}
 
 func (co *ChargeOrder) AddItem(item map[string]interface{}) {
 	co.Items = append(co.Items, item)
 }
+
+// ShippingOrder is the order as shipped, for the subtotal given. Items may include their "quantity", and "weight" in grams.
+func (co *ChargeOrder) ShippingOrder(subtotal int) *shipping.Order {
+	order := &shipping.Order{
+		Subtotal: subtotal,
+	}
+	for _, item := range co.Items {
+		order.Items = append(order.Items, shipping.Item{
+			Quantity: intValue(item["quantity"]),
+			Weight:   intValue(item["weight"]),
+		})
+	}
+	return order
+}
+
+// Order items are decoded from JSON, so their numbers are float64, or strings where the platform sends them as such.
+func intValue(value interface{}) int {
+	switch v := value.(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	case string:
+		i, _ := strconv.Atoi(v)
+		return i
+	}
+	return 0
+}
@@ -0,0 +1,25 @@
+package buyte
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShippingOrder(t *testing.T) {
+	assert := assert.New(t)
+	order := &ChargeOrder{
+		Items: []map[string]interface{}{
+			{"name": "T-Shirt", "quantity": float64(2), "weight": float64(250)},
+			{"name": "Cap", "quantity": "1", "weight": "100"},
+			{"name": "Sticker"},
+		},
+	}
+	shippingOrder := order.ShippingOrder(4500)
+	assert.Equal(4500, shippingOrder.Subtotal)
+	assert.Equal(4, shippingOrder.ItemCount())
+	assert.Equal(600, shippingOrder.Weight())
+
+	method := &PaymentTokenShipping{ID: "standard", Rate: 1000, MinOrder: 5000}
+	assert.False(method.ShippingRate().Applies(shippingOrder), "Shipping methods only ship orders within their limits.")
+}
@@ -1,9 +1,15 @@
 package buyte
 
-import "context"
+import (
+	"context"
+
+	"github.com/rsoury/buyte/pkg/shipping"
+)
 
 type CheckoutStore interface {
 	GetFullCheckout(context.Context, string, *FullCheckoutOptions) (*FullCheckout, error)
+	// Get a checkout's primary and routed connections, with their credentials, before any payment token exists for it.
+	GetCheckoutConnections(context.Context, string) (*PaymentTokenCheckout, error)
 }
 
 // Public Load Full Checkout Widget Response
@@ -20,6 +26,8 @@ type FullCheckoutShippingMethod struct {
 	Rate        int         `json:"rate"`
 	MinOrder    int         `json:"minOrder"`
 	MaxOrder    interface{} `json:"maxOrder,omitempty"`
+	// Estimated delivery, where the merchant gives one.
+	Delivery *shipping.DeliveryWindow `json:"delivery,omitempty"`
 }
 type FullCheckoutMerchant struct {
 	StoreName  string `json:"storeName"`
@@ -28,10 +36,12 @@ type FullCheckoutMerchant struct {
 	CoverImage string `json:"coverImage"`
 }
 type FullCheckoutGatewayProvider struct {
-	ID        string `json:"id"`
-	Name      string `json:"name"`
-	PublicKey string `json:"publicKey"`
-	IsTest    bool   `json:"isTest"`
+	ID             string            `json:"id"`
+	Type           string            `json:"type"`
+	Name           string            `json:"name"`
+	PublicKey      string            `json:"publicKey"`
+	IsTest         bool              `json:"isTest"`
+	AdditionalData map[string]string `json:"additionalData,omitempty"`
 }
 type FullCheckout struct {
 	ID              string                       `json:"id"`
@@ -43,8 +53,39 @@ type FullCheckout struct {
 	Country         string                       `json:"country"`
 	Merchant        FullCheckoutMerchant         `json:"merchant"`
 	CustomCSS       string                       `json:"customCss"`
+	Requirements    *CheckoutRequirements        `json:"requirements,omitempty"`
 }
 
 type FullCheckoutOptions struct {
 	UserCountryCode string
+	// The state or province, and postcode, of the user's shipping address, where known. ie. While a wallet's payment sheet is open
+	UserRegion     string
+	UserPostalCode string
+	// Shipping methods are priced for the order, where known. Otherwise their base rates are given.
+	Order *shipping.Order
+}
+
+// Location is where the user's order ships to.
+func (o *FullCheckoutOptions) Location() *shipping.Location {
+	return &shipping.Location{
+		Country:    o.UserCountryCode,
+		Region:     o.UserRegion,
+		PostalCode: o.UserPostalCode,
+	}
+}
+
+func NewFullCheckoutShippingMethod(rate shipping.Rate) FullCheckoutShippingMethod {
+	method := FullCheckoutShippingMethod{
+		ID:          rate.ID,
+		Name:        rate.Label,
+		Description: rate.Description,
+		Rate:        rate.Rate,
+		MinOrder:    rate.MinOrder,
+		Delivery:    rate.Delivery,
+	}
+	// Shipping methods without a maximum order have a nil MaxOrder.
+	if rate.MaxOrder > 0 {
+		method.MaxOrder = rate.MaxOrder
+	}
+	return method
 }
@@ -1,7 +1,8 @@
 package buyte
 
 const (
-	FULL_CHECKOUT = "fullCheckout"
-	CHARGE        = "charge"
-	PAYMENT_TOKEN = "token"
+	FULL_CHECKOUT    = "fullCheckout"
+	CHARGE           = "charge"
+	PAYMENT_TOKEN    = "token"
+	APPLE_PAY_DOMAIN = "applePayDomain"
 )
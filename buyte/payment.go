@@ -2,10 +2,17 @@ package buyte
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"strings"
+	"time"
 
+	"github.com/rsoury/buyte/pkg/afterpay"
+	"github.com/rsoury/buyte/pkg/envelope"
 	"github.com/rsoury/buyte/pkg/googlepay"
+	"github.com/rsoury/buyte/pkg/paypal"
+	"github.com/rsoury/buyte/pkg/samsungpay"
+	"github.com/rsoury/buyte/pkg/shipping"
 	"github.com/rsoury/buyte/pkg/util"
 
 	"github.com/pkg/errors"
@@ -13,8 +20,23 @@ import (
 )
 
 const (
-	APPLE_PAY  = "Apple Pay"
-	GOOGLE_PAY = "Google Pay"
+	APPLE_PAY   = "Apple Pay"
+	GOOGLE_PAY  = "Google Pay"
+	SAMSUNG_PAY = "Samsung Pay"
+	PAYPAL      = "PayPal"
+	AFTERPAY    = "Afterpay"
+)
+
+// Payment token statuses. Expired tokens cannot be charged, and have their payment data purged.
+const (
+	PAYMENT_TOKEN_ACTIVE  = "active"
+	PAYMENT_TOKEN_EXPIRED = "expired"
+)
+
+// Apple Pay payment data types, as the decrypted token's paymentDataType.
+const (
+	PAYMENT_DATA_3DSECURE = "3DSecure"
+	PAYMENT_DATA_EMV      = "EMV"
 )
 
 type PaymentTokenStore interface {
@@ -30,6 +52,8 @@ type AuthorizedPaymentResponse struct {
 	Currency          string                                   `json:"currency"`
 	Country           string                                   `json:"country"`
 	RawPaymentRequest map[string]interface{}                   `json:"rawPaymentRequest,omitempty"`
+	// Token produced by the gateway's own client SDK for the wallet payment. ie. Square's wallet nonce
+	GatewayToken string `json:"gatewayToken,omitempty"`
 }
 type AuthorizedPaymentResponseShippingMethod struct {
 	ID          string `json:"id"`
@@ -47,14 +71,100 @@ type GooglePayAuthorizedPaymentResponse struct {
 	AuthorizedPaymentResponse
 	Result *googlepay.Response `json:"result"`
 }
+type SamsungPayAuthorizedPaymentResponse struct {
+	AuthorizedPaymentResponse
+	Result *samsungpay.Response `json:"result"`
+}
+type PayPalAuthorizedPaymentResponse struct {
+	AuthorizedPaymentResponse
+	Result *paypal.Approval `json:"result"`
+}
+type AfterpayAuthorizedPaymentResponse struct {
+	AuthorizedPaymentResponse
+	Result *afterpay.Return `json:"result"`
+}
 
 type NetworkToken struct {
 	*applepay.Token
+	// Identifies the merchant token (MPAN) for recurring, deferred and automatic reload payments.
+	MerchantTokenIdentifier string `json:"merchantTokenIdentifier,omitempty"`
 }
+
+// ParseNetworkToken reads decrypted Apple Pay payment data, and checks it carries the data of its payment data type.
+// 3DSecure tokens hold an online payment cryptogram. EMV tokens, ie. China UnionPay, hold EMV data and optionally an encrypted PIN.
+func ParseNetworkToken(plaintext []byte) (*NetworkToken, error) {
+	networkToken := &NetworkToken{}
+	if err := json.Unmarshal(plaintext, networkToken); err != nil {
+		return nil, errors.Wrap(err, "Could not read decrypted payment data")
+	}
+	if networkToken.Token == nil {
+		return nil, errors.New("Decrypted payment data is empty")
+	}
+	switch networkToken.DataType() {
+	case PAYMENT_DATA_3DSECURE:
+		if len(networkToken.PaymentData.OnlinePaymentCryptogram) == 0 {
+			return nil, errors.New("3DSecure payment data has no online payment cryptogram")
+		}
+	case PAYMENT_DATA_EMV:
+		if len(networkToken.PaymentData.EMVData) == 0 {
+			return nil, errors.New("EMV payment data has no EMV data")
+		}
+	default:
+		return nil, errors.Errorf("Unknown payment data type %q", networkToken.PaymentDataType)
+	}
+	return networkToken, nil
+}
+
+// NewGooglePayNetworkToken reads decrypted Google Pay payment data as a network token, to be charged like an Apple Pay 3DSecure token.
+// Only CRYPTOGRAM_3DS payment data carries a cryptogram. PAN_ONLY cards require the gateway to perform 3-D Secure itself.
+func NewGooglePayNetworkToken(paymentData *googlepay.PaymentData) (*NetworkToken, error) {
+	if !paymentData.IsCryptogram3DS() {
+		return nil, UnsupportedPaymentData("Buyte", paymentData.PaymentMethodDetails.AuthMethod)
+	}
+	details := paymentData.PaymentMethodDetails
+	cryptogram, err := base64.StdEncoding.DecodeString(details.Cryptogram)
+	if err != nil || len(cryptogram) == 0 {
+		return nil, errors.New("CRYPTOGRAM_3DS payment data has no valid cryptogram")
+	}
+	// Expiration dates are YYMMDD, at the last day of the month.
+	lastDay := time.Date(details.ExpirationYear, time.Month(details.ExpirationMonth)+1, 0, 0, 0, 0, 0, time.UTC)
+	token := &applepay.Token{
+		ApplicationPrimaryAccountNumber: details.Pan,
+		ApplicationExpirationDate:       lastDay.Format("060102"),
+		PaymentDataType:                 PAYMENT_DATA_3DSECURE,
+	}
+	token.PaymentData.OnlinePaymentCryptogram = cryptogram
+	token.PaymentData.ECIIndicator = details.EciIndicator
+	return &NetworkToken{Token: token}, nil
+}
+
+// NewSamsungPayNetworkToken reads a decrypted Samsung Pay 3DS payload as a network token, to be charged like an Apple Pay 3DSecure token.
+func NewSamsungPayNetworkToken(payload *samsungpay.Payload) (*NetworkToken, error) {
+	cryptogram, err := base64.StdEncoding.DecodeString(payload.Cryptogram)
+	if err != nil || len(cryptogram) == 0 {
+		return nil, errors.New("3DS payload has no valid cryptogram")
+	}
+	// Expiration dates are MMYY. Network tokens expire at the last day of the month, as YYMMDD.
+	expiration, err := time.Parse("0106", payload.TokenPANExpiration)
+	if err != nil {
+		return nil, errors.Wrap(err, "3DS payload has no valid token expiration")
+	}
+	lastDay := expiration.AddDate(0, 1, -1)
+	token := &applepay.Token{
+		ApplicationPrimaryAccountNumber: payload.TokenPAN,
+		ApplicationExpirationDate:       lastDay.Format("060102"),
+		PaymentDataType:                 PAYMENT_DATA_3DSECURE,
+	}
+	token.PaymentData.OnlinePaymentCryptogram = cryptogram
+	token.PaymentData.ECIIndicator = payload.EciIndicator
+	return &NetworkToken{Token: token}, nil
+}
+
 type ProviderCheckoutConnectionProviderDetails struct {
 	Name string `json:"name"`
 }
 type ProviderCheckoutConnection struct {
+	ID          string                                    `json:"id"`
 	Type        string                                    `json:"type"`
 	IsTest      bool                                      `json:"isTest"`
 	Credentials string                                    `json:"credentials"`
@@ -63,12 +173,6 @@ type ProviderCheckoutConnection struct {
 type PaymentMethod struct {
 	Name string `json:"name"`
 }
-type PublicPaymentToken struct {
-	ID       string `json:"id"`
-	Object   string `json:"object"`
-	Amount   int    `json:"amount"`
-	Currency string `json:"currency"`
-}
 type PaymentTokenShipping struct {
 	ID          string `json:"id"`
 	Label       string `json:"label"`
@@ -95,7 +199,40 @@ type PaymentTokenCheckout struct {
 	Label       string                      `json:"label"`
 	Description string                      `json:"description,omitempty"`
 	Connection  *ProviderCheckoutConnection `json:"connection,omitempty"`
+	Connections *CheckoutConnections        `json:"connections,omitempty"`
 }
+
+// Additional connections a checkout may route charges through, in addition to its primary connection.
+type CheckoutConnections struct {
+	Items []*RoutedCheckoutConnection `json:"items"`
+}
+type RoutedCheckoutConnection struct {
+	ID         string                      `json:"id"`
+	Priority   int                         `json:"priority"`
+	Rules      string                      `json:"rules,omitempty"`
+	Connection *ProviderCheckoutConnection `json:"connection"`
+}
+
+// Routing rules restrict which charges a connection may process. Empty rules match every charge.
+type ConnectionRoutingRules struct {
+	Currencies     []string `json:"currencies,omitempty"`
+	CardNetworks   []string `json:"cardNetworks,omitempty"`
+	PaymentMethods []string `json:"paymentMethods,omitempty"`
+	MinAmount      int      `json:"minAmount,omitempty"`
+	MaxAmount      int      `json:"maxAmount,omitempty"`
+}
+
+func (c *RoutedCheckoutConnection) RoutingRules() (*ConnectionRoutingRules, error) {
+	rules := &ConnectionRoutingRules{}
+	if c.Rules == "" {
+		return rules, nil
+	}
+	if err := json.Unmarshal([]byte(c.Rules), rules); err != nil {
+		return &ConnectionRoutingRules{}, errors.Wrap(err, "Could not read connection routing rules")
+	}
+	return rules, nil
+}
+
 type PaymentToken struct {
 	ID                     string                        `json:"id"`
 	Object                 string                        `json:"object"`
@@ -106,6 +243,12 @@ type PaymentToken struct {
 	ShippingMethod         *PaymentTokenShipping         `json:"shippingMethod,omitempty"`
 	SelectedShippingMethod *PaymentTokenSelectedShipping `json:"selectedShippingMethod,omitempty"`
 	Checkout               *PaymentTokenCheckout         `json:"checkout"`
+	GatewayToken           string                        `json:"gatewayToken,omitempty"`
+	Agreement              *PaymentAgreement             `json:"agreement,omitempty"`
+	Status                 string                        `json:"status"`
+	ExpiresAt              string                        `json:"expiresAt,omitempty"`
+	// Set once the token's payment data has been purged. The token's metadata is kept for the charges made against it.
+	PurgedAt string `json:"purgedAt,omitempty"`
 }
 type ApplePayPaymentToken struct {
 	*PaymentToken
@@ -115,6 +258,18 @@ type GooglePayPaymentToken struct {
 	*PaymentToken
 	Response *googlepay.Response `json:"response"`
 }
+type SamsungPayPaymentToken struct {
+	*PaymentToken
+	Response *samsungpay.Response `json:"response"`
+}
+type PayPalPaymentToken struct {
+	*PaymentToken
+	Response *paypal.Order `json:"response"`
+}
+type AfterpayPaymentToken struct {
+	*PaymentToken
+	Response *afterpay.Checkout `json:"response"`
+}
 type CreatePaymentTokenInput struct {
 	ID                string                        `json:"id"`
 	Value             interface{}                   `json:"value"`
@@ -126,22 +281,50 @@ type CreatePaymentTokenInput struct {
 	Currency          string                        `json:"currency"`
 	Country           string                        `json:"country"`
 	RawPaymentRequest interface{}                   `json:"rawPaymentRequest,omitempty"`
+	GatewayToken      string                        `json:"gatewayToken,omitempty"`
+	Agreement         *PaymentAgreement             `json:"agreement,omitempty"`
+	ExpiresAt         string                        `json:"expiresAt,omitempty"`
 }
 
-func (p *PaymentToken) IsApplePay() bool {
-	return p.PaymentMethod.Name == APPLE_PAY
+// IsExpired checks whether the token may no longer be charged. Tokens created without an expiry do not expire.
+// An expiry that cannot be read is treated as expired, rather than allowing the token to be charged indefinitely.
+func (p *PaymentToken) IsExpired(now time.Time) bool {
+	if p.PurgedAt != "" {
+		return true
+	}
+	if p.ExpiresAt == "" {
+		return false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, p.ExpiresAt)
+	if err != nil {
+		return true
+	}
+	return !now.Before(expiresAt)
+}
+
+// SetStatus sets the token's status as of the time given.
+func (p *PaymentToken) SetStatus(now time.Time) {
+	if p.IsExpired(now) {
+		p.Status = PAYMENT_TOKEN_EXPIRED
+	} else {
+		p.Status = PAYMENT_TOKEN_ACTIVE
+	}
 }
 
-func (p *PaymentToken) IsGooglePay() bool {
-	return p.PaymentMethod.Name == GOOGLE_PAY
+// Values encrypted at rest are decrypted by the store. Values still sealed cannot be read, ie. When the store has no key provider.
+func (p *PaymentToken) decodeValue(v interface{}) error {
+	if envelope.IsSealed(p.Value) {
+		return errors.New("Payment token value is encrypted")
+	}
+	return json.Unmarshal([]byte(p.Value), v)
 }
 
 func (p *PaymentToken) GooglePay() (*GooglePayPaymentToken, error) {
-	if !p.IsGooglePay() {
+	if p.PaymentMethod == nil || p.PaymentMethod.Name != GOOGLE_PAY {
 		return &GooglePayPaymentToken{}, errors.New("PaymentMethod not Google Pay")
 	}
 	var googlePayResponse googlepay.Response
-	err := json.Unmarshal([]byte(p.Value), &googlePayResponse)
+	err := p.decodeValue(&googlePayResponse)
 	if err != nil {
 		return &GooglePayPaymentToken{}, errors.Wrap(err, "Could not format PaymentToken to GooglePayPaymentToken")
 	}
@@ -152,11 +335,11 @@ func (p *PaymentToken) GooglePay() (*GooglePayPaymentToken, error) {
 }
 
 func (p *PaymentToken) ApplePay() (*ApplePayPaymentToken, error) {
-	if !p.IsApplePay() {
+	if p.PaymentMethod == nil || p.PaymentMethod.Name != APPLE_PAY {
 		return &ApplePayPaymentToken{}, errors.New("PaymentMethod not Apple Pay")
 	}
 	var applePayResponse applepay.Response
-	err := json.Unmarshal([]byte(p.Value), &applePayResponse)
+	err := p.decodeValue(&applePayResponse)
 	if err != nil {
 		return &ApplePayPaymentToken{}, errors.Wrap(err, "Could not format PaymentToken to ApplePayPaymentToken")
 	}
@@ -166,121 +349,217 @@ func (p *PaymentToken) ApplePay() (*ApplePayPaymentToken, error) {
 	}, nil
 }
 
-func (p *PaymentToken) Format() (interface{}, error) {
-	if p.PaymentMethod.Name == "" {
-		return p, errors.New("PaymentMethod not present")
+func (p *PaymentToken) PayPal() (*PayPalPaymentToken, error) {
+	if p.PaymentMethod == nil || p.PaymentMethod.Name != PAYPAL {
+		return &PayPalPaymentToken{}, errors.New("PaymentMethod not PayPal")
 	}
-	switch p.PaymentMethod.Name {
-	case APPLE_PAY:
-		return p.ApplePay()
-	case GOOGLE_PAY:
-		return p.GooglePay()
-	default:
+	var order paypal.Order
+	err := p.decodeValue(&order)
+	if err != nil {
+		return &PayPalPaymentToken{}, errors.Wrap(err, "Could not format PaymentToken to PayPalPaymentToken")
 	}
-	return p, nil
+	return &PayPalPaymentToken{
+		p,
+		&order,
+	}, nil
 }
 
-func (p *PaymentToken) Customer() *Customer {
-	if p.IsApplePay() {
-		applePayPaymentToken, err := p.ApplePay()
-		if err != nil {
-			return &Customer{}
-		}
-		var applePayContact applepay.Contact
-		if applePayPaymentToken.Response.ShippingContact.EmailAddress != "" {
-			applePayContact = applePayPaymentToken.Response.ShippingContact
-		} else if applePayPaymentToken.Response.BillingContact.EmailAddress != "" {
-			applePayContact = applePayPaymentToken.Response.BillingContact
-		}
-		customer := &Customer{
-			Name:         strings.TrimSpace(applePayContact.GivenName + " " + applePayContact.FamilyName),
-			GivenName:    applePayContact.GivenName,
-			FamilyName:   applePayContact.FamilyName,
-			EmailAddress: applePayContact.EmailAddress,
-			PhoneNumber:  applePayContact.PhoneNumber,
-		}
-		customer.SetShippingAddress(&CustomerAddress{
-			AddressLines:          applePayPaymentToken.Response.ShippingContact.AddressLines,
-			AdministrativeArea:    applePayPaymentToken.Response.ShippingContact.AdministrativeArea,
-			Country:               applePayPaymentToken.Response.ShippingContact.Country,
-			CountryCode:           applePayPaymentToken.Response.ShippingContact.CountryCode,
-			Locality:              applePayPaymentToken.Response.ShippingContact.Locality,
-			PostalCode:            applePayPaymentToken.Response.ShippingContact.PostalCode,
-			SubAdministrativeArea: applePayPaymentToken.Response.ShippingContact.SubAdministrativeArea,
-			SubLocality:           applePayPaymentToken.Response.ShippingContact.SubLocality,
-		})
-		customer.SetBillingAddress(&CustomerAddress{
-			AddressLines:          applePayPaymentToken.Response.BillingContact.AddressLines,
-			AdministrativeArea:    applePayPaymentToken.Response.BillingContact.AdministrativeArea,
-			Country:               applePayPaymentToken.Response.BillingContact.Country,
-			CountryCode:           applePayPaymentToken.Response.BillingContact.CountryCode,
-			Locality:              applePayPaymentToken.Response.BillingContact.Locality,
-			PostalCode:            applePayPaymentToken.Response.BillingContact.PostalCode,
-			SubAdministrativeArea: applePayPaymentToken.Response.BillingContact.SubAdministrativeArea,
-			SubLocality:           applePayPaymentToken.Response.BillingContact.SubLocality,
-		})
-		return customer
-	} else if p.IsGooglePay() {
-		googlePayPaymentToken, err := p.GooglePay()
-		if err != nil {
-			return &Customer{}
-		}
-		shippingAddress := googlePayPaymentToken.Response.ShippingAddress
-		var billingAddress googlepay.Address
-		if googlePayPaymentToken.Response.BillingAddress.PhoneNumber != "" {
-			billingAddress = googlePayPaymentToken.Response.BillingAddress
-		} else if googlePayPaymentToken.Response.PaymentMethodData.Info.BillingAddress.PhoneNumber != "" {
-			billingAddress = googlePayPaymentToken.Response.PaymentMethodData.Info.BillingAddress
-		}
-		var name string
-		if shippingAddress.Name != "" {
-			name = shippingAddress.Name
-		} else if billingAddress.Name != "" {
-			name = billingAddress.Name
-		}
-		var phoneNumber string
-		if shippingAddress.PhoneNumber != "" {
-			phoneNumber = shippingAddress.PhoneNumber
-		} else if billingAddress.PhoneNumber != "" {
-			phoneNumber = billingAddress.PhoneNumber
+func (p *PaymentToken) Afterpay() (*AfterpayPaymentToken, error) {
+	if p.PaymentMethod == nil || p.PaymentMethod.Name != AFTERPAY {
+		return &AfterpayPaymentToken{}, errors.New("PaymentMethod not Afterpay")
+	}
+	var checkout afterpay.Checkout
+	err := p.decodeValue(&checkout)
+	if err != nil {
+		return &AfterpayPaymentToken{}, errors.Wrap(err, "Could not format PaymentToken to AfterpayPaymentToken")
+	}
+	return &AfterpayPaymentToken{
+		p,
+		&checkout,
+	}, nil
+}
+
+func (p *PaymentToken) SamsungPay() (*SamsungPayPaymentToken, error) {
+	if p.PaymentMethod == nil || p.PaymentMethod.Name != SAMSUNG_PAY {
+		return &SamsungPayPaymentToken{}, errors.New("PaymentMethod not Samsung Pay")
+	}
+	var samsungPayResponse samsungpay.Response
+	err := p.decodeValue(&samsungPayResponse)
+	if err != nil {
+		return &SamsungPayPaymentToken{}, errors.Wrap(err, "Could not format PaymentToken to SamsungPayPaymentToken")
+	}
+	return &SamsungPayPaymentToken{
+		p,
+		&samsungPayResponse,
+	}, nil
+}
+
+// NewApplePayCustomer reads the customer from the contacts returned with an Apple Pay payment.
+func NewApplePayCustomer(response *applepay.Response) *Customer {
+	var applePayContact applepay.Contact
+	if response.ShippingContact.EmailAddress != "" {
+		applePayContact = response.ShippingContact
+	} else if response.BillingContact.EmailAddress != "" {
+		applePayContact = response.BillingContact
+	}
+	customer := &Customer{
+		Name:         strings.TrimSpace(applePayContact.GivenName + " " + applePayContact.FamilyName),
+		GivenName:    applePayContact.GivenName,
+		FamilyName:   applePayContact.FamilyName,
+		EmailAddress: applePayContact.EmailAddress,
+		PhoneNumber:  applePayContact.PhoneNumber,
+	}
+	customer.SetShippingAddress(&CustomerAddress{
+		AddressLines:          response.ShippingContact.AddressLines,
+		AdministrativeArea:    response.ShippingContact.AdministrativeArea,
+		Country:               response.ShippingContact.Country,
+		CountryCode:           response.ShippingContact.CountryCode,
+		Locality:              response.ShippingContact.Locality,
+		PostalCode:            response.ShippingContact.PostalCode,
+		SubAdministrativeArea: response.ShippingContact.SubAdministrativeArea,
+		SubLocality:           response.ShippingContact.SubLocality,
+	})
+	customer.SetBillingAddress(&CustomerAddress{
+		AddressLines:          response.BillingContact.AddressLines,
+		AdministrativeArea:    response.BillingContact.AdministrativeArea,
+		Country:               response.BillingContact.Country,
+		CountryCode:           response.BillingContact.CountryCode,
+		Locality:              response.BillingContact.Locality,
+		PostalCode:            response.BillingContact.PostalCode,
+		SubAdministrativeArea: response.BillingContact.SubAdministrativeArea,
+		SubLocality:           response.BillingContact.SubLocality,
+	})
+	return customer
+}
+
+// NewGooglePayCustomer reads the customer from the addresses returned with a Google Pay payment.
+func NewGooglePayCustomer(response *googlepay.Response) *Customer {
+	shippingAddress := response.ShippingAddress
+	var billingAddress googlepay.Address
+	if response.BillingAddress.PhoneNumber != "" {
+		billingAddress = response.BillingAddress
+	} else if response.PaymentMethodData.Info.BillingAddress.PhoneNumber != "" {
+		billingAddress = response.PaymentMethodData.Info.BillingAddress
+	}
+	var name string
+	if shippingAddress.Name != "" {
+		name = shippingAddress.Name
+	} else if billingAddress.Name != "" {
+		name = billingAddress.Name
+	}
+	var phoneNumber string
+	if shippingAddress.PhoneNumber != "" {
+		phoneNumber = shippingAddress.PhoneNumber
+	} else if billingAddress.PhoneNumber != "" {
+		phoneNumber = billingAddress.PhoneNumber
+	}
+	givenName, familyName := util.Namesplit(name)
+	customer := &Customer{
+		Name:         name,
+		GivenName:    givenName,
+		FamilyName:   familyName,
+		EmailAddress: response.Email,
+		PhoneNumber:  phoneNumber,
+	}
+	customer.SetShippingAddress(&CustomerAddress{
+		AddressLines: []string{
+			shippingAddress.Address1,
+			shippingAddress.Address2,
+			shippingAddress.Address3,
+		},
+		AdministrativeArea: shippingAddress.AdministrativeArea,
+		CountryCode:        shippingAddress.CountryCode,
+		Locality:           shippingAddress.Locality,
+		PostalCode:         shippingAddress.PostalCode,
+	})
+	customer.SetBillingAddress(&CustomerAddress{
+		AddressLines: []string{
+			billingAddress.Address1,
+			billingAddress.Address2,
+			billingAddress.Address3,
+		},
+		AdministrativeArea: billingAddress.AdministrativeArea,
+		CountryCode:        billingAddress.CountryCode,
+		Locality:           billingAddress.Locality,
+		PostalCode:         billingAddress.PostalCode,
+	})
+	return customer
+}
+
+// NewPayPalCustomer reads the customer from the payer and shipping details of an approved PayPal order.
+func NewPayPalCustomer(order *paypal.Order) *Customer {
+	shipping := order.Shipping()
+	customer := &Customer{
+		Name: order.PayerName(),
+	}
+	if order.Payer != nil {
+		customer.GivenName = order.Payer.Name.GivenName
+		customer.FamilyName = order.Payer.Name.Surname
+		customer.EmailAddress = order.Payer.EmailAddress
+		if order.Payer.Phone != nil {
+			customer.PhoneNumber = order.Payer.Phone.PhoneNumber.NationalNumber
 		}
-		givenName, familyName := util.Namesplit(name)
-		customer := &Customer{
-			Name:         name,
-			GivenName:    givenName,
-			FamilyName:   familyName,
-			EmailAddress: googlePayPaymentToken.Response.Email,
-			PhoneNumber:  phoneNumber,
+		customer.SetBillingAddress(payPalAddress(order.Payer.Address))
+	}
+	if customer.Name == "" && shipping.Name != nil {
+		customer.Name = shipping.Name.FullName
+		customer.GivenName, customer.FamilyName = util.Namesplit(customer.Name)
+	}
+	customer.SetShippingAddress(payPalAddress(shipping.Address))
+	return customer
+}
+
+// NewAfterpayCustomer reads the customer from the consumer and contacts of a confirmed Afterpay checkout.
+func NewAfterpayCustomer(checkout *afterpay.Checkout) *Customer {
+	customer := &Customer{
+		Name: checkout.ConsumerName(),
+	}
+	if checkout.Consumer != nil {
+		customer.GivenName = checkout.Consumer.GivenNames
+		customer.FamilyName = checkout.Consumer.Surname
+		customer.EmailAddress = checkout.Consumer.Email
+		customer.PhoneNumber = checkout.Consumer.PhoneNumber
+	}
+	if checkout.Shipping != nil {
+		if customer.Name == "" {
+			customer.Name = checkout.Shipping.Name
+			customer.GivenName, customer.FamilyName = util.Namesplit(customer.Name)
 		}
-		customer.SetShippingAddress(&CustomerAddress{
-			AddressLines: []string{
-				shippingAddress.Address1,
-				shippingAddress.Address2,
-				shippingAddress.Address3,
-			},
-			AdministrativeArea: shippingAddress.AdministrativeArea,
-			CountryCode:        shippingAddress.CountryCode,
-			Locality:           shippingAddress.Locality,
-			PostalCode:         shippingAddress.PostalCode,
-		})
-		customer.SetBillingAddress(&CustomerAddress{
-			AddressLines: []string{
-				billingAddress.Address1,
-				billingAddress.Address2,
-				billingAddress.Address3,
-			},
-			AdministrativeArea: billingAddress.AdministrativeArea,
-			CountryCode:        billingAddress.CountryCode,
-			Locality:           billingAddress.Locality,
-			PostalCode:         billingAddress.PostalCode,
-		})
-		return customer
-	}
-	return &Customer{}
-}
-
-func (p *PaymentToken) IsRejectedSigningTimeDelta(err error) bool {
-	return strings.Contains(err.Error(), "invalid token signature: rejected signing time delta")
+		customer.PhoneNumber = firstNonEmpty(customer.PhoneNumber, checkout.Shipping.PhoneNumber)
+	}
+	customer.SetShippingAddress(afterpayAddress(checkout.Shipping))
+	customer.SetBillingAddress(afterpayAddress(checkout.Billing))
+	return customer
+}
+
+// NewSamsungPayCustomer reads the customer from the addresses returned with a Samsung Pay payment.
+func NewSamsungPayCustomer(response *samsungpay.Response) *Customer {
+	shippingAddress := response.ShippingAddress
+	billingAddress := response.BillingAddress
+	name := firstNonEmpty(shippingAddress.Addressee, billingAddress.Addressee)
+	givenName, familyName := util.Namesplit(name)
+	customer := &Customer{
+		Name:         name,
+		GivenName:    givenName,
+		FamilyName:   familyName,
+		EmailAddress: response.Email,
+		PhoneNumber:  firstNonEmpty(shippingAddress.PhoneNumber, billingAddress.PhoneNumber),
+	}
+	customer.SetShippingAddress(samsungPayAddress(shippingAddress))
+	customer.SetBillingAddress(samsungPayAddress(billingAddress))
+	return customer
+}
+
+// ShippingRate is the rate the shipping method was selected at, with the order limits it was offered for.
+func (s *PaymentTokenShipping) ShippingRate() *shipping.Rate {
+	return &shipping.Rate{
+		ID:          s.ID,
+		Label:       s.Label,
+		Description: s.Description,
+		Rate:        s.Rate,
+		MinOrder:    s.MinOrder,
+		MaxOrder:    s.MaxOrder,
+	}
 }
 
 // Copy selected shipping data to ShippingMethod.
@@ -307,6 +586,7 @@ func NewPaymentTokenInput(response *AuthorizedPaymentResponse) *CreatePaymentTok
 		Currency:          response.Currency,
 		Country:           response.Country,
 		RawPaymentRequest: response.RawPaymentRequest,
+		GatewayToken:      response.GatewayToken,
 	}
 
 	// If memory address for shipping is not nil
@@ -325,11 +605,16 @@ func NewPaymentTokenInput(response *AuthorizedPaymentResponse) *CreatePaymentTok
 	return tokenInput
 }
 
-// NewApplePayPaymentTokenInput Sets result of Authed Payment Response as the value
-func NewApplePayPaymentTokenInput(response *ApplePayAuthorizedPaymentResponse) *CreatePaymentTokenInput {
+// NewApplePayPaymentTokenInput Sets result of Authed Payment Response as the value, and the payment agreement requested.
+func NewApplePayPaymentTokenInput(response *ApplePayAuthorizedPaymentResponse) (*CreatePaymentTokenInput, error) {
 	input := NewPaymentTokenInput(&response.AuthorizedPaymentResponse)
 	input.Value = response.Result
-	return input
+	agreement, err := NewPaymentAgreement(response.RawPaymentRequest)
+	if err != nil {
+		return input, err
+	}
+	input.Agreement = agreement
+	return input, nil
 }
 
 // NewApplePayPaymentTokenInput Sets result of Authed Payment Response as the value
@@ -339,6 +624,27 @@ func NewGooglePayPaymentTokenInput(response *GooglePayAuthorizedPaymentResponse)
 	return input
 }
 
+// NewSamsungPayPaymentTokenInput Sets result of Authed Payment Response as the value
+func NewSamsungPayPaymentTokenInput(response *SamsungPayAuthorizedPaymentResponse) *CreatePaymentTokenInput {
+	input := NewPaymentTokenInput(&response.AuthorizedPaymentResponse)
+	input.Value = response.Result
+	return input
+}
+
+// NewPayPalPaymentTokenInput Sets the approved order as the value, to be captured by the PayPal gateway once charged.
+func NewPayPalPaymentTokenInput(response *PayPalAuthorizedPaymentResponse, order *paypal.Order) *CreatePaymentTokenInput {
+	input := NewPaymentTokenInput(&response.AuthorizedPaymentResponse)
+	input.Value = order
+	return input
+}
+
+// NewAfterpayPaymentTokenInput Sets the confirmed checkout as the value, to be captured by the Afterpay gateway once charged.
+func NewAfterpayPaymentTokenInput(response *AfterpayAuthorizedPaymentResponse, checkout *afterpay.Checkout) *CreatePaymentTokenInput {
+	input := NewPaymentTokenInput(&response.AuthorizedPaymentResponse)
+	input.Value = checkout
+	return input
+}
+
 // Format Payment Token Input
 func (i *CreatePaymentTokenInput) Format() error {
 	// Ensure input Value is formatted appropriately -- in JSON.
@@ -381,3 +687,27 @@ func (n *NetworkToken) ExpYear() string {
 	}
 	return ""
 }
+
+// DataType is the token's payment data type. Tokens without one are 3DSecure, as all tokens were before EMV was introduced.
+func (n *NetworkToken) DataType() string {
+	if n.PaymentDataType == "" {
+		return PAYMENT_DATA_3DSECURE
+	}
+	return n.PaymentDataType
+}
+func (n *NetworkToken) IsEMV() bool {
+	return n.DataType() == PAYMENT_DATA_EMV
+}
+
+// EMVData is the token's EMV payment data, base64 encoded as it is in the token.
+func (n *NetworkToken) EMVData() string {
+	if len(n.PaymentData.EMVData) == 0 {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(n.PaymentData.EMVData)
+}
+
+// EncryptedPINData is the PIN the customer entered, encrypted to the payment network. Only present for EMV tokens requiring one.
+func (n *NetworkToken) EncryptedPINData() string {
+	return n.PaymentData.EncryptedPINData
+}
@@ -1 +1,196 @@
 package buyte
+
+import (
+	"testing"
+	"time"
+
+	"github.com/palantir/stacktrace"
+	"github.com/pkg/errors"
+	"github.com/rsoury/buyte/pkg/googlepay"
+	"github.com/rsoury/buyte/pkg/samsungpay"
+	"github.com/stretchr/testify/assert"
+)
+
+const emvTokenData = `{
+    "applicationPrimaryAccountNumber": "6250947000000014",
+    "applicationExpirationDate": "331231",
+    "currencyCode": "156",
+    "transactionAmount": 100,
+    "deviceManufacturerIdentifier": "040010030273",
+    "paymentDataType": "EMV",
+    "paymentData": {
+        "emvData": "nyYIESIzRFVmd4g=",
+        "encryptedPINData": "8BD8A9A1E2B8C3D4"
+    }
+}`
+
+const merchantTokenData = `{
+    "applicationPrimaryAccountNumber": "4817499130172785",
+    "applicationExpirationDate": "331231",
+    "currencyCode": "036",
+    "transactionAmount": 1000,
+    "deviceManufacturerIdentifier": "040010030273",
+    "paymentDataType": "3DSecure",
+    "merchantTokenIdentifier": "DNITHE302308980427388297",
+    "paymentData": {
+        "onlinePaymentCryptogram": "Ag0wIaIAHrzC2TyUMqHLMAABAAA=",
+        "eciIndicator": "7"
+    }
+}`
+
+func TestParseNetworkTokenEMV(t *testing.T) {
+	assert := assert.New(t)
+	networkToken, err := ParseNetworkToken([]byte(emvTokenData))
+	if assert.NoError(err) {
+		assert.True(networkToken.IsEMV())
+		assert.Equal(PAYMENT_DATA_EMV, networkToken.DataType())
+		assert.Equal("nyYIESIzRFVmd4g=", networkToken.EMVData())
+		assert.Equal("8BD8A9A1E2B8C3D4", networkToken.EncryptedPINData())
+	}
+
+	_, err = ParseNetworkToken([]byte(`{"paymentDataType":"EMV","paymentData":{}}`))
+	assert.Error(err)
+}
+
+func TestParseNetworkToken3DSecure(t *testing.T) {
+	assert := assert.New(t)
+	networkToken, err := ParseNetworkToken([]byte(merchantTokenData))
+	if assert.NoError(err) {
+		assert.False(networkToken.IsEMV())
+		assert.Equal(PAYMENT_DATA_3DSECURE, networkToken.DataType())
+		assert.Equal("", networkToken.EMVData())
+		assert.Equal("DNITHE302308980427388297", networkToken.MerchantTokenIdentifier)
+	}
+
+	_, err = ParseNetworkToken([]byte(`{"paymentData":{"eciIndicator":"7"}}`))
+	assert.Error(err, "A 3DSecure token without a cryptogram should not be charged.")
+	_, err = ParseNetworkToken([]byte(`{"paymentDataType":"Unknown","paymentData":{"onlinePaymentCryptogram":"AA=="}}`))
+	assert.Error(err)
+	_, err = ParseNetworkToken([]byte(`null`))
+	assert.Error(err)
+}
+
+func TestIsUnsupportedPaymentData(t *testing.T) {
+	err := UnsupportedPaymentData("Stripe", PAYMENT_DATA_EMV)
+	assert.True(t, IsUnsupportedPaymentData(err))
+	assert.False(t, IsGatewayUnavailable(err))
+	assert.Contains(t, err.Error(), "Stripe does not support EMV payment data")
+}
+
+func TestIsGatewayNotAttempted(t *testing.T) {
+	assert := assert.New(t)
+	err := GatewayNotAttempted(errors.New("connection refused"), "Could not reach Stripe")
+	assert.True(IsGatewayNotAttempted(err))
+	assert.True(IsGatewayUnavailable(err))
+	assert.True(IsGatewayNotAttempted(stacktrace.Propagate(err, "Could not create charge")))
+	assert.False(IsGatewayNotAttempted(GatewayUnavailable(err, "Stripe is unavailable")), "An ambiguous outcome is never retried elsewhere.")
+}
+
+func TestPaymentTokenExpiry(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	paymentToken := &PaymentToken{ID: "tok_xxx", ExpiresAt: "2026-10-19T10:05:00Z"}
+	paymentToken.SetStatus(now)
+	assert.False(paymentToken.IsExpired(now))
+	assert.Equal(PAYMENT_TOKEN_ACTIVE, paymentToken.Status)
+
+	later := now.Add(5 * time.Minute)
+	paymentToken.SetStatus(later)
+	assert.True(paymentToken.IsExpired(later), "Tokens expire at their expiry.")
+	assert.Equal(PAYMENT_TOKEN_EXPIRED, paymentToken.Status)
+
+	assert.False((&PaymentToken{}).IsExpired(later), "Tokens without an expiry do not expire.")
+	assert.True((&PaymentToken{PurgedAt: "2026-10-19T09:00:00Z"}).IsExpired(now), "Purged tokens are expired.")
+	assert.True((&PaymentToken{ExpiresAt: "19/10/2026"}).IsExpired(now), "Tokens with a malformed expiry are expired.")
+}
+
+func TestNewPaymentAgreement(t *testing.T) {
+	assert := assert.New(t)
+	agreement, err := NewPaymentAgreement(map[string]interface{}{
+		"countryCode": "AU",
+		"recurringPaymentRequest": map[string]interface{}{
+			"paymentDescription": "Coffee subscription",
+			"regularBilling": map[string]interface{}{
+				"label":                        "Monthly",
+				"amount":                       "10.00",
+				"paymentTiming":                "recurring",
+				"recurringPaymentIntervalUnit": "month",
+			},
+			"billingAgreement":     "Charged monthly until cancelled.",
+			"managementURL":        "https://shop.example.com/subscription",
+			"tokenNotificationURL": "https://shop.example.com/tokens",
+		},
+	})
+	if assert.NoError(err) && assert.NotNil(agreement) {
+		assert.Equal(PAYMENT_AGREEMENT_RECURRING, agreement.Type)
+		assert.Equal("Coffee subscription", agreement.Description)
+		assert.Equal("Charged monthly until cancelled.", agreement.BillingAgreement)
+		assert.Equal("https://shop.example.com/subscription", agreement.ManagementURL)
+		assert.Equal("https://shop.example.com/tokens", agreement.TokenNotificationURL)
+	}
+
+	agreement, err = NewPaymentAgreement(map[string]interface{}{"countryCode": "AU"})
+	assert.NoError(err)
+	assert.Nil(agreement, "One-off payments have no agreement.")
+
+	_, err = NewPaymentAgreement(map[string]interface{}{
+		"deferredPaymentRequest": map[string]interface{}{"paymentDescription": "Hotel booking"},
+	})
+	assert.Error(err, "A management URL is required.")
+
+	_, err = NewPaymentAgreement(map[string]interface{}{
+		"deferredPaymentRequest":        map[string]interface{}{"managementURL": "https://shop.example.com"},
+		"automaticReloadPaymentRequest": map[string]interface{}{"managementURL": "https://shop.example.com"},
+	})
+	assert.Error(err)
+}
+
+func TestNewGooglePayNetworkToken(t *testing.T) {
+	assert := assert.New(t)
+	paymentData := &googlepay.PaymentData{
+		PaymentMethod: "CARD",
+		PaymentMethodDetails: googlepay.PaymentMethodDetails{
+			AuthMethod:      googlepay.AuthMethodCryptogram3DS,
+			Pan:             "4895370012003478",
+			ExpirationMonth: 2,
+			ExpirationYear:  2032,
+			Cryptogram:      "AgAAAAAABk4DWZ4C28yUQAAAAAA=",
+			EciIndicator:    "05",
+		},
+	}
+	networkToken, err := NewGooglePayNetworkToken(paymentData)
+	if assert.NoError(err) {
+		assert.Equal("4895370012003478", networkToken.ApplicationPrimaryAccountNumber)
+		assert.Equal("320229", networkToken.ApplicationExpirationDate)
+		assert.Equal("02", networkToken.ExpMonth())
+		assert.Equal("2032", networkToken.ExpYear())
+		assert.Equal(PAYMENT_DATA_3DSECURE, networkToken.DataType())
+		assert.NotEmpty(networkToken.PaymentData.OnlinePaymentCryptogram)
+		assert.Equal("05", networkToken.PaymentData.ECIIndicator)
+	}
+
+	paymentData.PaymentMethodDetails.AuthMethod = googlepay.AuthMethodPANOnly
+	_, err = NewGooglePayNetworkToken(paymentData)
+	assert.True(IsUnsupportedPaymentData(err))
+}
+
+func TestNewSamsungPayNetworkToken(t *testing.T) {
+	assert := assert.New(t)
+	payload := &samsungpay.Payload{
+		TokenPAN:           "5204240250197840",
+		TokenPANExpiration: "0232",
+		Cryptogram:         "AK+zkbPMCORcABCD3AGRAoABFA==",
+		EciIndicator:       "2",
+	}
+	networkToken, err := NewSamsungPayNetworkToken(payload)
+	if assert.NoError(err) {
+		assert.Equal("5204240250197840", networkToken.ApplicationPrimaryAccountNumber)
+		assert.Equal("320229", networkToken.ApplicationExpirationDate)
+		assert.Equal(PAYMENT_DATA_3DSECURE, networkToken.DataType())
+		assert.Equal("2", networkToken.PaymentData.ECIIndicator)
+	}
+
+	payload.TokenPANExpiration = "2032-02"
+	_, err = NewSamsungPayNetworkToken(payload)
+	assert.Error(err)
+}
@@ -2,20 +2,107 @@ package buyte
 
 import (
 	"context"
+	"net/http"
 
+	"github.com/palantir/stacktrace"
+	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
 
 // Payment Gateway Types
 const (
-	STRIPE = "STRIPE"
-	ADYEN  = "ADYEN"
+	STRIPE      = "STRIPE"
+	ADYEN       = "ADYEN"
+	BRAINTREE   = "BRAINTREE"
+	CHECKOUTCOM = "CHECKOUTCOM"
+	SQUARE      = "SQUARE"
+	// PayPal Checkout, which only processes PayPal orders.
+	PAYPAL_CHECKOUT = "PAYPAL"
+	// Afterpay's Online API, which only processes Afterpay checkouts.
+	AFTERPAY_ONLINE = "AFTERPAY"
+)
+
+// Payment Gateway Error Codes -- Attached with stacktrace.PropagateWithCode and inherited when propagated.
+const (
+	// The gateway failed, or could not be reached, after the request may have been sent. The outcome is unknown, so it is not safe to retry against another connection.
+	EcodeGatewayUnavailable stacktrace.ErrorCode = 503
+	// The request never reached the gateway, ie. the connection was refused or its circuit is open. Safe to retry against another connection.
+	EcodeGatewayNotAttempted stacktrace.ErrorCode = 502
+	// The gateway cannot charge the payment data it was given, ie. EMV Apple Pay tokens. Nothing was sent to the gateway.
+	EcodeUnsupportedPaymentData stacktrace.ErrorCode = 415
 )
 
 type Gateway struct {
-	Type        string             `json:"type"`
-	IsTest      bool               `json:"isTest"`
-	Credentials interface{}        `json:"credentials"`
-	Context     context.Context    `json:"-"`
-	Logger      *zap.SugaredLogger `json:"-"`
+	Type         string             `json:"type"`
+	IsTest       bool               `json:"isTest"`
+	ConnectionId string             `json:"connectionId,omitempty"`
+	Credentials  interface{}        `json:"credentials"`
+	Context      context.Context    `json:"-"`
+	Logger       *zap.SugaredLogger `json:"-"`
+	Transport    GatewayTransport   `json:"-"`
+}
+
+// GatewayTransport executes HTTP requests against a payment gateway on behalf of a gateway connection.
+type GatewayTransport interface {
+	Do(ctx context.Context, gateway *Gateway, req *GatewayRequest) (*GatewayResponse, error)
+}
+type GatewayRequest struct {
+	Method string
+	URL    string
+	Header http.Header
+	Body   []byte
+	// Requests carrying the gateway's idempotency key may be safely retried.
+	IdempotencyKey string
+}
+type GatewayResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// GatewayUnavailable marks an error as the gateway being unavailable, rather than a decline or invalid request.
+func GatewayUnavailable(err error, msg string, vals ...interface{}) error {
+	return stacktrace.PropagateWithCode(err, EcodeGatewayUnavailable, msg, vals...)
+}
+
+// IsGatewayUnavailable checks the error, and the errors it wraps, for the gateway unavailable or not attempted codes.
+func IsGatewayUnavailable(err error) bool {
+	return hasCode(err, EcodeGatewayUnavailable) || hasCode(err, EcodeGatewayNotAttempted)
+}
+
+// GatewayNotAttempted marks an error as the gateway being unavailable before the request was sent, so the gateway cannot have applied it.
+func GatewayNotAttempted(err error, msg string, vals ...interface{}) error {
+	return stacktrace.PropagateWithCode(err, EcodeGatewayNotAttempted, msg, vals...)
+}
+
+// IsGatewayNotAttempted checks the error, and the errors it wraps, for the gateway not attempted code.
+// Errors marked unavailable after the request may have been sent are never not attempted, whatever they wrap.
+func IsGatewayNotAttempted(err error) bool {
+	return hasCode(err, EcodeGatewayNotAttempted) && !hasCode(err, EcodeGatewayUnavailable)
+}
+
+// UnsupportedPaymentData is returned by gateways asked to charge a payment data type they do not accept.
+func UnsupportedPaymentData(gateway string, dataType string) error {
+	return stacktrace.NewErrorWithCode(EcodeUnsupportedPaymentData, "%s does not support %s payment data", gateway, dataType)
+}
+
+// IsUnsupportedPaymentData checks the error, and the errors it wraps, for the unsupported payment data code.
+func IsUnsupportedPaymentData(err error) bool {
+	return hasCode(err, EcodeUnsupportedPaymentData)
+}
+
+func hasCode(err error, code stacktrace.ErrorCode) bool {
+	for err != nil {
+		if stacktrace.GetCode(err) == code {
+			return true
+		}
+		if cause := errors.Unwrap(err); cause != nil {
+			err = cause
+		} else if causer, ok := err.(interface{ Cause() error }); ok {
+			err = causer.Cause()
+		} else {
+			return false
+		}
+	}
+	return false
 }
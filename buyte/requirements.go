@@ -0,0 +1,284 @@
+package buyte
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rsoury/applepay"
+
+	"github.com/rsoury/buyte/pkg/afterpay"
+	"github.com/rsoury/buyte/pkg/googlepay"
+	"github.com/rsoury/buyte/pkg/paypal"
+	"github.com/rsoury/buyte/pkg/samsungpay"
+)
+
+// Contact fields a checkout may require of the billing and shipping contacts. Named as Apple Pay names them.
+const (
+	CONTACT_FIELD_NAME           = "name"
+	CONTACT_FIELD_POSTAL_ADDRESS = "postalAddress"
+)
+
+// CheckoutRequirements restrict the wallet payments a checkout accepts.
+// Empty requirements accept any card network, and require no contact details.
+type CheckoutRequirements struct {
+	AllowedCardNetworks    []string `json:"allowedCardNetworks,omitempty"`
+	RequiredBillingFields  []string `json:"requiredBillingFields,omitempty"`
+	RequiredShippingFields []string `json:"requiredShippingFields,omitempty"`
+	RequireEmail           bool     `json:"requireEmail"`
+	RequirePhone           bool     `json:"requirePhone"`
+}
+
+// IsEmpty checks whether the checkout has no requirements configured.
+func (r *CheckoutRequirements) IsEmpty() bool {
+	return len(r.AllowedCardNetworks) == 0 &&
+		len(r.RequiredBillingFields) == 0 &&
+		len(r.RequiredShippingFields) == 0 &&
+		!r.RequireEmail &&
+		!r.RequirePhone
+}
+
+// AllowsCardNetwork checks whether the checkout accepts a card network. Wallets name networks differently. ie. "MasterCard" in Apple Pay, "MASTERCARD" in Google Pay.
+func (r *CheckoutRequirements) AllowsCardNetwork(network string) bool {
+	if len(r.AllowedCardNetworks) == 0 {
+		return true
+	}
+	for _, allowed := range r.AllowedCardNetworks {
+		if normaliseCardNetwork(allowed) == normaliseCardNetwork(network) {
+			return true
+		}
+	}
+	return false
+}
+
+func normaliseCardNetwork(network string) string {
+	return strings.ToLower(strings.Replace(network, " ", "", -1))
+}
+
+// PaymentContacts are the card network and contact details a wallet returned with a payment.
+type PaymentContacts struct {
+	CardNetwork     string
+	EmailAddress    string
+	PhoneNumber     string
+	BillingName     string
+	BillingAddress  *CustomerAddress
+	ShippingName    string
+	ShippingAddress *CustomerAddress
+}
+
+func NewApplePayPaymentContacts(response *applepay.Response) *PaymentContacts {
+	billing := response.BillingContact
+	shipping := response.ShippingContact
+	return &PaymentContacts{
+		CardNetwork:     response.Token.PaymentMethod.Network,
+		EmailAddress:    firstNonEmpty(shipping.EmailAddress, billing.EmailAddress),
+		PhoneNumber:     firstNonEmpty(shipping.PhoneNumber, billing.PhoneNumber),
+		BillingName:     strings.TrimSpace(billing.GivenName + " " + billing.FamilyName),
+		ShippingName:    strings.TrimSpace(shipping.GivenName + " " + shipping.FamilyName),
+		BillingAddress:  applePayContactAddress(billing),
+		ShippingAddress: applePayContactAddress(shipping),
+	}
+}
+
+func NewGooglePayPaymentContacts(response *googlepay.Response) *PaymentContacts {
+	// The billing address is returned with the card, or alongside the payment data in older versions of the API.
+	billing := response.PaymentMethodData.Info.BillingAddress
+	if billing == (googlepay.Address{}) {
+		billing = response.BillingAddress
+	}
+	shipping := response.ShippingAddress
+	return &PaymentContacts{
+		CardNetwork:     response.PaymentMethodData.Info.CardNetwork,
+		EmailAddress:    response.Email,
+		PhoneNumber:     firstNonEmpty(shipping.PhoneNumber, billing.PhoneNumber),
+		BillingName:     billing.Name,
+		ShippingName:    shipping.Name,
+		BillingAddress:  googlePayAddress(billing),
+		ShippingAddress: googlePayAddress(shipping),
+	}
+}
+
+func NewSamsungPayPaymentContacts(response *samsungpay.Response) *PaymentContacts {
+	billing := response.BillingAddress
+	shipping := response.ShippingAddress
+	return &PaymentContacts{
+		CardNetwork:     response.PaymentCredential.CardBrand,
+		EmailAddress:    response.Email,
+		PhoneNumber:     firstNonEmpty(shipping.PhoneNumber, billing.PhoneNumber),
+		BillingName:     billing.Addressee,
+		ShippingName:    shipping.Addressee,
+		BillingAddress:  samsungPayAddress(billing),
+		ShippingAddress: samsungPayAddress(shipping),
+	}
+}
+
+// PayPal payments are not made with a card, so have no card network.
+func NewPayPalPaymentContacts(order *paypal.Order) *PaymentContacts {
+	contacts := &PaymentContacts{
+		BillingName:     order.PayerName(),
+		ShippingAddress: payPalAddress(order.Shipping().Address),
+	}
+	if order.Payer != nil {
+		contacts.EmailAddress = order.Payer.EmailAddress
+		if order.Payer.Phone != nil {
+			contacts.PhoneNumber = order.Payer.Phone.PhoneNumber.NationalNumber
+		}
+		contacts.BillingAddress = payPalAddress(order.Payer.Address)
+	}
+	if name := order.Shipping().Name; name != nil {
+		contacts.ShippingName = name.FullName
+	}
+	return contacts
+}
+
+// Afterpay payments are not made with a card the merchant may restrict, so have no card network.
+func NewAfterpayPaymentContacts(checkout *afterpay.Checkout) *PaymentContacts {
+	contacts := &PaymentContacts{
+		BillingAddress:  afterpayAddress(checkout.Billing),
+		ShippingAddress: afterpayAddress(checkout.Shipping),
+	}
+	if checkout.Consumer != nil {
+		contacts.EmailAddress = checkout.Consumer.Email
+		contacts.PhoneNumber = checkout.Consumer.PhoneNumber
+	}
+	if checkout.Billing != nil {
+		contacts.BillingName = checkout.Billing.Name
+	}
+	if checkout.Shipping != nil {
+		contacts.ShippingName = checkout.Shipping.Name
+		contacts.PhoneNumber = firstNonEmpty(contacts.PhoneNumber, checkout.Shipping.PhoneNumber)
+	}
+	// The consumer is the account holder, and so the billing contact.
+	contacts.BillingName = firstNonEmpty(contacts.BillingName, checkout.ConsumerName())
+	return contacts
+}
+
+func applePayContactAddress(contact applepay.Contact) *CustomerAddress {
+	return &CustomerAddress{
+		AddressLines:       contact.AddressLines,
+		AdministrativeArea: contact.AdministrativeArea,
+		CountryCode:        contact.CountryCode,
+		Locality:           contact.Locality,
+		PostalCode:         contact.PostalCode,
+	}
+}
+
+func googlePayAddress(address googlepay.Address) *CustomerAddress {
+	return &CustomerAddress{
+		AddressLines:       []string{address.Address1, address.Address2, address.Address3},
+		AdministrativeArea: address.AdministrativeArea,
+		CountryCode:        address.CountryCode,
+		Locality:           address.Locality,
+		PostalCode:         address.PostalCode,
+	}
+}
+
+func samsungPayAddress(address samsungpay.Address) *CustomerAddress {
+	return &CustomerAddress{
+		AddressLines:       []string{address.AddressLine1, address.AddressLine2},
+		AdministrativeArea: address.State,
+		CountryCode:        address.CountryCode,
+		Locality:           address.City,
+		PostalCode:         address.PostalCode,
+	}
+}
+
+func payPalAddress(address *paypal.Address) *CustomerAddress {
+	if address == nil {
+		return &CustomerAddress{}
+	}
+	return &CustomerAddress{
+		AddressLines:       []string{address.AddressLine1, address.AddressLine2},
+		AdministrativeArea: address.AdminArea1,
+		CountryCode:        address.CountryCode,
+		Locality:           address.AdminArea2,
+		PostalCode:         address.PostalCode,
+	}
+}
+
+func afterpayAddress(contact *afterpay.Contact) *CustomerAddress {
+	if contact == nil {
+		return &CustomerAddress{}
+	}
+	return &CustomerAddress{
+		AddressLines:       []string{contact.Line1, contact.Line2},
+		AdministrativeArea: contact.Region,
+		CountryCode:        contact.CountryCode,
+		Locality:           contact.Area1,
+		PostalCode:         contact.Postcode,
+	}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// A postal address needs at least an address line and a country to be delivered to.
+func isCompleteAddress(address *CustomerAddress) bool {
+	if address == nil || address.CountryCode == "" {
+		return false
+	}
+	for _, line := range address.AddressLines {
+		if strings.TrimSpace(line) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// RequirementsError lists each way a payment did not meet its checkout's requirements.
+type RequirementsError struct {
+	Violations []string
+}
+
+func (e *RequirementsError) Error() string {
+	return "Payment does not meet the checkout's requirements: " + strings.Join(e.Violations, ", ")
+}
+
+// IsRequirementsError checks whether a payment was rejected for not meeting its checkout's requirements.
+func IsRequirementsError(err error) bool {
+	var requirementsErr *RequirementsError
+	return errors.As(err, &requirementsErr)
+}
+
+// Check checks a wallet payment meets the requirements, returning a RequirementsError if not.
+func (r *CheckoutRequirements) Check(contacts *PaymentContacts) error {
+	var violations []string
+	// Payments without a card, ie. PayPal and Afterpay, are not restricted by card network.
+	if contacts.CardNetwork != "" && !r.AllowsCardNetwork(contacts.CardNetwork) {
+		violations = append(violations, "card network "+contacts.CardNetwork+" is not accepted")
+	}
+	if r.RequireEmail && contacts.EmailAddress == "" {
+		violations = append(violations, "email address is required")
+	}
+	if r.RequirePhone && contacts.PhoneNumber == "" {
+		violations = append(violations, "phone number is required")
+	}
+	violations = append(violations, checkContactFields("billing", r.RequiredBillingFields, contacts.BillingName, contacts.BillingAddress)...)
+	violations = append(violations, checkContactFields("shipping", r.RequiredShippingFields, contacts.ShippingName, contacts.ShippingAddress)...)
+	if len(violations) > 0 {
+		return &RequirementsError{Violations: violations}
+	}
+	return nil
+}
+
+func checkContactFields(contact string, fields []string, name string, address *CustomerAddress) []string {
+	var violations []string
+	for _, field := range fields {
+		switch field {
+		case CONTACT_FIELD_NAME:
+			if name == "" {
+				violations = append(violations, contact+" name is required")
+			}
+		case CONTACT_FIELD_POSTAL_ADDRESS:
+			if !isCompleteAddress(address) {
+				violations = append(violations, contact+" postal address is required")
+			}
+		}
+	}
+	return violations
+}
@@ -0,0 +1,173 @@
+package buyte
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/rsoury/applepay"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rsoury/buyte/pkg/afterpay"
+	"github.com/rsoury/buyte/pkg/googlepay"
+	"github.com/rsoury/buyte/pkg/paypal"
+)
+
+const applePayContactsResponse = `{
+	"billingContact": {
+		"givenName": "Jane",
+		"familyName": "Citizen",
+		"addressLines": ["1 George St"],
+		"locality": "Sydney",
+		"postalCode": "2000",
+		"countryCode": "AU"
+	},
+	"shippingContact": {
+		"emailAddress": "jane@example.com",
+		"phoneNumber": "+61400000000"
+	},
+	"token": {
+		"paymentMethod": { "network": "MasterCard" }
+	}
+}`
+
+const googlePayContactsResponse = `{
+	"email": "jane@example.com",
+	"paymentMethodData": {
+		"info": {
+			"cardNetwork": "AMEX",
+			"billingAddress": { "name": "Jane Citizen", "countryCode": "AU", "postalCode": "2000" }
+		}
+	}
+}`
+
+const payPalContactsOrder = `{
+	"id": "5O190127TN364715T",
+	"status": "APPROVED",
+	"payer": {
+		"email_address": "jane@example.com",
+		"name": { "given_name": "Jane", "surname": "Citizen" },
+		"address": { "country_code": "AU" }
+	},
+	"purchase_units": [{
+		"amount": { "currency_code": "AUD", "value": "32.00" },
+		"shipping": {
+			"name": { "full_name": "John Citizen" },
+			"address": { "address_line_1": "1 George St", "admin_area_2": "Sydney", "admin_area_1": "NSW", "postal_code": "2000", "country_code": "AU" }
+		}
+	}]
+}`
+
+func TestCheckRequirementsApplePay(t *testing.T) {
+	assert := assert.New(t)
+	response := &applepay.Response{}
+	if err := json.Unmarshal([]byte(applePayContactsResponse), response); err != nil {
+		t.Fatal(err)
+	}
+	contacts := NewApplePayPaymentContacts(response)
+
+	requirements := &CheckoutRequirements{
+		AllowedCardNetworks:   []string{"visa", "MASTERCARD"},
+		RequiredBillingFields: []string{CONTACT_FIELD_NAME, CONTACT_FIELD_POSTAL_ADDRESS},
+		RequireEmail:          true,
+		RequirePhone:          true,
+	}
+	assert.NoError(requirements.Check(contacts))
+
+	requirements.AllowedCardNetworks = []string{"Visa"}
+	requirements.RequiredShippingFields = []string{CONTACT_FIELD_POSTAL_ADDRESS}
+	err := requirements.Check(contacts)
+	if assert.True(IsRequirementsError(err)) {
+		assert.Equal([]string{
+			"card network MasterCard is not accepted",
+			"shipping postal address is required",
+		}, err.(*RequirementsError).Violations)
+	}
+}
+
+func TestCheckRequirementsGooglePay(t *testing.T) {
+	assert := assert.New(t)
+	response := &googlepay.Response{}
+	if err := json.Unmarshal([]byte(googlePayContactsResponse), response); err != nil {
+		t.Fatal(err)
+	}
+	contacts := NewGooglePayPaymentContacts(response)
+
+	assert.NoError((&CheckoutRequirements{}).Check(contacts))
+	assert.NoError((&CheckoutRequirements{
+		AllowedCardNetworks:   []string{"amex"},
+		RequiredBillingFields: []string{CONTACT_FIELD_NAME},
+		RequireEmail:          true,
+	}).Check(contacts))
+
+	// The billing address has no address lines.
+	err := (&CheckoutRequirements{
+		RequiredBillingFields: []string{CONTACT_FIELD_POSTAL_ADDRESS},
+		RequirePhone:          true,
+	}).Check(contacts)
+	if assert.True(IsRequirementsError(err)) {
+		assert.Equal([]string{
+			"phone number is required",
+			"billing postal address is required",
+		}, err.(*RequirementsError).Violations)
+	}
+}
+
+func TestCheckRequirementsPayPal(t *testing.T) {
+	assert := assert.New(t)
+	order := &paypal.Order{}
+	if err := json.Unmarshal([]byte(payPalContactsOrder), order); err != nil {
+		t.Fatal(err)
+	}
+	contacts := NewPayPalPaymentContacts(order)
+
+	// PayPal payments have no card network to restrict.
+	assert.NoError((&CheckoutRequirements{
+		AllowedCardNetworks:    []string{"visa"},
+		RequiredBillingFields:  []string{CONTACT_FIELD_NAME},
+		RequiredShippingFields: []string{CONTACT_FIELD_NAME, CONTACT_FIELD_POSTAL_ADDRESS},
+		RequireEmail:           true,
+	}).Check(contacts))
+
+	err := (&CheckoutRequirements{
+		RequiredBillingFields: []string{CONTACT_FIELD_POSTAL_ADDRESS},
+		RequirePhone:          true,
+	}).Check(contacts)
+	if assert.True(IsRequirementsError(err)) {
+		assert.Equal([]string{
+			"phone number is required",
+			"billing postal address is required",
+		}, err.(*RequirementsError).Violations)
+	}
+
+	customer := NewPayPalCustomer(order)
+	assert.Equal("Jane Citizen", customer.Name)
+	assert.Equal("jane@example.com", customer.EmailAddress)
+	assert.Equal("Sydney", customer.ShippingAddress.Locality)
+	assert.Equal("NSW", customer.ShippingAddress.AdministrativeArea)
+}
+
+func TestCheckRequirementsAfterpay(t *testing.T) {
+	assert := assert.New(t)
+	checkout := &afterpay.Checkout{
+		Consumer: &afterpay.Consumer{GivenNames: "Jane", Surname: "Citizen", Email: "jane@example.com"},
+		Shipping: &afterpay.Contact{Name: "John Citizen", Line1: "1 George St", Area1: "Sydney", Postcode: "2000", CountryCode: "AU", PhoneNumber: "0400000000"},
+	}
+	contacts := NewAfterpayPaymentContacts(checkout)
+
+	assert.NoError((&CheckoutRequirements{
+		AllowedCardNetworks:    []string{"visa"},
+		RequiredBillingFields:  []string{CONTACT_FIELD_NAME},
+		RequiredShippingFields: []string{CONTACT_FIELD_NAME, CONTACT_FIELD_POSTAL_ADDRESS},
+		RequireEmail:           true,
+		RequirePhone:           true,
+	}).Check(contacts))
+	assert.True(IsRequirementsError((&CheckoutRequirements{
+		RequiredBillingFields: []string{CONTACT_FIELD_POSTAL_ADDRESS},
+	}).Check(contacts)))
+
+	customer := NewAfterpayCustomer(checkout)
+	assert.Equal("Jane Citizen", customer.Name)
+	assert.Equal("0400000000", customer.PhoneNumber)
+	assert.Equal("Sydney", customer.ShippingAddress.Locality)
+	assert.Nil(customer.BillingAddress)
+}
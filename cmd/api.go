@@ -1,14 +1,14 @@
 package cmd
 
 import (
-	"errors"
-
+	"github.com/pkg/errors"
 	cli "github.com/spf13/cobra"
 	config "github.com/spf13/viper"
 	"go.uber.org/zap"
 
 	"github.com/rsoury/buyte/buyte"
 	"github.com/rsoury/buyte/conf"
+	"github.com/rsoury/buyte/pkg/envelope"
 	"github.com/rsoury/buyte/server"
 	"github.com/rsoury/buyte/store/graphql"
 )
@@ -47,7 +47,19 @@ func StartApi(cmd *cli.Command, args []string) {
 			)
 		}
 		// Despite being the Graphql Client type, it satisfies the store interface
-		store = graphql.New()
+		client := graphql.New()
+		sealer, err := envelope.New()
+		if err != nil {
+			logger.Fatalw("Could not start server",
+				"error", errors.Wrap(err, "Could not setup payment token encryption"),
+			)
+		}
+		if sealer != nil {
+			client.EncryptPaymentTokens(sealer)
+		} else if config.GetBool("server.production") {
+			logger.Warnw("Payment token values are stored unencrypted. Set 'tokens.encryption.provider' to encrypt them.")
+		}
+		store = client
 	default:
 		logger.Fatalw("Could not start server",
 			"error", errors.New("Invalid 'storage.type'"),
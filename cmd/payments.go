@@ -67,7 +67,7 @@ var paymentsAddCmd = &cli.Command{
 	Long: `
 		A method to quickly add a Payment Option to Buyte.
 
-		ie. APPLE_PAY or GOOGLE_PAY
+		ie. "Apple Pay", "Google Pay", "Samsung Pay", "PayPal" or "Afterpay"
 	`,
 	Run: func(cmd *cli.Command, args []string) {
 		s := spinner.New(spinner.CharSets[11], 100*time.Millisecond)
@@ -101,7 +101,7 @@ var paymentsDeleteCmd = &cli.Command{
 	Long: `
 		A method to quickly delete a Payment Option from Buyte.
 
-		ie. APPLE_PAY or GOOGLE_PAY
+		ie. "Apple Pay", "Google Pay", "Samsung Pay", "PayPal" or "Afterpay"
 	`,
 	Run: func(cmd *cli.Command, args []string) {
 		s := spinner.New(spinner.CharSets[11], 100*time.Millisecond)
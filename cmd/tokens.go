@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	cognito "github.com/aws/aws-sdk-go/service/cognitoidentityprovider"
+	"github.com/briandowns/spinner"
+	"github.com/logrusorgru/aurora"
+	"github.com/pkg/errors"
+	cli "github.com/spf13/cobra"
+	config "github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/conf"
+	"github.com/rsoury/buyte/pkg/envelope"
+	"github.com/rsoury/buyte/pkg/sweeper"
+	"github.com/rsoury/buyte/pkg/user"
+	store "github.com/rsoury/buyte/store/graphql"
+)
+
+type TokensCommand struct {
+	AWSConfig       *buyte.AWSConfig
+	User            *user.SuperUser
+	AuthAccessToken *string
+}
+
+// tokensCmd represents the tokens command
+var tokensCmd = &cli.Command{
+	Use:   "tokens",
+	Short: "Manage Buyte Payment Tokens",
+}
+
+var tokensSweepCmd = &cli.Command{
+	Use:   "sweep",
+	Short: "Purge the payment data of expired Payment Tokens",
+	Long: `
+		Purges the payment data of every payment token that has expired, keeping the token's metadata for the charges made against it.
+
+		With --watch, sweeps at each interval until interrupted. ie. buyte tokens sweep --watch --interval 15m
+	`,
+	Run: func(cmd *cli.Command, args []string) {
+		email, _ := cmd.Flags().GetString("email")
+		password, _ := cmd.Flags().GetString("password")
+		watch, _ := cmd.Flags().GetBool("watch")
+
+		awsConfig := NewAWSConfigFromCmd(cmd)
+		superUser := &user.SuperUser{
+			Username: email,
+			Password: password,
+		}
+		if !watch {
+			command, err := NewTokensCommand(awsConfig, superUser)
+			if err != nil {
+				zap.S().Fatal(errors.Wrap(err, "Cannot create command and authenticate with user"))
+			}
+			purged, err := command.Sweep()
+			if err != nil {
+				zap.S().Fatal(errors.Wrap(err, "Cannot sweep expired payment tokens"))
+			}
+			fmt.Println(aurora.Green(fmt.Sprintf("%d expired payment tokens have been purged!", purged)))
+			return
+		}
+
+		// Access tokens expire, so the super user authenticates for each sweep.
+		conf.InitSignalHandler()
+		ticker := time.NewTicker(config.GetDuration("tokens.sweeper.interval"))
+		defer ticker.Stop()
+		for {
+			command, err := NewTokensCommand(awsConfig, superUser)
+			if err != nil {
+				zap.S().Errorw("Sweep", "error", errors.Wrap(err, "Cannot authenticate with user"))
+			} else if _, err := command.Sweep(); err != nil {
+				zap.S().Errorw("Sweep", "error", err)
+			}
+			select {
+			case <-conf.Stop.Chan():
+				return
+			case <-ticker.C:
+			}
+		}
+	},
+}
+
+var tokensRotateCmd = &cli.Command{
+	Use:   "rotate",
+	Short: "Re-encrypt Payment Token values with the current encryption key",
+	Long: `
+		Re-wraps the data key of every payment token with the current key of "tokens.encryption.provider", and encrypts values stored before encryption was enabled.
+
+		Keys are rotated by adding a new key, ie. a newer file key or a new "tokens.encryption.key_id", and running rotate while the previous key is still available.
+	`,
+	Run: func(cmd *cli.Command, args []string) {
+		s := spinner.New(spinner.CharSets[11], 100*time.Millisecond)
+		s.Start()
+		defer func() {
+			s.Stop()
+		}()
+
+		email, _ := cmd.Flags().GetString("email")
+		password, _ := cmd.Flags().GetString("password")
+
+		command, err := NewTokensCommand(NewAWSConfigFromCmd(cmd), &user.SuperUser{
+			Username: email,
+			Password: password,
+		})
+		if err != nil {
+			zap.S().Fatal(errors.Wrap(err, "Cannot create command and authenticate with user"))
+		}
+
+		command.Rotate()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(tokensCmd)
+
+	AssignAWSFlags(tokensCmd)
+
+	userEnvConfig := user.NewSuperUserEnvConfig()
+	tokensCmd.PersistentFlags().StringP("email", "e", userEnvConfig.Username, "The User Username/Email.")
+	tokensCmd.PersistentFlags().StringP("password", "p", userEnvConfig.Password, "The User Password.")
+
+	tokensCmd.AddCommand(tokensSweepCmd)
+	tokensCmd.AddCommand(tokensRotateCmd)
+
+	tokensSweepCmd.PersistentFlags().Bool("watch", false, "Keep sweeping at each interval until interrupted")
+	tokensSweepCmd.PersistentFlags().Duration("interval", config.GetDuration("tokens.sweeper.interval"), "How often to sweep with --watch")
+	_ = config.BindPFlag("tokens.sweeper.interval", tokensSweepCmd.PersistentFlags().Lookup("interval"))
+}
+
+func NewTokensCommand(awsConfig *buyte.AWSConfig, user *user.SuperUser) (*TokensCommand, error) {
+	// Authenticate with Cognito
+	sess, _ := session.NewSession(
+		&aws.Config{Region: aws.String(awsConfig.Region)},
+	)
+	svc := cognito.New(sess)
+
+	authParameters := map[string]*string{
+		"USERNAME": aws.String(user.Username),
+		"PASSWORD": aws.String(user.Password),
+	}
+
+	input := &cognito.AdminInitiateAuthInput{
+		ClientId:   &awsConfig.CognitoClientId,
+		UserPoolId: &awsConfig.CognitoUserPoolId,
+		AuthFlow:   aws.String("ADMIN_USER_PASSWORD_AUTH"),
+	}
+	input.SetAuthParameters(authParameters)
+
+	auth, err := svc.AdminInitiateAuth(input)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokensCommand{
+		AWSConfig:       awsConfig,
+		User:            user,
+		AuthAccessToken: auth.AuthenticationResult.AccessToken,
+	}, nil
+}
+
+// Sweep purges expired tokens as the super user, returning the number purged.
+func (c *TokensCommand) Sweep() (int, error) {
+	ctx := (&user.User{AccessToken: *c.AuthAccessToken}).WithContext(context.Background())
+	return sweeper.New(store.New(), config.GetInt("tokens.sweeper.batch")).Sweep(ctx)
+}
+
+// Rotate re-encrypts every payment token's value with the current key, as the super user.
+func (c *TokensCommand) Rotate() {
+	sealer, err := envelope.New()
+	if err != nil {
+		zap.S().Fatal(errors.Wrap(err, "Cannot setup payment token encryption"))
+	}
+	if sealer == nil {
+		zap.S().Fatal(errors.New("'tokens.encryption.provider' is required to rotate payment tokens"))
+	}
+	client := store.New()
+	client.EncryptPaymentTokens(sealer)
+
+	ctx := (&user.User{AccessToken: *c.AuthAccessToken}).WithContext(context.Background())
+	rotated, err := client.RotatePaymentTokens(ctx, config.GetInt("tokens.sweeper.batch"))
+	if err != nil {
+		zap.S().Fatal(errors.Wrap(err, "Cannot rotate payment tokens"))
+	}
+	fmt.Println(aurora.Green(fmt.Sprintf("%d payment tokens have been re-encrypted!", rotated)))
+}
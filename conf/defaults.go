@@ -41,17 +41,60 @@ func init() {
 	// Database Settings
 	config.SetDefault("storage.type", "graphql")
 	config.SetDefault("storage.endpoint", "")
+	config.SetDefault("storage.api_key", "") // For unauthenticated reads, ie. Apple Pay domain association files
 
 	// Merchant Settings -- Apple Pay
 	config.SetDefault("apple.merchant.id", "merchant.com.buytecheckout")
 	config.SetDefault("apple.merchant.name", "Buyte Apple Pay Checkout")
 	config.SetDefault("apple.merchant.domain", "go.buytecheckout.com")
 	config.SetDefault("apple.certs", "")
+	config.SetDefault("apple.processing.certs", "") // Glob of processing certificates. Defaults to certs/cert-processing*.crt
+	config.SetDefault("apple.processing.expiry_warning", "720h")
+	config.SetDefault("apple.merchant.domain_association", "") // Defaults to certs/apple-developer-merchantid-domain-association
+	config.SetDefault("apple.registrar.type", "apple")         // "local" to record merchant domains with a stand-in during development
+	config.SetDefault("apple.registrar.endpoint", "https://apple-pay-gateway-cert.apple.com/paymentservices/registerMerchant")
+	config.SetDefault("apple.merchants.cache_ttl", "10m") // How long merchant owned Apple Pay certificates are cached
+	config.SetDefault("apple.session.hosts", []string{})  // Merchant validation hosts Apple Pay sessions may be requested from. Defaults to Apple's published hosts
+	config.SetDefault("apple.root.path", "")
+	config.SetDefault("apple.root.fingerprint", "63343abfb89a6a03ebb57e9b3f5fa7be7c4f5c756f3017b3a8c488c3653e9179") // Apple Root CA - G3
+	config.SetDefault("apple.verification.window", "5m")
+	config.SetDefault("apple.verification.sandbox", false)
+
+	// Secrets Store -- Merchant owned certificates. "secretsmanager" or "file"
+	config.SetDefault("secrets.type", "")
+	config.SetDefault("secrets.region", "ap-southeast-2")
+	config.SetDefault("secrets.prefix", "buyte/")
+	config.SetDefault("secrets.dir", "")
 
 	// Merchant Settings -- Google Pay
 	config.SetDefault("google.merchant.id", "05174216476243863888")
 	config.SetDefault("google.merchant.name", "Buyte Google Pay Checkout")
 	config.SetDefault("google.merchant.domain", "go.buytecheckout.com")
+	config.SetDefault("google.merchant.keys", "") // Glob of merchant private keys for DIRECT tokenization. Defaults to certs/google-merchant*-key.pem
+	config.SetDefault("google.root.path", "")     // Defaults to certs/google-root-signing-keys.json
+	config.SetDefault("google.tokenization.direct", false)
+
+	// Merchant Settings -- Samsung Pay
+	config.SetDefault("samsung.merchant.keys", "") // Glob of merchant private keys. Defaults to certs/samsung-merchant*-key.pem
+
+	// Payment Tokens -- How long tokens may be charged for, by the payment method's path. ie. tokens.ttl.applepay
+	config.SetDefault("tokens.ttl.default", "1h")
+	config.SetDefault("tokens.ttl.applepay", "5m") // Apple Pay tokens are only verified within apple.verification.window of being signed
+	config.SetDefault("tokens.ttl.googlepay", "1h")
+	config.SetDefault("tokens.ttl.samsungpay", "1h")
+	config.SetDefault("tokens.ttl.paypal", "3h") // Approved PayPal orders are only captured within 3 hours
+	config.SetDefault("tokens.ttl.afterpay", "1h")
+	// Expired tokens have their payment data purged by "buyte tokens sweep"
+	config.SetDefault("tokens.sweeper.interval", "15m")
+	config.SetDefault("tokens.sweeper.batch", 100)
+	// Payment Token Encryption -- "kms" or "file". Values are stored unencrypted when unset.
+	config.SetDefault("tokens.encryption.provider", "")
+	config.SetDefault("tokens.encryption.key_id", "") // The KMS key, or file key, data keys are wrapped with. Defaults to the last file key
+	config.SetDefault("tokens.encryption.keys", "")   // Glob of base64 encoded key files for the file provider
+	config.SetDefault("tokens.encryption.region", "ap-southeast-2")
+
+	// Tax -- Calculated for where orders ship to, with the built-in rates unless "tax.rates" is set. See tax.DefaultRates
+	config.SetDefault("tax.enabled", false)
 
 	// Lambda Functions Settings
 	config.SetDefault("func.region", "ap-southeast-2")
@@ -69,4 +112,34 @@ func init() {
 	config.SetDefault("stripe.live.public", "")
 	config.SetDefault("stripe.test.secret", "")
 	config.SetDefault("stripe.test.public", "")
+
+	// Braintree Settings
+	config.SetDefault("braintree.live.endpoint", "https://payments.braintree-api.com/graphql")
+	config.SetDefault("braintree.test.endpoint", "https://payments.sandbox.braintree-api.com/graphql")
+
+	// Checkout.com Settings
+	config.SetDefault("checkoutcom.live.endpoint", "https://api.checkout.com")
+	config.SetDefault("checkoutcom.test.endpoint", "https://api.sandbox.checkout.com")
+
+	// Square Settings
+	config.SetDefault("square.live.endpoint", "https://connect.squareup.com")
+	config.SetDefault("square.test.endpoint", "https://connect.squareupsandbox.com")
+	config.SetDefault("square.version", "2021-09-15")
+
+	// PayPal Settings
+	config.SetDefault("paypal.live.endpoint", "https://api-m.paypal.com")
+	config.SetDefault("paypal.test.endpoint", "https://api-m.sandbox.paypal.com")
+
+	// Afterpay Settings
+	config.SetDefault("afterpay.live.endpoint", "https://global-api.afterpay.com")
+	config.SetDefault("afterpay.test.endpoint", "https://global-api-sandbox.afterpay.com")
+	// How long each connection's order total limits are cached for.
+	config.SetDefault("afterpay.configuration.cache_ttl", "1h")
+
+	// Payment Gateway Transport Settings
+	config.SetDefault("gateway.timeout", "10s")
+	config.SetDefault("gateway.retries", 2)
+	config.SetDefault("gateway.backoff", "250ms")
+	config.SetDefault("gateway.breaker.threshold", 5)
+	config.SetDefault("gateway.breaker.cooldown", "30s")
 }
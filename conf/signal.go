@@ -4,6 +4,7 @@ import (
 	"os"
 	"os/signal"
 	"sync"
+	"syscall"
 
 	"go.uber.org/zap"
 )
@@ -22,19 +23,41 @@ var (
 	}
 	// Handle signals
 	signalChannel = make(chan os.Signal, 1)
+	// Functions to run on SIGHUP
+	reloaders   []func()
+	reloadersMu sync.Mutex
 )
 
+// OnReload registers a function to run when the process receives SIGHUP. ie. To reload certificates.
+func OnReload(reload func()) {
+	reloadersMu.Lock()
+	defer reloadersMu.Unlock()
+	reloaders = append(reloaders, reload)
+}
+
+// Reload runs all registered reload functions.
+func Reload() {
+	reloadersMu.Lock()
+	defer reloadersMu.Unlock()
+	for _, reload := range reloaders {
+		reload()
+	}
+}
+
 // Handles all incoming signals
 func InitSignalHandler() {
 
 	// Stop flag will indicate if Ctrl-C/Interrupt has been sent to the process
-	signal.Notify(signalChannel, os.Interrupt)
+	signal.Notify(signalChannel, os.Interrupt, syscall.SIGHUP)
 
 	// Handle signals
 	go func() {
 		for {
 			for sig := range signalChannel {
 				switch sig {
+				case syscall.SIGHUP:
+					zap.S().Info("Received SIGHUP. Reloading...")
+					Reload()
 				case os.Interrupt:
 					zap.S().Info("Received Interrupt...")
 					close(Stop.c)
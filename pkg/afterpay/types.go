@@ -0,0 +1,126 @@
+// Package afterpay holds the Afterpay Online API resources an Afterpay payment is recorded with.
+// Customers are redirected to Afterpay to confirm a checkout, then returned to the merchant with the checkout's token.
+// See https://developers.afterpay.com/afterpay-online/reference
+package afterpay
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/rsoury/buyte/pkg/util"
+)
+
+// Statuses Afterpay appends to the return URL, as the status query parameter.
+const (
+	StatusSuccess   = "SUCCESS"
+	StatusCancelled = "CANCELLED"
+)
+
+// Payment statuses.
+const (
+	PaymentApproved = "APPROVED"
+	PaymentDeclined = "DECLINED"
+)
+
+// Return is the query Afterpay redirects the customer back to the merchant with. ie. ?orderToken=002.xxx&status=SUCCESS
+type Return struct {
+	OrderToken string `json:"orderToken"`
+	Status     string `json:"status"`
+}
+
+// Money is a decimal amount in the currency's major unit. ie. "10.00"
+type Money struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// Checkout is an Afterpay checkout, as created by Buyte and confirmed by the customer on Afterpay.
+type Checkout struct {
+	Token               string    `json:"token"`
+	Expires             string    `json:"expires,omitempty"`
+	RedirectCheckoutURL string    `json:"redirectCheckoutUrl,omitempty"`
+	Amount              Money     `json:"amount"`
+	Consumer            *Consumer `json:"consumer,omitempty"`
+	Billing             *Contact  `json:"billing,omitempty"`
+	Shipping            *Contact  `json:"shipping,omitempty"`
+	MerchantReference   string    `json:"merchantReference,omitempty"`
+}
+
+type Consumer struct {
+	PhoneNumber string `json:"phoneNumber,omitempty"`
+	GivenNames  string `json:"givenNames,omitempty"`
+	Surname     string `json:"surname,omitempty"`
+	Email       string `json:"email,omitempty"`
+}
+
+type Contact struct {
+	Name  string `json:"name,omitempty"`
+	Line1 string `json:"line1,omitempty"`
+	Line2 string `json:"line2,omitempty"`
+	// Suburb or city
+	Area1 string `json:"area1,omitempty"`
+	// State, province or region
+	Region      string `json:"region,omitempty"`
+	Postcode    string `json:"postcode,omitempty"`
+	CountryCode string `json:"countryCode,omitempty"`
+	PhoneNumber string `json:"phoneNumber,omitempty"`
+}
+
+type Payment struct {
+	ID             string `json:"id"`
+	Token          string `json:"token"`
+	Status         string `json:"status"`
+	OriginalAmount Money  `json:"originalAmount"`
+}
+
+// Configuration is the range of order totals the merchant's Afterpay account accepts.
+type Configuration struct {
+	MinimumAmount *Money `json:"minimumAmount,omitempty"`
+	MaximumAmount Money  `json:"maximumAmount"`
+}
+
+// Limits are the order totals Afterpay accepts, in the currency's minor unit.
+type Limits struct {
+	Currency      string `json:"currency"`
+	MinimumAmount int    `json:"minimumAmount"`
+	MaximumAmount int    `json:"maximumAmount"`
+}
+
+// ConsumerName returns the consumer's full name.
+func (c *Checkout) ConsumerName() string {
+	if c.Consumer == nil {
+		return ""
+	}
+	return strings.TrimSpace(c.Consumer.GivenNames + " " + c.Consumer.Surname)
+}
+
+// HasAmount checks the checkout is for the amount given as formatted for Afterpay, in the currency.
+func (c *Checkout) HasAmount(value string, currency string) bool {
+	return c.Amount.Amount == value && strings.EqualFold(c.Amount.Currency, currency)
+}
+
+// Limits converts the configured range to the currency's minor unit. Accounts without a minimum accept any order up to the maximum.
+func (c *Configuration) Limits() (*Limits, error) {
+	currency := c.MaximumAmount.Currency
+	maximum, err := util.ParseAmount(c.MaximumAmount.Amount, currency)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not read maximum amount")
+	}
+	limits := &Limits{
+		Currency:      strings.ToUpper(currency),
+		MaximumAmount: maximum,
+	}
+	if c.MinimumAmount != nil {
+		limits.MinimumAmount, err = util.ParseAmount(c.MinimumAmount.Amount, currency)
+		if err != nil {
+			return nil, errors.Wrap(err, "Could not read minimum amount")
+		}
+	}
+	return limits, nil
+}
+
+// Allows checks an order total is eligible for Afterpay.
+func (l *Limits) Allows(amount int, currency string) bool {
+	return strings.EqualFold(l.Currency, currency) && amount >= l.MinimumAmount && amount <= l.MaximumAmount
+}
@@ -0,0 +1,214 @@
+// Package applepaydomain registers merchant domains for Apple Pay on the web.
+// Domains using Buyte's Apple Pay identity are registered through Apple's Platform Integration API.
+// See https://developer.apple.com/documentation/applepaywebmerchantregistrationapi
+package applepaydomain
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	config "github.com/spf13/viper"
+)
+
+// AssociationPath is where Apple requests the domain association file from.
+const AssociationPath = "/.well-known/apple-developer-merchantid-domain-association"
+
+// VerificationPath is where merchants serve their verification token, proving they own a domain using their own Apple Pay identity.
+const VerificationPath = "/.well-known/buyte-domain-verification"
+
+var (
+	ErrInvalidDomain = errors.New("Invalid domain. Please provide a publicly accessible hostname, ie. shop.example.com")
+	ErrUnverified    = errors.New("Domain ownership could not be verified")
+
+	hostnameRegex = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+)
+
+// Normalise validates a domain and returns it lowercased, without a scheme, port or path.
+func Normalise(domain string) (string, error) {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	domain = strings.TrimPrefix(domain, "https://")
+	domain = strings.TrimPrefix(domain, "http://")
+	if i := strings.IndexAny(domain, "/?#"); i >= 0 {
+		domain = domain[:i]
+	}
+	if host, _, err := net.SplitHostPort(domain); err == nil {
+		domain = host
+	}
+	domain = strings.TrimSuffix(domain, ".")
+	if net.ParseIP(domain) != nil || !hostnameRegex.MatchString(domain) {
+		return "", ErrInvalidDomain
+	}
+	return domain, nil
+}
+
+// Registration describes a merchant's domains to register against Buyte's Apple Pay identity.
+type Registration struct {
+	Domains []string `json:"domainNames"`
+	// Buyte's identifier for the merchant. ie. The user id.
+	PartnerMerchantIdentifier string `json:"partnerInternalMerchantIdentifier"`
+	PartnerMerchantName       string `json:"partnerMerchantName"`
+	MerchantURL               string `json:"merchantUrl,omitempty"`
+	// The merchant identifier tokens are encrypted to. ie. Buyte's.
+	EncryptTo string `json:"encryptTo"`
+}
+
+// Registrar registers merchant domains with Apple.
+type Registrar interface {
+	Register(ctx context.Context, registration *Registration) error
+}
+
+// NewRegistrar creates the registrar set by "apple.registrar.type"
+func NewRegistrar(merchantCertificate *tls.Certificate) (Registrar, error) {
+	switch config.GetString("apple.registrar.type") {
+	case "apple":
+		if merchantCertificate == nil {
+			return nil, errors.New("Registering domains with Apple requires the merchant identity certificate")
+		}
+		return NewAppleRegistrar(config.GetString("apple.registrar.endpoint"), *merchantCertificate), nil
+	case "local":
+		if config.GetBool("server.production") {
+			return nil, errors.New("Domains cannot be registered with a local stand-in in production")
+		}
+		return NewLocalRegistrar(), nil
+	}
+	return nil, errors.New("Invalid 'apple.registrar.type'")
+}
+
+// AppleRegistrar registers domains through Apple's Platform Integration API, authenticating with Buyte's merchant identity certificate.
+type AppleRegistrar struct {
+	Endpoint string
+	Client   *http.Client
+}
+
+func NewAppleRegistrar(endpoint string, merchantCertificate tls.Certificate) *AppleRegistrar {
+	return &AppleRegistrar{
+		Endpoint: endpoint,
+		Client: &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{
+					Certificates: []tls.Certificate{merchantCertificate},
+				},
+			},
+		},
+	}
+}
+
+func (a *AppleRegistrar) Register(ctx context.Context, registration *Registration) error {
+	body, err := json.Marshal(registration)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "Could not reach Apple Pay domain registration")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return errors.Errorf("Apple Pay domain registration failed with status %d: %s", resp.StatusCode, respBody)
+	}
+	return nil
+}
+
+// Verifier checks a merchant controls a domain, before Buyte hosts their association file for it.
+type Verifier interface {
+	Verify(ctx context.Context, domain string, token string) error
+}
+
+// VerificationToken is the token a merchant serves from their domain, proving they own it.
+// Derived from the merchant and domain, it needs no secret, as only the domain's owner can serve it.
+func VerificationToken(merchant string, domain string) string {
+	sum := sha256.Sum256([]byte(merchant + ":" + domain))
+	return hex.EncodeToString(sum[:])
+}
+
+// HTTPVerifier requests the verification token from the domain over HTTPS.
+type HTTPVerifier struct {
+	Client *http.Client
+}
+
+func NewHTTPVerifier() *HTTPVerifier {
+	return &HTTPVerifier{
+		Client: &http.Client{
+			Timeout: 10 * time.Second,
+			// The token must be served by the domain itself.
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+func (v *HTTPVerifier) Verify(ctx context.Context, domain string, token string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+domain+VerificationPath, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		return errors.Wrap(ErrUnverified, err.Error())
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return errors.Wrap(ErrUnverified, err.Error())
+	}
+	if resp.StatusCode != http.StatusOK || token == "" || strings.TrimSpace(string(body)) != token {
+		return ErrUnverified
+	}
+	return nil
+}
+
+// LocalRegistrar stands in for Apple during development and testing, recording registrations.
+type LocalRegistrar struct {
+	// Returned from Register when set.
+	Err error
+
+	mu         sync.Mutex
+	registered map[string]*Registration
+}
+
+func NewLocalRegistrar() *LocalRegistrar {
+	return &LocalRegistrar{
+		registered: map[string]*Registration{},
+	}
+}
+
+func (l *LocalRegistrar) Register(ctx context.Context, registration *Registration) error {
+	if l.Err != nil {
+		return l.Err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, domain := range registration.Domains {
+		l.registered[domain] = registration
+	}
+	return nil
+}
+
+// Registered returns the registration for a domain, if any.
+func (l *LocalRegistrar) Registered(domain string) (*Registration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	registration, ok := l.registered[domain]
+	return registration, ok
+}
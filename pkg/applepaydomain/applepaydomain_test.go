@@ -0,0 +1,119 @@
+package applepaydomain
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	config "github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalise(t *testing.T) {
+	valid := map[string]string{
+		"shop.example.com":                "shop.example.com",
+		"  Shop.Example.COM ":             "shop.example.com",
+		"https://shop.example.com/cart?x": "shop.example.com",
+		"shop.example.com:443":            "shop.example.com",
+		"shop.example.com.":               "shop.example.com",
+	}
+	for input, expected := range valid {
+		domain, err := Normalise(input)
+		if assert.NoError(t, err, input) {
+			assert.Equal(t, expected, domain)
+		}
+	}
+
+	invalid := []string{"", "localhost", "127.0.0.1", "[::1]:443", "shop_example.com", "-shop.example.com", "shop..example.com"}
+	for _, input := range invalid {
+		_, err := Normalise(input)
+		assert.Equal(t, ErrInvalidDomain, err, input)
+	}
+}
+
+func TestAppleRegistrar(t *testing.T) {
+	assert := assert.New(t)
+	var received *Registration
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = &Registration{}
+		_ = json.NewDecoder(r.Body).Decode(received)
+		if received.Domains[0] == "unverified.example.com" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"statusMessage":"Domain verification failed"}`))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	registrar := &AppleRegistrar{
+		Endpoint: server.URL,
+		Client:   server.Client(),
+	}
+	err := registrar.Register(context.Background(), &Registration{
+		Domains:                   []string{"shop.example.com"},
+		PartnerMerchantIdentifier: "user_xxx",
+		PartnerMerchantName:       "Test Store",
+		EncryptTo:                 "merchant.com.buytecheckout",
+	})
+	assert.NoError(err)
+	if assert.NotNil(received) {
+		assert.Equal("user_xxx", received.PartnerMerchantIdentifier)
+		assert.Equal("merchant.com.buytecheckout", received.EncryptTo)
+	}
+
+	err = registrar.Register(context.Background(), &Registration{Domains: []string{"unverified.example.com"}})
+	if assert.Error(err) {
+		assert.Contains(err.Error(), "Domain verification failed")
+	}
+}
+
+func TestLocalRegistrar(t *testing.T) {
+	registrar := NewLocalRegistrar()
+	registration := &Registration{Domains: []string{"shop.example.com"}, PartnerMerchantIdentifier: "user_xxx"}
+	assert.NoError(t, registrar.Register(context.Background(), registration))
+
+	registered, ok := registrar.Registered("shop.example.com")
+	assert.True(t, ok)
+	assert.Equal(t, registration, registered)
+	_, ok = registrar.Registered("other.example.com")
+	assert.False(t, ok)
+}
+
+func TestNewRegistrarLocalInProduction(t *testing.T) {
+	registrarType, production := config.GetString("apple.registrar.type"), config.GetBool("server.production")
+	defer func() {
+		config.Set("apple.registrar.type", registrarType)
+		config.Set("server.production", production)
+	}()
+	config.Set("apple.registrar.type", "local")
+
+	config.Set("server.production", false)
+	_, err := NewRegistrar(nil)
+	assert.NoError(t, err)
+
+	config.Set("server.production", true)
+	_, err = NewRegistrar(nil)
+	assert.Error(t, err, "Production domains should never be recorded by the local stand-in.")
+}
+
+func TestHTTPVerifier(t *testing.T) {
+	assert := assert.New(t)
+	token := VerificationToken("user_xxx", "shop.example.com")
+	assert.NotEqual(token, VerificationToken("user_yyy", "shop.example.com"), "Tokens should be unique to the merchant.")
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(VerificationPath, r.URL.Path)
+		_, _ = w.Write([]byte(token + "\n"))
+	}))
+	defer server.Close()
+	verifier := NewHTTPVerifier()
+	verifier.Client.Transport = server.Client().Transport
+	domain := strings.TrimPrefix(server.URL, "https://")
+
+	assert.NoError(verifier.Verify(context.Background(), domain, token))
+	assert.Error(verifier.Verify(context.Background(), domain, VerificationToken("user_yyy", "shop.example.com")))
+}
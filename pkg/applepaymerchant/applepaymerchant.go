@@ -0,0 +1,214 @@
+// Package applepaymerchant resolves the Apple Pay merchant identity used for a checkout.
+// Merchants may use their own identity, with certificates kept in the secrets store, otherwise Buyte's identity is used.
+package applepaymerchant
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rsoury/applepay"
+
+	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/applepaytoken"
+	"github.com/rsoury/buyte/pkg/secrets"
+)
+
+// ErrDomainNotAllowed is returned when a session is requested for a domain the identity is not registered for.
+var ErrDomainNotAllowed = errors.New("Domain is not registered for Apple Pay")
+
+// Identity is an Apple Pay merchant, with the certificates to start payment sessions and decrypt payment tokens.
+type Identity struct {
+	ID                 string
+	MerchantIdentifier string
+	DisplayName        string
+	Domains            []string
+	Keyring            *applepaytoken.Keyring
+
+	merchantCertificate *tls.Certificate
+	certificatesSecret  string
+}
+
+func NewIdentity(id string, merchantIdentifier string, displayName string, domains []string, merchantCertificate *tls.Certificate, keyring *applepaytoken.Keyring) *Identity {
+	return &Identity{
+		ID:                  id,
+		MerchantIdentifier:  merchantIdentifier,
+		DisplayName:         displayName,
+		Domains:             domains,
+		Keyring:             keyring,
+		merchantCertificate: merchantCertificate,
+	}
+}
+
+// Domain selects the domain to start a session for.
+// The requested domain must be registered against the identity. Without a requested domain, the first registered domain is used.
+func (i *Identity) Domain(requested string) (string, error) {
+	if requested == "" {
+		if len(i.Domains) == 0 {
+			return "", ErrDomainNotAllowed
+		}
+		return i.Domains[0], nil
+	}
+	for _, domain := range i.Domains {
+		if strings.EqualFold(domain, requested) {
+			return domain, nil
+		}
+	}
+	return "", ErrDomainNotAllowed
+}
+
+// WithDomains returns a copy of the identity, allowing sessions for additional domains.
+// ie. Merchant domains registered with Apple against Buyte's identity.
+func (i *Identity) WithDomains(domains ...string) *Identity {
+	identity := *i
+	identity.Domains = append(append([]string{}, i.Domains...), domains...)
+	return &identity
+}
+
+// Session requests an Apple Pay payment session for the given domain, from a merchant validation URL checked by a SessionURLValidator.
+func (i *Identity) Session(ctx context.Context, sessionURL *url.URL, domain string) ([]byte, error) {
+	if i.merchantCertificate == nil {
+		return nil, errors.New("No Apple Pay merchant identity certificate loaded")
+	}
+	domain, err := i.Domain(domain)
+	if err != nil {
+		return nil, err
+	}
+	return requestSession(ctx, SessionClient(*i.merchantCertificate), sessionURL, &sessionRequest{
+		MerchantIdentifier: i.MerchantIdentifier,
+		DomainName:         domain,
+		DisplayName:        i.DisplayName,
+	})
+}
+
+// DecryptResponse decrypts a token with the identity's processing certificates.
+func (i *Identity) DecryptResponse(response *applepay.Response) (*buyte.NetworkToken, error) {
+	plaintext, err := i.Keyring.Decrypt(&response.Token)
+	if err != nil {
+		return nil, err
+	}
+	return buyte.ParseNetworkToken(plaintext)
+}
+
+// AdditionalData is the data a checkout widget requires to present Apple Pay.
+func (i *Identity) AdditionalData() map[string]string {
+	return map[string]string{
+		"merchantId":   i.MerchantIdentifier,
+		"merchantName": i.DisplayName,
+	}
+}
+
+// Parse loads an identity from the certificates held in the secrets store.
+func Parse(merchant *buyte.ApplePayMerchant, secret []byte) (*Identity, error) {
+	certificates := &buyte.ApplePayMerchantCertificates{}
+	if err := json.Unmarshal(secret, certificates); err != nil {
+		return nil, errors.Wrap(err, "Could not decode Apple Pay merchant certificates")
+	}
+	merchantCertificate, err := tls.X509KeyPair([]byte(certificates.MerchantCertificate), []byte(certificates.MerchantKey))
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not load Apple Pay merchant identity certificate")
+	}
+	// Validate the merchant certificate against the identifier up front, rather than on the first session.
+	if _, err := applepay.New(merchant.MerchantIdentifier, applepay.MerchantCertificate(merchantCertificate)); err != nil {
+		return nil, errors.Wrap(err, "Invalid Apple Pay merchant identity certificate")
+	}
+
+	var keys []*applepaytoken.ProcessingKey
+	for index, pair := range certificates.ProcessingCertificates {
+		cert, err := tls.X509KeyPair([]byte(pair.Certificate), []byte(pair.Key))
+		if err != nil {
+			return nil, errors.Wrap(err, "Could not load Apple Pay processing certificate "+strconv.Itoa(index))
+		}
+		key, err := applepaytoken.NewProcessingKey(merchant.MerchantIdentifier, cert, merchant.CertificatesSecret+"#"+strconv.Itoa(index))
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("No Apple Pay processing certificates found")
+	}
+	keyring := applepaytoken.NewKeyring(merchant.MerchantIdentifier, "")
+	keyring.Set(keys)
+
+	return NewIdentity(merchant.ID, merchant.MerchantIdentifier, merchant.DisplayName, merchant.Domains, &merchantCertificate, keyring), nil
+}
+
+type cached struct {
+	identity *Identity
+	loadedAt time.Time
+}
+
+// Resolver selects the identity for a merchant, loading and caching certificates from the secrets store.
+type Resolver struct {
+	Default *Identity
+	Secrets secrets.Store
+	// How long loaded certificates are cached for.
+	TTL time.Duration
+
+	mu    sync.Mutex
+	cache map[string]*cached
+	now   func() time.Time
+}
+
+func NewResolver(defaultIdentity *Identity, secretStore secrets.Store, ttl time.Duration) *Resolver {
+	return &Resolver{
+		Default: defaultIdentity,
+		Secrets: secretStore,
+		TTL:     ttl,
+		cache:   map[string]*cached{},
+		now:     time.Now,
+	}
+}
+
+// Resolve returns the identity for the merchant. Merchants without their own identity use the default.
+func (r *Resolver) Resolve(ctx context.Context, merchant *buyte.ApplePayMerchant) (*Identity, error) {
+	if merchant == nil || merchant.ID == "" {
+		return r.Default, nil
+	}
+	if r.Secrets == nil {
+		return nil, errors.New("No secrets store configured to load Apple Pay merchant certificates")
+	}
+
+	r.mu.Lock()
+	entry, ok := r.cache[merchant.ID]
+	r.mu.Unlock()
+	if ok && r.now().Sub(entry.loadedAt) < r.TTL {
+		// Identity details may change without the certificates changing.
+		if entry.identity.certificatesSecret == merchant.CertificatesSecret && entry.identity.MerchantIdentifier == merchant.MerchantIdentifier {
+			identity := *entry.identity
+			identity.DisplayName = merchant.DisplayName
+			identity.Domains = merchant.Domains
+			return &identity, nil
+		}
+	}
+
+	secret, err := r.Secrets.Get(ctx, merchant.CertificatesSecret)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not get Apple Pay merchant certificates")
+	}
+	identity, err := Parse(merchant, secret)
+	if err != nil {
+		return nil, err
+	}
+	identity.certificatesSecret = merchant.CertificatesSecret
+
+	r.mu.Lock()
+	r.cache[merchant.ID] = &cached{identity: identity, loadedAt: r.now()}
+	r.mu.Unlock()
+
+	return identity, nil
+}
+
+// Flush drops all cached certificates, so they are loaded from the secrets store on next use.
+func (r *Resolver) Flush() {
+	r.mu.Lock()
+	r.cache = map[string]*cached{}
+	r.mu.Unlock()
+}
@@ -0,0 +1,164 @@
+package applepaymerchant
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/secrets"
+)
+
+var merchantIDHashOID = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 32}
+
+// Issues a certificate for the merchant identifier, as Apple would.
+func certificatePair(t *testing.T, merchantIdentifier string, key crypto.Signer) buyte.ApplePayCertificateKeyPair {
+	hash := sha256.Sum256([]byte(merchantIdentifier))
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "Merchant ID: " + merchantIdentifier},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{
+			{Id: merchantIDHashOID, Value: []byte("@." + hex.EncodeToString(hash[:]))},
+		},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buyte.ApplePayCertificateKeyPair{
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		Key:         string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})),
+	}
+}
+
+func certificatesSecret(t *testing.T, merchantIdentifier string) []byte {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merchant := certificatePair(t, merchantIdentifier, rsaKey)
+	secret, err := json.Marshal(&buyte.ApplePayMerchantCertificates{
+		MerchantCertificate: merchant.Certificate,
+		MerchantKey:         merchant.Key,
+		ProcessingCertificates: []buyte.ApplePayCertificateKeyPair{
+			certificatePair(t, merchantIdentifier, ecKey),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secret
+}
+
+type memorySecrets struct {
+	values map[string][]byte
+	gets   int
+}
+
+func (m *memorySecrets) Get(ctx context.Context, name string) ([]byte, error) {
+	m.gets++
+	value, ok := m.values[name]
+	if !ok {
+		return nil, secrets.ErrNotFound
+	}
+	return value, nil
+}
+
+var storeMerchant = &buyte.ApplePayMerchant{
+	ID:                 "applepaymerchant_xxx",
+	MerchantIdentifier: "merchant.com.example.store",
+	DisplayName:        "Example Store",
+	Domains:            []string{"shop.example.com", "www.example.com"},
+	CertificatesSecret: "applepay/merchant.com.example.store",
+}
+
+func TestResolve(t *testing.T) {
+	assert := assert.New(t)
+	store := &memorySecrets{values: map[string][]byte{
+		storeMerchant.CertificatesSecret: certificatesSecret(t, storeMerchant.MerchantIdentifier),
+	}}
+	defaultIdentity := NewIdentity("", "merchant.com.buytecheckout", "Buyte Apple Pay Checkout", []string{"go.buytecheckout.com"}, nil, nil)
+	resolver := NewResolver(defaultIdentity, store, time.Minute)
+
+	identity, err := resolver.Resolve(context.Background(), &buyte.ApplePayMerchant{})
+	assert.NoError(err)
+	assert.Equal(defaultIdentity, identity, "Merchants without an identity use the default.")
+
+	identity, err = resolver.Resolve(context.Background(), storeMerchant)
+	if assert.NoError(err) {
+		assert.Equal("merchant.com.example.store", identity.MerchantIdentifier)
+		assert.Equal(map[string]string{
+			"merchantId":   "merchant.com.example.store",
+			"merchantName": "Example Store",
+		}, identity.AdditionalData())
+		assert.Len(identity.Keyring.Keys(), 1)
+	}
+
+	// Certificates are cached, while identity details are kept current.
+	renamed := *storeMerchant
+	renamed.DisplayName = "Renamed Store"
+	identity, err = resolver.Resolve(context.Background(), &renamed)
+	if assert.NoError(err) {
+		assert.Equal("Renamed Store", identity.DisplayName)
+	}
+	assert.Equal(1, store.gets)
+
+	resolver.Flush()
+	_, err = resolver.Resolve(context.Background(), storeMerchant)
+	assert.NoError(err)
+	assert.Equal(2, store.gets)
+
+	missing := *storeMerchant
+	missing.ID = "applepaymerchant_yyy"
+	missing.CertificatesSecret = "applepay/missing"
+	_, err = resolver.Resolve(context.Background(), &missing)
+	assert.Error(err)
+}
+
+func TestParseWrongMerchant(t *testing.T) {
+	merchant := *storeMerchant
+	merchant.MerchantIdentifier = "merchant.com.another"
+	_, err := Parse(&merchant, certificatesSecret(t, storeMerchant.MerchantIdentifier))
+	assert.Error(t, err, "Certificates issued for another merchant identifier should be rejected.")
+}
+
+func TestDomain(t *testing.T) {
+	assert := assert.New(t)
+	identity := NewIdentity(storeMerchant.ID, storeMerchant.MerchantIdentifier, storeMerchant.DisplayName, storeMerchant.Domains, nil, nil)
+
+	domain, err := identity.Domain("")
+	assert.NoError(err)
+	assert.Equal("shop.example.com", domain)
+
+	domain, err = identity.Domain("WWW.example.com")
+	assert.NoError(err)
+	assert.Equal("www.example.com", domain)
+
+	_, err = identity.Domain("attacker.example.net")
+	assert.Equal(ErrDomainNotAllowed, err)
+}
@@ -0,0 +1,203 @@
+package applepaymerchant
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SessionHosts are Apple's published merchant validation hostnames.
+// See https://developer.apple.com/documentation/apple_pay_on_the_web/setting_up_your_server
+var SessionHosts = []string{
+	"apple-pay-gateway.apple.com",
+	"cn-apple-pay-gateway.apple.com",
+	"apple-pay-gateway-nc-pod1.apple.com",
+	"apple-pay-gateway-nc-pod2.apple.com",
+	"apple-pay-gateway-nc-pod3.apple.com",
+	"apple-pay-gateway-nc-pod4.apple.com",
+	"apple-pay-gateway-nc-pod5.apple.com",
+	"apple-pay-gateway-pr-pod1.apple.com",
+	"apple-pay-gateway-pr-pod2.apple.com",
+	"apple-pay-gateway-pr-pod3.apple.com",
+	"apple-pay-gateway-pr-pod4.apple.com",
+	"apple-pay-gateway-pr-pod5.apple.com",
+	"cn-apple-pay-gateway-sh-pod1.apple.com",
+	"cn-apple-pay-gateway-sh-pod2.apple.com",
+	"cn-apple-pay-gateway-sh-pod3.apple.com",
+	"cn-apple-pay-gateway-tj-pod1.apple.com",
+	"cn-apple-pay-gateway-tj-pod2.apple.com",
+	"cn-apple-pay-gateway-tj-pod3.apple.com",
+	"apple-pay-gateway-cert.apple.com",
+	"cn-apple-pay-gateway-cert.apple.com",
+}
+
+// Reasons a session URL is rejected.
+const (
+	SessionURLMalformed      = "malformed_url"
+	SessionURLScheme         = "https_required"
+	SessionURLHostNotAllowed = "host_not_allowed"
+	SessionURLUnresolvable   = "host_unresolvable"
+	SessionURLPrivateAddress = "private_address"
+)
+
+// SessionURLError is returned when a merchant validation URL is not one of Apple's.
+// The URL is provided by the browser, so the request must not be able to reach anywhere else with the merchant certificate.
+type SessionURLError struct {
+	Reason string
+	URL    string
+}
+
+func (e *SessionURLError) Error() string {
+	return "Invalid Apple Pay validation URL (" + e.Reason + ")"
+}
+
+// IsInvalidSessionURL checks whether a session was refused because of its validation URL.
+func IsInvalidSessionURL(err error) bool {
+	var urlErr *SessionURLError
+	return errors.As(err, &urlErr)
+}
+
+type IPResolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// SessionURLValidator checks merchant validation URLs against an allowlist of hosts.
+type SessionURLValidator struct {
+	Hosts    []string
+	Resolver IPResolver
+}
+
+func NewSessionURLValidator(hosts []string) *SessionURLValidator {
+	return &SessionURLValidator{
+		Hosts:    hosts,
+		Resolver: net.DefaultResolver,
+	}
+}
+
+// Validate parses the URL, requiring HTTPS to an allowed host on the default port, that only resolves to public addresses.
+func (v *SessionURLValidator) Validate(ctx context.Context, rawURL string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || u.Host == "" || u.Opaque != "" {
+		return nil, &SessionURLError{Reason: SessionURLMalformed, URL: rawURL}
+	}
+	if u.Scheme != "https" {
+		return nil, &SessionURLError{Reason: SessionURLScheme, URL: rawURL}
+	}
+	// Credentials or a port are never part of Apple's URLs.
+	if u.User != nil || u.Port() != "" || !v.allowed(u.Host) {
+		return nil, &SessionURLError{Reason: SessionURLHostNotAllowed, URL: rawURL}
+	}
+	addrs, err := v.Resolver.LookupIPAddr(ctx, u.Hostname())
+	if err != nil || len(addrs) == 0 {
+		return nil, &SessionURLError{Reason: SessionURLUnresolvable, URL: rawURL}
+	}
+	for _, addr := range addrs {
+		if !IsPublicIP(addr.IP) {
+			return nil, &SessionURLError{Reason: SessionURLPrivateAddress, URL: rawURL}
+		}
+	}
+	return u, nil
+}
+
+func (v *SessionURLValidator) allowed(host string) bool {
+	for _, allowed := range v.Hosts {
+		// Exact matches only. Case is significant to the allowlist, as no browser sends Apple's hosts otherwise.
+		if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// IsPublicIP checks that an address is not loopback, private, link local, shared or otherwise non-routable.
+func IsPublicIP(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	if ip4 := ip.To4(); ip4 != nil && (sharedAddressSpace.Contains(ip4) || ip4[0] == 0 || ip4.Equal(net.IPv4bcast)) {
+		return false
+	}
+	return true
+}
+
+// Refuses connections to non-public addresses at dial time, so that DNS changing between validation and the request cannot reach them.
+func dialControl(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if !IsPublicIP(net.ParseIP(host)) {
+		return &SessionURLError{Reason: SessionURLPrivateAddress, URL: address}
+	}
+	return nil
+}
+
+// SessionClient returns a client authenticated with a merchant identity certificate, which only connects to public addresses.
+func SessionClient(merchantCertificate tls.Certificate) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: dialControl,
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: dialer.DialContext,
+			TLSClientConfig: &tls.Config{
+				Certificates: []tls.Certificate{merchantCertificate},
+			},
+			Proxy: nil,
+		},
+		// Apple does not redirect validation requests. Following one would leave the allowlist.
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+		Timeout: 30 * time.Second,
+	}
+}
+
+type sessionRequest struct {
+	MerchantIdentifier string `json:"merchantIdentifier"`
+	DomainName         string `json:"domainName"`
+	DisplayName        string `json:"displayName"`
+}
+
+// requestSession posts the session request to a validated URL.
+func requestSession(ctx context.Context, client *http.Client, u *url.URL, request *sessionRequest) ([]byte, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		if IsInvalidSessionURL(err) {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "Could not request Apple Pay session")
+	}
+	defer resp.Body.Close()
+	payload, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not read Apple Pay session")
+	}
+	if resp.StatusCode >= 300 {
+		return nil, errors.Errorf("Apple Pay session request failed with status %d: %s", resp.StatusCode, payload)
+	}
+	return payload, nil
+}
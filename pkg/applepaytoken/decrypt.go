@@ -0,0 +1,97 @@
+package applepaytoken
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+
+	"github.com/pkg/errors"
+	"github.com/rsoury/applepay"
+)
+
+// Decrypt decrypts a token's payment data with the processing certificate it was encrypted for, returning the plaintext JSON.
+// The applepay library only parses the fields it knows of, dropping fields such as merchantTokenIdentifier, so the plaintext is read by the caller.
+// The signature is not checked here. Tokens are checked with a Verifier before they are decrypted.
+func (k *Keyring) Decrypt(token *applepay.PKPaymentToken) ([]byte, error) {
+	key, err := k.Key(token.PaymentData.Header.PublicKeyHash)
+	if err != nil {
+		return nil, err
+	}
+	return key.Decrypt(token)
+}
+
+// Decrypt decrypts a token's payment data with this processing key.
+func (k *ProcessingKey) Decrypt(token *applepay.PKPaymentToken) ([]byte, error) {
+	var symmetricKey []byte
+	var err error
+	switch token.PaymentData.Version {
+	case versionEC:
+		symmetricKey, err = k.deriveKey(token)
+	case versionRSA:
+		symmetricKey, err = k.unwrapKey(token)
+	default:
+		return nil, errors.Errorf("Unsupported Apple Pay payment data version %q", token.PaymentData.Version)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not obtain the payment data encryption key")
+	}
+
+	block, err := aes.NewCipher(symmetricKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not create the payment data cipher")
+	}
+	// Apple uses a 16 byte, all zero, IV.
+	aesGCM, err := cipher.NewGCMWithNonceSize(block, 16)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not create the payment data cipher")
+	}
+	plaintext, err := aesGCM.Open(nil, make([]byte, aesGCM.NonceSize()), token.PaymentData.Data, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not decrypt the payment data")
+	}
+	return plaintext, nil
+}
+
+// deriveKey derives an EC_v1 token's key from the ephemeral public key and the processing private key.
+// See NIST SP 800-56A section 5.8.1, with Apple's parameters.
+func (k *ProcessingKey) deriveKey(token *applepay.PKPaymentToken) ([]byte, error) {
+	priv, ok := k.certificate.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("Processing key is not an EC key")
+	}
+	parsed, err := x509.ParsePKIXPublicKey(token.PaymentData.Header.EphemeralPublicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not parse the ephemeral public key")
+	}
+	pub, ok := parsed.(*ecdsa.PublicKey)
+	if !ok || pub.Curve != priv.Curve || !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, errors.New("Invalid ephemeral public key")
+	}
+	x, _ := priv.Curve.ScalarMult(pub.X, pub.Y, priv.D.Bytes())
+	sharedSecret := make([]byte, (priv.Curve.Params().BitSize+7)/8)
+	x.FillBytes(sharedSecret)
+
+	h := sha256.New()
+	h.Write([]byte{0, 0, 0, 1})
+	h.Write(sharedSecret)
+	h.Write([]byte("\x0did-aes256-GCM"))
+	h.Write([]byte("Apple"))
+	h.Write(k.merchantIDHash)
+	return h.Sum(nil), nil
+}
+
+// unwrapKey decrypts an RSA_v1 token's wrapped key with the processing private key.
+func (k *ProcessingKey) unwrapKey(token *applepay.PKPaymentToken) ([]byte, error) {
+	priv, ok := k.certificate.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("Processing key is not an RSA key")
+	}
+	if len(token.PaymentData.Header.WrappedKey) == 0 {
+		return nil, errors.New("Token has no wrapped key")
+	}
+	return rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, token.PaymentData.Header.WrappedKey, nil)
+}
@@ -0,0 +1,54 @@
+package applepaytoken
+
+import (
+	"github.com/pkg/errors"
+)
+
+type Reason string
+
+// Reasons a token failed verification.
+const (
+	ReasonVersion     Reason = "unsupported_version"
+	ReasonMalformed   Reason = "malformed_signature"
+	ReasonCertificate Reason = "invalid_certificate"
+	ReasonChain       Reason = "untrusted_certificate_chain"
+	ReasonSignature   Reason = "invalid_signature"
+	ReasonSigningTime Reason = "rejected_signing_time"
+)
+
+// VerificationError is returned when a token cannot be trusted.
+type VerificationError struct {
+	Reason Reason
+	Err    error
+}
+
+func newError(reason Reason, err error) error {
+	return &VerificationError{
+		Reason: reason,
+		Err:    err,
+	}
+}
+
+func (e *VerificationError) Error() string {
+	return "Apple Pay token verification failed (" + string(e.Reason) + "): " + e.Err.Error()
+}
+
+func (e *VerificationError) Unwrap() error {
+	return e.Err
+}
+
+func (e *VerificationError) Cause() error {
+	return e.Err
+}
+
+// IsVerificationError checks whether the token was rejected, as opposed to a failure on Buyte's end.
+func IsVerificationError(err error) bool {
+	var verificationErr *VerificationError
+	return errors.As(err, &verificationErr)
+}
+
+// IsRejectedSigningTime checks whether the token was signed outside of the allowed time window. ie. A replayed token.
+func IsRejectedSigningTime(err error) bool {
+	var verificationErr *VerificationError
+	return errors.As(err, &verificationErr) && verificationErr.Reason == ReasonSigningTime
+}
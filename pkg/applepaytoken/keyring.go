@@ -0,0 +1,168 @@
+package applepaytoken
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rsoury/applepay"
+)
+
+// ErrUnknownProcessingKey is returned when a token was encrypted for a processing certificate that is not loaded.
+var ErrUnknownProcessingKey = errors.New("Apple Pay token was encrypted with an unknown processing certificate")
+
+// ProcessingKey is a Payment Processing Certificate and its private key.
+type ProcessingKey struct {
+	// Base64 SHA-256 hash of the certificate's public key, as found in a token's header.publicKeyHash
+	PublicKeyHash   string    `json:"publicKeyHash"`
+	Subject         string    `json:"subject"`
+	CertificatePath string    `json:"-"`
+	NotBefore       time.Time `json:"notBefore"`
+	NotAfter        time.Time `json:"notAfter"`
+
+	certificate    tls.Certificate
+	merchantIDHash []byte
+}
+
+// Expired checks whether the certificate has expired at the given time.
+func (k *ProcessingKey) Expired(at time.Time) bool {
+	return at.After(k.NotAfter)
+}
+
+// ExpiresWithin checks whether the certificate expires within the given duration of the given time.
+func (k *ProcessingKey) ExpiresWithin(d time.Duration, at time.Time) bool {
+	return at.Add(d).After(k.NotAfter)
+}
+
+// Keyring holds every Payment Processing Certificate loaded for a merchant.
+// Apple Pay processing certificates expire and are rotated with overlap, so tokens may be encrypted for any one of them.
+type Keyring struct {
+	MerchantID string
+	// Glob matching the certificates to load. Each certificate's key is expected alongside it, ie. cert-processing.crt and cert-processing-key.pem
+	Pattern string
+
+	mu   sync.RWMutex
+	keys map[string]*ProcessingKey
+}
+
+func NewKeyring(merchantID string, pattern string) *Keyring {
+	return &Keyring{
+		MerchantID: merchantID,
+		Pattern:    pattern,
+		keys:       map[string]*ProcessingKey{},
+	}
+}
+
+// KeyPath returns the path of the private key for a certificate.
+func KeyPath(certificatePath string) string {
+	return strings.TrimSuffix(certificatePath, filepath.Ext(certificatePath)) + "-key.pem"
+}
+
+// LoadProcessingKey loads a Payment Processing Certificate and its key for the given merchant.
+func LoadProcessingKey(merchantID string, certificatePath string, keyPath string) (*ProcessingKey, error) {
+	cert, err := tls.LoadX509KeyPair(certificatePath, keyPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not load processing certificate "+certificatePath)
+	}
+	return NewProcessingKey(merchantID, cert, certificatePath)
+}
+
+// NewProcessingKey validates a Payment Processing Certificate for the given merchant. Source describes where it was loaded from.
+func NewProcessingKey(merchantID string, cert tls.Certificate, source string) (*ProcessingKey, error) {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not parse processing certificate "+source)
+	}
+	// Checks the certificate was issued for the merchant.
+	if _, err := applepay.New(merchantID, applepay.ProcessingCertificate(cert)); err != nil {
+		return nil, errors.Wrap(err, "Invalid processing certificate "+source)
+	}
+	merchantIDHash := sha256.Sum256([]byte(merchantID))
+	return &ProcessingKey{
+		PublicKeyHash:   PublicKeyHash(leaf),
+		Subject:         leaf.Subject.CommonName,
+		CertificatePath: source,
+		NotBefore:       leaf.NotBefore,
+		NotAfter:        leaf.NotAfter,
+		certificate:     cert,
+		merchantIDHash:  merchantIDHash[:],
+	}, nil
+}
+
+// PublicKeyHash returns the hash Apple includes in a token's header to identify the processing certificate used.
+func PublicKeyHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Load (re)loads every certificate matching the pattern.
+// The loaded keys are only replaced when at least one certificate could be loaded, so a bad reload does not take Apple Pay down.
+// Certificates that fail to load are returned as errors alongside the keys that loaded.
+func (k *Keyring) Load() ([]*ProcessingKey, []error) {
+	paths, err := filepath.Glob(k.Pattern)
+	if err != nil {
+		return nil, []error{errors.Wrap(err, "Invalid processing certificate pattern")}
+	}
+	var errs []error
+	keys := map[string]*ProcessingKey{}
+	for _, path := range paths {
+		key, err := LoadProcessingKey(k.MerchantID, path, KeyPath(path))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		keys[key.PublicKeyHash] = key
+	}
+	if len(keys) == 0 {
+		return nil, append(errs, errors.New("No processing certificates found matching "+k.Pattern))
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.mu.Unlock()
+
+	return k.Keys(), errs
+}
+
+// Set replaces the loaded keys. ie. With keys loaded from a secrets store.
+func (k *Keyring) Set(keys []*ProcessingKey) {
+	loaded := map[string]*ProcessingKey{}
+	for _, key := range keys {
+		loaded[key.PublicKeyHash] = key
+	}
+	k.mu.Lock()
+	k.keys = loaded
+	k.mu.Unlock()
+}
+
+// Keys returns the loaded keys, soonest to expire first.
+func (k *Keyring) Keys() []*ProcessingKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	keys := make([]*ProcessingKey, 0, len(k.keys))
+	for _, key := range k.keys {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].NotAfter.Before(keys[j].NotAfter)
+	})
+	return keys
+}
+
+// Key returns the key for a token's header.publicKeyHash
+func (k *Keyring) Key(publicKeyHash []byte) (*ProcessingKey, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[base64.StdEncoding.EncodeToString(publicKeyHash)]
+	if !ok {
+		return nil, ErrUnknownProcessingKey
+	}
+	return key, nil
+}
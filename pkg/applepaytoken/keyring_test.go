@@ -0,0 +1,187 @@
+package applepaytoken
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rsoury/applepay"
+	"github.com/stretchr/testify/assert"
+)
+
+const testMerchantID = "merchant.com.buytecheckout.test"
+
+var merchantIDHashOID = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 32}
+
+// Writes a processing certificate and key, as issued by Apple for the merchant.
+func writeProcessingKey(t *testing.T, dir string, name string, notAfter time.Time) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := sha256.Sum256([]byte(testMerchantID))
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "Merchant ID: " + testMerchantID},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		ExtraExtensions: []pkix.Extension{
+			{Id: merchantIDHashOID, Value: []byte("@." + hex.EncodeToString(hash[:]))},
+		},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPath := filepath.Join(dir, name+".crt")
+	if err := ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(KeyPath(certPath), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath
+}
+
+func TestKeyring(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "applepaytoken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	expiring := writeProcessingKey(t, dir, "cert-processing", time.Now().Add(7*24*time.Hour))
+	rotated := writeProcessingKey(t, dir, "cert-processing-2021", time.Now().Add(365*24*time.Hour))
+
+	keyring := NewKeyring(testMerchantID, filepath.Join(dir, "cert-processing*.crt"))
+	keys, errs := keyring.Load()
+	assert.Empty(errs)
+	if assert.Len(keys, 2) {
+		assert.Equal(expiring, keys[0].CertificatePath, "Keys should be ordered by expiry.")
+		assert.Equal(rotated, keys[1].CertificatePath)
+		assert.True(keys[0].ExpiresWithin(30*24*time.Hour, time.Now()))
+		assert.False(keys[1].ExpiresWithin(30*24*time.Hour, time.Now()))
+	}
+
+	// Tokens identify the processing certificate by the hash of its public key.
+	for _, key := range keys {
+		hash, _ := base64.StdEncoding.DecodeString(key.PublicKeyHash)
+		found, err := keyring.Key(hash)
+		if assert.NoError(err) {
+			assert.Equal(key.CertificatePath, found.CertificatePath)
+		}
+	}
+	_, err = keyring.Key([]byte("unknown"))
+	assert.Equal(ErrUnknownProcessingKey, err)
+
+	// Removing the expiring certificate and reloading drops it.
+	os.Remove(expiring)
+	keys, errs = keyring.Load()
+	assert.Empty(errs)
+	assert.Len(keys, 1)
+
+	// A reload that finds no usable certificates keeps the loaded keys.
+	os.Remove(KeyPath(rotated))
+	keys, errs = keyring.Load()
+	assert.Nil(keys)
+	assert.Len(errs, 2)
+	assert.Len(keyring.Keys(), 1)
+}
+
+func TestLoadProcessingKeyWrongMerchant(t *testing.T) {
+	dir, err := ioutil.TempDir("", "applepaytoken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := writeProcessingKey(t, dir, "cert-processing", time.Now().Add(time.Hour))
+
+	_, err = LoadProcessingKey("merchant.com.another", path, KeyPath(path))
+	assert.Error(t, err)
+}
+
+// Encrypts payment data for a processing key, as Apple does for EC_v1 tokens.
+func encryptPaymentData(t *testing.T, key *ProcessingKey, plaintext []byte) *applepay.PKPaymentToken {
+	ephemeral, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	processingPublicKey := &key.certificate.PrivateKey.(*ecdsa.PrivateKey).PublicKey
+	ephemeralDer, err := x509.MarshalPKIXPublicKey(&ephemeral.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x, _ := elliptic.P256().ScalarMult(processingPublicKey.X, processingPublicKey.Y, ephemeral.D.Bytes())
+	sharedSecret := make([]byte, 32)
+	x.FillBytes(sharedSecret)
+	merchantIDHash := sha256.Sum256([]byte(testMerchantID))
+	h := sha256.New()
+	h.Write([]byte{0, 0, 0, 1})
+	h.Write(sharedSecret)
+	h.Write([]byte("\x0did-aes256-GCM"))
+	h.Write([]byte("Apple"))
+	h.Write(merchantIDHash[:])
+	block, err := aes.NewCipher(h.Sum(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aesGCM, _ := cipher.NewGCMWithNonceSize(block, 16)
+	publicKeyHash, _ := base64.StdEncoding.DecodeString(key.PublicKeyHash)
+
+	token := &applepay.PKPaymentToken{}
+	token.PaymentData.Version = versionEC
+	token.PaymentData.Header.EphemeralPublicKey = ephemeralDer
+	token.PaymentData.Header.PublicKeyHash = publicKeyHash
+	token.PaymentData.Data = aesGCM.Seal(nil, make([]byte, 16), plaintext, nil)
+	return token
+}
+
+func TestKeyringDecrypt(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "applepaytoken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeProcessingKey(t, dir, "cert-processing", time.Now().Add(time.Hour))
+	keyring := NewKeyring(testMerchantID, filepath.Join(dir, "cert-processing*.crt"))
+	keys, errs := keyring.Load()
+	if !assert.Empty(errs) {
+		return
+	}
+
+	plaintext := []byte(`{"applicationPrimaryAccountNumber":"4817499130172785","merchantTokenIdentifier":"DNITHE302308980427388297"}`)
+	token := encryptPaymentData(t, keys[0], plaintext)
+	decrypted, err := keyring.Decrypt(token)
+	if assert.NoError(err) {
+		assert.Equal(plaintext, decrypted, "Fields unknown to the applepay library should be kept.")
+	}
+
+	token.PaymentData.Data[0] ^= 0xff
+	_, err = keyring.Decrypt(token)
+	assert.Error(err, "Tampered payment data should not decrypt.")
+
+	token.PaymentData.Header.PublicKeyHash = []byte("unknown")
+	_, err = keyring.Decrypt(token)
+	assert.Equal(ErrUnknownProcessingKey, err)
+}
@@ -0,0 +1,157 @@
+package applepaytoken
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	leafCertificateOID         = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 29}
+	intermediateCertificateOID = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 2, 14}
+
+	signedDataOID    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	messageDigestOID = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	signingTimeOID   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+
+	sha256OID          = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	ecdsaWithSHA256OID = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	ecPublicKeyOID     = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	rsaEncryptionOID   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	sha256WithRSAOID   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+)
+
+// PKCS#7 / CMS structures. See RFC 5652
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+type signedDataContent struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      asn1.RawValue
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     issuerAndSerialNumber
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+// The parts of a token signature required to verify it.
+type signature struct {
+	certificates     []*x509.Certificate
+	serialNumber     *big.Int
+	algorithm        x509.SignatureAlgorithm
+	signedAttributes []byte
+	signature        []byte
+	messageDigest    []byte
+	signingTime      time.Time
+}
+
+func parseSignature(data []byte) (*signature, error) {
+	info := &contentInfo{}
+	if _, err := asn1.Unmarshal(data, info); err != nil {
+		return nil, errors.Wrap(err, "Could not decode signature")
+	}
+	if !info.ContentType.Equal(signedDataOID) {
+		return nil, errors.New("Signature is not PKCS#7 signed data")
+	}
+	content := &signedDataContent{}
+	if _, err := asn1.Unmarshal(info.Content.Bytes, content); err != nil {
+		return nil, errors.Wrap(err, "Could not decode signed data")
+	}
+	certificates, err := x509.ParseCertificates(content.Certificates.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not decode signature certificates")
+	}
+	if len(content.SignerInfos) != 1 {
+		return nil, errors.Errorf("Expected a single signer, found %d", len(content.SignerInfos))
+	}
+	signer := content.SignerInfos[0]
+	if !signer.DigestAlgorithm.Algorithm.Equal(sha256OID) {
+		return nil, errors.New("Unsupported digest algorithm")
+	}
+	algorithm, err := signatureAlgorithm(signer.DigestEncryptionAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if len(signer.AuthenticatedAttributes.FullBytes) == 0 {
+		return nil, errors.New("Signature has no signed attributes")
+	}
+
+	// Signed attributes are signed as a SET, rather than with the implicit tag they're transmitted with.
+	signedAttributes := make([]byte, len(signer.AuthenticatedAttributes.FullBytes))
+	copy(signedAttributes, signer.AuthenticatedAttributes.FullBytes)
+	signedAttributes[0] = 0x31
+
+	var attributes []attribute
+	if _, err := asn1.UnmarshalWithParams(signedAttributes, &attributes, "set"); err != nil {
+		return nil, errors.Wrap(err, "Could not decode signed attributes")
+	}
+	result := &signature{
+		certificates:     certificates,
+		serialNumber:     signer.IssuerAndSerialNumber.SerialNumber,
+		algorithm:        algorithm,
+		signedAttributes: signedAttributes,
+		signature:        signer.EncryptedDigest,
+	}
+	for _, attr := range attributes {
+		switch {
+		case attr.Type.Equal(messageDigestOID):
+			if _, err := asn1.Unmarshal(attr.Values.Bytes, &result.messageDigest); err != nil {
+				return nil, errors.Wrap(err, "Could not decode message digest")
+			}
+		case attr.Type.Equal(signingTimeOID):
+			if _, err := asn1.Unmarshal(attr.Values.Bytes, &result.signingTime); err != nil {
+				return nil, errors.Wrap(err, "Could not decode signing time")
+			}
+		}
+	}
+	if len(result.messageDigest) == 0 {
+		return nil, errors.New("Message digest not found")
+	}
+	if result.signingTime.IsZero() {
+		return nil, errors.New("Signing time not found")
+	}
+	return result, nil
+}
+
+func signatureAlgorithm(oid asn1.ObjectIdentifier) (x509.SignatureAlgorithm, error) {
+	switch {
+	case oid.Equal(ecdsaWithSHA256OID), oid.Equal(ecPublicKeyOID):
+		return x509.ECDSAWithSHA256, nil
+	case oid.Equal(sha256WithRSAOID), oid.Equal(rsaEncryptionOID):
+		return x509.SHA256WithRSA, nil
+	}
+	return x509.UnknownSignatureAlgorithm, errors.Errorf("Unsupported signature algorithm %s", oid)
+}
+
+func findCertificate(certificates []*x509.Certificate, oid asn1.ObjectIdentifier) *x509.Certificate {
+	for _, cert := range certificates {
+		for _, ext := range cert.Extensions {
+			if ext.Id.Equal(oid) {
+				return cert
+			}
+		}
+	}
+	return nil
+}
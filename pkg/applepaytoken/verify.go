@@ -0,0 +1,147 @@
+// Package applepaytoken verifies the signature of Apple Pay payment tokens and decrypts them with the merchant's processing certificates.
+// See https://developer.apple.com/library/archive/documentation/PassKit/Reference/PaymentTokenJSON/PaymentTokenJSON.html
+package applepaytoken
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rsoury/applepay"
+)
+
+const (
+	versionEC  = "EC_v1"
+	versionRSA = "RSA_v1"
+)
+
+// Verifier checks that a token was signed by Apple, chaining to a pinned Apple root certificate.
+type Verifier struct {
+	Root *x509.Certificate
+	// The maximum time between the token being signed and the transaction being received.
+	TimeWindow time.Duration
+	// Sandbox tokens are often recorded and replayed during development, so the signing time window is not enforced.
+	Sandbox bool
+}
+
+func New(root *x509.Certificate, timeWindow time.Duration, sandbox bool) *Verifier {
+	return &Verifier{
+		Root:       root,
+		TimeWindow: timeWindow,
+		Sandbox:    sandbox,
+	}
+}
+
+// LoadRootCertificate loads the pinned Apple root certificate from a PEM or DER file.
+// When a SHA-256 fingerprint is provided, the certificate must match it.
+func LoadRootCertificate(path string, fingerprint string) (*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not read Apple root certificate")
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	root, err := x509.ParseCertificate(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not parse Apple root certificate")
+	}
+	if !root.IsCA {
+		return nil, errors.New("Apple root certificate is not a CA")
+	}
+	if fingerprint != "" {
+		sum := sha256.Sum256(root.Raw)
+		expected := strings.ToLower(strings.Replace(fingerprint, ":", "", -1))
+		if hex.EncodeToString(sum[:]) != expected {
+			return nil, errors.New("Apple root certificate does not match the pinned fingerprint")
+		}
+	}
+	return root, nil
+}
+
+// Verify checks the token's signature, certificate chain and signing time against the time the transaction was received.
+func (v *Verifier) Verify(token *applepay.PKPaymentToken, transactionTime time.Time) error {
+	version := token.PaymentData.Version
+	if version != versionEC && version != versionRSA {
+		return newError(ReasonVersion, errors.Errorf("Unsupported token version %q", version))
+	}
+	if v.Root == nil {
+		return newError(ReasonChain, errors.New("No Apple root certificate configured"))
+	}
+
+	signature, err := parseSignature(token.PaymentData.Signature)
+	if err != nil {
+		return newError(ReasonMalformed, err)
+	}
+
+	// The leaf and intermediate certificates must carry Apple's extensions.
+	leaf := findCertificate(signature.certificates, leafCertificateOID)
+	if leaf == nil {
+		return newError(ReasonCertificate, errors.New("Leaf certificate with Apple Pay extension not found"))
+	}
+	intermediate := findCertificate(signature.certificates, intermediateCertificateOID)
+	if intermediate == nil {
+		return newError(ReasonCertificate, errors.New("Intermediate certificate with Apple Pay extension not found"))
+	}
+
+	// Root -> Intermediate -> Leaf, valid at the time of signing.
+	if err := intermediate.CheckSignatureFrom(v.Root); err != nil {
+		return newError(ReasonChain, errors.Wrap(err, "Intermediate certificate is not signed by the Apple root"))
+	}
+	if err := leaf.CheckSignatureFrom(intermediate); err != nil {
+		return newError(ReasonChain, errors.Wrap(err, "Leaf certificate is not signed by the intermediate certificate"))
+	}
+	for _, cert := range []*x509.Certificate{leaf, intermediate} {
+		if signature.signingTime.Before(cert.NotBefore) || signature.signingTime.After(cert.NotAfter) {
+			return newError(ReasonChain, errors.Errorf("Certificate %s was not valid at the signing time", cert.Subject.CommonName))
+		}
+	}
+
+	// The signature must be produced by the leaf, over the token's signed data.
+	if signature.serialNumber.Cmp(leaf.SerialNumber) != 0 {
+		return newError(ReasonSignature, errors.New("Token was not signed by the leaf certificate"))
+	}
+	digest := sha256.Sum256(signedData(token))
+	if !bytes.Equal(digest[:], signature.messageDigest) {
+		return newError(ReasonSignature, errors.New("Message digest does not match the token data"))
+	}
+	if err := leaf.CheckSignature(signature.algorithm, signature.signedAttributes, signature.signature); err != nil {
+		return newError(ReasonSignature, errors.Wrap(err, "Invalid signature"))
+	}
+
+	// Limit replay attacks.
+	if !v.Sandbox {
+		delta := transactionTime.Sub(signature.signingTime)
+		if delta < -time.Second {
+			return newError(ReasonSigningTime, errors.Errorf("Token was signed after the transaction (%s difference)", delta))
+		}
+		if delta > v.TimeWindow {
+			return newError(ReasonSigningTime, errors.Errorf("Token was signed outside of the allowed time window (%s difference)", delta))
+		}
+	}
+
+	return nil
+}
+
+// The data signed by the device: the ephemeral public key or wrapped key, the encrypted data, the transaction id and application data.
+func signedData(token *applepay.PKPaymentToken) []byte {
+	signed := bytes.NewBuffer(nil)
+	switch token.PaymentData.Version {
+	case versionEC:
+		signed.Write(token.PaymentData.Header.EphemeralPublicKey)
+	case versionRSA:
+		signed.Write(token.PaymentData.Header.WrappedKey)
+	}
+	signed.Write(token.PaymentData.Data)
+	transactionId, _ := hex.DecodeString(token.PaymentData.Header.TransactionID)
+	signed.Write(transactionId)
+	applicationData, _ := hex.DecodeString(token.PaymentData.Header.ApplicationData)
+	signed.Write(applicationData)
+	return signed.Bytes()
+}
@@ -0,0 +1,246 @@
+package applepaytoken
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rsoury/applepay"
+	"github.com/stretchr/testify/assert"
+)
+
+type certificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// Issues a certificate. Without a parent, the certificate is self-signed as a root.
+func issue(t *testing.T, name string, serial int64, parent *certificate, isCA bool, extension asn1.ObjectIdentifier) *certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-24 * time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	if extension != nil {
+		template.ExtraExtensions = []pkix.Extension{{Id: extension, Value: []byte{0x05, 0x00}}}
+	}
+	signer := &certificate{cert: template, key: key}
+	if parent != nil {
+		signer = parent
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer.cert, &key.PublicKey, signer.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &certificate{cert: cert, key: key}
+}
+
+func setOf(t *testing.T, value interface{}) asn1.RawValue {
+	der, err := asn1.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: der}
+}
+
+// Signs the token data as an Apple device would, producing a detached PKCS#7 signature.
+func sign(t *testing.T, token *applepay.PKPaymentToken, signingTime time.Time, leaf, intermediate *certificate) []byte {
+	digest := sha256.Sum256(signedData(token))
+	attributes, err := asn1.MarshalWithParams([]attribute{
+		{Type: signingTimeOID, Values: setOf(t, signingTime.UTC())},
+		{Type: messageDigestOID, Values: setOf(t, digest[:])},
+	}, "set")
+	if err != nil {
+		t.Fatal(err)
+	}
+	attributesDigest := sha256.Sum256(attributes)
+	sig, err := ecdsa.SignASN1(rand.Reader, leaf.key, attributesDigest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	implicitAttributes := append([]byte{0xa0}, attributes[1:]...)
+
+	certs := append(append([]byte{}, leaf.cert.Raw...), intermediate.cert.Raw...)
+	content, err := asn1.Marshal(signedDataContent{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: sha256OID}},
+		ContentInfo:      asn1.RawValue{FullBytes: []byte{0x30, 0x0b, 0x06, 0x09, 0x2a, 0x86, 0x48, 0x86, 0xf7, 0x0d, 0x01, 0x07, 0x01}},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certs},
+		SignerInfos: []signerInfo{{
+			Version: 1,
+			IssuerAndSerialNumber: issuerAndSerialNumber{
+				Issuer:       asn1.RawValue{FullBytes: leaf.cert.RawIssuer},
+				SerialNumber: leaf.cert.SerialNumber,
+			},
+			DigestAlgorithm:           pkix.AlgorithmIdentifier{Algorithm: sha256OID},
+			AuthenticatedAttributes:   asn1.RawValue{FullBytes: implicitAttributes},
+			DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: ecdsaWithSHA256OID},
+			EncryptedDigest:           sig,
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := asn1.Marshal(contentInfo{
+		ContentType: signedDataOID,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: content},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signature
+}
+
+type chain struct {
+	root, intermediate, leaf *certificate
+}
+
+func newChain(t *testing.T) *chain {
+	root := issue(t, "Test Apple Root CA", 1, nil, true, nil)
+	intermediate := issue(t, "Test Apple Application Integration CA", 2, root, true, intermediateCertificateOID)
+	leaf := issue(t, "Test ecc-smp-broker-sign", 3, intermediate, false, leafCertificateOID)
+	return &chain{root, intermediate, leaf}
+}
+
+func newToken() *applepay.PKPaymentToken {
+	token := &applepay.PKPaymentToken{}
+	token.PaymentData.Version = "EC_v1"
+	token.PaymentData.Data = []byte("encrypted payment data")
+	token.PaymentData.Header.EphemeralPublicKey = []byte("ephemeral public key")
+	token.PaymentData.Header.TransactionID = hex.EncodeToString([]byte("transaction"))
+	return token
+}
+
+func TestVerify(t *testing.T) {
+	c := newChain(t)
+	token := newToken()
+	now := time.Now()
+	token.PaymentData.Signature = sign(t, token, now.Add(-time.Minute), c.leaf, c.intermediate)
+
+	verifier := New(c.root.cert, 5*time.Minute, false)
+	assert.NoError(t, verifier.Verify(token, now))
+}
+
+func TestVerifyTamperedData(t *testing.T) {
+	c := newChain(t)
+	token := newToken()
+	now := time.Now()
+	token.PaymentData.Signature = sign(t, token, now, c.leaf, c.intermediate)
+	token.PaymentData.Data = []byte("replaced payment data")
+
+	err := New(c.root.cert, 5*time.Minute, false).Verify(token, now)
+	assertReason(t, ReasonSignature, err)
+}
+
+func TestVerifyTamperedTransactionId(t *testing.T) {
+	c := newChain(t)
+	token := newToken()
+	now := time.Now()
+	token.PaymentData.Signature = sign(t, token, now, c.leaf, c.intermediate)
+	token.PaymentData.Header.TransactionID = hex.EncodeToString([]byte("another"))
+
+	err := New(c.root.cert, 5*time.Minute, false).Verify(token, now)
+	assertReason(t, ReasonSignature, err)
+}
+
+func TestVerifyUntrustedRoot(t *testing.T) {
+	c := newChain(t)
+	other := newChain(t)
+	token := newToken()
+	now := time.Now()
+	token.PaymentData.Signature = sign(t, token, now, c.leaf, c.intermediate)
+
+	err := New(other.root.cert, 5*time.Minute, false).Verify(token, now)
+	assertReason(t, ReasonChain, err)
+}
+
+func TestVerifyMissingExtension(t *testing.T) {
+	c := newChain(t)
+	leaf := issue(t, "Not Apple", 4, c.intermediate, false, nil)
+	token := newToken()
+	now := time.Now()
+	token.PaymentData.Signature = sign(t, token, now, leaf, c.intermediate)
+
+	err := New(c.root.cert, 5*time.Minute, false).Verify(token, now)
+	assertReason(t, ReasonCertificate, err)
+}
+
+func TestVerifySigningTime(t *testing.T) {
+	c := newChain(t)
+	token := newToken()
+	now := time.Now()
+	token.PaymentData.Signature = sign(t, token, now.Add(-10*time.Minute), c.leaf, c.intermediate)
+
+	err := New(c.root.cert, 5*time.Minute, false).Verify(token, now)
+	assertReason(t, ReasonSigningTime, err)
+	assert.True(t, IsRejectedSigningTime(err))
+
+	err = New(c.root.cert, 5*time.Minute, true).Verify(token, now)
+	assert.NoError(t, err, "The signing time window is not enforced in sandbox.")
+
+	err = New(c.root.cert, 5*time.Minute, false).Verify(token, now.Add(-20*time.Minute))
+	assertReason(t, ReasonSigningTime, err)
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	c := newChain(t)
+	token := newToken()
+	token.PaymentData.Signature = []byte("not a signature")
+	err := New(c.root.cert, 5*time.Minute, false).Verify(token, time.Now())
+	assertReason(t, ReasonMalformed, err)
+
+	token.PaymentData.Version = "EC_v2"
+	err = New(c.root.cert, 5*time.Minute, false).Verify(token, time.Now())
+	assertReason(t, ReasonVersion, err)
+}
+
+func TestLoadRootCertificate(t *testing.T) {
+	c := newChain(t)
+	dir, err := ioutil.TempDir("", "applepaytoken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "root.crt")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.root.cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256(c.root.cert.Raw)
+	root, err := LoadRootCertificate(path, hex.EncodeToString(sum[:]))
+	if assert.NoError(t, err) {
+		assert.Equal(t, c.root.cert.Raw, root.Raw)
+	}
+	_, err = LoadRootCertificate(path, "00")
+	assert.Error(t, err, "A root not matching the pinned fingerprint should be rejected.")
+}
+
+func assertReason(t *testing.T, reason Reason, err error) {
+	if assert.Error(t, err) && assert.True(t, IsVerificationError(err)) {
+		assert.Equal(t, reason, err.(*VerificationError).Reason, err.Error())
+	}
+}
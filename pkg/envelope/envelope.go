@@ -0,0 +1,144 @@
+// Package envelope encrypts payment data at rest, with a data key per record wrapped by a KeyProvider.
+// Sealed values are JSON, so they may be stored wherever the plain value was. ie. A payment token's AWSJSON value
+package envelope
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+	config "github.com/spf13/viper"
+)
+
+// Version of the envelope format.
+const Version = "v1"
+
+// Envelope is a sealed value. The data key is bound to the record it was sealed for, so sealed values cannot be swapped between records.
+type Envelope struct {
+	Version string `json:"envelope"`
+	// The key the data key is wrapped with.
+	KeyID      string `json:"kid"`
+	WrappedKey []byte `json:"key"`
+	// AES-256-GCM, with the nonce prepended.
+	Data []byte `json:"data"`
+}
+
+type Sealer struct {
+	provider KeyProvider
+}
+
+// New creates a sealer with the key provider set by "tokens.encryption.provider". Returns nil when values are not to be encrypted.
+func New() (*Sealer, error) {
+	if config.GetString("tokens.encryption.provider") == "" {
+		return nil, nil
+	}
+	provider, err := NewKeyProvider()
+	if err != nil {
+		return nil, err
+	}
+	return NewSealer(provider), nil
+}
+
+func NewSealer(provider KeyProvider) *Sealer {
+	return &Sealer{
+		provider: provider,
+	}
+}
+
+// IsSealed checks whether the value is an envelope. Values stored before encryption was enabled are not.
+func IsSealed(value string) bool {
+	if !strings.Contains(value, `"envelope"`) {
+		return false
+	}
+	_, err := parse(value)
+	return err == nil
+}
+
+// Seal encrypts the value for the record given, with a new data key.
+func (s *Sealer) Seal(ctx context.Context, recordId string, value string) (string, error) {
+	dataKey, wrappedKey, err := s.provider.GenerateDataKey(ctx)
+	if err != nil {
+		return "", err
+	}
+	data, err := seal(dataKey, []byte(value), []byte(recordId))
+	if err != nil {
+		return "", errors.Wrap(err, "Could not encrypt value")
+	}
+	return format(&Envelope{
+		Version:    Version,
+		KeyID:      s.provider.KeyID(),
+		WrappedKey: wrappedKey,
+		Data:       data,
+	})
+}
+
+// Open decrypts a value sealed for the record given. Values that are not sealed are returned as they are.
+func (s *Sealer) Open(ctx context.Context, recordId string, value string) (string, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+	envelope, err := parse(value)
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := s.provider.Unwrap(ctx, envelope.KeyID, envelope.WrappedKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataKey, envelope.Data, []byte(recordId))
+	if err != nil {
+		return "", errors.Wrap(err, "Could not decrypt value")
+	}
+	return string(plaintext), nil
+}
+
+// Rotate re-wraps the value's data key with the current key, and seals values that are not yet sealed.
+// Returns whether the value changed. The data itself is only re-encrypted when it was not sealed.
+func (s *Sealer) Rotate(ctx context.Context, recordId string, value string) (string, bool, error) {
+	if !IsSealed(value) {
+		sealed, err := s.Seal(ctx, recordId, value)
+		return sealed, err == nil, err
+	}
+	envelope, err := parse(value)
+	if err != nil {
+		return "", false, err
+	}
+	if envelope.KeyID == s.provider.KeyID() {
+		return value, false, nil
+	}
+	dataKey, err := s.provider.Unwrap(ctx, envelope.KeyID, envelope.WrappedKey)
+	if err != nil {
+		return "", false, err
+	}
+	wrappedKey, err := s.provider.Wrap(ctx, dataKey)
+	if err != nil {
+		return "", false, err
+	}
+	envelope.KeyID = s.provider.KeyID()
+	envelope.WrappedKey = wrappedKey
+	rotated, err := format(envelope)
+	return rotated, err == nil, err
+}
+
+func parse(value string) (*Envelope, error) {
+	envelope := &Envelope{}
+	if err := json.Unmarshal([]byte(value), envelope); err != nil {
+		return nil, errors.Wrap(err, "Could not read envelope")
+	}
+	if envelope.Version != Version {
+		return nil, errors.Errorf("Unsupported envelope version %q", envelope.Version)
+	}
+	if envelope.KeyID == "" || len(envelope.WrappedKey) == 0 || len(envelope.Data) == 0 {
+		return nil, errors.New("Envelope is incomplete")
+	}
+	return envelope, nil
+}
+
+func format(envelope *Envelope) (string, error) {
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return "", errors.Wrap(err, "Could not write envelope")
+	}
+	return string(data), nil
+}
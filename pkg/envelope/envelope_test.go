@@ -0,0 +1,172 @@
+package envelope
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+const value = `{"paymentMethodData":{"tokenizationData":{"type":"PAYMENT_GATEWAY","token":"tok_chargeable"}}}`
+
+// Writes a base64 encoded key file to the directory.
+func writeKey(t *testing.T, dir string, name string) {
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func fileProvider(t *testing.T, dir string) *FileKeyProvider {
+	provider, err := LoadFileKeyProvider(filepath.Join(dir, "token-key*.key"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func TestSealAndOpen(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	writeKey(t, dir, "token-key-1.key")
+	sealer := NewSealer(fileProvider(t, dir))
+	ctx := context.Background()
+
+	sealed, err := sealer.Seal(ctx, "tok_xxx", value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(IsSealed(sealed))
+	assert.NotContains(sealed, "tok_chargeable", "The value should not be readable once sealed.")
+
+	opened, err := sealer.Open(ctx, "tok_xxx", sealed)
+	assert.NoError(err)
+	assert.Equal(value, opened)
+
+	_, err = sealer.Open(ctx, "tok_yyy", sealed)
+	assert.Error(err, "A value sealed for one record should not open for another.")
+
+	opened, err = sealer.Open(ctx, "tok_xxx", value)
+	assert.NoError(err)
+	assert.Equal(value, opened, "Values stored before encryption should be returned as they are.")
+	assert.False(IsSealed(value))
+	assert.False(IsSealed("{}"))
+}
+
+func TestRotate(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	writeKey(t, dir, "token-key-1.key")
+	ctx := context.Background()
+
+	previous := NewSealer(fileProvider(t, dir))
+	sealed, err := previous.Seal(ctx, "tok_xxx", value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeKey(t, dir, "token-key-2.key")
+	provider := fileProvider(t, dir)
+	assert.Equal("token-key-2", provider.KeyID(), "The newest key should wrap new data keys.")
+	sealer := NewSealer(provider)
+
+	rotated, changed, err := sealer.Rotate(ctx, "tok_xxx", sealed)
+	assert.NoError(err)
+	assert.True(changed)
+	envelope, _ := parse(rotated)
+	assert.Equal("token-key-2", envelope.KeyID)
+	opened, err := sealer.Open(ctx, "tok_xxx", rotated)
+	assert.NoError(err)
+	assert.Equal(value, opened)
+
+	_, changed, err = sealer.Rotate(ctx, "tok_xxx", rotated)
+	assert.NoError(err)
+	assert.False(changed, "Values wrapped with the current key are left as they are.")
+
+	rotated, changed, err = sealer.Rotate(ctx, "tok_yyy", value)
+	assert.NoError(err)
+	assert.True(changed, "Values stored before encryption should be sealed.")
+	assert.True(IsSealed(rotated))
+}
+
+func TestLoadFileKeyProvider(t *testing.T) {
+	dir := t.TempDir()
+	_, err := LoadFileKeyProvider(filepath.Join(dir, "*.key"), "")
+	assert.Error(t, err, "Some key is required.")
+
+	writeKey(t, dir, "token-key-1.key")
+	_, err = LoadFileKeyProvider(filepath.Join(dir, "*.key"), "token-key-9")
+	assert.Error(t, err, "The current key should be loaded.")
+
+	_ = ioutil.WriteFile(filepath.Join(dir, "short.key"), []byte(base64.StdEncoding.EncodeToString([]byte("short"))), 0600)
+	_, err = LoadFileKeyProvider(filepath.Join(dir, "*.key"), "")
+	assert.Error(t, err, "Keys should be 32 bytes.")
+}
+
+// Stands in for KMS, wrapping data keys with a local key per KMS key.
+type kmsStandIn struct {
+	kmsiface.KMSAPI
+	keys map[string][]byte
+}
+
+func (k *kmsStandIn) GenerateDataKeyWithContext(ctx aws.Context, input *kms.GenerateDataKeyInput, _ ...request.Option) (*kms.GenerateDataKeyOutput, error) {
+	dataKey := make([]byte, 32)
+	_, _ = rand.Read(dataKey)
+	encrypted, err := k.EncryptWithContext(ctx, &kms.EncryptInput{KeyId: input.KeyId, Plaintext: dataKey})
+	if err != nil {
+		return nil, err
+	}
+	return &kms.GenerateDataKeyOutput{KeyId: input.KeyId, Plaintext: dataKey, CiphertextBlob: encrypted.CiphertextBlob}, nil
+}
+
+func (k *kmsStandIn) EncryptWithContext(ctx aws.Context, input *kms.EncryptInput, _ ...request.Option) (*kms.EncryptOutput, error) {
+	blob, err := seal(k.keys[*input.KeyId], input.Plaintext, nil)
+	return &kms.EncryptOutput{KeyId: input.KeyId, CiphertextBlob: blob}, err
+}
+
+func (k *kmsStandIn) DecryptWithContext(ctx aws.Context, input *kms.DecryptInput, _ ...request.Option) (*kms.DecryptOutput, error) {
+	key, ok := k.keys[*input.KeyId]
+	if !ok {
+		return nil, errors.New("NotFoundException")
+	}
+	plaintext, err := open(key, input.CiphertextBlob, nil)
+	return &kms.DecryptOutput{KeyId: input.KeyId, Plaintext: plaintext}, err
+}
+
+func TestKMSKeyProvider(t *testing.T) {
+	assert := assert.New(t)
+	ctx := context.Background()
+	client := &kmsStandIn{keys: map[string][]byte{}}
+	for _, alias := range []string{"alias/buyte-tokens", "alias/buyte-tokens-2"} {
+		key := make([]byte, 32)
+		_, _ = rand.Read(key)
+		client.keys[alias] = key
+	}
+
+	sealer := NewSealer(&KMSKeyProvider{Client: client, Key: "alias/buyte-tokens"})
+	sealed, err := sealer.Seal(ctx, "tok_xxx", value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened, err := sealer.Open(ctx, "tok_xxx", sealed)
+	assert.NoError(err)
+	assert.Equal(value, opened)
+
+	rotator := NewSealer(&KMSKeyProvider{Client: client, Key: "alias/buyte-tokens-2"})
+	rotated, changed, err := rotator.Rotate(ctx, "tok_xxx", sealed)
+	assert.NoError(err)
+	assert.True(changed)
+	opened, err = rotator.Open(ctx, "tok_xxx", rotated)
+	assert.NoError(err)
+	assert.Equal(value, opened)
+}
@@ -0,0 +1,212 @@
+package envelope
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/pkg/errors"
+	config "github.com/spf13/viper"
+)
+
+// Data keys are AES-256 keys.
+const dataKeySize = 32
+
+// KeyProvider issues the data keys payment data is encrypted with, wrapped by a key only the provider holds.
+type KeyProvider interface {
+	// KeyID is the key new data keys are wrapped with.
+	KeyID() string
+	// GenerateDataKey returns a new data key, and the data key wrapped by the current key.
+	GenerateDataKey(ctx context.Context) (dataKey []byte, wrappedKey []byte, err error)
+	// Unwrap returns the data key wrapped by the key given.
+	Unwrap(ctx context.Context, keyId string, wrappedKey []byte) ([]byte, error)
+	// Wrap wraps a data key with the current key. ie. To rotate a data key wrapped by a previous key.
+	Wrap(ctx context.Context, dataKey []byte) ([]byte, error)
+}
+
+// NewKeyProvider creates the key provider set by "tokens.encryption.provider"
+func NewKeyProvider() (KeyProvider, error) {
+	switch config.GetString("tokens.encryption.provider") {
+	case "kms":
+		keyId := config.GetString("tokens.encryption.key_id")
+		if keyId == "" {
+			return nil, errors.New("'tokens.encryption.key_id' is required for the kms provider")
+		}
+		sess, err := session.NewSession(
+			&aws.Config{Region: aws.String(config.GetString("tokens.encryption.region"))},
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "Could not create AWS session")
+		}
+		return &KMSKeyProvider{
+			Client: kms.New(sess),
+			Key:    keyId,
+		}, nil
+	case "file":
+		return LoadFileKeyProvider(config.GetString("tokens.encryption.keys"), config.GetString("tokens.encryption.key_id"))
+	}
+	return nil, errors.New("Invalid 'tokens.encryption.provider'")
+}
+
+// FileKeyProvider wraps data keys with AES-256 keys read from local files. For development, or hosts without a KMS.
+type FileKeyProvider struct {
+	keys    map[string][]byte
+	current string
+}
+
+// LoadFileKeyProvider loads every key matching the glob, identified by file name without its extension. ie. token-key-2026-10.key is "token-key-2026-10"
+// Each file holds a base64 encoded 32 byte key. ie. `openssl rand -base64 32`
+// Data keys are wrapped with the key given, otherwise the last key in path order. Keys are rotated by adding a newer key while previous keys are still loaded.
+func LoadFileKeyProvider(pattern string, currentKeyId string) (*FileKeyProvider, error) {
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid token encryption key pattern")
+	}
+	sort.Strings(paths)
+	provider := &FileKeyProvider{
+		keys: map[string][]byte{},
+	}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "Could not read token encryption key "+path)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) != dataKeySize {
+			return nil, errors.New("Token encryption key " + path + " is not a base64 encoded 32 byte key")
+		}
+		keyId := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		provider.keys[keyId] = key
+		provider.current = keyId
+	}
+	if len(provider.keys) == 0 {
+		return nil, errors.New("No token encryption keys match " + pattern)
+	}
+	if currentKeyId != "" {
+		if _, ok := provider.keys[currentKeyId]; !ok {
+			return nil, errors.New("Token encryption key " + currentKeyId + " is not loaded")
+		}
+		provider.current = currentKeyId
+	}
+	return provider, nil
+}
+
+func (p *FileKeyProvider) KeyID() string {
+	return p.current
+}
+
+func (p *FileKeyProvider) GenerateDataKey(ctx context.Context) ([]byte, []byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, nil, errors.Wrap(err, "Could not generate data key")
+	}
+	wrappedKey, err := p.Wrap(ctx, dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return dataKey, wrappedKey, nil
+}
+
+func (p *FileKeyProvider) Wrap(ctx context.Context, dataKey []byte) ([]byte, error) {
+	return seal(p.keys[p.current], dataKey, []byte(p.current))
+}
+
+func (p *FileKeyProvider) Unwrap(ctx context.Context, keyId string, wrappedKey []byte) ([]byte, error) {
+	key, ok := p.keys[keyId]
+	if !ok {
+		return nil, errors.New("Token encryption key " + keyId + " is not loaded")
+	}
+	dataKey, err := open(key, wrappedKey, []byte(keyId))
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not unwrap data key")
+	}
+	return dataKey, nil
+}
+
+// KMSKeyProvider wraps data keys with an AWS KMS key, which never leaves KMS.
+type KMSKeyProvider struct {
+	Client kmsiface.KMSAPI
+	// The key id, ARN or alias data keys are wrapped with. ie. alias/buyte-tokens
+	Key string
+}
+
+func (p *KMSKeyProvider) KeyID() string {
+	return p.Key
+}
+
+func (p *KMSKeyProvider) GenerateDataKey(ctx context.Context) ([]byte, []byte, error) {
+	output, err := p.Client.GenerateDataKeyWithContext(ctx, &kms.GenerateDataKeyInput{
+		KeyId:   aws.String(p.Key),
+		KeySpec: aws.String(kms.DataKeySpecAes256),
+	})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Could not generate data key")
+	}
+	return output.Plaintext, output.CiphertextBlob, nil
+}
+
+func (p *KMSKeyProvider) Wrap(ctx context.Context, dataKey []byte) ([]byte, error) {
+	output, err := p.Client.EncryptWithContext(ctx, &kms.EncryptInput{
+		KeyId:     aws.String(p.Key),
+		Plaintext: dataKey,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not wrap data key")
+	}
+	return output.CiphertextBlob, nil
+}
+
+func (p *KMSKeyProvider) Unwrap(ctx context.Context, keyId string, wrappedKey []byte) ([]byte, error) {
+	output, err := p.Client.DecryptWithContext(ctx, &kms.DecryptInput{
+		KeyId:          aws.String(keyId),
+		CiphertextBlob: wrappedKey,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not unwrap data key")
+	}
+	return output.Plaintext, nil
+}
+
+// AES-256-GCM, with the nonce prepended to the ciphertext.
+func seal(key []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.Wrap(err, "Could not generate nonce")
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key []byte, ciphertext []byte, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("Ciphertext is too short")
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid key")
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,287 @@
+package googlepay
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ProtocolVersionECv2 = "ECv2"
+	// Tokens are always sent by Google.
+	SenderID = "Google"
+)
+
+// Tokenization types, as a payment method's tokenizationData.type
+const (
+	TokenizationTypeGateway = "PAYMENT_GATEWAY"
+	TokenizationTypeDirect  = "DIRECT"
+)
+
+// Authentication methods of decrypted card payment data.
+// PAN_ONLY cards are stored in the customer's Google account. CRYPTOGRAM_3DS cards are network tokens provisioned to a device.
+const (
+	AuthMethodPANOnly       = "PAN_ONLY"
+	AuthMethodCryptogram3DS = "CRYPTOGRAM_3DS"
+)
+
+// Token is the payment method token of a DIRECT tokenization, as the tokenizationData.token string.
+// See https://developers.google.com/pay/api/web/guides/resources/payment-data-cryptography
+type Token struct {
+	ProtocolVersion        string                 `json:"protocolVersion"`
+	Signature              string                 `json:"signature"`
+	IntermediateSigningKey IntermediateSigningKey `json:"intermediateSigningKey"`
+	SignedMessage          string                 `json:"signedMessage"`
+}
+
+type IntermediateSigningKey struct {
+	// JSON encoded SignedKey
+	SignedKey  string   `json:"signedKey"`
+	Signatures []string `json:"signatures"`
+}
+
+type SignedKey struct {
+	KeyValue      string `json:"keyValue"`
+	KeyExpiration string `json:"keyExpiration"`
+}
+
+type SignedMessage struct {
+	EncryptedMessage   string `json:"encryptedMessage"`
+	EphemeralPublicKey string `json:"ephemeralPublicKey"`
+	Tag                string `json:"tag"`
+}
+
+// PaymentData is the decrypted form of a token's encryptedMessage
+type PaymentData struct {
+	GatewayMerchantID    string               `json:"gatewayMerchantId,omitempty"`
+	MessageExpiration    string               `json:"messageExpiration"`
+	MessageID            string               `json:"messageId"`
+	PaymentMethod        string               `json:"paymentMethod"`
+	PaymentMethodDetails PaymentMethodDetails `json:"paymentMethodDetails"`
+}
+
+type PaymentMethodDetails struct {
+	AuthMethod string `json:"authMethod"`
+	// The card's PAN for PAN_ONLY, or the device PAN (DPAN) for CRYPTOGRAM_3DS
+	Pan             string `json:"pan"`
+	ExpirationMonth int    `json:"expirationMonth"`
+	ExpirationYear  int    `json:"expirationYear"`
+	// Base64 encoded 3-D Secure cryptogram. CRYPTOGRAM_3DS only
+	Cryptogram   string `json:"cryptogram,omitempty"`
+	EciIndicator string `json:"eciIndicator,omitempty"`
+}
+
+// IsCryptogram3DS checks whether the payment data is a network token with a cryptogram.
+func (p *PaymentData) IsCryptogram3DS() bool {
+	return p.PaymentMethodDetails.AuthMethod == AuthMethodCryptogram3DS
+}
+
+// Decryptor verifies and decrypts DIRECT tokenization tokens sent to a Google Pay merchant.
+type Decryptor struct {
+	MerchantID string
+
+	rootKeys    []*RootKey
+	privateKeys []*ecdsa.PrivateKey
+}
+
+// NewDecryptor creates a Decryptor for a merchant. Tokens are decrypted with any one of the private keys, the first being the current key.
+func NewDecryptor(merchantID string, rootKeys []*RootKey, privateKeys []*ecdsa.PrivateKey) *Decryptor {
+	return &Decryptor{
+		MerchantID:  merchantID,
+		rootKeys:    rootKeys,
+		privateKeys: privateKeys,
+	}
+}
+
+// PublicKey returns the public key of the current private key, for the tokenizationSpecification of a payment request.
+func (d *Decryptor) PublicKey() string {
+	if len(d.privateKeys) == 0 {
+		return ""
+	}
+	return PublicKey(d.privateKeys[0])
+}
+
+// Decrypt verifies a token was signed by Google for this merchant, and decrypts its payment data.
+func (d *Decryptor) Decrypt(tokenData string, now time.Time) (*PaymentData, error) {
+	token := &Token{}
+	if err := json.Unmarshal([]byte(tokenData), token); err != nil {
+		return nil, newError(ReasonMalformed, err)
+	}
+	if token.ProtocolVersion != ProtocolVersionECv2 {
+		return nil, newError(ReasonVersion, errors.Errorf("Protocol version %q is not supported", token.ProtocolVersion))
+	}
+
+	intermediateKey, err := d.verifyIntermediateSigningKey(token, now)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.StdEncoding.DecodeString(token.Signature)
+	if err != nil {
+		return nil, newError(ReasonMalformed, err)
+	}
+	signed := signedBytes(SenderID, "merchant:"+d.MerchantID, token.ProtocolVersion, token.SignedMessage)
+	if !verify(intermediateKey, signed, signature) {
+		return nil, newError(ReasonSignature, errors.New("Message signature does not match the intermediate signing key"))
+	}
+
+	message := &SignedMessage{}
+	if err := json.Unmarshal([]byte(token.SignedMessage), message); err != nil {
+		return nil, newError(ReasonMalformed, err)
+	}
+	plaintext, err := d.decrypt(message)
+	if err != nil {
+		return nil, err
+	}
+
+	paymentData := &PaymentData{}
+	if err := json.Unmarshal(plaintext, paymentData); err != nil {
+		return nil, newError(ReasonMalformed, err)
+	}
+	expiration, err := parseMillis(paymentData.MessageExpiration)
+	if err != nil {
+		return nil, newError(ReasonMalformed, errors.Wrap(err, "Invalid messageExpiration"))
+	}
+	if !now.Before(expiration) {
+		return nil, newError(ReasonMessageExpired, errors.Errorf("Message expired at %s", expiration.UTC().Format(time.RFC3339)))
+	}
+	return paymentData, nil
+}
+
+// Check the intermediate signing key is signed by one of Google's root keys and has not expired.
+func (d *Decryptor) verifyIntermediateSigningKey(token *Token, now time.Time) (*ecdsa.PublicKey, error) {
+	signed := signedBytes(SenderID, token.ProtocolVersion, token.IntermediateSigningKey.SignedKey)
+	trusted := false
+	for _, rootKey := range d.rootKeys {
+		if rootKey.ProtocolVersion != token.ProtocolVersion || rootKey.Expired(now) {
+			continue
+		}
+		for _, encoded := range token.IntermediateSigningKey.Signatures {
+			signature, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				return nil, newError(ReasonMalformed, err)
+			}
+			if verify(rootKey.PublicKey, signed, signature) {
+				trusted = true
+				break
+			}
+		}
+		if trusted {
+			break
+		}
+	}
+	if !trusted {
+		return nil, newError(ReasonIntermediateKey, errors.New("Intermediate signing key is not signed by a Google root signing key"))
+	}
+
+	signedKey := &SignedKey{}
+	if err := json.Unmarshal([]byte(token.IntermediateSigningKey.SignedKey), signedKey); err != nil {
+		return nil, newError(ReasonMalformed, err)
+	}
+	expiration, err := parseMillis(signedKey.KeyExpiration)
+	if err != nil {
+		return nil, newError(ReasonMalformed, errors.Wrap(err, "Invalid keyExpiration"))
+	}
+	if !now.Before(expiration) {
+		return nil, newError(ReasonKeyExpired, errors.Errorf("Intermediate signing key expired at %s", expiration.UTC().Format(time.RFC3339)))
+	}
+	publicKey, err := parsePublicKey(signedKey.KeyValue)
+	if err != nil {
+		return nil, newError(ReasonMalformed, err)
+	}
+	return publicKey, nil
+}
+
+// Decrypt the encrypted message with whichever private key it was encrypted for.
+// ECIES-KEM with HKDF-SHA256, AES-256-CTR and HMAC-SHA256, per Google's ECv2 protocol.
+func (d *Decryptor) decrypt(message *SignedMessage) ([]byte, error) {
+	ephemeralPublicKey, err := base64.StdEncoding.DecodeString(message.EphemeralPublicKey)
+	if err != nil {
+		return nil, newError(ReasonMalformed, err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(message.EncryptedMessage)
+	if err != nil {
+		return nil, newError(ReasonMalformed, err)
+	}
+	tag, err := base64.StdEncoding.DecodeString(message.Tag)
+	if err != nil {
+		return nil, newError(ReasonMalformed, err)
+	}
+	x, y := elliptic.Unmarshal(elliptic.P256(), ephemeralPublicKey)
+	if x == nil {
+		return nil, newError(ReasonMalformed, errors.New("Invalid ephemeral public key"))
+	}
+
+	for _, privateKey := range d.privateKeys {
+		sharedX, _ := privateKey.Curve.ScalarMult(x, y, privateKey.D.Bytes())
+		sharedSecret := make([]byte, (privateKey.Curve.Params().BitSize+7)/8)
+		sharedX.FillBytes(sharedSecret)
+
+		keys := hkdf(append(append([]byte{}, ephemeralPublicKey...), sharedSecret...), []byte(SenderID), 64)
+		symmetricKey, macKey := keys[:32], keys[32:]
+
+		mac := hmac.New(sha256.New, macKey)
+		mac.Write(ciphertext)
+		if !hmac.Equal(mac.Sum(nil), tag) {
+			// Encrypted for another key.
+			continue
+		}
+
+		block, err := aes.NewCipher(symmetricKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "Could not create the payment data cipher")
+		}
+		plaintext := make([]byte, len(ciphertext))
+		// Google uses an all zero IV.
+		cipher.NewCTR(block, make([]byte, aes.BlockSize)).XORKeyStream(plaintext, ciphertext)
+		return plaintext, nil
+	}
+	if len(d.privateKeys) == 0 {
+		return nil, newError(ReasonUnknownPrivateKey, errors.New("No Google Pay private keys are loaded"))
+	}
+	return nil, newError(ReasonTag, errors.New("Message tag does not match any private key"))
+}
+
+// Signed data is each value prefixed with its length, as a 4 byte little endian integer.
+func signedBytes(values ...string) []byte {
+	var signed []byte
+	for _, value := range values {
+		length := make([]byte, 4)
+		binary.LittleEndian.PutUint32(length, uint32(len(value)))
+		signed = append(signed, length...)
+		signed = append(signed, value...)
+	}
+	return signed
+}
+
+// Signatures are ASN.1 DER encoded ECDSA signatures over the SHA-256 digest.
+func verify(publicKey *ecdsa.PublicKey, data []byte, signature []byte) bool {
+	digest := sha256.Sum256(data)
+	return ecdsa.VerifyASN1(publicKey, digest[:], signature)
+}
+
+// HKDF with SHA-256 and an all zero salt. See RFC 5869.
+func hkdf(secret []byte, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, make([]byte, sha256.Size))
+	extract.Write(secret)
+	prk := extract.Sum(nil)
+
+	var okm, previous []byte
+	for i := byte(1); len(okm) < length; i++ {
+		expand := hmac.New(sha256.New, prk)
+		expand.Write(previous)
+		expand.Write(info)
+		expand.Write([]byte{i})
+		previous = expand.Sum(nil)
+		okm = append(okm, previous...)
+	}
+	return okm[:length]
+}
@@ -0,0 +1,218 @@
+package googlepay
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testMerchantID = "12345678901234567890"
+
+type testKeys struct {
+	root         *ecdsa.PrivateKey
+	intermediate *ecdsa.PrivateKey
+	merchant     *ecdsa.PrivateKey
+}
+
+func generateKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func encodePublicKey(t *testing.T, key *ecdsa.PrivateKey) string {
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(der)
+}
+
+func sign(t *testing.T, key *ecdsa.PrivateKey, data []byte) string {
+	digest := sha256.Sum256(data)
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(signature)
+}
+
+func millis(at time.Time) string {
+	return strconv.FormatInt(at.UnixNano()/int64(time.Millisecond), 10)
+}
+
+// Writes the root key in the format of Google's keys.json, and the merchant key as PEM, then loads both from disk.
+func loadDecryptor(t *testing.T, keys *testKeys) *Decryptor {
+	dir, err := ioutil.TempDir("", "googlepay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	rootKeys, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{
+			{"keyValue": encodePublicKey(t, keys.root), "protocolVersion": ProtocolVersionECv2, "keyExpiration": millis(time.Now().Add(24 * time.Hour))},
+		},
+	})
+	rootPath := filepath.Join(dir, "google-root-signing-keys.json")
+	if err := ioutil.WriteFile(rootPath, rootKeys, 0600); err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(keys.merchant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, "google-merchant-key.pem")
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	loadedRootKeys, err := LoadRootKeys(rootPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKeys, err := LoadPrivateKeys(filepath.Join(dir, "google-merchant*-key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewDecryptor(testMerchantID, loadedRootKeys, privateKeys)
+}
+
+// Creates a token the way Google does, for the merchant's public key.
+func createToken(t *testing.T, keys *testKeys, paymentData *PaymentData, keyExpiration time.Time) string {
+	signedKey, _ := json.Marshal(&SignedKey{
+		KeyValue:      encodePublicKey(t, keys.intermediate),
+		KeyExpiration: millis(keyExpiration),
+	})
+
+	plaintext, _ := json.Marshal(paymentData)
+	ephemeral := generateKey(t)
+	ephemeralPublicKey := elliptic.Marshal(elliptic.P256(), ephemeral.X, ephemeral.Y)
+	sharedX, _ := elliptic.P256().ScalarMult(keys.merchant.X, keys.merchant.Y, ephemeral.D.Bytes())
+	sharedSecret := make([]byte, 32)
+	sharedX.FillBytes(sharedSecret)
+	derived := hkdf(append(append([]byte{}, ephemeralPublicKey...), sharedSecret...), []byte(SenderID), 64)
+	block, _ := aes.NewCipher(derived[:32])
+	ciphertext := make([]byte, len(plaintext))
+	cipher.NewCTR(block, make([]byte, aes.BlockSize)).XORKeyStream(ciphertext, plaintext)
+	mac := hmac.New(sha256.New, derived[32:])
+	mac.Write(ciphertext)
+
+	signedMessage, _ := json.Marshal(&SignedMessage{
+		EncryptedMessage:   base64.StdEncoding.EncodeToString(ciphertext),
+		EphemeralPublicKey: base64.StdEncoding.EncodeToString(ephemeralPublicKey),
+		Tag:                base64.StdEncoding.EncodeToString(mac.Sum(nil)),
+	})
+
+	token, _ := json.Marshal(&Token{
+		ProtocolVersion: ProtocolVersionECv2,
+		Signature:       sign(t, keys.intermediate, signedBytes(SenderID, "merchant:"+testMerchantID, ProtocolVersionECv2, string(signedMessage))),
+		IntermediateSigningKey: IntermediateSigningKey{
+			SignedKey:  string(signedKey),
+			Signatures: []string{sign(t, keys.root, signedBytes(SenderID, ProtocolVersionECv2, string(signedKey)))},
+		},
+		SignedMessage: string(signedMessage),
+	})
+	return string(token)
+}
+
+func cryptogramPaymentData(expiration time.Time) *PaymentData {
+	return &PaymentData{
+		GatewayMerchantID: "buyte",
+		MessageExpiration: millis(expiration),
+		MessageID:         "AH2EjtdjzIeGmLvwrMsqK1fD7JZ",
+		PaymentMethod:     "CARD",
+		PaymentMethodDetails: PaymentMethodDetails{
+			AuthMethod:      AuthMethodCryptogram3DS,
+			Pan:             "4895370012003478",
+			ExpirationMonth: 12,
+			ExpirationYear:  2030,
+			Cryptogram:      "AgAAAAAABk4DWZ4C28yUQAAAAAA=",
+			EciIndicator:    "07",
+		},
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	keys := &testKeys{root: generateKey(t), intermediate: generateKey(t), merchant: generateKey(t)}
+	decryptor := loadDecryptor(t, keys)
+	now := time.Now()
+
+	token := createToken(t, keys, cryptogramPaymentData(now.Add(time.Hour)), now.Add(time.Hour))
+	paymentData, err := decryptor.Decrypt(token, now)
+	if assert.NoError(t, err) {
+		assert.True(t, paymentData.IsCryptogram3DS())
+		assert.Equal(t, "4895370012003478", paymentData.PaymentMethodDetails.Pan)
+		assert.Equal(t, "AgAAAAAABk4DWZ4C28yUQAAAAAA=", paymentData.PaymentMethodDetails.Cryptogram)
+		assert.Equal(t, "07", paymentData.PaymentMethodDetails.EciIndicator)
+		assert.Equal(t, 2030, paymentData.PaymentMethodDetails.ExpirationYear)
+	}
+	assert.Equal(t, PublicKey(keys.merchant), decryptor.PublicKey())
+}
+
+func TestDecryptRejected(t *testing.T) {
+	keys := &testKeys{root: generateKey(t), intermediate: generateKey(t), merchant: generateKey(t)}
+	decryptor := loadDecryptor(t, keys)
+	now := time.Now()
+
+	assertReason := func(t *testing.T, reason Reason, err error) {
+		if assert.True(t, IsVerificationError(err), "%v", err) {
+			assert.Equal(t, reason, err.(*VerificationError).Reason)
+		}
+	}
+
+	t.Run("expired intermediate signing key", func(t *testing.T) {
+		token := createToken(t, keys, cryptogramPaymentData(now.Add(time.Hour)), now.Add(-time.Minute))
+		_, err := decryptor.Decrypt(token, now)
+		assertReason(t, ReasonKeyExpired, err)
+	})
+
+	t.Run("expired message", func(t *testing.T) {
+		token := createToken(t, keys, cryptogramPaymentData(now.Add(-time.Minute)), now.Add(time.Hour))
+		_, err := decryptor.Decrypt(token, now)
+		assertReason(t, ReasonMessageExpired, err)
+	})
+
+	t.Run("untrusted root", func(t *testing.T) {
+		untrusted := &testKeys{root: generateKey(t), intermediate: keys.intermediate, merchant: keys.merchant}
+		token := createToken(t, untrusted, cryptogramPaymentData(now.Add(time.Hour)), now.Add(time.Hour))
+		_, err := decryptor.Decrypt(token, now)
+		assertReason(t, ReasonIntermediateKey, err)
+	})
+
+	t.Run("another merchant", func(t *testing.T) {
+		token := createToken(t, keys, cryptogramPaymentData(now.Add(time.Hour)), now.Add(time.Hour))
+		_, err := NewDecryptor("09876543210987654321", decryptor.rootKeys, decryptor.privateKeys).Decrypt(token, now)
+		assertReason(t, ReasonSignature, err)
+	})
+
+	t.Run("another private key", func(t *testing.T) {
+		other := &testKeys{root: keys.root, intermediate: keys.intermediate, merchant: generateKey(t)}
+		token := createToken(t, other, cryptogramPaymentData(now.Add(time.Hour)), now.Add(time.Hour))
+		_, err := decryptor.Decrypt(token, now)
+		assertReason(t, ReasonTag, err)
+	})
+
+	t.Run("unsupported protocol version", func(t *testing.T) {
+		_, err := decryptor.Decrypt(`{"protocolVersion":"ECv1"}`, now)
+		assertReason(t, ReasonVersion, err)
+	})
+}
@@ -0,0 +1,50 @@
+package googlepay
+
+import (
+	"github.com/pkg/errors"
+)
+
+type Reason string
+
+// Reasons a token failed verification.
+const (
+	ReasonVersion           Reason = "unsupported_protocol_version"
+	ReasonMalformed         Reason = "malformed_token"
+	ReasonIntermediateKey   Reason = "untrusted_intermediate_signing_key"
+	ReasonKeyExpired        Reason = "expired_intermediate_signing_key"
+	ReasonSignature         Reason = "invalid_signature"
+	ReasonTag               Reason = "invalid_tag"
+	ReasonMessageExpired    Reason = "expired_message"
+	ReasonUnknownPrivateKey Reason = "unknown_private_key"
+)
+
+// VerificationError is returned when a token cannot be trusted.
+type VerificationError struct {
+	Reason Reason
+	Err    error
+}
+
+func newError(reason Reason, err error) error {
+	return &VerificationError{
+		Reason: reason,
+		Err:    err,
+	}
+}
+
+func (e *VerificationError) Error() string {
+	return "Google Pay token verification failed (" + string(e.Reason) + "): " + e.Err.Error()
+}
+
+func (e *VerificationError) Unwrap() error {
+	return e.Err
+}
+
+func (e *VerificationError) Cause() error {
+	return e.Err
+}
+
+// IsVerificationError checks whether the token was rejected, as opposed to a failure on Buyte's end.
+func IsVerificationError(err error) bool {
+	var verificationErr *VerificationError
+	return errors.As(err, &verificationErr)
+}
@@ -0,0 +1,172 @@
+package googlepay
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Google's root signing keys are published at these URLs. Tokens from the TEST environment are signed with the test keys.
+const (
+	RootKeysURL     = "https://payments.developers.google.com/paymentmethodtoken/keys.json"
+	TestRootKeysURL = "https://payments.developers.google.com/paymentmethodtoken/test/keys.json"
+)
+
+// RootKey is one of Google's root signing keys.
+type RootKey struct {
+	PublicKey       *ecdsa.PublicKey
+	ProtocolVersion string
+	// Zero where the key does not expire. ECv1 keys have no expiration.
+	Expiration time.Time
+}
+
+// Expired checks whether the key has expired at the given time.
+func (k *RootKey) Expired(at time.Time) bool {
+	return !k.Expiration.IsZero() && !at.Before(k.Expiration)
+}
+
+type rootKeysFile struct {
+	Keys []struct {
+		KeyValue        string `json:"keyValue"`
+		ProtocolVersion string `json:"protocolVersion"`
+		KeyExpiration   string `json:"keyExpiration,omitempty"`
+	} `json:"keys"`
+}
+
+// LoadRootKeys loads Google's root signing keys from a copy of the published keys.json
+func LoadRootKeys(path string) ([]*RootKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not read Google root signing keys "+path)
+	}
+	return ParseRootKeys(data)
+}
+
+// ParseRootKeys parses Google's root signing keys, in the format of the published keys.json
+func ParseRootKeys(data []byte) ([]*RootKey, error) {
+	file := &rootKeysFile{}
+	if err := json.Unmarshal(data, file); err != nil {
+		return nil, errors.Wrap(err, "Could not parse Google root signing keys")
+	}
+	var keys []*RootKey
+	for _, item := range file.Keys {
+		publicKey, err := parsePublicKey(item.KeyValue)
+		if err != nil {
+			return nil, errors.Wrap(err, "Could not parse Google root signing key")
+		}
+		key := &RootKey{
+			PublicKey:       publicKey,
+			ProtocolVersion: item.ProtocolVersion,
+		}
+		if item.KeyExpiration != "" {
+			key.Expiration, err = parseMillis(item.KeyExpiration)
+			if err != nil {
+				return nil, errors.Wrap(err, "Could not parse Google root signing key expiration")
+			}
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("No Google root signing keys found")
+	}
+	return keys, nil
+}
+
+// LoadPrivateKeys loads every merchant private key matching the glob, in path order.
+// Keys are rotated by registering a new public key with Google while the previous key is still loaded.
+func LoadPrivateKeys(pattern string) ([]*ecdsa.PrivateKey, error) {
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid Google Pay private key pattern")
+	}
+	sort.Strings(paths)
+	var keys []*ecdsa.PrivateKey
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "Could not read Google Pay private key "+path)
+		}
+		key, err := ParsePrivateKey(data)
+		if err != nil {
+			return nil, errors.Wrap(err, "Could not load Google Pay private key "+path)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("No Google Pay private keys match " + pattern)
+	}
+	return keys, nil
+}
+
+// ParsePrivateKey parses a PEM encoded NIST P-256 private key, in PKCS #8 or SEC 1 form.
+// ie. As generated by `openssl ecparam -name prime256v1 -genkey -noout`
+func ParsePrivateKey(data []byte) (*ecdsa.PrivateKey, error) {
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("No private key found")
+		}
+		var key interface{}
+		var err error
+		switch block.Type {
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		default:
+			// ie. EC PARAMETERS
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		ecKey, ok := key.(*ecdsa.PrivateKey)
+		if !ok || ecKey.Curve != elliptic.P256() {
+			return nil, errors.New("Private key is not a NIST P-256 key")
+		}
+		return ecKey, nil
+	}
+}
+
+// PublicKey returns the base64 encoded, uncompressed point of a merchant private key's public key.
+// This is the publicKey parameter of a DIRECT tokenizationSpecification.
+func PublicKey(key *ecdsa.PrivateKey) string {
+	return base64.StdEncoding.EncodeToString(elliptic.Marshal(key.Curve, key.X, key.Y))
+}
+
+// Signing keys are base64 encoded X.509 SubjectPublicKeyInfo.
+func parsePublicKey(value string) (*ecdsa.PublicKey, error) {
+	der, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := parsed.(*ecdsa.PublicKey)
+	if !ok || publicKey.Curve != elliptic.P256() {
+		return nil, errors.New("Signing key is not a NIST P-256 key")
+	}
+	return publicKey, nil
+}
+
+// Expirations are milliseconds since the epoch, as a string.
+func parseMillis(value string) (time.Time, error) {
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)), nil
+}
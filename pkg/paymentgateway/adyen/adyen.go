@@ -1,15 +1,12 @@
 package adyen
 
 import (
-	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
@@ -21,6 +18,7 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/paymentgateway/transport"
 	"github.com/rsoury/buyte/pkg/util"
 )
 
@@ -39,13 +37,25 @@ type AdyenAuthoriseParams struct {
 	MerchantAccount string                             `json:"merchantAccount"`
 	Amount          AdyenAmountParams                  `json:"amount"`
 	AdditionalData  AdyenAuthoriseAdditionalDataParams `json:"additionalData"`
-	MpiData         AdyenAuthoriseMpiDataParams        `json:"mpiData"`
+	MpiData         *AdyenAuthoriseMpiDataParams       `json:"mpiData,omitempty"`
+	// Stored credential fields, for payment agreements and the merchant initiated charges made against them.
+	ShopperReference                 string                `json:"shopperReference,omitempty"`
+	ShopperInteraction               string                `json:"shopperInteraction,omitempty"`
+	RecurringProcessingModel         string                `json:"recurringProcessingModel,omitempty"`
+	SelectedRecurringDetailReference string                `json:"selectedRecurringDetailReference,omitempty"`
+	Recurring                        *AdyenRecurringParams `json:"recurring,omitempty"`
+}
+type AdyenRecurringParams struct {
+	Contract string `json:"contract"`
 }
 type AdyenAuthoriseAdditionalDataParams struct {
-	Card               string `json:"card.encrypted.json"`
+	Card               string `json:"card.encrypted.json,omitempty"`
 	Type               string `json:"paymentdatasource.type"`
 	SelectedBrand      string `json:"selectedBrand"`
-	ShopperInteraction string `json:"shopperInteraction"`
+	ShopperInteraction string `json:"shopperInteraction,omitempty"`
+	// EMV payment data replaces the MPI data for EMV Apple Pay tokens.
+	EmvData          string `json:"emvData,omitempty"`
+	EncryptedPINData string `json:"encryptedPINData,omitempty"`
 }
 type AdyenAmountParams struct {
 	Value    int    `json:"value"`
@@ -74,9 +84,17 @@ type AdyenGooglePayPaymentMethodParams struct {
 	Token string `json:"paywithgoogle.token"`
 }
 
+// Adyen's recurring processing model for each payment agreement type.
+var RecurringProcessingModel = map[string]string{
+	buyte.PAYMENT_AGREEMENT_RECURRING:        "Subscription",
+	buyte.PAYMENT_AGREEMENT_DEFERRED:         "UnscheduledCardOnFile",
+	buyte.PAYMENT_AGREEMENT_AUTOMATIC_RELOAD: "UnscheduledCardOnFile",
+}
+
 var CardTypeSource = map[string]string{
-	"Apple Pay":  "applepay",
-	"Google Pay": "paywithgoogle",
+	"Apple Pay":   "applepay",
+	"Google Pay":  "paywithgoogle",
+	"Samsung Pay": "samsungpay",
 }
 
 type AdyenCredentials struct {
@@ -118,11 +136,13 @@ func New(ctx context.Context, connection *buyte.ProviderCheckoutConnection) (*Ga
 	}
 
 	return &Gateway{
-		Type:        connection.Type,
-		IsTest:      connection.IsTest,
-		Credentials: credentials,
-		Context:     ctx,
-		Logger:      zap.S().With("package", "paymentgateway.adyen"),
+		Type:         connection.Type,
+		IsTest:       connection.IsTest,
+		ConnectionId: connection.ID,
+		Credentials:  credentials,
+		Context:      ctx,
+		Logger:       zap.S().With("package", "paymentgateway.adyen"),
+		Transport:    transport.Default(),
 	}, nil
 }
 
@@ -154,15 +174,7 @@ func (g *Gateway) Charge(input *buyte.CreateChargeInput, networkToken *buyte.Net
 	}
 
 	// Build Authorise request
-	cryptogram, err := util.DecodeCryptogram(networkToken.PaymentData.OnlinePaymentCryptogram)
-	if err != nil {
-		return &buyte.GatewayCharge{}, stacktrace.Propagate(err, "Could not build authorisation request")
-	}
 	description := g.getDescription(input, paymentToken)
-	eci := "07"
-	if networkToken.PaymentData.ECIIndicator != "" {
-		eci = util.Rjust(networkToken.PaymentData.ECIIndicator, 2, "0")
-	}
 	authParams := &AdyenAuthoriseParams{
 		Reference:       description,
 		MerchantAccount: g.AdyenCredentials().MerchantAccount,
@@ -176,16 +188,64 @@ func (g *Gateway) Charge(input *buyte.CreateChargeInput, networkToken *buyte.Net
 			SelectedBrand:      CardTypeSource[paymentToken.PaymentMethod.Name],
 			ShopperInteraction: "Ecommerce",
 		},
-		MpiData: AdyenAuthoriseMpiDataParams{
+	}
+	if networkToken.IsEMV() {
+		authParams.AdditionalData.EmvData = networkToken.EMVData()
+		authParams.AdditionalData.EncryptedPINData = networkToken.EncryptedPINData()
+	} else {
+		cryptogram, err := util.DecodeCryptogram(networkToken.PaymentData.OnlinePaymentCryptogram)
+		if err != nil {
+			return &buyte.GatewayCharge{}, stacktrace.Propagate(err, "Could not build authorisation request")
+		}
+		eci := "07"
+		if networkToken.PaymentData.ECIIndicator != "" {
+			eci = util.Rjust(networkToken.PaymentData.ECIIndicator, 2, "0")
+		}
+		authParams.MpiData = &AdyenAuthoriseMpiDataParams{
 			AuthenticationResponse: "Y",
 			DirectoryResponse:      "Y",
 			Eci:                    eci,
 			Cavv:                   cryptogram,
+		}
+	}
+
+	// Store the card for later merchant initiated charges against the payment agreement.
+	if agreement := paymentToken.Agreement; agreement != nil {
+		authParams.ShopperReference = paymentToken.ID
+		authParams.ShopperInteraction = "Ecommerce"
+		authParams.RecurringProcessingModel = RecurringProcessingModel[agreement.Type]
+		authParams.Recurring = &AdyenRecurringParams{Contract: "RECURRING"}
+	}
+
+	return g.authoriseAndCapture(input, authParams, transport.IdempotencyKey(paymentToken, "authorise"), transport.IdempotencyKey(paymentToken, "capture"))
+}
+
+// ChargeMerchantInitiated charges the card stored by an agreement's initial charge, without the customer present.
+func (g *Gateway) ChargeMerchantInitiated(input *buyte.CreateChargeInput, initialCharge *buyte.Charge, paymentToken *buyte.PaymentToken) (*buyte.GatewayCharge, error) {
+	authParams := &AdyenAuthoriseParams{
+		Reference:       g.getDescription(input, paymentToken),
+		MerchantAccount: g.AdyenCredentials().MerchantAccount,
+		Amount: AdyenAmountParams{
+			Value:    input.Amount,
+			Currency: strings.ToUpper(input.Currency),
 		},
+		AdditionalData: AdyenAuthoriseAdditionalDataParams{
+			Type:          CardTypeSource[paymentToken.PaymentMethod.Name],
+			SelectedBrand: CardTypeSource[paymentToken.PaymentMethod.Name],
+		},
+		ShopperReference:                 initialCharge.ProviderCharge.CustomerReference,
+		ShopperInteraction:               "ContAuth",
+		RecurringProcessingModel:         RecurringProcessingModel[initialCharge.Agreement.Type],
+		SelectedRecurringDetailReference: initialCharge.ProviderCharge.PaymentMethodReference,
+		Recurring:                        &AdyenRecurringParams{Contract: "RECURRING"},
 	}
+	// Agreements are charged many times, so the payment token cannot key these requests.
+	return g.authoriseAndCapture(input, authParams, "", "")
+}
 
+func (g *Gateway) authoriseAndCapture(input *buyte.CreateChargeInput, authParams *AdyenAuthoriseParams, authoriseKey string, captureKey string) (*buyte.GatewayCharge, error) {
 	// Execute authorisation
-	authoriseResponse, err := g.authorise(authParams)
+	authoriseResponse, err := g.authorise(authParams, authoriseKey)
 	if err != nil {
 		return &buyte.GatewayCharge{}, stacktrace.Propagate(err, "Could not execute authorisation request")
 	}
@@ -201,7 +261,7 @@ func (g *Gateway) Charge(input *buyte.CreateChargeInput, networkToken *buyte.Net
 
 	// Build Capture Request
 	captureParams := &AdyenCaptureParams{
-		Reference:       description,
+		Reference:       authParams.Reference,
 		MerchantAccount: g.AdyenCredentials().MerchantAccount,
 		ModificationAmount: AdyenAmountParams{
 			Value:    input.Amount,
@@ -211,17 +271,25 @@ func (g *Gateway) Charge(input *buyte.CreateChargeInput, networkToken *buyte.Net
 	}
 
 	// Execute request
-	captureResponse, err := g.capture(captureParams)
+	captureResponse, err := g.capture(captureParams, captureKey)
 	if err != nil {
 		return &buyte.GatewayCharge{}, stacktrace.Propagate(err, "Could not execute capture request")
 	}
 	g.Logger.Infow("Capture", "response", captureResponse)
 
 	// Return Charge
-	return &buyte.GatewayCharge{
+	result := &buyte.GatewayCharge{
 		Reference: psp,
 		Type:      g.Type,
-	}, nil
+	}
+	if authParams.Recurring != nil {
+		result.CustomerReference = authParams.ShopperReference
+		result.PaymentMethodReference = authParams.SelectedRecurringDetailReference
+		if reference, err := jsonparser.GetString(authoriseResponse, "additionalData", "recurring.recurringDetailReference"); err == nil {
+			result.PaymentMethodReference = reference
+		}
+	}
+	return result, nil
 }
 
 func (g *Gateway) ChargeNative(input *buyte.CreateChargeInput, nativeToken string, paymentToken *buyte.PaymentToken) (*buyte.GatewayCharge, error) {
@@ -240,7 +308,7 @@ func (g *Gateway) ChargeNative(input *buyte.CreateChargeInput, nativeToken strin
 			},
 		}
 
-		response, err := g.googlepay(params)
+		response, err := g.googlepay(params, transport.IdempotencyKey(paymentToken, "payments"))
 		if err != nil {
 			return &buyte.GatewayCharge{}, stacktrace.Propagate(err, "Could not execute Google Pay payment request")
 		}
@@ -275,7 +343,7 @@ func (g *Gateway) getDescription(input *buyte.CreateChargeInput, paymentToken *b
 	return description
 }
 
-func (g *Gateway) googlepay(params *AdyenGooglePayParams) ([]byte, error) {
+func (g *Gateway) googlepay(params *AdyenGooglePayParams, idempotencyKey string) ([]byte, error) {
 	// Get Endpoint
 	url := g.checkoutEndpoint() + "/payments"
 
@@ -284,10 +352,10 @@ func (g *Gateway) googlepay(params *AdyenGooglePayParams) ([]byte, error) {
 	if err != nil {
 		return []byte{}, err
 	}
-	return g.Post(url, jsonData)
+	return g.post(url, jsonData, idempotencyKey)
 }
 
-func (g *Gateway) capture(params *AdyenCaptureParams) ([]byte, error) {
+func (g *Gateway) capture(params *AdyenCaptureParams, idempotencyKey string) ([]byte, error) {
 	// Get Endpoint
 	url := g.paymentsEndpoint() + "/capture"
 
@@ -296,11 +364,11 @@ func (g *Gateway) capture(params *AdyenCaptureParams) ([]byte, error) {
 	if err != nil {
 		return []byte{}, err
 	}
-	return g.Post(url, jsonData)
+	return g.post(url, jsonData, idempotencyKey)
 }
 
 // Returns response body as byte array
-func (g *Gateway) authorise(params *AdyenAuthoriseParams) ([]byte, error) {
+func (g *Gateway) authorise(params *AdyenAuthoriseParams, idempotencyKey string) ([]byte, error) {
 	// Get Endpoint
 	url := g.paymentsEndpoint() + "/authorise"
 	// url := "https://hookb.in/G9Qy1gMmL8s1m1eBNyQ7"
@@ -310,43 +378,50 @@ func (g *Gateway) authorise(params *AdyenAuthoriseParams) ([]byte, error) {
 	if err != nil {
 		return []byte{}, err
 	}
-	return g.Post(url, jsonData)
+	return g.post(url, jsonData, idempotencyKey)
 }
 
 func (g *Gateway) Post(url string, jsonBody []byte) ([]byte, error) {
+	return g.post(url, jsonBody, "")
+}
+
+func (g *Gateway) post(url string, jsonBody []byte, idempotencyKey string) ([]byte, error) {
 	// Build Request
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonBody))
-	if err != nil {
-		return []byte{}, err
+	header := http.Header{}
+	header.Set("Authorization", "Basic "+g.AdyenCredentials().AuthKey())
+	header.Set("Content-Type", "application/json")
+	if idempotencyKey != "" {
+		header.Set("Idempotency-Key", idempotencyKey)
 	}
-	req.Header.Set("Authorization", "Basic "+g.AdyenCredentials().AuthKey())
-	req.Header.Set("Content-Type", "application/json")
 
 	// Execute request
-	client := g.client()
-	resp, err := client.Do(req)
+	resp, err := g.transport().Do(g.Context, (*buyte.Gateway)(g), &buyte.GatewayRequest{
+		Method:         http.MethodPost,
+		URL:            url,
+		Header:         header,
+		Body:           jsonBody,
+		IdempotencyKey: idempotencyKey,
+	})
 	if err != nil {
 		return []byte{}, err
 	}
-	if resp != nil {
-		defer resp.Body.Close()
-	}
 	if resp.StatusCode == 401 {
 		return []byte{}, errors.New("Unauthorized")
 	}
+	if resp.StatusCode >= 400 {
+		message, _ := jsonparser.GetString(resp.Body, "message")
+		return []byte{}, errors.Errorf("Adyen request failed with status %d: %s", resp.StatusCode, message)
+	}
 
 	// Return response body
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return []byte{}, err
-	}
-	return body, nil
+	return resp.Body, nil
 }
 
-func (g *Gateway) client() *http.Client {
-	return &http.Client{
-		Timeout: time.Second * 10,
+func (g *Gateway) transport() buyte.GatewayTransport {
+	if g.Transport == nil {
+		return transport.Default()
 	}
+	return g.Transport
 }
 
 func (g *Gateway) paymentsEndpoint() string {
@@ -0,0 +1,273 @@
+package afterpay
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/palantir/stacktrace"
+	"github.com/pkg/errors"
+	config "github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/conf"
+	bnpl "github.com/rsoury/buyte/pkg/afterpay"
+	"github.com/rsoury/buyte/pkg/paymentgateway/transport"
+	"github.com/rsoury/buyte/pkg/util"
+)
+
+type Gateway buyte.Gateway
+
+type AfterpayCredentials struct {
+	MerchantId string `json:"merchantId"`
+	SecretKey  []byte `json:"secretKey"`
+}
+
+func (a *AfterpayCredentials) AuthKey() string {
+	raw := a.MerchantId + ":" + string(a.SecretKey)
+	return base64.StdEncoding.EncodeToString([]byte(raw))
+}
+
+// AfterpayCheckoutParams describe the checkout a customer is redirected to Afterpay to confirm.
+type AfterpayCheckoutParams struct {
+	Amount            bnpl.Money             `json:"amount"`
+	Consumer          *bnpl.Consumer         `json:"consumer,omitempty"`
+	Shipping          *bnpl.Contact          `json:"shipping,omitempty"`
+	Merchant          AfterpayMerchantParams `json:"merchant"`
+	MerchantReference string                 `json:"merchantReference,omitempty"`
+}
+type AfterpayMerchantParams struct {
+	RedirectConfirmURL string `json:"redirectConfirmUrl"`
+	RedirectCancelURL  string `json:"redirectCancelUrl"`
+}
+type AfterpayCaptureParams struct {
+	// Afterpay's idempotency key
+	RequestID         string `json:"requestId,omitempty"`
+	Token             string `json:"token"`
+	MerchantReference string `json:"merchantReference,omitempty"`
+}
+
+// Each account's limits are shared by every connection with the same merchant, until they expire.
+var configurations = struct {
+	sync.Mutex
+	limits map[string]cachedLimits
+}{limits: map[string]cachedLimits{}}
+
+type cachedLimits struct {
+	limits    *bnpl.Limits
+	expiresAt time.Time
+}
+
+func New(ctx context.Context, connection *buyte.ProviderCheckoutConnection) (*Gateway, error) {
+	var err error
+	credentials := &AfterpayCredentials{}
+	creds := []byte(connection.Credentials)
+	err = jsonparser.ObjectEach(creds, func(key []byte, value []byte, _ jsonparser.ValueType, _ int) error {
+		keyStr := string(key)
+		switch keyStr {
+		case "merchantId":
+			credentials.MerchantId = string(value)
+		case "secretKey":
+			credentials.SecretKey, _ = jsonparser.Unescape(value, []byte(""))
+		}
+		return nil
+	})
+	if err != nil {
+		return &Gateway{}, err
+	}
+
+	return &Gateway{
+		Type:         connection.Type,
+		IsTest:       connection.IsTest,
+		ConnectionId: connection.ID,
+		Credentials:  credentials,
+		Context:      ctx,
+		Logger:       zap.S().With("package", "paymentgateway.afterpay"),
+		Transport:    transport.Default(),
+	}, nil
+}
+
+func (g *Gateway) AfterpayCredentials() *AfterpayCredentials {
+	return g.Credentials.(*AfterpayCredentials)
+}
+
+// For now.
+func (g *Gateway) IsConnect() bool {
+	return false
+}
+
+// Afterpay checkouts are captured by Afterpay as confirmed. There is no payment data for Buyte to decrypt.
+func (g *Gateway) IsPassthrough(paymentMethod string) bool {
+	return paymentMethod == buyte.AFTERPAY
+}
+
+// Afterpay does not process cards.
+func (g *Gateway) Charge(input *buyte.CreateChargeInput, networkToken *buyte.NetworkToken, paymentToken *buyte.PaymentToken) (*buyte.GatewayCharge, error) {
+	return &buyte.GatewayCharge{}, buyte.UnsupportedPaymentData("Afterpay", paymentToken.PaymentMethod.Name)
+}
+
+// Capture a confirmed Afterpay checkout in full. nativeToken is the checkout's token.
+func (g *Gateway) ChargeNative(input *buyte.CreateChargeInput, nativeToken string, paymentToken *buyte.PaymentToken) (*buyte.GatewayCharge, error) {
+	if input.Capture != nil && !*input.Capture {
+		return &buyte.GatewayCharge{}, errors.New("Afterpay checkouts are captured when charged, and cannot be authorised only")
+	}
+	idempotencyKey := transport.IdempotencyKey(paymentToken, "captures")
+	jsonData, err := json.Marshal(&AfterpayCaptureParams{
+		RequestID:         idempotencyKey,
+		Token:             nativeToken,
+		MerchantReference: input.Order.Reference,
+	})
+	if err != nil {
+		return &buyte.GatewayCharge{}, err
+	}
+	response, err := g.request(http.MethodPost, g.endpoint()+"/v2/payments/capture", jsonData, idempotencyKey)
+	if err != nil {
+		return &buyte.GatewayCharge{}, stacktrace.Propagate(err, "Could not execute capture request")
+	}
+
+	payment := &bnpl.Payment{}
+	if err := json.Unmarshal(response, payment); err != nil {
+		return &buyte.GatewayCharge{}, errors.Wrap(err, "Could not read capture response")
+	}
+	if payment.Status != bnpl.PaymentApproved {
+		return &buyte.GatewayCharge{}, errors.Errorf("Afterpay payment %s was not approved: %s", payment.ID, payment.Status)
+	}
+
+	g.Logger.Infow("Afterpay Capture", "payment_id", payment.ID, "status", payment.Status)
+
+	// Return Charge
+	return &buyte.GatewayCharge{
+		Reference: payment.ID,
+		Type:      g.Type,
+	}, nil
+}
+
+// CreateCheckout creates a checkout for the customer to confirm on Afterpay, returning the URL to redirect them to.
+func (g *Gateway) CreateCheckout(params *AfterpayCheckoutParams) (*bnpl.Checkout, error) {
+	jsonData, err := json.Marshal(params)
+	if err != nil {
+		return &bnpl.Checkout{}, err
+	}
+	response, err := g.request(http.MethodPost, g.endpoint()+"/v2/checkouts", jsonData, "")
+	if err != nil {
+		return &bnpl.Checkout{}, stacktrace.Propagate(err, "Could not create Afterpay checkout")
+	}
+	checkout := &bnpl.Checkout{}
+	if err := json.Unmarshal(response, checkout); err != nil {
+		return &bnpl.Checkout{}, errors.Wrap(err, "Could not read checkout response")
+	}
+
+	g.Logger.Infow("Afterpay Checkout", "token", checkout.Token, "expires", checkout.Expires)
+
+	return checkout, nil
+}
+
+// NewCheckoutParams describes a checkout for the amount, returning the customer to the URLs given once confirmed or cancelled.
+func NewCheckoutParams(amount int, currency string, confirmURL string, cancelURL string) *AfterpayCheckoutParams {
+	return &AfterpayCheckoutParams{
+		Amount: bnpl.Money{
+			Amount:   util.FormatAmount(amount, currency),
+			Currency: strings.ToUpper(currency),
+		},
+		Merchant: AfterpayMerchantParams{
+			RedirectConfirmURL: confirmURL,
+			RedirectCancelURL:  cancelURL,
+		},
+	}
+}
+
+// GetCheckout gets a checkout, with the consumer and contact details it was confirmed with.
+func (g *Gateway) GetCheckout(token string) (*bnpl.Checkout, error) {
+	response, err := g.request(http.MethodGet, g.endpoint()+"/v2/checkouts/"+url.PathEscape(token), nil, "")
+	if err != nil {
+		return &bnpl.Checkout{}, stacktrace.Propagate(err, "Could not get Afterpay checkout")
+	}
+	checkout := &bnpl.Checkout{}
+	if err := json.Unmarshal(response, checkout); err != nil {
+		return &bnpl.Checkout{}, errors.Wrap(err, "Could not read checkout response")
+	}
+	return checkout, nil
+}
+
+// Limits gets the order totals the merchant's Afterpay account accepts.
+func (g *Gateway) Limits() (*bnpl.Limits, error) {
+	key := g.endpoint() + "|" + g.AfterpayCredentials().MerchantId
+	configurations.Lock()
+	cached, ok := configurations.limits[key]
+	configurations.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.limits, nil
+	}
+
+	response, err := g.request(http.MethodGet, g.endpoint()+"/v2/configuration", nil, "")
+	if err != nil {
+		return nil, stacktrace.Propagate(err, "Could not get Afterpay configuration")
+	}
+	configuration := &bnpl.Configuration{}
+	if err := json.Unmarshal(response, configuration); err != nil {
+		return nil, errors.Wrap(err, "Could not read configuration response")
+	}
+	limits, err := configuration.Limits()
+	if err != nil {
+		return nil, err
+	}
+	configurations.Lock()
+	configurations.limits[key] = cachedLimits{
+		limits:    limits,
+		expiresAt: time.Now().Add(config.GetDuration("afterpay.configuration.cache_ttl")),
+	}
+	configurations.Unlock()
+	return limits, nil
+}
+
+func (g *Gateway) request(method string, requestURL string, jsonBody []byte, idempotencyKey string) ([]byte, error) {
+	// Build Request
+	header := http.Header{}
+	header.Set("Authorization", "Basic "+g.AfterpayCredentials().AuthKey())
+	header.Set("Content-Type", "application/json")
+	header.Set("Accept", "application/json")
+	// Afterpay requires the integration and merchant be identified.
+	header.Set("User-Agent", "Buyte/"+conf.Version+" (Go; Merchant/"+g.AfterpayCredentials().MerchantId+") https://github.com/rsoury/buyte")
+
+	// Execute request
+	resp, err := g.transport().Do(g.Context, (*buyte.Gateway)(g), &buyte.GatewayRequest{
+		Method:         method,
+		URL:            requestURL,
+		Header:         header,
+		Body:           jsonBody,
+		IdempotencyKey: idempotencyKey,
+	})
+	if err != nil {
+		return []byte{}, err
+	}
+	if resp.StatusCode == 401 {
+		return []byte{}, errors.New("Unauthorized")
+	}
+	if resp.StatusCode >= 400 {
+		return []byte{}, errors.Errorf("Afterpay request failed with status %d: %s", resp.StatusCode, string(resp.Body))
+	}
+
+	// Return response body
+	return resp.Body, nil
+}
+
+func (g *Gateway) transport() buyte.GatewayTransport {
+	if g.Transport == nil {
+		return transport.Default()
+	}
+	return g.Transport
+}
+
+func (g *Gateway) endpoint() string {
+	if g.IsTest {
+		return config.GetString("afterpay.test.endpoint")
+	}
+	return config.GetString("afterpay.live.endpoint")
+}
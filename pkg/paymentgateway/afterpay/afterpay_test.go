@@ -0,0 +1,208 @@
+package afterpay
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	config "github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rsoury/buyte/buyte"
+	bnpl "github.com/rsoury/buyte/pkg/afterpay"
+)
+
+const credentials = `{
+	"merchantId": "merchant_xxx",
+	"secretKey": "secret_xxx"
+}`
+const confirmedCheckout = `{
+	"token": "002.xxx",
+	"expires": "2026-10-19T10:00:00.000Z",
+	"amount": { "amount": "32.00", "currency": "AUD" },
+	"consumer": { "givenNames": "Jane", "surname": "Citizen", "email": "jane@example.com", "phoneNumber": "0400000000" },
+	"shipping": { "name": "Jane Citizen", "line1": "1 George St", "area1": "Sydney", "region": "NSW", "postcode": "2000", "countryCode": "AU" }
+}`
+
+var (
+	chargeInput = &buyte.CreateChargeInput{
+		Amount:   3200,
+		Currency: "aud",
+		Order: buyte.ChargeOrder{
+			Reference: "some-order-id",
+		},
+	}
+	afterpayPaymentToken = &buyte.PaymentToken{
+		ID: "tok_xxx",
+		PaymentMethod: &buyte.PaymentMethod{
+			Name: buyte.AFTERPAY,
+		},
+	}
+)
+
+// Stands in for the Afterpay Online API, recording request bodies by path.
+func StandIn(t *testing.T, approved bool, requests map[string]map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		data := map[string]interface{}{}
+		_ = json.Unmarshal(body, &data)
+		requests[r.URL.Path] = data
+
+		assert.Equal(t, "Basic bWVyY2hhbnRfeHh4OnNlY3JldF94eHg=", r.Header.Get("Authorization"))
+		assert.Contains(t, r.Header.Get("User-Agent"), "Merchant/merchant_xxx")
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method + " " + r.URL.Path {
+		case "GET /v2/configuration":
+			_, _ = w.Write([]byte(`{"minimumAmount":{"amount":"1.00","currency":"AUD"},"maximumAmount":{"amount":"2000.00","currency":"AUD"}}`))
+		case "POST /v2/checkouts":
+			w.WriteHeader(201)
+			_, _ = w.Write([]byte(`{"token":"002.xxx","expires":"2026-10-19T10:00:00.000Z","redirectCheckoutUrl":"https://portal.sandbox.afterpay.com/au/checkout/?token=002.xxx"}`))
+		case "GET /v2/checkouts/002.xxx":
+			_, _ = w.Write([]byte(confirmedCheckout))
+		case "POST /v2/payments/capture":
+			if approved {
+				w.WriteHeader(201)
+				_, _ = w.Write([]byte(`{"id":"100101","token":"002.xxx","status":"APPROVED","originalAmount":{"amount":"32.00","currency":"AUD"}}`))
+			} else {
+				w.WriteHeader(402)
+				_, _ = w.Write([]byte(`{"id":"100101","token":"002.xxx","status":"DECLINED"}`))
+			}
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+}
+
+func GatewaySetup(t *testing.T, endpoint string) *Gateway {
+	config.Set("afterpay.test.endpoint", endpoint)
+	config.Set("afterpay.configuration.cache_ttl", "1h")
+	gateway, err := New(context.Background(), &buyte.ProviderCheckoutConnection{
+		Type:        buyte.AFTERPAY_ONLINE,
+		IsTest:      true,
+		Credentials: credentials,
+		Provider: buyte.ProviderCheckoutConnectionProviderDetails{
+			Name: "Afterpay",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gateway
+}
+
+func TestNew(t *testing.T) {
+	assert := assert.New(t)
+	gateway := GatewaySetup(t, "")
+	credentials := gateway.AfterpayCredentials()
+	assert.Equal("merchant_xxx", credentials.MerchantId)
+	assert.Equal("secret_xxx", string(credentials.SecretKey))
+	assert.True(gateway.IsPassthrough(buyte.AFTERPAY))
+	assert.False(gateway.IsPassthrough(buyte.APPLE_PAY))
+}
+
+func TestCreateCheckout(t *testing.T) {
+	assert := assert.New(t)
+	requests := map[string]map[string]interface{}{}
+	server := StandIn(t, true, requests)
+	defer server.Close()
+	gateway := GatewaySetup(t, server.URL)
+
+	checkout, err := gateway.CreateCheckout(NewCheckoutParams(3200, "aud", "https://shop.example.com/confirm", "https://shop.example.com/cart"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal("002.xxx", checkout.Token)
+	assert.Contains(checkout.RedirectCheckoutURL, "token=002.xxx")
+
+	params := requests["/v2/checkouts"]
+	assert.Equal(map[string]interface{}{"amount": "32.00", "currency": "AUD"}, params["amount"])
+	merchant := params["merchant"].(map[string]interface{})
+	assert.Equal("https://shop.example.com/confirm", merchant["redirectConfirmUrl"])
+	assert.Equal("https://shop.example.com/cart", merchant["redirectCancelUrl"])
+}
+
+func TestGetCheckout(t *testing.T) {
+	assert := assert.New(t)
+	requests := map[string]map[string]interface{}{}
+	server := StandIn(t, true, requests)
+	defer server.Close()
+	gateway := GatewaySetup(t, server.URL)
+
+	checkout, err := gateway.GetCheckout("002.xxx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.True(checkout.HasAmount("32.00", "aud"))
+	assert.Equal("Jane Citizen", checkout.ConsumerName())
+	assert.Equal("Sydney", checkout.Shipping.Area1)
+
+	_, err = gateway.GetCheckout("unknown")
+	assert.Error(err)
+}
+
+func TestLimits(t *testing.T) {
+	assert := assert.New(t)
+	requests := map[string]map[string]interface{}{}
+	server := StandIn(t, true, requests)
+	defer server.Close()
+	gateway := GatewaySetup(t, server.URL)
+
+	limits, err := gateway.Limits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(&bnpl.Limits{Currency: "AUD", MinimumAmount: 100, MaximumAmount: 200000}, limits)
+	assert.True(limits.Allows(3200, "aud"))
+	assert.False(limits.Allows(250000, "aud"), "Orders over the maximum are not eligible.")
+	assert.False(limits.Allows(3200, "nzd"), "Orders in another currency are not eligible.")
+
+	// Limits are cached for the account.
+	delete(requests, "/v2/configuration")
+	_, err = gateway.Limits()
+	assert.NoError(err)
+	assert.NotContains(requests, "/v2/configuration")
+}
+
+func TestChargeNative(t *testing.T) {
+	assert := assert.New(t)
+	requests := map[string]map[string]interface{}{}
+	server := StandIn(t, true, requests)
+	defer server.Close()
+	gateway := GatewaySetup(t, server.URL)
+
+	result, err := gateway.ChargeNative(chargeInput, "002.xxx", afterpayPaymentToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal("100101", result.Reference)
+	assert.Equal(buyte.AFTERPAY_ONLINE, result.Type)
+
+	capture := requests["/v2/payments/capture"]
+	assert.Equal("002.xxx", capture["token"])
+	assert.Equal("tok_xxx-captures", capture["requestId"])
+	assert.Equal("some-order-id", capture["merchantReference"])
+}
+
+func TestChargeDeclined(t *testing.T) {
+	requests := map[string]map[string]interface{}{}
+	server := StandIn(t, false, requests)
+	defer server.Close()
+	gateway := GatewaySetup(t, server.URL)
+
+	_, err := gateway.ChargeNative(chargeInput, "002.xxx", afterpayPaymentToken)
+	assert.Error(t, err, "A declined payment should return an error.")
+}
+
+func TestChargeUnsupported(t *testing.T) {
+	requests := map[string]map[string]interface{}{}
+	server := StandIn(t, true, requests)
+	defer server.Close()
+	gateway := GatewaySetup(t, server.URL)
+
+	_, err := gateway.Charge(chargeInput, &buyte.NetworkToken{}, &buyte.PaymentToken{PaymentMethod: &buyte.PaymentMethod{Name: buyte.APPLE_PAY}})
+	assert.True(t, buyte.IsUnsupportedPaymentData(err), "Afterpay does not charge cards.")
+	assert.Empty(t, requests, "Nothing should be sent to Afterpay.")
+}
@@ -0,0 +1,259 @@
+package braintree
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/buger/jsonparser"
+	"github.com/machinebox/graphql"
+	"github.com/palantir/stacktrace"
+	"github.com/pkg/errors"
+	config "github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/paymentgateway/transport"
+	"github.com/rsoury/buyte/pkg/util"
+)
+
+const apiVersion = "2019-01-01"
+
+type Gateway buyte.Gateway
+type BraintreeCredentials struct {
+	MerchantId        string `json:"merchantId"`
+	PublicKey         string `json:"publicKey"`
+	PrivateKey        string `json:"privateKey"`
+	TokenizationKey   string `json:"tokenizationKey"`
+	MerchantAccountId string `json:"merchantAccountId,omitempty"`
+}
+
+func (b *BraintreeCredentials) AuthKey() string {
+	raw := b.PublicKey + ":" + b.PrivateKey
+	return base64.StdEncoding.EncodeToString([]byte(raw))
+}
+
+// Braintree GraphQL response for a charge mutation.
+type BraintreeTransaction struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// Transaction statuses that indicate the charge did not go through.
+var failedTransactionStatuses = map[string]bool{
+	"FAILED":              true,
+	"GATEWAY_REJECTED":    true,
+	"PROCESSOR_DECLINED":  true,
+	"SETTLEMENT_DECLINED": true,
+	"VOIDED":              true,
+}
+
+var TokenizationMethod = map[string]string{
+	buyte.APPLE_PAY:   "APPLE_PAY",
+	buyte.GOOGLE_PAY:  "GOOGLE_PAY",
+	buyte.SAMSUNG_PAY: "SAMSUNG_PAY",
+}
+
+func New(ctx context.Context, connection *buyte.ProviderCheckoutConnection) (*Gateway, error) {
+	credentials := &BraintreeCredentials{}
+	if err := json.Unmarshal([]byte(connection.Credentials), credentials); err != nil {
+		return &Gateway{}, err
+	}
+	return &Gateway{
+		Type:         connection.Type,
+		IsTest:       connection.IsTest,
+		ConnectionId: connection.ID,
+		Credentials:  credentials,
+		Context:      ctx,
+		Logger:       zap.S().With("package", "paymentgateway.braintree"),
+		Transport:    transport.Default(),
+	}, nil
+}
+
+func (g *Gateway) BraintreeCredentials() *BraintreeCredentials {
+	return g.Credentials.(*BraintreeCredentials)
+}
+
+// For now.
+func (g *Gateway) IsConnect() bool {
+	return false
+}
+
+func (g *Gateway) Charge(input *buyte.CreateChargeInput, networkToken *buyte.NetworkToken, paymentToken *buyte.PaymentToken) (*buyte.GatewayCharge, error) {
+	// Network tokens are vaulted with a cryptogram only.
+	if networkToken.IsEMV() {
+		return &buyte.GatewayCharge{}, buyte.UnsupportedPaymentData("Braintree", networkToken.DataType())
+	}
+	cryptogram, err := util.DecodeCryptogram(networkToken.PaymentData.OnlinePaymentCryptogram)
+	if err != nil {
+		return &buyte.GatewayCharge{}, errors.Wrap(err, "Could not deduce payment cryptogram")
+	}
+
+	// Vault the decrypted network token as a single use payment method
+	networkTokenInput := map[string]interface{}{
+		"number":          networkToken.ApplicationPrimaryAccountNumber,
+		"expirationMonth": networkToken.ExpMonth(),
+		"expirationYear":  networkToken.ExpYear(),
+		"cryptogram":      cryptogram,
+		"originDetails": map[string]string{
+			"origin": TokenizationMethod[paymentToken.PaymentMethod.Name],
+		},
+	}
+	if networkToken.PaymentData.ECIIndicator != "" {
+		networkTokenInput["eciIndicator"] = util.Rjust(networkToken.PaymentData.ECIIndicator, 2, "0")
+	}
+	if networkToken.CardholderName != "" {
+		networkTokenInput["cardholderName"] = networkToken.CardholderName
+	}
+
+	req := graphql.NewRequest(`
+		mutation TokenizeNetworkToken($input: TokenizeNetworkTokenInput!) {
+			tokenizeNetworkToken(input: $input) {
+				paymentMethod {
+					id
+				}
+			}
+		}
+	`)
+	req.Var("input", map[string]interface{}{
+		"networkToken": networkTokenInput,
+	})
+	var respData struct {
+		TokenizeNetworkToken struct {
+			PaymentMethod struct {
+				ID string `json:"id"`
+			} `json:"paymentMethod"`
+		} `json:"tokenizeNetworkToken"`
+	}
+	if err := g.Run(req, &respData); err != nil {
+		return &buyte.GatewayCharge{}, stacktrace.Propagate(err, "Could not tokenize network token")
+	}
+	paymentMethodId := respData.TokenizeNetworkToken.PaymentMethod.ID
+	if paymentMethodId == "" {
+		return &buyte.GatewayCharge{}, errors.New("Braintree did not return a payment method for the network token")
+	}
+
+	return g.chargeCreditCard(input, paymentMethodId, paymentToken)
+}
+
+// Google Pay tokens produced with the "braintree" gateway contain a payment method nonce.
+func (g *Gateway) ChargeNative(input *buyte.CreateChargeInput, nativeToken string, paymentToken *buyte.PaymentToken) (*buyte.GatewayCharge, error) {
+	nonce, err := jsonparser.GetString([]byte(nativeToken), "androidPayCards", "[0]", "nonce")
+	if err != nil {
+		return &buyte.GatewayCharge{}, errors.Wrap(err, "Could not obtain Braintree payment method nonce")
+	}
+
+	req := graphql.NewRequest(`
+		mutation ChargePaymentMethod($input: ChargePaymentMethodInput!) {
+			chargePaymentMethod(input: $input) {
+				transaction {
+					id
+					status
+				}
+			}
+		}
+	`)
+	req.Var("input", map[string]interface{}{
+		"paymentMethodId": nonce,
+		"transaction":     g.transactionInput(input, paymentToken),
+	})
+	var respData struct {
+		ChargePaymentMethod struct {
+			Transaction BraintreeTransaction `json:"transaction"`
+		} `json:"chargePaymentMethod"`
+	}
+	if err := g.Run(req, &respData); err != nil {
+		return &buyte.GatewayCharge{}, stacktrace.Propagate(err, "Could not execute Google Pay payment request")
+	}
+
+	return g.gatewayCharge(&respData.ChargePaymentMethod.Transaction)
+}
+
+func (g *Gateway) chargeCreditCard(input *buyte.CreateChargeInput, paymentMethodId string, paymentToken *buyte.PaymentToken) (*buyte.GatewayCharge, error) {
+	req := graphql.NewRequest(`
+		mutation ChargeCreditCard($input: ChargeCreditCardInput!) {
+			chargeCreditCard(input: $input) {
+				transaction {
+					id
+					status
+				}
+			}
+		}
+	`)
+	req.Var("input", map[string]interface{}{
+		"paymentMethodId": paymentMethodId,
+		"transaction":     g.transactionInput(input, paymentToken),
+	})
+	var respData struct {
+		ChargeCreditCard struct {
+			Transaction BraintreeTransaction `json:"transaction"`
+		} `json:"chargeCreditCard"`
+	}
+	if err := g.Run(req, &respData); err != nil {
+		return &buyte.GatewayCharge{}, stacktrace.Propagate(err, "Could not execute credit card charge")
+	}
+
+	return g.gatewayCharge(&respData.ChargeCreditCard.Transaction)
+}
+
+func (g *Gateway) transactionInput(input *buyte.CreateChargeInput, paymentToken *buyte.PaymentToken) map[string]interface{} {
+	transaction := map[string]interface{}{
+		"amount":      util.FormatAmount(input.Amount, input.Currency),
+		"description": g.getDescription(input, paymentToken),
+	}
+	if input.Order.Reference != "" {
+		transaction["orderId"] = input.Order.Reference
+	}
+	if merchantAccountId := g.BraintreeCredentials().MerchantAccountId; merchantAccountId != "" {
+		transaction["merchantAccountId"] = merchantAccountId
+	}
+	return transaction
+}
+
+func (g *Gateway) gatewayCharge(transaction *BraintreeTransaction) (*buyte.GatewayCharge, error) {
+	if transaction.ID == "" {
+		return &buyte.GatewayCharge{}, errors.New("Braintree did not return a transaction")
+	}
+	if failedTransactionStatuses[transaction.Status] {
+		return &buyte.GatewayCharge{}, errors.Errorf("Braintree transaction %s was not successful: %s", transaction.ID, transaction.Status)
+	}
+
+	g.Logger.Infow("Braintree Charge", "transaction_id", transaction.ID, "status", transaction.Status)
+
+	// Return Charge
+	return &buyte.GatewayCharge{
+		Reference: transaction.ID,
+		Type:      g.Type,
+	}, nil
+}
+
+func (g *Gateway) getDescription(input *buyte.CreateChargeInput, paymentToken *buyte.PaymentToken) string {
+	description := input.Description
+	if description == "" {
+		description = "Buyte: " + paymentToken.PaymentMethod.Name
+		if input.Order.Reference != "" {
+			description = description + " - " + input.Order.Reference
+		}
+	}
+	return description
+}
+
+// Run executes a request against the Braintree GraphQL API
+func (g *Gateway) Run(req *graphql.Request, resp interface{}) error {
+	req.Header.Set("Authorization", "Basic "+g.BraintreeCredentials().AuthKey())
+	req.Header.Set("Braintree-Version", apiVersion)
+
+	ctx := g.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	client := graphql.NewClient(g.endpoint(), graphql.WithHTTPClient(transport.HTTPClient((*buyte.Gateway)(g))))
+	return client.Run(ctx, req, resp)
+}
+
+func (g *Gateway) endpoint() string {
+	if g.IsTest {
+		return config.GetString("braintree.test.endpoint")
+	}
+	return config.GetString("braintree.live.endpoint")
+}
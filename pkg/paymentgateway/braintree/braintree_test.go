@@ -0,0 +1,181 @@
+package braintree
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	config "github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rsoury/buyte/buyte"
+)
+
+const credentials = `{
+	"merchantId": "merchant_xxx",
+	"publicKey": "public_xxx",
+	"privateKey": "private_xxx",
+	"tokenizationKey": "sandbox_xxx_merchant_xxx"
+}`
+const networkTokenData = `{
+    "applicationPrimaryAccountNumber": "4817499130172785",
+    "applicationExpirationDate": "231231",
+    "currencyCode": "36",
+    "transactionAmount": 1,
+    "deviceManufacturerIdentifier": "040010030273",
+    "paymentDataType": "3DSecure",
+    "paymentData": {
+        "onlinePaymentCryptogram": "Ag0wIaIAHrzC2TyUMqHLMAABAAA=",
+        "eciIndicator": "7"
+    }
+}`
+const googlePayToken = `{"androidPayCards":[{"type":"AndroidPayCard","nonce":"tokengp_xxx","description":"Android Pay","details":{"cardType":"Visa","lastTwo":"11"}}]}`
+
+var (
+	chargeInput = &buyte.CreateChargeInput{
+		Amount:   3200,
+		Currency: "aud",
+		Order: buyte.ChargeOrder{
+			Reference: "some-order-id",
+		},
+	}
+	applePayPaymentToken = &buyte.PaymentToken{
+		PaymentMethod: &buyte.PaymentMethod{
+			Name: buyte.APPLE_PAY,
+		},
+	}
+	googlePayPaymentToken = &buyte.PaymentToken{
+		PaymentMethod: &buyte.PaymentMethod{
+			Name: buyte.GOOGLE_PAY,
+		},
+	}
+)
+
+type graphqlRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+// Stands in for the Braintree GraphQL API, recording each request it receives.
+func StandIn(t *testing.T, status string, requests *[]graphqlRequest) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Basic cHVibGljX3h4eDpwcml2YXRlX3h4eA==", r.Header.Get("Authorization"))
+		assert.Equal(t, apiVersion, r.Header.Get("Braintree-Version"))
+
+		req := graphqlRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatal(err)
+		}
+		*requests = append(*requests, req)
+
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.Contains(req.Query, "tokenizeNetworkToken("):
+			_, _ = w.Write([]byte(`{"data":{"tokenizeNetworkToken":{"paymentMethod":{"id":"pm_xxx"}}}}`))
+		case strings.Contains(req.Query, "chargeCreditCard("):
+			_, _ = w.Write([]byte(`{"data":{"chargeCreditCard":{"transaction":{"id":"tr_card","status":"` + status + `"}}}}`))
+		case strings.Contains(req.Query, "chargePaymentMethod("):
+			_, _ = w.Write([]byte(`{"data":{"chargePaymentMethod":{"transaction":{"id":"tr_gp","status":"` + status + `"}}}}`))
+		default:
+			_, _ = w.Write([]byte(`{"errors":[{"message":"Unknown mutation"}]}`))
+		}
+	}))
+}
+
+func GatewaySetup(t *testing.T, endpoint string) *Gateway {
+	config.Set("braintree.test.endpoint", endpoint)
+	gateway, err := New(context.Background(), &buyte.ProviderCheckoutConnection{
+		Type:        buyte.BRAINTREE,
+		IsTest:      true,
+		Credentials: credentials,
+		Provider: buyte.ProviderCheckoutConnectionProviderDetails{
+			Name: "Braintree",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gateway
+}
+
+func TestCharge(t *testing.T) {
+	assert := assert.New(t)
+
+	var requests []graphqlRequest
+	server := StandIn(t, "SUBMITTED_FOR_SETTLEMENT", &requests)
+	defer server.Close()
+	gateway := GatewaySetup(t, server.URL)
+
+	networkToken := &buyte.NetworkToken{}
+	if err := json.Unmarshal([]byte(networkTokenData), networkToken); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := gateway.Charge(chargeInput, networkToken, applePayPaymentToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal("tr_card", result.Reference)
+	assert.Equal(buyte.BRAINTREE, result.Type)
+
+	if assert.Len(requests, 2) {
+		token := requests[0].Variables["input"].(map[string]interface{})["networkToken"].(map[string]interface{})
+		assert.Equal("4817499130172785", token["number"])
+		assert.Equal("12", token["expirationMonth"])
+		assert.Equal("2023", token["expirationYear"])
+		assert.Equal("Ag0wIaIAHrzC2TyUMqHLMAABAAA=", token["cryptogram"])
+		assert.Equal("07", token["eciIndicator"])
+
+		charge := requests[1].Variables["input"].(map[string]interface{})
+		assert.Equal("pm_xxx", charge["paymentMethodId"])
+		assert.Equal("32.00", charge["transaction"].(map[string]interface{})["amount"])
+		assert.Equal("some-order-id", charge["transaction"].(map[string]interface{})["orderId"])
+	}
+}
+
+func TestChargeEMV(t *testing.T) {
+	var requests []graphqlRequest
+	server := StandIn(t, "SUBMITTED_FOR_SETTLEMENT", &requests)
+	defer server.Close()
+	gateway := GatewaySetup(t, server.URL)
+
+	networkToken := &buyte.NetworkToken{}
+	if err := json.Unmarshal([]byte(networkTokenData), networkToken); err != nil {
+		t.Fatal(err)
+	}
+	networkToken.PaymentDataType = buyte.PAYMENT_DATA_EMV
+	_, err := gateway.Charge(chargeInput, networkToken, applePayPaymentToken)
+	assert.True(t, buyte.IsUnsupportedPaymentData(err))
+	assert.Empty(t, requests)
+}
+
+func TestChargeNative(t *testing.T) {
+	assert := assert.New(t)
+
+	var requests []graphqlRequest
+	server := StandIn(t, "SUBMITTED_FOR_SETTLEMENT", &requests)
+	defer server.Close()
+	gateway := GatewaySetup(t, server.URL)
+
+	result, err := gateway.ChargeNative(chargeInput, googlePayToken, googlePayPaymentToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal("tr_gp", result.Reference)
+	if assert.Len(requests, 1) {
+		assert.Equal("tokengp_xxx", requests[0].Variables["input"].(map[string]interface{})["paymentMethodId"])
+	}
+}
+
+func TestChargeDeclined(t *testing.T) {
+	var requests []graphqlRequest
+	server := StandIn(t, "PROCESSOR_DECLINED", &requests)
+	defer server.Close()
+	gateway := GatewaySetup(t, server.URL)
+
+	_, err := gateway.ChargeNative(chargeInput, googlePayToken, googlePayPaymentToken)
+	assert.Error(t, err, "A declined transaction should return an error.")
+}
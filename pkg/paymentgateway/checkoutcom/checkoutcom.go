@@ -0,0 +1,342 @@
+package checkoutcom
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/buger/jsonparser"
+	"github.com/palantir/stacktrace"
+	"github.com/pkg/errors"
+	config "github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/paymentgateway/transport"
+	"github.com/rsoury/buyte/pkg/util"
+)
+
+type Gateway buyte.Gateway
+
+type CheckoutComCredentials struct {
+	SecretKey           []byte `json:"secretKey"`
+	PublicKey           string `json:"publicKey"`
+	ProcessingChannelId string `json:"processingChannelId"`
+	// When set, Apple Pay payment data is tokenised by Checkout.com rather than decrypted by Buyte.
+	ApplePayPassthrough bool `json:"applePayPassthrough"`
+}
+
+type CheckoutComPaymentParams struct {
+	Source              interface{}            `json:"source"`
+	Amount              int                    `json:"amount"`
+	Currency            string                 `json:"currency"`
+	Reference           string                 `json:"reference,omitempty"`
+	Description         string                 `json:"description,omitempty"`
+	Capture             bool                   `json:"capture"`
+	ProcessingChannelId string                 `json:"processing_channel_id,omitempty"`
+	Metadata            map[string]interface{} `json:"metadata,omitempty"`
+}
+type CheckoutComNetworkTokenSource struct {
+	Type        string `json:"type"`
+	Token       string `json:"token"`
+	ExpiryMonth int    `json:"expiry_month"`
+	ExpiryYear  int    `json:"expiry_year"`
+	TokenType   string `json:"token_type"`
+	Cryptogram  string `json:"cryptogram"`
+	Eci         string `json:"eci"`
+	Name        string `json:"name,omitempty"`
+}
+type CheckoutComTokenSource struct {
+	Type  string `json:"type"`
+	Token string `json:"token"`
+}
+type CheckoutComWalletTokenParams struct {
+	Type      string          `json:"type"`
+	TokenData json.RawMessage `json:"token_data"`
+}
+type CheckoutComCaptureParams struct {
+	Amount    int    `json:"amount,omitempty"`
+	Reference string `json:"reference,omitempty"`
+}
+type CheckoutComRefundParams struct {
+	Amount    int    `json:"amount,omitempty"`
+	Reference string `json:"reference,omitempty"`
+}
+type CheckoutComPayment struct {
+	ID       string `json:"id"`
+	ActionID string `json:"action_id"`
+	Status   string `json:"status"`
+	Approved bool   `json:"approved"`
+}
+
+var TokenType = map[string]string{
+	buyte.APPLE_PAY:  "applepay",
+	buyte.GOOGLE_PAY: "googlepay",
+}
+
+func New(ctx context.Context, connection *buyte.ProviderCheckoutConnection) (*Gateway, error) {
+	var err error
+	credentials := &CheckoutComCredentials{}
+	creds := []byte(connection.Credentials)
+	err = jsonparser.ObjectEach(creds, func(key []byte, value []byte, _ jsonparser.ValueType, _ int) error {
+		keyStr := string(key)
+		switch keyStr {
+		case "secretKey":
+			credentials.SecretKey, _ = jsonparser.Unescape(value, []byte(""))
+		case "publicKey":
+			credentials.PublicKey = string(value)
+		case "processingChannelId":
+			credentials.ProcessingChannelId = string(value)
+		case "applePayPassthrough":
+			credentials.ApplePayPassthrough, _ = strconv.ParseBool(string(value))
+		}
+		return nil
+	})
+	if err != nil {
+		return &Gateway{}, err
+	}
+
+	return &Gateway{
+		Type:         connection.Type,
+		IsTest:       connection.IsTest,
+		ConnectionId: connection.ID,
+		Credentials:  credentials,
+		Context:      ctx,
+		Logger:       zap.S().With("package", "paymentgateway.checkoutcom"),
+		Transport:    transport.Default(),
+	}, nil
+}
+
+func (g *Gateway) CheckoutComCredentials() *CheckoutComCredentials {
+	return g.Credentials.(*CheckoutComCredentials)
+}
+
+// For now.
+func (g *Gateway) IsConnect() bool {
+	return false
+}
+
+// Google Pay is always tokenised by Checkout.com. Apple Pay only when configured to do so.
+func (g *Gateway) IsPassthrough(paymentMethod string) bool {
+	if paymentMethod == buyte.APPLE_PAY {
+		return g.CheckoutComCredentials().ApplePayPassthrough
+	}
+	return false
+}
+
+// Charge a decrypted network token using a network_token source
+func (g *Gateway) Charge(input *buyte.CreateChargeInput, networkToken *buyte.NetworkToken, paymentToken *buyte.PaymentToken) (*buyte.GatewayCharge, error) {
+	// network_token sources only accept a cryptogram. EMV tokens may be charged with Apple Pay passthrough instead.
+	if networkToken.IsEMV() {
+		return &buyte.GatewayCharge{}, buyte.UnsupportedPaymentData("Checkout.com", networkToken.DataType())
+	}
+	// network_token sources only name Apple Pay and Google Pay tokens. ie. Samsung Pay tokens have no token_type
+	tokenType, ok := TokenType[paymentToken.PaymentMethod.Name]
+	if !ok {
+		return &buyte.GatewayCharge{}, buyte.UnsupportedPaymentData("Checkout.com", paymentToken.PaymentMethod.Name)
+	}
+	cryptogram, err := util.DecodeCryptogram(networkToken.PaymentData.OnlinePaymentCryptogram)
+	if err != nil {
+		return &buyte.GatewayCharge{}, errors.Wrap(err, "Could not deduce payment cryptogram")
+	}
+	expMonth, _ := strconv.Atoi(networkToken.ExpMonth())
+	expYear, _ := strconv.Atoi(networkToken.ExpYear())
+	eci := "07"
+	if networkToken.PaymentData.ECIIndicator != "" {
+		eci = util.Rjust(networkToken.PaymentData.ECIIndicator, 2, "0")
+	}
+
+	params := g.paymentParams(input, paymentToken)
+	params.Source = &CheckoutComNetworkTokenSource{
+		Type:        "network_token",
+		Token:       networkToken.ApplicationPrimaryAccountNumber,
+		ExpiryMonth: expMonth,
+		ExpiryYear:  expYear,
+		TokenType:   tokenType,
+		Cryptogram:  cryptogram,
+		Eci:         eci,
+		Name:        networkToken.CardholderName,
+	}
+
+	return g.executePayment(params, transport.IdempotencyKey(paymentToken, "payments"))
+}
+
+// Charge a wallet token by first exchanging it for a Checkout.com token.
+// For Apple Pay, nativeToken is the JSON of the token's paymentData. For Google Pay, it is the tokenizationData token.
+func (g *Gateway) ChargeNative(input *buyte.CreateChargeInput, nativeToken string, paymentToken *buyte.PaymentToken) (*buyte.GatewayCharge, error) {
+	tokenType, ok := TokenType[paymentToken.PaymentMethod.Name]
+	if !ok {
+		return &buyte.GatewayCharge{}, errors.New("Payment method " + paymentToken.PaymentMethod.Name + " cannot be tokenised by Checkout.com")
+	}
+	token, err := g.tokenise(&CheckoutComWalletTokenParams{
+		Type:      tokenType,
+		TokenData: json.RawMessage(nativeToken),
+	})
+	if err != nil {
+		return &buyte.GatewayCharge{}, stacktrace.Propagate(err, "Could not tokenise wallet payment data")
+	}
+
+	params := g.paymentParams(input, paymentToken)
+	params.Source = &CheckoutComTokenSource{
+		Type:  "token",
+		Token: token,
+	}
+
+	return g.executePayment(params, transport.IdempotencyKey(paymentToken, "payments"))
+}
+
+// Capture a previously authorised payment. An amount of 0 captures the full authorised amount.
+func (g *Gateway) Capture(reference string, amount int) error {
+	jsonData, err := json.Marshal(&CheckoutComCaptureParams{
+		Amount: amount,
+	})
+	if err != nil {
+		return err
+	}
+	response, err := g.Post(g.endpoint()+"/payments/"+reference+"/captures", jsonData)
+	if err != nil {
+		return stacktrace.Propagate(err, "Could not execute capture request")
+	}
+	g.Logger.Infow("Capture", "payment_id", reference, "response", string(response))
+	return nil
+}
+
+// Refund a captured payment. An amount of 0 refunds the full captured amount.
+func (g *Gateway) Refund(reference string, amount int) error {
+	jsonData, err := json.Marshal(&CheckoutComRefundParams{
+		Amount: amount,
+	})
+	if err != nil {
+		return err
+	}
+	response, err := g.Post(g.endpoint()+"/payments/"+reference+"/refunds", jsonData)
+	if err != nil {
+		return stacktrace.Propagate(err, "Could not execute refund request")
+	}
+	g.Logger.Infow("Refund", "payment_id", reference, "response", string(response))
+	return nil
+}
+
+func (g *Gateway) paymentParams(input *buyte.CreateChargeInput, paymentToken *buyte.PaymentToken) *CheckoutComPaymentParams {
+	capture := true
+	if input.Capture != nil {
+		capture = *input.Capture
+	}
+	return &CheckoutComPaymentParams{
+		Amount:              input.Amount,
+		Currency:            strings.ToUpper(input.Currency),
+		Reference:           input.Order.Reference,
+		Description:         g.getDescription(input, paymentToken),
+		Capture:             capture,
+		ProcessingChannelId: g.CheckoutComCredentials().ProcessingChannelId,
+		Metadata:            input.Metadata,
+	}
+}
+
+func (g *Gateway) getDescription(input *buyte.CreateChargeInput, paymentToken *buyte.PaymentToken) string {
+	description := input.Description
+	if description == "" {
+		description = "Buyte: " + paymentToken.PaymentMethod.Name
+		if input.Order.Reference != "" {
+			description = description + " - " + input.Order.Reference
+		}
+	}
+	return description
+}
+
+func (g *Gateway) tokenise(params *CheckoutComWalletTokenParams) (string, error) {
+	jsonData, err := json.Marshal(params)
+	if err != nil {
+		return "", err
+	}
+	// Tokens are requested with the public key.
+	response, err := g.post(g.endpoint()+"/tokens", jsonData, g.CheckoutComCredentials().PublicKey, "")
+	if err != nil {
+		return "", err
+	}
+	token, err := jsonparser.GetString(response, "token")
+	if err != nil {
+		return "", errors.Wrap(err, "Could not obtain token")
+	}
+	return token, nil
+}
+
+func (g *Gateway) executePayment(params *CheckoutComPaymentParams, idempotencyKey string) (*buyte.GatewayCharge, error) {
+	jsonData, err := json.Marshal(params)
+	if err != nil {
+		return &buyte.GatewayCharge{}, err
+	}
+	response, err := g.post(g.endpoint()+"/payments", jsonData, string(g.CheckoutComCredentials().SecretKey), idempotencyKey)
+	if err != nil {
+		return &buyte.GatewayCharge{}, stacktrace.Propagate(err, "Could not execute payment request")
+	}
+
+	payment := &CheckoutComPayment{}
+	if err := json.Unmarshal(response, payment); err != nil {
+		return &buyte.GatewayCharge{}, errors.Wrap(err, "Could not read payment response")
+	}
+	if !payment.Approved {
+		return &buyte.GatewayCharge{}, errors.Errorf("Checkout.com payment %s was not approved: %s", payment.ID, payment.Status)
+	}
+
+	g.Logger.Infow("Checkout.com Payment", "payment_id", payment.ID, "status", payment.Status)
+
+	// Return Charge
+	return &buyte.GatewayCharge{
+		Reference:     payment.ID,
+		Type:          g.Type,
+		AuthoriseOnly: !params.Capture,
+	}, nil
+}
+
+// Post executes a request authenticated with the secret key.
+func (g *Gateway) Post(url string, jsonBody []byte) ([]byte, error) {
+	return g.post(url, jsonBody, string(g.CheckoutComCredentials().SecretKey), "")
+}
+
+func (g *Gateway) post(url string, jsonBody []byte, key string, idempotencyKey string) ([]byte, error) {
+	// Build Request
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+key)
+	header.Set("Content-Type", "application/json")
+	if idempotencyKey != "" {
+		header.Set("Cko-Idempotency-Key", idempotencyKey)
+	}
+
+	// Execute request
+	resp, err := g.transport().Do(g.Context, (*buyte.Gateway)(g), &buyte.GatewayRequest{
+		Method:         http.MethodPost,
+		URL:            url,
+		Header:         header,
+		Body:           jsonBody,
+		IdempotencyKey: idempotencyKey,
+	})
+	if err != nil {
+		return []byte{}, err
+	}
+	if resp.StatusCode == 401 {
+		return []byte{}, errors.New("Unauthorized")
+	}
+	if resp.StatusCode >= 400 {
+		return []byte{}, errors.Errorf("Checkout.com request failed with status %d: %s", resp.StatusCode, string(resp.Body))
+	}
+
+	// Return response body
+	return resp.Body, nil
+}
+
+func (g *Gateway) transport() buyte.GatewayTransport {
+	if g.Transport == nil {
+		return transport.Default()
+	}
+	return g.Transport
+}
+
+func (g *Gateway) endpoint() string {
+	if g.IsTest {
+		return config.GetString("checkoutcom.test.endpoint")
+	}
+	return config.GetString("checkoutcom.live.endpoint")
+}
@@ -0,0 +1,222 @@
+package checkoutcom
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	config "github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rsoury/buyte/buyte"
+)
+
+const credentials = `{
+	"secretKey": "sk_sbox_xxx",
+	"publicKey": "pk_sbox_xxx",
+	"processingChannelId": "pc_xxx",
+	"applePayPassthrough": true
+}`
+const networkTokenData = `{
+    "applicationPrimaryAccountNumber": "4817499130172785",
+    "applicationExpirationDate": "231231",
+    "currencyCode": "36",
+    "transactionAmount": 1,
+    "deviceManufacturerIdentifier": "040010030273",
+    "paymentDataType": "3DSecure",
+    "paymentData": {
+        "onlinePaymentCryptogram": "Ag0wIaIAHrzC2TyUMqHLMAABAAA=",
+        "eciIndicator": "5"
+    }
+}`
+const emvNetworkTokenData = `{
+    "applicationPrimaryAccountNumber": "6250947000000014",
+    "applicationExpirationDate": "331231",
+    "currencyCode": "156",
+    "transactionAmount": 100,
+    "deviceManufacturerIdentifier": "040010030273",
+    "paymentDataType": "EMV",
+    "paymentData": {
+        "emvData": "nyYIESIzRFVmd4g="
+    }
+}`
+const applePayPaymentData = `{"version":"EC_v1","data":"dGVzdA==","signature":"dGVzdA==","header":{"ephemeralPublicKey":"dGVzdA==","publicKeyHash":"dGVzdA==","transactionId":"abc"}}`
+const googlePayToken = `{"signature":"MEUCIQ...","protocolVersion":"ECv1","signedMessage":"{}"}`
+
+var (
+	chargeInput = &buyte.CreateChargeInput{
+		Amount:   3200,
+		Currency: "aud",
+		Order: buyte.ChargeOrder{
+			Reference: "some-order-id",
+		},
+	}
+	applePayPaymentToken = &buyte.PaymentToken{
+		PaymentMethod: &buyte.PaymentMethod{
+			Name: buyte.APPLE_PAY,
+		},
+	}
+	googlePayPaymentToken = &buyte.PaymentToken{
+		PaymentMethod: &buyte.PaymentMethod{
+			Name: buyte.GOOGLE_PAY,
+		},
+	}
+)
+
+// Stands in for the Checkout.com API, recording request bodies by path.
+func StandIn(t *testing.T, approved bool, requests map[string]map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		data := map[string]interface{}{}
+		_ = json.Unmarshal(body, &data)
+		requests[r.URL.Path] = data
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/tokens":
+			assert.Equal(t, "Bearer pk_sbox_xxx", r.Header.Get("Authorization"))
+			w.WriteHeader(201)
+			_, _ = w.Write([]byte(`{"type":"` + data["type"].(string) + `","token":"tok_xxx"}`))
+		case "/payments":
+			assert.Equal(t, "Bearer sk_sbox_xxx", r.Header.Get("Authorization"))
+			w.WriteHeader(201)
+			if approved {
+				_, _ = w.Write([]byte(`{"id":"pay_xxx","status":"Captured","approved":true}`))
+			} else {
+				_, _ = w.Write([]byte(`{"id":"pay_xxx","status":"Declined","approved":false}`))
+			}
+		case "/payments/pay_xxx/captures", "/payments/pay_xxx/refunds":
+			w.WriteHeader(202)
+			_, _ = w.Write([]byte(`{"action_id":"act_xxx"}`))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+}
+
+func GatewaySetup(t *testing.T, endpoint string) *Gateway {
+	config.Set("checkoutcom.test.endpoint", endpoint)
+	gateway, err := New(context.Background(), &buyte.ProviderCheckoutConnection{
+		Type:        buyte.CHECKOUTCOM,
+		IsTest:      true,
+		Credentials: credentials,
+		Provider: buyte.ProviderCheckoutConnectionProviderDetails{
+			Name: "Checkout.com",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gateway
+}
+
+func TestNew(t *testing.T) {
+	assert := assert.New(t)
+	gateway := GatewaySetup(t, "")
+	credentials := gateway.CheckoutComCredentials()
+	assert.Equal("sk_sbox_xxx", string(credentials.SecretKey))
+	assert.Equal("pk_sbox_xxx", credentials.PublicKey)
+	assert.Equal("pc_xxx", credentials.ProcessingChannelId)
+	assert.True(gateway.IsPassthrough(buyte.APPLE_PAY))
+	assert.False(gateway.IsPassthrough(buyte.GOOGLE_PAY))
+}
+
+func TestCharge(t *testing.T) {
+	assert := assert.New(t)
+	requests := map[string]map[string]interface{}{}
+	server := StandIn(t, true, requests)
+	defer server.Close()
+	gateway := GatewaySetup(t, server.URL)
+
+	networkToken := &buyte.NetworkToken{}
+	if err := json.Unmarshal([]byte(networkTokenData), networkToken); err != nil {
+		t.Fatal(err)
+	}
+	result, err := gateway.Charge(chargeInput, networkToken, applePayPaymentToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal("pay_xxx", result.Reference)
+
+	payment := requests["/payments"]
+	source := payment["source"].(map[string]interface{})
+	assert.Equal("network_token", source["type"])
+	assert.Equal("4817499130172785", source["token"])
+	assert.Equal(float64(12), source["expiry_month"])
+	assert.Equal(float64(2023), source["expiry_year"])
+	assert.Equal("applepay", source["token_type"])
+	assert.Equal("Ag0wIaIAHrzC2TyUMqHLMAABAAA=", source["cryptogram"])
+	assert.Equal("05", source["eci"])
+	assert.Equal(float64(3200), payment["amount"])
+	assert.Equal("AUD", payment["currency"])
+	assert.Equal(true, payment["capture"])
+	assert.Equal("pc_xxx", payment["processing_channel_id"])
+}
+
+func TestChargeEMV(t *testing.T) {
+	requests := map[string]map[string]interface{}{}
+	server := StandIn(t, true, requests)
+	defer server.Close()
+	gateway := GatewaySetup(t, server.URL)
+
+	networkToken := &buyte.NetworkToken{}
+	if err := json.Unmarshal([]byte(emvNetworkTokenData), networkToken); err != nil {
+		t.Fatal(err)
+	}
+	_, err := gateway.Charge(chargeInput, networkToken, applePayPaymentToken)
+	assert.True(t, buyte.IsUnsupportedPaymentData(err), "EMV tokens have no cryptogram for a network_token source.")
+	assert.Empty(t, requests, "Nothing should be sent to Checkout.com.")
+}
+
+func TestChargeNativeApplePay(t *testing.T) {
+	assert := assert.New(t)
+	requests := map[string]map[string]interface{}{}
+	server := StandIn(t, true, requests)
+	defer server.Close()
+	gateway := GatewaySetup(t, server.URL)
+
+	result, err := gateway.ChargeNative(chargeInput, applePayPaymentData, applePayPaymentToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal("pay_xxx", result.Reference)
+	assert.Equal("applepay", requests["/tokens"]["type"])
+	assert.Equal("EC_v1", requests["/tokens"]["token_data"].(map[string]interface{})["version"])
+	assert.Equal("tok_xxx", requests["/payments"]["source"].(map[string]interface{})["token"])
+}
+
+func TestChargeNativeGooglePay(t *testing.T) {
+	assert := assert.New(t)
+	requests := map[string]map[string]interface{}{}
+	server := StandIn(t, true, requests)
+	defer server.Close()
+	gateway := GatewaySetup(t, server.URL)
+
+	capture := false
+	input := *chargeInput
+	input.Capture = &capture
+	result, err := gateway.ChargeNative(&input, googlePayToken, googlePayPaymentToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal("googlepay", requests["/tokens"]["type"])
+	assert.Equal(false, requests["/payments"]["capture"])
+	assert.True(result.AuthoriseOnly, "The charge should be recorded as not yet captured.")
+
+	assert.NoError(gateway.Capture("pay_xxx", 0))
+	assert.NoError(gateway.Refund("pay_xxx", 1000))
+	assert.Equal(float64(1000), requests["/payments/pay_xxx/refunds"]["amount"])
+}
+
+func TestChargeDeclined(t *testing.T) {
+	requests := map[string]map[string]interface{}{}
+	server := StandIn(t, false, requests)
+	defer server.Close()
+	gateway := GatewaySetup(t, server.URL)
+
+	_, err := gateway.ChargeNative(chargeInput, googlePayToken, googlePayPaymentToken)
+	assert.Error(t, err, "A declined payment should return an error.")
+}
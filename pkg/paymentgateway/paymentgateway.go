@@ -6,6 +6,11 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rsoury/buyte/buyte"
 	"github.com/rsoury/buyte/pkg/paymentgateway/adyen"
+	"github.com/rsoury/buyte/pkg/paymentgateway/afterpay"
+	"github.com/rsoury/buyte/pkg/paymentgateway/braintree"
+	"github.com/rsoury/buyte/pkg/paymentgateway/checkoutcom"
+	"github.com/rsoury/buyte/pkg/paymentgateway/paypal"
+	"github.com/rsoury/buyte/pkg/paymentgateway/square"
 	"github.com/rsoury/buyte/pkg/paymentgateway/stripe"
 )
 
@@ -19,6 +24,18 @@ type GatewayProvider interface {
 	IsConnect() bool
 }
 
+// PassthroughProvider is implemented by gateways that can decrypt a wallet's payment data themselves.
+// Payment data for these payment methods is passed to ChargeNative untouched, or the payment token's gateway token where one was provided.
+type PassthroughProvider interface {
+	IsPassthrough(paymentMethod string) bool
+}
+
+// MerchantInitiatedProvider is implemented by gateways that can charge a payment agreement again without the customer present.
+// The initial charge stores the payment method with the gateway, returning its references on the GatewayCharge.
+type MerchantInitiatedProvider interface {
+	ChargeMerchantInitiated(input *buyte.CreateChargeInput, initialCharge *buyte.Charge, paymentToken *buyte.PaymentToken) (*buyte.GatewayCharge, error)
+}
+
 // Each provider has their own underling gateway provider details.
 type Provider struct {
 	IsTest  bool            `json:"isTest"`
@@ -42,6 +59,36 @@ func New(ctx context.Context, connection *buyte.ProviderCheckoutConnection) (*Pr
 			return &Provider{}, errors.Wrap(err, "Could not setup Adyen Gateway")
 		}
 		gatewayProvider = gateway
+	case buyte.BRAINTREE:
+		gateway, err := braintree.New(ctx, connection)
+		if err != nil {
+			return &Provider{}, errors.Wrap(err, "Could not setup Braintree Gateway")
+		}
+		gatewayProvider = gateway
+	case buyte.CHECKOUTCOM:
+		gateway, err := checkoutcom.New(ctx, connection)
+		if err != nil {
+			return &Provider{}, errors.Wrap(err, "Could not setup Checkout.com Gateway")
+		}
+		gatewayProvider = gateway
+	case buyte.SQUARE:
+		gateway, err := square.New(ctx, connection)
+		if err != nil {
+			return &Provider{}, errors.Wrap(err, "Could not setup Square Gateway")
+		}
+		gatewayProvider = gateway
+	case buyte.PAYPAL_CHECKOUT:
+		gateway, err := paypal.New(ctx, connection)
+		if err != nil {
+			return &Provider{}, errors.Wrap(err, "Could not setup PayPal Gateway")
+		}
+		gatewayProvider = gateway
+	case buyte.AFTERPAY_ONLINE:
+		gateway, err := afterpay.New(ctx, connection)
+		if err != nil {
+			return &Provider{}, errors.Wrap(err, "Could not setup Afterpay Gateway")
+		}
+		gatewayProvider = gateway
 	default:
 		return &Provider{}, errors.New("Payment Provider " + connection.Provider.Name + " is not supported")
 	}
@@ -53,3 +100,18 @@ func New(ctx context.Context, connection *buyte.ProviderCheckoutConnection) (*Pr
 		Gateway: gatewayProvider,
 	}, nil
 }
+
+func (p *Provider) IsPassthrough(paymentMethod string) bool {
+	if passthrough, ok := p.Gateway.(PassthroughProvider); ok {
+		return passthrough.IsPassthrough(paymentMethod)
+	}
+	return false
+}
+
+// ChargeMerchantInitiated charges a payment agreement again, if the gateway supports it.
+func (p *Provider) ChargeMerchantInitiated(input *buyte.CreateChargeInput, initialCharge *buyte.Charge, paymentToken *buyte.PaymentToken) (*buyte.GatewayCharge, error) {
+	if provider, ok := p.Gateway.(MerchantInitiatedProvider); ok {
+		return provider.ChargeMerchantInitiated(input, initialCharge, paymentToken)
+	}
+	return &buyte.GatewayCharge{}, errors.New("Payment Provider " + p.Details.Name + " does not support merchant initiated charges")
+}
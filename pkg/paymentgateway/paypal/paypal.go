@@ -0,0 +1,303 @@
+package paypal
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/buger/jsonparser"
+	"github.com/palantir/stacktrace"
+	"github.com/pkg/errors"
+	config "github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/paymentgateway/transport"
+	orders "github.com/rsoury/buyte/pkg/paypal"
+	"github.com/rsoury/buyte/pkg/util"
+)
+
+type Gateway buyte.Gateway
+
+type PayPalCredentials struct {
+	ClientId     string `json:"clientId"`
+	ClientSecret []byte `json:"clientSecret"`
+}
+
+func (p *PayPalCredentials) AuthKey() string {
+	raw := p.ClientId + ":" + string(p.ClientSecret)
+	return base64.StdEncoding.EncodeToString([]byte(raw))
+}
+
+type PayPalOrderParams struct {
+	Intent        string                     `json:"intent"`
+	PurchaseUnits []PayPalPurchaseUnitParams `json:"purchase_units"`
+}
+type PayPalPurchaseUnitParams struct {
+	// The checkout the order was created for.
+	CustomID    string        `json:"custom_id,omitempty"`
+	Description string        `json:"description,omitempty"`
+	Amount      orders.Amount `json:"amount"`
+}
+type PayPalCaptureParams struct {
+	InvoiceID   string `json:"invoice_id,omitempty"`
+	NoteToPayer string `json:"note_to_payer,omitempty"`
+}
+
+// Access tokens are shared by every connection with the same client, until they expire.
+var accessTokens = struct {
+	sync.Mutex
+	tokens map[string]accessToken
+}{tokens: map[string]accessToken{}}
+
+type accessToken struct {
+	value     string
+	expiresAt time.Time
+}
+
+func New(ctx context.Context, connection *buyte.ProviderCheckoutConnection) (*Gateway, error) {
+	var err error
+	credentials := &PayPalCredentials{}
+	creds := []byte(connection.Credentials)
+	err = jsonparser.ObjectEach(creds, func(key []byte, value []byte, _ jsonparser.ValueType, _ int) error {
+		keyStr := string(key)
+		switch keyStr {
+		case "clientId":
+			credentials.ClientId = string(value)
+		case "clientSecret":
+			credentials.ClientSecret, _ = jsonparser.Unescape(value, []byte(""))
+		}
+		return nil
+	})
+	if err != nil {
+		return &Gateway{}, err
+	}
+
+	return &Gateway{
+		Type:         connection.Type,
+		IsTest:       connection.IsTest,
+		ConnectionId: connection.ID,
+		Credentials:  credentials,
+		Context:      ctx,
+		Logger:       zap.S().With("package", "paymentgateway.paypal"),
+		Transport:    transport.Default(),
+	}, nil
+}
+
+func (g *Gateway) PayPalCredentials() *PayPalCredentials {
+	return g.Credentials.(*PayPalCredentials)
+}
+
+// For now.
+func (g *Gateway) IsConnect() bool {
+	return false
+}
+
+// PayPal orders are captured by PayPal as approved. There is no payment data for Buyte to decrypt.
+func (g *Gateway) IsPassthrough(paymentMethod string) bool {
+	return paymentMethod == buyte.PAYPAL
+}
+
+// PayPal does not process cards.
+func (g *Gateway) Charge(input *buyte.CreateChargeInput, networkToken *buyte.NetworkToken, paymentToken *buyte.PaymentToken) (*buyte.GatewayCharge, error) {
+	return &buyte.GatewayCharge{}, buyte.UnsupportedPaymentData("PayPal", paymentToken.PaymentMethod.Name)
+}
+
+// Capture an approved PayPal order. nativeToken is the order's ID.
+func (g *Gateway) ChargeNative(input *buyte.CreateChargeInput, nativeToken string, paymentToken *buyte.PaymentToken) (*buyte.GatewayCharge, error) {
+	if input.Capture != nil && !*input.Capture {
+		return &buyte.GatewayCharge{}, errors.New("PayPal orders are captured when charged, and cannot be authorised only")
+	}
+	jsonData, err := json.Marshal(&PayPalCaptureParams{
+		InvoiceID:   input.Order.Reference,
+		NoteToPayer: g.getDescription(input, paymentToken),
+	})
+	if err != nil {
+		return &buyte.GatewayCharge{}, err
+	}
+	response, err := g.request(http.MethodPost, g.endpoint()+"/v2/checkout/orders/"+url.PathEscape(nativeToken)+"/capture", jsonData, transport.IdempotencyKey(paymentToken, "captures"))
+	if err != nil {
+		return &buyte.GatewayCharge{}, stacktrace.Propagate(err, "Could not execute capture request")
+	}
+
+	order := &orders.Order{}
+	if err := json.Unmarshal(response, order); err != nil {
+		return &buyte.GatewayCharge{}, errors.Wrap(err, "Could not read capture response")
+	}
+	capture := order.Capture()
+	// Pending captures have been accepted by PayPal, and settle later. ie. eChecks
+	if order.Status != orders.StatusCompleted || (capture.Status != orders.StatusCompleted && capture.Status != orders.StatusPending) {
+		return &buyte.GatewayCharge{}, errors.Errorf("PayPal order %s was not captured: %s %s", order.ID, order.Status, capture.Status)
+	}
+
+	g.Logger.Infow("PayPal Capture", "order_id", order.ID, "capture_id", capture.ID, "status", capture.Status)
+
+	// Return Charge
+	return &buyte.GatewayCharge{
+		Reference: capture.ID,
+		Type:      g.Type,
+	}, nil
+}
+
+// CreateOrder creates an order for the customer to approve, to be captured once charged.
+func (g *Gateway) CreateOrder(amount int, currency string, checkoutId string) (*orders.Order, error) {
+	jsonData, err := json.Marshal(&PayPalOrderParams{
+		Intent: orders.IntentCapture,
+		PurchaseUnits: []PayPalPurchaseUnitParams{
+			{
+				CustomID: checkoutId,
+				Amount: orders.Amount{
+					CurrencyCode: strings.ToUpper(currency),
+					Value:        util.FormatAmount(amount, currency),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return &orders.Order{}, err
+	}
+	response, err := g.request(http.MethodPost, g.endpoint()+"/v2/checkout/orders", jsonData, "")
+	if err != nil {
+		return &orders.Order{}, stacktrace.Propagate(err, "Could not create PayPal order")
+	}
+	order := &orders.Order{}
+	if err := json.Unmarshal(response, order); err != nil {
+		return &orders.Order{}, errors.Wrap(err, "Could not read order response")
+	}
+
+	g.Logger.Infow("PayPal Order", "order_id", order.ID, "status", order.Status)
+
+	return order, nil
+}
+
+// GetOrder gets an order, with the payer and shipping details the customer approved it with.
+func (g *Gateway) GetOrder(orderId string) (*orders.Order, error) {
+	response, err := g.request(http.MethodGet, g.endpoint()+"/v2/checkout/orders/"+url.PathEscape(orderId), nil, "")
+	if err != nil {
+		return &orders.Order{}, stacktrace.Propagate(err, "Could not get PayPal order")
+	}
+	order := &orders.Order{}
+	if err := json.Unmarshal(response, order); err != nil {
+		return &orders.Order{}, errors.Wrap(err, "Could not read order response")
+	}
+	return order, nil
+}
+
+func (g *Gateway) getDescription(input *buyte.CreateChargeInput, paymentToken *buyte.PaymentToken) string {
+	description := input.Description
+	if description == "" {
+		description = "Buyte: " + paymentToken.PaymentMethod.Name
+		if input.Order.Reference != "" {
+			description = description + " - " + input.Order.Reference
+		}
+	}
+	return description
+}
+
+func (g *Gateway) request(method string, requestURL string, jsonBody []byte, idempotencyKey string) ([]byte, error) {
+	token, err := g.accessToken()
+	if err != nil {
+		return []byte{}, err
+	}
+
+	// Build Request
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+token)
+	header.Set("Content-Type", "application/json")
+	// Have PayPal return the full order, with payer and shipping details.
+	header.Set("Prefer", "return=representation")
+	if idempotencyKey != "" {
+		header.Set("PayPal-Request-Id", idempotencyKey)
+	}
+
+	// Execute request
+	resp, err := g.transport().Do(g.Context, (*buyte.Gateway)(g), &buyte.GatewayRequest{
+		Method:         method,
+		URL:            requestURL,
+		Header:         header,
+		Body:           jsonBody,
+		IdempotencyKey: idempotencyKey,
+	})
+	if err != nil {
+		return []byte{}, err
+	}
+	if resp.StatusCode == 401 {
+		g.expireAccessToken()
+		return []byte{}, errors.New("Unauthorized")
+	}
+	if resp.StatusCode >= 400 {
+		return []byte{}, errors.Errorf("PayPal request failed with status %d: %s", resp.StatusCode, string(resp.Body))
+	}
+
+	// Return response body
+	return resp.Body, nil
+}
+
+// Obtain an OAuth access token for the client credentials.
+func (g *Gateway) accessToken() (string, error) {
+	key := g.accessTokenKey()
+	accessTokens.Lock()
+	token, ok := accessTokens.tokens[key]
+	accessTokens.Unlock()
+	if ok && time.Now().Before(token.expiresAt) {
+		return token.value, nil
+	}
+
+	header := http.Header{}
+	header.Set("Authorization", "Basic "+g.PayPalCredentials().AuthKey())
+	header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := g.transport().Do(g.Context, (*buyte.Gateway)(g), &buyte.GatewayRequest{
+		Method: http.MethodPost,
+		URL:    g.endpoint() + "/v1/oauth2/token",
+		Header: header,
+		Body:   []byte("grant_type=client_credentials"),
+	})
+	if err != nil {
+		return "", stacktrace.Propagate(err, "Could not obtain PayPal access token")
+	}
+	if resp.StatusCode >= 400 {
+		return "", errors.Errorf("PayPal access token request failed with status %d", resp.StatusCode)
+	}
+	value, err := jsonparser.GetString(resp.Body, "access_token")
+	if err != nil {
+		return "", errors.Wrap(err, "Could not obtain access token")
+	}
+	expiresIn, _ := jsonparser.GetInt(resp.Body, "expires_in")
+	accessTokens.Lock()
+	accessTokens.tokens[key] = accessToken{
+		value: value,
+		// Leave a margin for requests made just before expiry.
+		expiresAt: time.Now().Add(time.Duration(expiresIn)*time.Second - time.Minute),
+	}
+	accessTokens.Unlock()
+	return value, nil
+}
+
+func (g *Gateway) expireAccessToken() {
+	accessTokens.Lock()
+	delete(accessTokens.tokens, g.accessTokenKey())
+	accessTokens.Unlock()
+}
+
+func (g *Gateway) accessTokenKey() string {
+	return g.endpoint() + "|" + g.PayPalCredentials().ClientId
+}
+
+func (g *Gateway) transport() buyte.GatewayTransport {
+	if g.Transport == nil {
+		return transport.Default()
+	}
+	return g.Transport
+}
+
+func (g *Gateway) endpoint() string {
+	if g.IsTest {
+		return config.GetString("paypal.test.endpoint")
+	}
+	return config.GetString("paypal.live.endpoint")
+}
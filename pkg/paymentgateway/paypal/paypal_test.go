@@ -0,0 +1,206 @@
+package paypal
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	config "github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rsoury/buyte/buyte"
+	orders "github.com/rsoury/buyte/pkg/paypal"
+)
+
+const credentials = `{
+	"clientId": "client_xxx",
+	"clientSecret": "secret_xxx"
+}`
+const approvedOrder = `{
+	"id": "5O190127TN364715T",
+	"status": "APPROVED",
+	"intent": "CAPTURE",
+	"payer": {
+		"payer_id": "QYR5Z8XDVJNXQ",
+		"email_address": "jane@example.com",
+		"name": { "given_name": "Jane", "surname": "Citizen" }
+	},
+	"purchase_units": [{
+		"custom_id": "checkout",
+		"amount": { "currency_code": "AUD", "value": "32.00" },
+		"shipping": {
+			"name": { "full_name": "Jane Citizen" },
+			"address": { "address_line_1": "1 George St", "admin_area_2": "Sydney", "admin_area_1": "NSW", "postal_code": "2000", "country_code": "AU" }
+		}
+	}]
+}`
+
+var (
+	chargeInput = &buyte.CreateChargeInput{
+		Amount:   3200,
+		Currency: "aud",
+		Order: buyte.ChargeOrder{
+			Reference: "some-order-id",
+		},
+	}
+	payPalPaymentToken = &buyte.PaymentToken{
+		ID: "tok_xxx",
+		PaymentMethod: &buyte.PaymentMethod{
+			Name: buyte.PAYPAL,
+		},
+	}
+)
+
+// Stands in for the PayPal Orders API, recording request bodies by path.
+func StandIn(t *testing.T, captureStatus string, requests map[string]map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		data := map[string]interface{}{}
+		_ = json.Unmarshal(body, &data)
+		requests[r.URL.Path] = data
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/v1/oauth2/token" {
+			assert.Equal(t, "Basic Y2xpZW50X3h4eDpzZWNyZXRfeHh4", r.Header.Get("Authorization"))
+			assert.Equal(t, "grant_type=client_credentials", string(body))
+			_, _ = w.Write([]byte(`{"access_token":"A21AAxxx","token_type":"Bearer","expires_in":32400}`))
+			return
+		}
+		assert.Equal(t, "Bearer A21AAxxx", r.Header.Get("Authorization"))
+		switch r.Method + " " + r.URL.Path {
+		case "POST /v2/checkout/orders":
+			w.WriteHeader(201)
+			_, _ = w.Write([]byte(`{"id":"5O190127TN364715T","status":"CREATED","intent":"CAPTURE","purchase_units":[{"amount":{"currency_code":"AUD","value":"32.00"}}]}`))
+		case "GET /v2/checkout/orders/5O190127TN364715T":
+			_, _ = w.Write([]byte(approvedOrder))
+		case "POST /v2/checkout/orders/5O190127TN364715T/capture":
+			assert.Equal(t, "tok_xxx-captures", r.Header.Get("PayPal-Request-Id"))
+			if captureStatus == orders.StatusDeclined {
+				w.WriteHeader(422)
+				_, _ = w.Write([]byte(`{"name":"UNPROCESSABLE_ENTITY","details":[{"issue":"INSTRUMENT_DECLINED"}]}`))
+				return
+			}
+			w.WriteHeader(201)
+			_, _ = w.Write([]byte(`{"id":"5O190127TN364715T","status":"COMPLETED","purchase_units":[{"payments":{"captures":[{"id":"3C679366HH908993F","status":"` + captureStatus + `","amount":{"currency_code":"AUD","value":"32.00"}}]}}]}`))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+}
+
+func GatewaySetup(t *testing.T, endpoint string) *Gateway {
+	config.Set("paypal.test.endpoint", endpoint)
+	gateway, err := New(context.Background(), &buyte.ProviderCheckoutConnection{
+		Type:        buyte.PAYPAL_CHECKOUT,
+		IsTest:      true,
+		Credentials: credentials,
+		Provider: buyte.ProviderCheckoutConnectionProviderDetails{
+			Name: "PayPal",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gateway
+}
+
+func TestNew(t *testing.T) {
+	assert := assert.New(t)
+	gateway := GatewaySetup(t, "")
+	credentials := gateway.PayPalCredentials()
+	assert.Equal("client_xxx", credentials.ClientId)
+	assert.Equal("secret_xxx", string(credentials.ClientSecret))
+	assert.True(gateway.IsPassthrough(buyte.PAYPAL))
+	assert.False(gateway.IsPassthrough(buyte.GOOGLE_PAY))
+}
+
+func TestCreateOrder(t *testing.T) {
+	assert := assert.New(t)
+	requests := map[string]map[string]interface{}{}
+	server := StandIn(t, orders.StatusCompleted, requests)
+	defer server.Close()
+	gateway := GatewaySetup(t, server.URL)
+
+	order, err := gateway.CreateOrder(3200, "aud", "checkout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal("5O190127TN364715T", order.ID)
+	assert.Equal(orders.StatusCreated, order.Status)
+
+	params := requests["/v2/checkout/orders"]
+	assert.Equal("CAPTURE", params["intent"])
+	unit := params["purchase_units"].([]interface{})[0].(map[string]interface{})
+	assert.Equal("checkout", unit["custom_id"])
+	assert.Equal(map[string]interface{}{"currency_code": "AUD", "value": "32.00"}, unit["amount"])
+}
+
+func TestGetOrder(t *testing.T) {
+	assert := assert.New(t)
+	requests := map[string]map[string]interface{}{}
+	server := StandIn(t, orders.StatusCompleted, requests)
+	defer server.Close()
+	gateway := GatewaySetup(t, server.URL)
+
+	order, err := gateway.GetOrder("5O190127TN364715T")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(orders.StatusApproved, order.Status)
+	assert.True(order.HasAmount("32.00", "aud"))
+	assert.False(order.HasAmount("3200", "aud"))
+	assert.Equal("jane@example.com", order.Payer.EmailAddress)
+	assert.Equal("Sydney", order.Shipping().Address.AdminArea2)
+
+	_, err = gateway.GetOrder("unknown")
+	assert.Error(err)
+}
+
+func TestChargeNative(t *testing.T) {
+	assert := assert.New(t)
+	requests := map[string]map[string]interface{}{}
+	server := StandIn(t, orders.StatusCompleted, requests)
+	defer server.Close()
+	gateway := GatewaySetup(t, server.URL)
+
+	result, err := gateway.ChargeNative(chargeInput, "5O190127TN364715T", payPalPaymentToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal("3C679366HH908993F", result.Reference)
+	assert.Equal(buyte.PAYPAL_CHECKOUT, result.Type)
+
+	capture := requests["/v2/checkout/orders/5O190127TN364715T/capture"]
+	assert.Equal("some-order-id", capture["invoice_id"])
+	assert.Equal("Buyte: PayPal - some-order-id", capture["note_to_payer"])
+}
+
+func TestChargeDeclined(t *testing.T) {
+	requests := map[string]map[string]interface{}{}
+	server := StandIn(t, orders.StatusDeclined, requests)
+	defer server.Close()
+	gateway := GatewaySetup(t, server.URL)
+
+	_, err := gateway.ChargeNative(chargeInput, "5O190127TN364715T", payPalPaymentToken)
+	assert.Error(t, err, "A declined capture should return an error.")
+}
+
+func TestChargeUnsupported(t *testing.T) {
+	requests := map[string]map[string]interface{}{}
+	server := StandIn(t, orders.StatusCompleted, requests)
+	defer server.Close()
+	gateway := GatewaySetup(t, server.URL)
+
+	_, err := gateway.Charge(chargeInput, &buyte.NetworkToken{}, &buyte.PaymentToken{PaymentMethod: &buyte.PaymentMethod{Name: buyte.APPLE_PAY}})
+	assert.True(t, buyte.IsUnsupportedPaymentData(err), "PayPal does not charge cards.")
+
+	capture := false
+	input := *chargeInput
+	input.Capture = &capture
+	_, err = gateway.ChargeNative(&input, "5O190127TN364715T", payPalPaymentToken)
+	assert.Error(t, err, "PayPal orders cannot be authorised only.")
+	assert.Empty(t, requests, "Nothing should be sent to PayPal.")
+}
@@ -0,0 +1,177 @@
+package paymentgateway
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/rsoury/buyte/buyte"
+)
+
+// The charge details used to select the connections a charge may be processed through.
+type RoutingCriteria struct {
+	Currency      string
+	CardNetwork   string
+	PaymentMethod string
+	// Zero where the amount is not yet known, matching connections of any amount. ie. Afterpay limits shown before checkout
+	Amount int
+}
+
+// The card network is read from the payment token by its payment method's handler. See paymentmethod.Handler
+func NewRoutingCriteria(input *buyte.CreateChargeInput, paymentToken *buyte.PaymentToken, cardNetwork string) *RoutingCriteria {
+	criteria := &RoutingCriteria{
+		Currency:    input.Currency,
+		CardNetwork: cardNetwork,
+		Amount:      input.Amount,
+	}
+	if paymentToken.PaymentMethod != nil {
+		criteria.PaymentMethod = paymentToken.PaymentMethod.Name
+	}
+	return criteria
+}
+
+// Connection returns the checkout's connection with the given id, whether primary or routed.
+func Connection(checkout *buyte.PaymentTokenCheckout, id string) *buyte.ProviderCheckoutConnection {
+	if id == "" {
+		return nil
+	}
+	if checkout.Connection != nil && checkout.Connection.ID == id {
+		return checkout.Connection
+	}
+	if checkout.Connections != nil {
+		for _, item := range checkout.Connections.Items {
+			if item != nil && item.Connection != nil && item.Connection.ID == id {
+				return item.Connection
+			}
+		}
+	}
+	return nil
+}
+
+// Route returns the connections of a checkout that may process a charge, in the order they should be attempted.
+// Routed connections are ordered by priority, followed by the checkout's primary connection as the fallback.
+func Route(checkout *buyte.PaymentTokenCheckout, criteria *RoutingCriteria) []*buyte.ProviderCheckoutConnection {
+	connections := []*buyte.ProviderCheckoutConnection{}
+	if checkout.Connections != nil {
+		routed := make([]*buyte.RoutedCheckoutConnection, 0, len(checkout.Connections.Items))
+		for _, item := range checkout.Connections.Items {
+			if item != nil && item.Connection != nil {
+				routed = append(routed, item)
+			}
+		}
+		sort.SliceStable(routed, func(i, j int) bool {
+			return routed[i].Priority < routed[j].Priority
+		})
+		for _, item := range routed {
+			rules, err := item.RoutingRules()
+			if err != nil {
+				zap.S().With("package", "paymentgateway").Warnw("Route", "connection", item.ID, "error", err)
+				continue
+			}
+			if Matches(rules, criteria) && Supports(item.Connection, criteria.PaymentMethod) {
+				connections = append(connections, item.Connection)
+			}
+		}
+	}
+
+	if checkout.Connection != nil {
+		isRouted := false
+		for _, connection := range connections {
+			if checkout.Connection.ID != "" && connection.ID == checkout.Connection.ID {
+				isRouted = true
+				break
+			}
+		}
+		if !isRouted && Supports(checkout.Connection, criteria.PaymentMethod) {
+			connections = append(connections, checkout.Connection)
+		}
+	}
+
+	return connections
+}
+
+// Gateways that only process a payment method of their own, which no other gateway can process.
+var dedicatedGateways = map[string]string{
+	buyte.PAYPAL_CHECKOUT: buyte.PAYPAL,
+	buyte.AFTERPAY_ONLINE: buyte.AFTERPAY,
+}
+
+// Supports checks whether a connection's gateway can process a payment method at all, regardless of its routing rules.
+// ie. PayPal orders may only be captured through a PayPal connection, which cannot charge wallet cards. Likewise for Afterpay checkouts.
+func Supports(connection *buyte.ProviderCheckoutConnection, paymentMethod string) bool {
+	if dedicated, ok := dedicatedGateways[connection.Type]; ok {
+		return dedicated == paymentMethod
+	}
+	for _, dedicated := range dedicatedGateways {
+		if dedicated == paymentMethod {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches checks whether a charge satisfies a connection's routing rules.
+func Matches(rules *buyte.ConnectionRoutingRules, criteria *RoutingCriteria) bool {
+	if len(rules.Currencies) > 0 && !contains(rules.Currencies, criteria.Currency, strings.ToLower) {
+		return false
+	}
+	if len(rules.CardNetworks) > 0 && !contains(rules.CardNetworks, criteria.CardNetwork, normaliseNetwork) {
+		return false
+	}
+	if len(rules.PaymentMethods) > 0 && !contains(rules.PaymentMethods, criteria.PaymentMethod, strings.ToLower) {
+		return false
+	}
+	if rules.MinAmount > 0 && criteria.Amount > 0 && criteria.Amount < rules.MinAmount {
+		return false
+	}
+	if rules.MaxAmount > 0 && criteria.Amount > rules.MaxAmount {
+		return false
+	}
+	return true
+}
+
+// Wallets name networks differently. ie. "MasterCard" in Apple Pay, "MASTERCARD" in Google Pay.
+func normaliseNetwork(network string) string {
+	return strings.ToLower(strings.Replace(network, " ", "", -1))
+}
+
+func contains(values []string, value string, normalise func(string) string) bool {
+	value = normalise(value)
+	for _, v := range values {
+		if normalise(v) == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ChargeFunc charges through one of the connections a charge is routed through.
+type ChargeFunc func(connection *buyte.ProviderCheckoutConnection) (*buyte.GatewayCharge, error)
+
+// Charge charges through each connection in order until one succeeds, returning the charge and the connection it was made through, or last attempted.
+// A charge fails over to the next connection only where nothing reached the gateway, ie. its connection was refused, or it does not support the payment data.
+// Any other failure, such as a timeout awaiting the gateway's response, may have been charged, so is returned rather than charged twice.
+func Charge(connections []*buyte.ProviderCheckoutConnection, charge ChargeFunc) (*buyte.GatewayCharge, *buyte.ProviderCheckoutConnection, error) {
+	var err error
+	var connection *buyte.ProviderCheckoutConnection
+	for i := range connections {
+		connection = connections[i]
+		var result *buyte.GatewayCharge
+		result, err = charge(connection)
+		if err == nil {
+			return result, connection, nil
+		}
+		if !buyte.IsGatewayNotAttempted(err) && !buyte.IsUnsupportedPaymentData(err) {
+			return nil, connection, err
+		}
+		if i < len(connections)-1 {
+			zap.S().With("package", "paymentgateway").Warnw("Charge", "message", "Failing over to the next connection", "connection", connection.ID, "type", connection.Type, "error", err)
+		}
+	}
+	if err == nil {
+		err = errors.New("No connection to charge through")
+	}
+	return nil, connection, err
+}
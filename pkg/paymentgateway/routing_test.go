@@ -0,0 +1,233 @@
+package paymentgateway
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/paymentgateway/transport"
+)
+
+var checkout = &buyte.PaymentTokenCheckout{
+	ID: "checkout_xxx",
+	Connection: &buyte.ProviderCheckoutConnection{
+		ID:   "primary",
+		Type: buyte.STRIPE,
+	},
+	Connections: &buyte.CheckoutConnections{
+		Items: []*buyte.RoutedCheckoutConnection{
+			{
+				ID:       "routed_usd",
+				Priority: 2,
+				Rules:    `{"currencies":["USD"]}`,
+				Connection: &buyte.ProviderCheckoutConnection{
+					ID:   "braintree",
+					Type: buyte.BRAINTREE,
+				},
+			},
+			{
+				ID:       "routed_amex",
+				Priority: 1,
+				Rules:    `{"cardNetworks":["amex"],"maxAmount":100000}`,
+				Connection: &buyte.ProviderCheckoutConnection{
+					ID:   "adyen",
+					Type: buyte.ADYEN,
+				},
+			},
+			{
+				ID:       "routed_any",
+				Priority: 3,
+				Connection: &buyte.ProviderCheckoutConnection{
+					ID:   "checkoutcom",
+					Type: buyte.CHECKOUTCOM,
+				},
+			},
+			{
+				ID:       "routed_invalid",
+				Priority: 0,
+				Rules:    `{"currencies":`,
+				Connection: &buyte.ProviderCheckoutConnection{
+					ID:   "square",
+					Type: buyte.SQUARE,
+				},
+			},
+		},
+	},
+}
+
+func connectionIds(connections []*buyte.ProviderCheckoutConnection) []string {
+	ids := []string{}
+	for _, connection := range connections {
+		ids = append(ids, connection.ID)
+	}
+	return ids
+}
+
+func TestRoute(t *testing.T) {
+	assert := assert.New(t)
+
+	connections := Route(checkout, &RoutingCriteria{
+		Currency:      "usd",
+		CardNetwork:   "AmEx",
+		PaymentMethod: buyte.APPLE_PAY,
+		Amount:        5000,
+	})
+	assert.Equal([]string{"adyen", "braintree", "checkoutcom", "primary"}, connectionIds(connections))
+
+	connections = Route(checkout, &RoutingCriteria{
+		Currency:      "aud",
+		CardNetwork:   "AMEX",
+		PaymentMethod: buyte.GOOGLE_PAY,
+		Amount:        200000,
+	})
+	assert.Equal([]string{"checkoutcom", "primary"}, connectionIds(connections))
+}
+
+func TestRouteWithoutConnections(t *testing.T) {
+	connections := Route(&buyte.PaymentTokenCheckout{
+		Connection: checkout.Connection,
+	}, &RoutingCriteria{Currency: "aud"})
+	assert.Equal(t, []string{"primary"}, connectionIds(connections))
+}
+
+func TestRoutePayPal(t *testing.T) {
+	assert := assert.New(t)
+	payPal := &buyte.RoutedCheckoutConnection{
+		ID:       "routed_paypal",
+		Priority: 4,
+		Connection: &buyte.ProviderCheckoutConnection{
+			ID:   "paypal",
+			Type: buyte.PAYPAL_CHECKOUT,
+		},
+	}
+	withPayPal := &buyte.PaymentTokenCheckout{
+		Connection: checkout.Connection,
+		Connections: &buyte.CheckoutConnections{
+			Items: append([]*buyte.RoutedCheckoutConnection{payPal}, checkout.Connections.Items...),
+		},
+	}
+
+	connections := Route(withPayPal, &RoutingCriteria{Currency: "aud", PaymentMethod: buyte.PAYPAL})
+	assert.Equal([]string{"paypal"}, connectionIds(connections), "PayPal orders are only captured through PayPal.")
+
+	connections = Route(withPayPal, &RoutingCriteria{Currency: "aud", PaymentMethod: buyte.GOOGLE_PAY})
+	assert.Equal([]string{"checkoutcom", "primary"}, connectionIds(connections), "PayPal does not charge wallet cards.")
+}
+
+func TestMatches(t *testing.T) {
+	assert := assert.New(t)
+
+	rules := &buyte.ConnectionRoutingRules{
+		Currencies:     []string{"aud", "NZD"},
+		CardNetworks:   []string{"Master Card", "visa"},
+		PaymentMethods: []string{buyte.APPLE_PAY},
+		MinAmount:      100,
+		MaxAmount:      1000,
+	}
+	criteria := &RoutingCriteria{
+		Currency:      "nzd",
+		CardNetwork:   "MASTERCARD",
+		PaymentMethod: buyte.APPLE_PAY,
+		Amount:        500,
+	}
+	assert.True(Matches(rules, criteria))
+	assert.True(Matches(&buyte.ConnectionRoutingRules{}, criteria), "Empty rules match every charge.")
+
+	declined := *criteria
+	declined.Amount = 50
+	assert.False(Matches(rules, &declined))
+	declined = *criteria
+	declined.PaymentMethod = buyte.GOOGLE_PAY
+	assert.False(Matches(rules, &declined))
+	declined = *criteria
+	declined.CardNetwork = "AMEX"
+	assert.False(Matches(rules, &declined))
+
+	unknown := *criteria
+	unknown.Amount = 0
+	assert.True(Matches(rules, &unknown), "An unknown amount matches any minimum.")
+}
+
+func TestConnection(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("primary", Connection(checkout, "primary").ID)
+	assert.Equal(buyte.ADYEN, Connection(checkout, "adyen").Type)
+	assert.Nil(Connection(checkout, "unknown"))
+	assert.Nil(Connection(checkout, ""))
+}
+
+// Charges through a stand in gateway with the shared transport, counting the charges each connection is asked for.
+func chargeThrough(servers map[string]string, charges map[string]int) ChargeFunc {
+	client := &transport.Transport{
+		Client:   &http.Client{Timeout: 20 * time.Millisecond},
+		Breakers: transport.NewBreakers(3, time.Minute),
+	}
+	return func(connection *buyte.ProviderCheckoutConnection) (*buyte.GatewayCharge, error) {
+		charges[connection.ID]++
+		_, err := client.Do(context.Background(), &buyte.Gateway{Type: connection.Type, ConnectionId: connection.ID}, &buyte.GatewayRequest{
+			Method:         http.MethodPost,
+			URL:            servers[connection.ID],
+			IdempotencyKey: "tok_xxx-payments",
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &buyte.GatewayCharge{Reference: "ch_" + connection.ID, Type: connection.Type}, nil
+	}
+}
+
+func TestChargeTimeoutDoesNotFailOver(t *testing.T) {
+	assert := assert.New(t)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer slow.Close()
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ok.Close()
+
+	connections := []*buyte.ProviderCheckoutConnection{
+		{ID: "adyen", Type: buyte.ADYEN},
+		{ID: "primary", Type: buyte.STRIPE},
+	}
+	charges := map[string]int{}
+	result, connection, err := Charge(connections, chargeThrough(map[string]string{"adyen": slow.URL, "primary": ok.URL}, charges))
+	assert.Nil(result)
+	assert.True(buyte.IsGatewayUnavailable(err))
+	assert.Equal("adyen", connection.ID)
+	assert.Equal(0, charges["primary"], "The first gateway received the charge, so may have applied it.")
+}
+
+func TestChargeFailsOverWhenNotAttempted(t *testing.T) {
+	assert := assert.New(t)
+	refused := httptest.NewServer(http.NotFoundHandler())
+	refused.Close()
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ok.Close()
+
+	connections := []*buyte.ProviderCheckoutConnection{
+		{ID: "adyen", Type: buyte.ADYEN},
+		{ID: "primary", Type: buyte.STRIPE},
+	}
+	charges := map[string]int{}
+	result, connection, err := Charge(connections, chargeThrough(map[string]string{"adyen": refused.URL, "primary": ok.URL}, charges))
+	assert.NoError(err)
+	assert.Equal("ch_primary", result.Reference)
+	assert.Equal("primary", connection.ID)
+	assert.Equal(1, charges["adyen"])
+
+	_, _, err = Charge(connections, func(connection *buyte.ProviderCheckoutConnection) (*buyte.GatewayCharge, error) {
+		return nil, buyte.UnsupportedPaymentData("Stripe", buyte.PAYMENT_DATA_EMV)
+	})
+	assert.True(buyte.IsUnsupportedPaymentData(err), "The last connection's error is returned.")
+
+	_, _, err = Charge(nil, chargeThrough(nil, charges))
+	assert.Error(err)
+}
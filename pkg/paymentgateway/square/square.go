@@ -0,0 +1,242 @@
+package square
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/buger/jsonparser"
+	"github.com/palantir/stacktrace"
+	"github.com/pkg/errors"
+	config "github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/paymentgateway/transport"
+)
+
+type Gateway buyte.Gateway
+type SquareCredentials struct {
+	AccessToken   string `json:"accessToken"`
+	MerchantId    string `json:"merchantId"`
+	ApplicationId string `json:"applicationId"`
+	LocationId    string `json:"locationId"`
+}
+
+type SquareMoney struct {
+	Amount   int    `json:"amount"`
+	Currency string `json:"currency"`
+}
+type SquarePaymentParams struct {
+	SourceId       string       `json:"source_id"`
+	IdempotencyKey string       `json:"idempotency_key"`
+	AmountMoney    SquareMoney  `json:"amount_money"`
+	AppFeeMoney    *SquareMoney `json:"app_fee_money,omitempty"`
+	Autocomplete   bool         `json:"autocomplete"`
+	LocationId     string       `json:"location_id,omitempty"`
+	ReferenceId    string       `json:"reference_id,omitempty"`
+	Note           string       `json:"note,omitempty"`
+}
+type SquarePayment struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// Payment statuses that indicate the charge did not go through.
+var failedPaymentStatuses = map[string]bool{
+	"CANCELED": true,
+	"FAILED":   true,
+}
+
+func New(ctx context.Context, connection *buyte.ProviderCheckoutConnection) (*Gateway, error) {
+	credentials := &SquareCredentials{}
+	if err := json.Unmarshal([]byte(connection.Credentials), credentials); err != nil {
+		return &Gateway{}, err
+	}
+	return &Gateway{
+		Type:         connection.Type,
+		IsTest:       connection.IsTest,
+		ConnectionId: connection.ID,
+		Credentials:  credentials,
+		Context:      ctx,
+		Logger:       zap.S().With("package", "paymentgateway.square"),
+		Transport:    transport.Default(),
+	}, nil
+}
+
+func (g *Gateway) SquareCredentials() *SquareCredentials {
+	return g.Credentials.(*SquareCredentials)
+}
+
+// For now.
+func (g *Gateway) IsConnect() bool {
+	return false
+}
+
+// Square tokenises Apple Pay and Google Pay payments into a wallet nonce within its own client SDK.
+func (g *Gateway) IsPassthrough(paymentMethod string) bool {
+	return paymentMethod == buyte.APPLE_PAY || paymentMethod == buyte.GOOGLE_PAY
+}
+
+// Square does not accept decrypted network tokens through the Payments API.
+func (g *Gateway) Charge(input *buyte.CreateChargeInput, networkToken *buyte.NetworkToken, paymentToken *buyte.PaymentToken) (*buyte.GatewayCharge, error) {
+	return &buyte.GatewayCharge{}, buyte.UnsupportedPaymentData("Square", paymentToken.PaymentMethod.Name)
+}
+
+// Charge a Square wallet nonce produced for an Apple Pay or Google Pay payment.
+func (g *Gateway) ChargeNative(input *buyte.CreateChargeInput, nativeToken string, paymentToken *buyte.PaymentToken) (*buyte.GatewayCharge, error) {
+	nonce, err := nonceFromToken(nativeToken)
+	if err != nil {
+		return &buyte.GatewayCharge{}, err
+	}
+
+	capture := true
+	if input.Capture != nil {
+		capture = *input.Capture
+	}
+	params := &SquarePaymentParams{
+		SourceId:       nonce,
+		IdempotencyKey: transport.IdempotencyKey(paymentToken, "payments"),
+		AmountMoney: SquareMoney{
+			Amount:   input.Amount,
+			Currency: strings.ToUpper(input.Currency),
+		},
+		Autocomplete: capture,
+		LocationId:   g.SquareCredentials().LocationId,
+		ReferenceId:  input.Order.Reference,
+		Note:         g.getDescription(input, paymentToken),
+	}
+	if params.IdempotencyKey == "" {
+		params.IdempotencyKey = nonce
+	}
+	if input.FeeAmount > 0 {
+		params.AppFeeMoney = &SquareMoney{
+			Amount:   input.FeeAmount,
+			Currency: strings.ToUpper(input.Currency),
+		}
+	}
+
+	jsonData, err := json.Marshal(params)
+	if err != nil {
+		return &buyte.GatewayCharge{}, err
+	}
+	response, err := g.post(g.endpoint()+"/v2/payments", jsonData, params.IdempotencyKey)
+	if err != nil {
+		return &buyte.GatewayCharge{}, stacktrace.Propagate(err, "Could not execute payment request")
+	}
+
+	payment := &SquarePayment{}
+	paymentBytes, _, _, err := jsonparser.Get(response, "payment")
+	if err != nil {
+		return &buyte.GatewayCharge{}, errors.Wrap(err, "Could not obtain payment")
+	}
+	if err := json.Unmarshal(paymentBytes, payment); err != nil {
+		return &buyte.GatewayCharge{}, errors.Wrap(err, "Could not read payment response")
+	}
+	if failedPaymentStatuses[payment.Status] {
+		return &buyte.GatewayCharge{}, errors.Errorf("Square payment %s was not successful: %s", payment.ID, payment.Status)
+	}
+
+	g.Logger.Infow("Square Payment", "payment_id", payment.ID, "status", payment.Status)
+
+	// Return Charge
+	return &buyte.GatewayCharge{
+		Reference:     payment.ID,
+		Type:          g.Type,
+		AuthoriseOnly: !params.Autocomplete,
+	}, nil
+}
+
+// Complete captures a payment that was charged without autocomplete.
+func (g *Gateway) Complete(reference string) error {
+	response, err := g.Post(g.endpoint()+"/v2/payments/"+reference+"/complete", []byte("{}"))
+	if err != nil {
+		return stacktrace.Propagate(err, "Could not execute complete payment request")
+	}
+	status, err := jsonparser.GetString(response, "payment", "status")
+	if err != nil {
+		return errors.Wrap(err, "Could not obtain payment")
+	}
+	if status != "COMPLETED" {
+		return errors.Errorf("Square payment %s was not completed: %s", reference, status)
+	}
+	g.Logger.Infow("Square Complete", "payment_id", reference, "status", status)
+	return nil
+}
+
+// The nonce is either provided as is, or as the "nonce" property of a Square tokenize result.
+func nonceFromToken(nativeToken string) (string, error) {
+	if strings.HasPrefix(strings.TrimSpace(nativeToken), "{") {
+		nonce, err := jsonparser.GetString([]byte(nativeToken), "nonce")
+		if err != nil {
+			return "", errors.Wrap(err, "Could not obtain Square wallet nonce")
+		}
+		return nonce, nil
+	}
+	if nativeToken == "" {
+		return "", errors.New("Square wallet nonce not provided")
+	}
+	return nativeToken, nil
+}
+
+func (g *Gateway) getDescription(input *buyte.CreateChargeInput, paymentToken *buyte.PaymentToken) string {
+	description := input.Description
+	if description == "" {
+		description = "Buyte: " + paymentToken.PaymentMethod.Name
+		if input.Order.Reference != "" {
+			description = description + " - " + input.Order.Reference
+		}
+	}
+	return description
+}
+
+func (g *Gateway) Post(url string, jsonBody []byte) ([]byte, error) {
+	return g.post(url, jsonBody, "")
+}
+
+// Square carries idempotency keys within the request body. The key is provided so that the request may be retried.
+func (g *Gateway) post(url string, jsonBody []byte, idempotencyKey string) ([]byte, error) {
+	// Build Request
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+g.SquareCredentials().AccessToken)
+	header.Set("Square-Version", config.GetString("square.version"))
+	header.Set("Content-Type", "application/json")
+
+	// Execute request
+	resp, err := g.transport().Do(g.Context, (*buyte.Gateway)(g), &buyte.GatewayRequest{
+		Method:         http.MethodPost,
+		URL:            url,
+		Header:         header,
+		Body:           jsonBody,
+		IdempotencyKey: idempotencyKey,
+	})
+	if err != nil {
+		return []byte{}, err
+	}
+	if resp.StatusCode == 401 {
+		return []byte{}, errors.New("Unauthorized")
+	}
+	if resp.StatusCode >= 400 {
+		detail, _ := jsonparser.GetString(resp.Body, "errors", "[0]", "detail")
+		code, _ := jsonparser.GetString(resp.Body, "errors", "[0]", "code")
+		return []byte{}, errors.Errorf("Square request failed with status %d: %s %s", resp.StatusCode, code, detail)
+	}
+
+	// Return response body
+	return resp.Body, nil
+}
+
+func (g *Gateway) transport() buyte.GatewayTransport {
+	if g.Transport == nil {
+		return transport.Default()
+	}
+	return g.Transport
+}
+
+func (g *Gateway) endpoint() string {
+	if g.IsTest {
+		return config.GetString("square.test.endpoint")
+	}
+	return config.GetString("square.live.endpoint")
+}
@@ -0,0 +1,169 @@
+package square
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	config "github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rsoury/buyte/buyte"
+)
+
+const credentials = `{
+	"accessToken": "EAAAxxx",
+	"merchantId": "ML_xxx",
+	"applicationId": "sandbox-sq0idb-xxx",
+	"locationId": "L_xxx"
+}`
+
+var (
+	chargeInput = &buyte.CreateChargeInput{
+		Amount:   3200,
+		Currency: "aud",
+		Order: buyte.ChargeOrder{
+			Reference: "some-order-id",
+		},
+	}
+	paymentToken = &buyte.PaymentToken{
+		ID: "tok_xxx",
+		PaymentMethod: &buyte.PaymentMethod{
+			Name: buyte.GOOGLE_PAY,
+		},
+	}
+)
+
+// Stands in for the Square Payments API.
+func StandIn(t *testing.T, status string, request map[string]interface{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer EAAAxxx", r.Header.Get("Authorization"))
+		assert.NotEmpty(t, r.Header.Get("Square-Version"))
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/v2/payments/sq_pay_xxx/complete" {
+			_, _ = w.Write([]byte(`{"payment":{"id":"sq_pay_xxx","status":"COMPLETED"}}`))
+			return
+		}
+		assert.Equal(t, "/v2/payments", r.URL.Path)
+
+		body, _ := ioutil.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &request)
+
+		if status == "" {
+			w.WriteHeader(400)
+			_, _ = w.Write([]byte(`{"errors":[{"category":"PAYMENT_METHOD_ERROR","code":"CARD_DECLINED","detail":"Card declined."}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"payment":{"id":"sq_pay_xxx","status":"` + status + `"}}`))
+	}))
+}
+
+func GatewaySetup(t *testing.T, endpoint string) *Gateway {
+	config.Set("square.test.endpoint", endpoint)
+	config.Set("square.version", "2021-09-15")
+	gateway, err := New(context.Background(), &buyte.ProviderCheckoutConnection{
+		Type:        buyte.SQUARE,
+		IsTest:      true,
+		Credentials: credentials,
+		Provider: buyte.ProviderCheckoutConnectionProviderDetails{
+			Name: "Square",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gateway
+}
+
+func TestChargeNative(t *testing.T) {
+	assert := assert.New(t)
+	request := map[string]interface{}{}
+	server := StandIn(t, "COMPLETED", request)
+	defer server.Close()
+	gateway := GatewaySetup(t, server.URL)
+
+	result, err := gateway.ChargeNative(chargeInput, "cnon:card-nonce-ok", paymentToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal("sq_pay_xxx", result.Reference)
+	assert.Equal(buyte.SQUARE, result.Type)
+	assert.Equal("cnon:card-nonce-ok", request["source_id"])
+	assert.Equal("tok_xxx-payments", request["idempotency_key"])
+	assert.Equal("L_xxx", request["location_id"])
+	assert.Equal(true, request["autocomplete"])
+	assert.Equal(float64(3200), request["amount_money"].(map[string]interface{})["amount"])
+	assert.Equal("AUD", request["amount_money"].(map[string]interface{})["currency"])
+}
+
+func TestChargeNativeAuthoriseOnly(t *testing.T) {
+	assert := assert.New(t)
+	request := map[string]interface{}{}
+	server := StandIn(t, "APPROVED", request)
+	defer server.Close()
+	gateway := GatewaySetup(t, server.URL)
+
+	capture := false
+	input := *chargeInput
+	input.Capture = &capture
+	result, err := gateway.ChargeNative(&input, "cnon:card-nonce-ok", paymentToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(false, request["autocomplete"])
+	assert.True(result.AuthoriseOnly, "The charge should be recorded as not yet captured.")
+	assert.NoError(gateway.Complete(result.Reference))
+}
+
+func TestChargeNativeTokenizeResult(t *testing.T) {
+	request := map[string]interface{}{}
+	server := StandIn(t, "APPROVED", request)
+	defer server.Close()
+	gateway := GatewaySetup(t, server.URL)
+
+	_, err := gateway.ChargeNative(chargeInput, `{"status":"OK","nonce":"cnon:wallet"}`, paymentToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "cnon:wallet", request["source_id"])
+}
+
+func TestChargeNativeDeclined(t *testing.T) {
+	request := map[string]interface{}{}
+	server := StandIn(t, "", request)
+	defer server.Close()
+	gateway := GatewaySetup(t, server.URL)
+
+	_, err := gateway.ChargeNative(chargeInput, "cnon:card-nonce-declined", paymentToken)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "CARD_DECLINED")
+	}
+}
+
+func TestCharge(t *testing.T) {
+	gateway := GatewaySetup(t, "")
+	assert.True(t, gateway.IsPassthrough(buyte.APPLE_PAY))
+	_, err := gateway.Charge(chargeInput, &buyte.NetworkToken{}, paymentToken)
+	assert.True(t, buyte.IsUnsupportedPaymentData(err), "Square cannot charge network tokens.")
+}
+
+func TestChargeNativeUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	}))
+	defer server.Close()
+	gateway := GatewaySetup(t, server.URL)
+
+	_, err := gateway.ChargeNative(chargeInput, "cnon:card-nonce-ok", paymentToken)
+	assert.True(t, buyte.IsGatewayUnavailable(err))
+	assert.False(t, buyte.IsGatewayNotAttempted(err), "Square may have applied a payment it failed on, so it should not be retried against another connection.")
+
+	declined := StandIn(t, "", map[string]interface{}{})
+	defer declined.Close()
+	gateway = GatewaySetup(t, declined.URL)
+	_, err = gateway.ChargeNative(chargeInput, "cnon:card-nonce-declined", paymentToken)
+	assert.False(t, buyte.IsGatewayUnavailable(err), "A decline should never be retried.")
+}
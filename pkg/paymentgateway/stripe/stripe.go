@@ -12,10 +12,13 @@ import (
 	config "github.com/spf13/viper"
 	"github.com/stripe/stripe-go"
 	"github.com/stripe/stripe-go/charge"
+	"github.com/stripe/stripe-go/customer"
+	"github.com/stripe/stripe-go/paymentintent"
 	"github.com/stripe/stripe-go/source"
 	"go.uber.org/zap"
 
 	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/paymentgateway/transport"
 	"github.com/rsoury/buyte/pkg/util"
 )
 
@@ -32,11 +35,13 @@ func New(ctx context.Context, connection *buyte.ProviderCheckoutConnection) (*Ga
 		return &Gateway{}, err
 	}
 	return &Gateway{
-		Type:        connection.Type,
-		IsTest:      connection.IsTest,
-		Credentials: credentials,
-		Context:     ctx,
-		Logger:      zap.S().With("package", "paymentgateway.stripe"),
+		Type:         connection.Type,
+		IsTest:       connection.IsTest,
+		ConnectionId: connection.ID,
+		Credentials:  credentials,
+		Context:      ctx,
+		Logger:       zap.S().With("package", "paymentgateway.stripe"),
+		Transport:    transport.Default(),
 	}, nil
 }
 
@@ -62,6 +67,10 @@ func (g *Gateway) IsConnect() bool {
 }
 
 func (g *Gateway) Charge(input *buyte.CreateChargeInput, networkToken *buyte.NetworkToken, paymentToken *buyte.PaymentToken) (*buyte.GatewayCharge, error) {
+	// Card sources only accept a cryptogram.
+	if networkToken.IsEMV() {
+		return &buyte.GatewayCharge{}, buyte.UnsupportedPaymentData("Stripe", networkToken.DataType())
+	}
 	// Create source
 	cryptogram, err := util.DecodeCryptogram(networkToken.PaymentData.OnlinePaymentCryptogram)
 
@@ -80,30 +89,121 @@ func (g *Gateway) Charge(input *buyte.CreateChargeInput, networkToken *buyte.Net
 		sourceData["eci"] = util.Rjust(networkToken.PaymentData.ECIIndicator, 2, "0")
 	}
 
-	stripe.Key = g.AuthKey()
 	sourceParams := &stripe.SourceObjectParams{
 		Type:     stripe.String("card"),
 		TypeData: sourceData,
 		Currency: stripe.String(input.Currency),
 	}
-	src, err := source.New(sourceParams)
+	if paymentToken.Agreement != nil {
+		sourceParams.Usage = stripe.String(string(stripe.SourceUsageReusable))
+	}
+	g.setRequestParams(&sourceParams.Params, paymentToken, "source")
+	sources := &source.Client{B: g.backend(), Key: g.AuthKey()}
+	src, err := sources.New(sourceParams)
 	if err != nil {
+		if isUnavailable(err) {
+			return &buyte.GatewayCharge{}, unavailable(err)
+		}
 		return &buyte.GatewayCharge{}, stacktrace.Propagate(err, "Could not create stripe source")
 	}
 	chargeParams := g.createChargeParams(input, paymentToken)
+	if paymentToken.Agreement != nil {
+		return g.executeAgreementCharge(chargeParams, src.ID, paymentToken)
+	}
 	return g.executeCharge(chargeParams, src.ID)
 }
 
 func (g *Gateway) ChargeNative(input *buyte.CreateChargeInput, nativeToken string, paymentToken *buyte.PaymentToken) (*buyte.GatewayCharge, error) {
-	stripe.Key = g.AuthKey()
 	tokenId, err := jsonparser.GetString([]byte(nativeToken), "id")
 	if err != nil {
 		return &buyte.GatewayCharge{}, errors.Wrap(err, "Could not charge stripe token")
 	}
 	chargeParams := g.createChargeParams(input, paymentToken)
+	if paymentToken.Agreement != nil {
+		return g.executeAgreementCharge(chargeParams, tokenId, paymentToken)
+	}
 	return g.executeCharge(chargeParams, tokenId)
 }
 
+// ChargeMerchantInitiated charges the source saved by an agreement's initial charge, without the customer present.
+// Charges do not carry merchant initiated flags, so these are made as off session Payment Intents.
+func (g *Gateway) ChargeMerchantInitiated(input *buyte.CreateChargeInput, initialCharge *buyte.Charge, paymentToken *buyte.PaymentToken) (*buyte.GatewayCharge, error) {
+	chargeParams := g.createChargeParams(input, paymentToken)
+	params := &stripe.PaymentIntentParams{
+		Amount:        chargeParams.Amount,
+		Currency:      chargeParams.Currency,
+		Description:   chargeParams.Description,
+		Customer:      stripe.String(initialCharge.ProviderCharge.CustomerReference),
+		PaymentMethod: stripe.String(initialCharge.ProviderCharge.PaymentMethodReference),
+		Confirm:       stripe.Bool(true),
+		OffSession:    stripe.Bool(true),
+	}
+	params.Metadata = chargeParams.Metadata
+	if chargeParams.Destination != nil {
+		params.TransferData = &stripe.PaymentIntentTransferDataParams{
+			Destination: chargeParams.Destination.Account,
+		}
+		params.ApplicationFeeAmount = chargeParams.ApplicationFeeAmount
+	}
+	// Agreements are charged many times, so the payment token cannot key these requests.
+	g.setRequestParams(&params.Params, nil, "payment_intent")
+
+	intents := &paymentintent.Client{B: g.backend(), Key: g.AuthKey()}
+	intent, err := intents.New(params)
+	if err != nil {
+		if isUnavailable(err) {
+			return &buyte.GatewayCharge{}, unavailable(err)
+		}
+		return &buyte.GatewayCharge{}, errors.Wrap(err, "Could not create stripe payment intent")
+	}
+	if intent.Status != stripe.PaymentIntentStatusSucceeded {
+		return &buyte.GatewayCharge{}, errors.Errorf("Stripe payment intent %s was not successful: %s", intent.ID, intent.Status)
+	}
+
+	g.Logger.Infow("Stripe Merchant Initiated Charge", "customer_id", initialCharge.ProviderCharge.CustomerReference, "payment_intent_id", intent.ID)
+
+	reference := intent.ID
+	if intent.Charges != nil && len(intent.Charges.Data) > 0 {
+		reference = intent.Charges.Data[0].ID
+	}
+	return &buyte.GatewayCharge{
+		Reference:              reference,
+		Type:                   g.Type,
+		CustomerReference:      initialCharge.ProviderCharge.CustomerReference,
+		PaymentMethodReference: initialCharge.ProviderCharge.PaymentMethodReference,
+	}, nil
+}
+
+// executeAgreementCharge saves the source to a customer before charging it, so the agreement may be charged again.
+func (g *Gateway) executeAgreementCharge(chargeParams *stripe.ChargeParams, token string, paymentToken *buyte.PaymentToken) (*buyte.GatewayCharge, error) {
+	customerParams := &stripe.CustomerParams{
+		Description: stripe.String("Buyte: " + paymentToken.Agreement.Description),
+	}
+	if err := customerParams.SetSource(token); err != nil {
+		return &buyte.GatewayCharge{}, errors.Wrap(err, "Could not create stripe customer")
+	}
+	g.setRequestParams(&customerParams.Params, paymentToken, "customer")
+	customers := &customer.Client{B: g.backend(), Key: g.AuthKey()}
+	cus, err := customers.New(customerParams)
+	if err != nil {
+		if isUnavailable(err) {
+			return &buyte.GatewayCharge{}, unavailable(err)
+		}
+		return &buyte.GatewayCharge{}, errors.Wrap(err, "Could not create stripe customer")
+	}
+	if cus.DefaultSource == nil {
+		return &buyte.GatewayCharge{}, errors.New("Stripe customer " + cus.ID + " has no source")
+	}
+	chargeParams.Customer = stripe.String(cus.ID)
+	result, err := g.executeCharge(chargeParams, cus.DefaultSource.ID)
+	if err != nil {
+		return result, err
+	}
+	result.CustomerReference = cus.ID
+	result.PaymentMethodReference = cus.DefaultSource.ID
+	return result, nil
+}
+
 func (g *Gateway) createChargeParams(input *buyte.CreateChargeInput, paymentToken *buyte.PaymentToken) *stripe.ChargeParams {
 	description := input.Description
 	if description == "" {
@@ -134,16 +234,38 @@ func (g *Gateway) createChargeParams(input *buyte.CreateChargeInput, paymentToke
 	for key, value := range input.Metadata {
 		chargeParams.AddMetadata(key, fmt.Sprintf("%v", value))
 	}
+	g.setRequestParams(&chargeParams.Params, paymentToken, "charge")
 	return chargeParams
 }
 
+// Requests carry the gateway's context, and an idempotency key derived from the payment token so that they may be retried.
+// Without a payment token, Stripe generates its own key per request.
+func (g *Gateway) setRequestParams(params *stripe.Params, paymentToken *buyte.PaymentToken, action string) {
+	params.Context = g.Context
+	if key := transport.IdempotencyKey(paymentToken, action); key != "" {
+		params.SetIdempotencyKey(key)
+	}
+}
+
+// Stripe requests are executed through the shared gateway transport. Retries are left to the transport.
+func (g *Gateway) backend() stripe.Backend {
+	return stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{
+		HTTPClient:        transport.HTTPClient((*buyte.Gateway)(g)),
+		MaxNetworkRetries: 0,
+	})
+}
+
 func (g *Gateway) executeCharge(chargeParams *stripe.ChargeParams, token string) (*buyte.GatewayCharge, error) {
 	err := chargeParams.SetSource(token)
 	if err != nil {
 		return &buyte.GatewayCharge{}, errors.Wrap(err, "Could not create stripe charge")
 	}
-	ch, err := charge.New(chargeParams)
+	charges := &charge.Client{B: g.backend(), Key: g.AuthKey()}
+	ch, err := charges.New(chargeParams)
 	if err != nil {
+		if isUnavailable(err) {
+			return &buyte.GatewayCharge{}, unavailable(err)
+		}
 		return &buyte.GatewayCharge{}, errors.Wrap(err, "Could not create stripe charge")
 	}
 
@@ -155,3 +277,24 @@ func (g *Gateway) executeCharge(chargeParams *stripe.ChargeParams, token string)
 		Type:      g.Type,
 	}, nil
 }
+
+// Stripe errors that are not declines or invalid requests, such as connection failures, rate limiting and server errors.
+// Errors raised by the transport are returned by Stripe's client as they are.
+func isUnavailable(err error) bool {
+	stripeErr, ok := err.(*stripe.Error)
+	if !ok {
+		return true
+	}
+	if stripeErr.HTTPStatusCode >= 500 || stripeErr.HTTPStatusCode == 429 {
+		return true
+	}
+	return stripeErr.Type == stripe.ErrorTypeAPI || stripeErr.Type == stripe.ErrorTypeAPIConnection || stripeErr.Type == stripe.ErrorTypeRateLimit
+}
+
+// Requests that never reached Stripe keep the transport's not attempted code. Any other failure may have been applied by Stripe.
+func unavailable(err error) error {
+	if buyte.IsGatewayNotAttempted(err) {
+		return stacktrace.Propagate(err, "Stripe is unavailable")
+	}
+	return buyte.GatewayUnavailable(err, "Stripe is unavailable")
+}
@@ -0,0 +1,118 @@
+package transport
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var ErrCircuitOpen = errors.New("Circuit breaker is open")
+
+const (
+	stateClosed = iota
+	stateOpen
+	stateHalfOpen
+)
+
+// Breaker stops requests to a gateway connection after consecutive failures.
+// Once the cooldown elapses, a single trial request is let through to decide whether to close again.
+type Breaker struct {
+	mu        sync.Mutex
+	state     int
+	failures  int
+	threshold int
+	cooldown  time.Duration
+	openedAt  time.Time
+	trial     bool
+	now       func() time.Time
+}
+
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// Allow reports whether a request may be made.
+func (b *Breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case stateOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = stateHalfOpen
+		b.trial = true
+		return true
+	case stateHalfOpen:
+		if b.trial {
+			return false
+		}
+		b.trial = true
+		return true
+	}
+	return true
+}
+
+func (b *Breaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = stateClosed
+	b.failures = 0
+	b.trial = false
+}
+
+func (b *Breaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.trial = false
+	if b.state == stateHalfOpen || (b.threshold > 0 && b.failures >= b.threshold) {
+		b.state = stateOpen
+		b.openedAt = b.now()
+	}
+}
+
+// Release gives up a trial request without an outcome. ie. The request was cancelled by the caller.
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+func (b *Breaker) IsOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == stateOpen && b.now().Sub(b.openedAt) < b.cooldown
+}
+
+// Breakers holds a breaker per gateway connection.
+type Breakers struct {
+	mu        sync.Mutex
+	breakers  map[string]*Breaker
+	threshold int
+	cooldown  time.Duration
+}
+
+func NewBreakers(threshold int, cooldown time.Duration) *Breakers {
+	return &Breakers{
+		breakers:  map[string]*Breaker{},
+		threshold: threshold,
+		cooldown:  cooldown,
+	}
+}
+
+func (b *Breakers) Get(key string) *Breaker {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	breaker, ok := b.breakers[key]
+	if !ok {
+		breaker = NewBreaker(b.threshold, b.cooldown)
+		b.breakers[key] = breaker
+	}
+	return breaker
+}
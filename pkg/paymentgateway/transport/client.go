@@ -0,0 +1,64 @@
+package transport
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/rsoury/buyte/buyte"
+)
+
+// The header SDK clients send idempotency keys with. ie. Stripe
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// HTTPClient adapts a gateway's transport for SDKs that require an *http.Client.
+// The SDK's request context is propagated, and a request sent with an Idempotency-Key header is treated as idempotent.
+func HTTPClient(gateway *buyte.Gateway) *http.Client {
+	return &http.Client{
+		Transport: &roundTripper{
+			gateway: gateway,
+		},
+	}
+}
+
+type roundTripper struct {
+	gateway *buyte.Gateway
+}
+
+func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	t := rt.gateway.Transport
+	if t == nil {
+		t = Default()
+	}
+	resp, err := t.Do(req.Context(), rt.gateway, &buyte.GatewayRequest{
+		Method:         req.Method,
+		URL:            req.URL.String(),
+		Header:         req.Header,
+		Body:           body,
+		IdempotencyKey: req.Header.Get(IdempotencyKeyHeader),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		Status:        http.StatusText(resp.StatusCode),
+		StatusCode:    resp.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        resp.Header,
+		Body:          ioutil.NopCloser(bytes.NewReader(resp.Body)),
+		ContentLength: int64(len(resp.Body)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,54 @@
+package transport
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Request outcomes
+const (
+	OutcomeSuccess      = "success"
+	OutcomeClientError  = "client_error"
+	OutcomeServerError  = "server_error"
+	OutcomeNetworkError = "network_error"
+	OutcomeCircuitOpen  = "circuit_open"
+	OutcomeCancelled    = "cancelled"
+)
+
+// Observation describes a single attempt of a gateway request.
+type Observation struct {
+	Provider   string
+	Connection string
+	Outcome    string
+	StatusCode int
+	Latency    time.Duration
+	Attempt    int
+}
+
+// Metrics receives the latency and outcome of every gateway request attempt.
+type Metrics interface {
+	Observe(*Observation)
+}
+
+// LoggerMetrics emits observations as structured logs.
+type LoggerMetrics struct {
+	logger *zap.SugaredLogger
+}
+
+func NewLoggerMetrics() *LoggerMetrics {
+	return &LoggerMetrics{
+		logger: zap.S().With("package", "paymentgateway.transport"),
+	}
+}
+
+func (m *LoggerMetrics) Observe(o *Observation) {
+	m.logger.Infow("Gateway Request",
+		"provider", o.Provider,
+		"connection", o.Connection,
+		"outcome", o.Outcome,
+		"status", o.StatusCode,
+		"latency_ms", o.Latency.Milliseconds(),
+		"attempt", o.Attempt,
+	)
+}
@@ -0,0 +1,222 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	config "github.com/spf13/viper"
+
+	"github.com/rsoury/buyte/buyte"
+)
+
+// Transport is the shared HTTP layer for payment gateways.
+// Requests carry the caller's context, idempotent requests are retried, and each gateway connection has its own circuit breaker.
+type Transport struct {
+	Client     *http.Client
+	MaxRetries int
+	Backoff    time.Duration
+	Breakers   *Breakers
+	Metrics    Metrics
+}
+
+var (
+	defaultTransport buyte.GatewayTransport
+	defaultMu        sync.Mutex
+)
+
+// New creates a Transport configured from the gateway settings.
+func New() *Transport {
+	return &Transport{
+		Client: &http.Client{
+			Timeout: config.GetDuration("gateway.timeout"),
+		},
+		MaxRetries: config.GetInt("gateway.retries"),
+		Backoff:    config.GetDuration("gateway.backoff"),
+		Breakers:   NewBreakers(config.GetInt("gateway.breaker.threshold"), config.GetDuration("gateway.breaker.cooldown")),
+		Metrics:    NewLoggerMetrics(),
+	}
+}
+
+// Default returns the transport gateways are created with.
+func Default() buyte.GatewayTransport {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultTransport == nil {
+		defaultTransport = New()
+	}
+	return defaultTransport
+}
+
+// SetDefault replaces the transport gateways are created with. ie. to stand in for gateways within tests.
+func SetDefault(t buyte.GatewayTransport) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultTransport = t
+}
+
+// Do executes a request against a gateway.
+// Requests that never reached the gateway, ie. refused connections and open circuits, are returned as gateway not attempted errors.
+// Other network failures, rate limiting and server errors are returned as gateway unavailable errors, as the gateway may have applied the request.
+// All other responses are returned for the gateway to interpret.
+func (t *Transport) Do(ctx context.Context, gateway *buyte.Gateway, req *buyte.GatewayRequest) (*buyte.GatewayResponse, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	breaker := t.Breakers.Get(breakerKey(gateway))
+
+	attempts := 1
+	if isRetryable(req) {
+		attempts += t.MaxRetries
+	}
+
+	var lastErr error
+	// Whether any attempt may have reached the gateway. Once one has, the outcome of the request is unknown.
+	sent := false
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			if err := sleep(ctx, t.Backoff*time.Duration(1<<uint(attempt-2))); err != nil {
+				return &buyte.GatewayResponse{}, err
+			}
+		}
+		if !breaker.Allow() {
+			t.observe(gateway, OutcomeCircuitOpen, 0, 0, attempt)
+			if sent {
+				return &buyte.GatewayResponse{}, buyte.GatewayUnavailable(lastErr, "%s connection is unavailable", gateway.Type)
+			}
+			return &buyte.GatewayResponse{}, buyte.GatewayNotAttempted(ErrCircuitOpen, "%s connection is unavailable", gateway.Type)
+		}
+
+		httpReq, err := newRequest(ctx, req)
+		if err != nil {
+			breaker.Release()
+			return &buyte.GatewayResponse{}, buyte.GatewayNotAttempted(err, "Could not create %s request", gateway.Type)
+		}
+		start := time.Now()
+		resp, err := t.do(httpReq)
+		latency := time.Since(start)
+
+		if err != nil {
+			// The caller cancelled the request. Nothing can be said of the gateway.
+			if ctx.Err() != nil {
+				breaker.Release()
+				t.observe(gateway, OutcomeCancelled, 0, latency, attempt)
+				return &buyte.GatewayResponse{}, errors.Wrap(ctx.Err(), "Gateway request cancelled")
+			}
+			breaker.Failure()
+			t.observe(gateway, OutcomeNetworkError, 0, latency, attempt)
+			if !sent && isNotSent(err) {
+				lastErr = buyte.GatewayNotAttempted(err, "Could not reach %s", gateway.Type)
+			} else {
+				sent = true
+				lastErr = buyte.GatewayUnavailable(err, "Could not reach %s", gateway.Type)
+			}
+			continue
+		}
+		sent = true
+		if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+			breaker.Failure()
+			t.observe(gateway, OutcomeServerError, resp.StatusCode, latency, attempt)
+			lastErr = buyte.GatewayUnavailable(errors.Errorf("Status %d", resp.StatusCode), "%s is unavailable", gateway.Type)
+			continue
+		}
+
+		breaker.Success()
+		if resp.StatusCode >= 400 {
+			t.observe(gateway, OutcomeClientError, resp.StatusCode, latency, attempt)
+		} else {
+			t.observe(gateway, OutcomeSuccess, resp.StatusCode, latency, attempt)
+		}
+		return resp, nil
+	}
+	return &buyte.GatewayResponse{}, lastErr
+}
+
+func newRequest(ctx context.Context, req *buyte.GatewayRequest) (*http.Request, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, req.Method, req.URL, bytes.NewReader(req.Body))
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range req.Header {
+		httpReq.Header[key] = values
+	}
+	return httpReq, nil
+}
+
+func (t *Transport) do(httpReq *http.Request) (*buyte.GatewayResponse, error) {
+	resp, err := t.Client.Do(httpReq)
+	if err != nil {
+		return &buyte.GatewayResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return &buyte.GatewayResponse{}, err
+	}
+	return &buyte.GatewayResponse{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       body,
+	}, nil
+}
+
+func (t *Transport) observe(gateway *buyte.Gateway, outcome string, status int, latency time.Duration, attempt int) {
+	if t.Metrics == nil {
+		return
+	}
+	t.Metrics.Observe(&Observation{
+		Provider:   gateway.Type,
+		Connection: gateway.ConnectionId,
+		Outcome:    outcome,
+		StatusCode: status,
+		Latency:    latency,
+		Attempt:    attempt,
+	})
+}
+
+// Network errors raised before the request was written, ie. failed DNS lookups and refused connections.
+// Any other network error, such as a timeout awaiting the response, may have followed the gateway receiving the request.
+func isNotSent(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// Only requests that cannot be applied twice by the gateway are retried.
+func isRetryable(req *buyte.GatewayRequest) bool {
+	return req.IdempotencyKey != "" || req.Method == http.MethodGet
+}
+
+// Connections are isolated from each other, so that one degraded account does not trip every merchant on the same gateway.
+func breakerKey(gateway *buyte.Gateway) string {
+	return gateway.Type + ":" + gateway.ConnectionId
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "Gateway request cancelled")
+	case <-timer.C:
+		return nil
+	}
+}
+
+// IdempotencyKey derives the key for a gateway request from the payment token being charged.
+// Payment tokens may only be charged once, so the key is stable across retries of the same request.
+func IdempotencyKey(paymentToken *buyte.PaymentToken, action string) string {
+	if paymentToken == nil || paymentToken.ID == "" {
+		return ""
+	}
+	return paymentToken.ID + "-" + action
+}
@@ -0,0 +1,246 @@
+package transport
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rsoury/buyte/buyte"
+)
+
+type recordedMetrics struct {
+	observations []*Observation
+}
+
+func (m *recordedMetrics) Observe(o *Observation) {
+	m.observations = append(m.observations, o)
+}
+
+// Stands in for a gateway, failing the first number of requests with the given status.
+func StandIn(failures int32, status int, requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count := atomic.AddInt32(requests, 1)
+		if count <= failures {
+			w.WriteHeader(status)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Idempotency-Key", r.Header.Get("Idempotency-Key"))
+		_, _ = w.Write(body)
+	}))
+}
+
+func TransportSetup() (*Transport, *recordedMetrics) {
+	metrics := &recordedMetrics{}
+	return &Transport{
+		Client:     &http.Client{Timeout: time.Second},
+		MaxRetries: 2,
+		Backoff:    time.Millisecond,
+		Breakers:   NewBreakers(3, time.Minute),
+		Metrics:    metrics,
+	}, metrics
+}
+
+var gateway = &buyte.Gateway{
+	Type:         buyte.ADYEN,
+	ConnectionId: "connection_xxx",
+}
+
+func TestDoRetriesIdempotentRequests(t *testing.T) {
+	assert := assert.New(t)
+	var requests int32
+	server := StandIn(2, 503, &requests)
+	defer server.Close()
+	transport, metrics := TransportSetup()
+
+	resp, err := transport.Do(context.Background(), gateway, &buyte.GatewayRequest{
+		Method:         http.MethodPost,
+		URL:            server.URL,
+		Body:           []byte(`{"amount":3200}`),
+		IdempotencyKey: "tok_xxx-payments",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(200, resp.StatusCode)
+	assert.Equal(`{"amount":3200}`, string(resp.Body), "The body should be resent on every attempt.")
+	assert.Equal(int32(3), requests)
+	if assert.Len(metrics.observations, 3) {
+		assert.Equal(OutcomeServerError, metrics.observations[0].Outcome)
+		assert.Equal(OutcomeSuccess, metrics.observations[2].Outcome)
+		assert.Equal(3, metrics.observations[2].Attempt)
+		assert.Equal("connection_xxx", metrics.observations[2].Connection)
+	}
+}
+
+func TestDoDoesNotRetryWithoutIdempotencyKey(t *testing.T) {
+	var requests int32
+	server := StandIn(1, 502, &requests)
+	defer server.Close()
+	transport, _ := TransportSetup()
+
+	_, err := transport.Do(context.Background(), gateway, &buyte.GatewayRequest{
+		Method: http.MethodPost,
+		URL:    server.URL,
+	})
+	assert.True(t, buyte.IsGatewayUnavailable(err))
+	assert.Equal(t, int32(1), requests)
+}
+
+func TestDoReturnsClientErrors(t *testing.T) {
+	var requests int32
+	server := StandIn(5, 422, &requests)
+	defer server.Close()
+	transport, _ := TransportSetup()
+
+	resp, err := transport.Do(context.Background(), gateway, &buyte.GatewayRequest{
+		Method:         http.MethodPost,
+		URL:            server.URL,
+		IdempotencyKey: "tok_xxx-payments",
+	})
+	assert.NoError(t, err, "Client errors are for the gateway to interpret.")
+	assert.Equal(t, 422, resp.StatusCode)
+	assert.Equal(t, int32(1), requests, "Client errors should not be retried.")
+}
+
+func TestDoOpensCircuit(t *testing.T) {
+	assert := assert.New(t)
+	var requests int32
+	server := StandIn(100, 500, &requests)
+	defer server.Close()
+	transport, metrics := TransportSetup()
+
+	req := &buyte.GatewayRequest{
+		Method:         http.MethodPost,
+		URL:            server.URL,
+		IdempotencyKey: "tok_xxx-payments",
+	}
+	_, err := transport.Do(context.Background(), gateway, req)
+	assert.True(buyte.IsGatewayUnavailable(err))
+	assert.Equal(int32(3), requests)
+
+	assert.False(buyte.IsGatewayNotAttempted(err), "The gateway may have applied a request it failed on.")
+
+	_, err = transport.Do(context.Background(), gateway, req)
+	assert.True(buyte.IsGatewayNotAttempted(err))
+	assert.Equal(int32(3), requests, "An open circuit should not reach the gateway.")
+	assert.Equal(OutcomeCircuitOpen, metrics.observations[len(metrics.observations)-1].Outcome)
+
+	// Other connections to the same gateway are unaffected.
+	other := &buyte.Gateway{Type: buyte.ADYEN, ConnectionId: "connection_yyy"}
+	_, _ = transport.Do(context.Background(), other, req)
+	assert.Equal(int32(6), requests)
+}
+
+func TestDoNotAttempted(t *testing.T) {
+	assert := assert.New(t)
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+	transport, metrics := TransportSetup()
+
+	_, err := transport.Do(context.Background(), gateway, &buyte.GatewayRequest{
+		Method:         http.MethodPost,
+		URL:            url,
+		IdempotencyKey: "tok_xxx-payments",
+	})
+	assert.True(buyte.IsGatewayNotAttempted(err), "A refused connection never reached the gateway.")
+	assert.True(buyte.IsGatewayUnavailable(err))
+	assert.Len(metrics.observations, 3)
+
+	_, err = transport.Do(context.Background(), gateway, &buyte.GatewayRequest{
+		Method: "BAD METHOD",
+		URL:    url,
+	})
+	assert.True(buyte.IsGatewayNotAttempted(err))
+}
+
+func TestDoTimeoutIsAmbiguous(t *testing.T) {
+	assert := assert.New(t)
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+	transport, _ := TransportSetup()
+	transport.Client.Timeout = 20 * time.Millisecond
+
+	_, err := transport.Do(context.Background(), gateway, &buyte.GatewayRequest{
+		Method: http.MethodPost,
+		URL:    server.URL,
+	})
+	assert.True(buyte.IsGatewayUnavailable(err))
+	assert.False(buyte.IsGatewayNotAttempted(err), "The gateway received the request, so may have applied it.")
+	assert.Equal(int32(1), requests)
+}
+
+func TestDoPropagatesContext(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	transport, _ := TransportSetup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := transport.Do(ctx, gateway, &buyte.GatewayRequest{
+		Method:         http.MethodPost,
+		URL:            server.URL,
+		IdempotencyKey: "tok_xxx-payments",
+	})
+	assert.Error(t, err)
+	assert.False(t, buyte.IsGatewayUnavailable(err), "A cancelled request says nothing of the gateway.")
+	assert.Equal(t, int32(1), requests)
+	assert.False(t, transport.Breakers.Get(breakerKey(gateway)).IsOpen())
+}
+
+func TestHTTPClient(t *testing.T) {
+	assert := assert.New(t)
+	var requests int32
+	server := StandIn(1, 503, &requests)
+	defer server.Close()
+	transport, _ := TransportSetup()
+
+	client := HTTPClient(&buyte.Gateway{
+		Type:      buyte.STRIPE,
+		Transport: transport,
+	})
+	req, _ := http.NewRequest(http.MethodPost, server.URL, nil)
+	req.Header.Set(IdempotencyKeyHeader, "tok_xxx-charge")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(200, resp.StatusCode)
+	assert.Equal("tok_xxx-charge", resp.Header.Get(IdempotencyKeyHeader))
+	assert.Equal(int32(2), requests)
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	assert := assert.New(t)
+	now := time.Now()
+	breaker := NewBreaker(1, time.Second)
+	breaker.now = func() time.Time { return now }
+
+	breaker.Failure()
+	assert.False(breaker.Allow())
+
+	now = now.Add(2 * time.Second)
+	assert.True(breaker.Allow(), "A trial request is allowed after the cooldown.")
+	assert.False(breaker.Allow(), "Only one trial request is allowed at a time.")
+	breaker.Success()
+	assert.True(breaker.Allow())
+}
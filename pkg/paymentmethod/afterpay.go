@@ -0,0 +1,91 @@
+package paymentmethod
+
+import (
+	"context"
+	"io"
+
+	"github.com/go-chi/render"
+	"github.com/pkg/errors"
+
+	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/afterpay"
+	"github.com/rsoury/buyte/pkg/util"
+)
+
+// AfterpayCheckouts gets the checkouts created through an Afterpay connection. ie. *afterpay.Gateway of pkg/paymentgateway
+type AfterpayCheckouts interface {
+	GetCheckout(token string) (*afterpay.Checkout, error)
+}
+
+// Afterpay handles Afterpay payments. Checkouts are created by Buyte, confirmed by the customer on Afterpay, and captured through the checkout's Afterpay connection once charged.
+type Afterpay struct {
+	// Checkouts obtains the Afterpay connection a checkout's Afterpay checkouts are created with.
+	Checkouts func(ctx context.Context, checkoutId string, currency string, amount int) (AfterpayCheckouts, error)
+}
+
+func (h *Afterpay) Name() string {
+	return buyte.AFTERPAY
+}
+
+func (h *Afterpay) Path() string {
+	return "afterpay"
+}
+
+// The result is the query Afterpay returned the customer with. The checkout itself is read from Afterpay, so the consumer and amount cannot be forged by the widget.
+func (h *Afterpay) DecodeAuthorization(ctx context.Context, body io.Reader) (*Authorization, error) {
+	response := &buyte.AfterpayAuthorizedPaymentResponse{}
+	if err := render.DecodeJSON(body, response); err != nil {
+		return nil, err
+	}
+	if response.Result == nil || response.Result.OrderToken == "" {
+		return nil, errors.New("Result with an orderToken is required")
+	}
+	if response.Result.Status != afterpay.StatusSuccess {
+		return nil, errors.Errorf("Afterpay checkout was not confirmed: %s", response.Result.Status)
+	}
+	checkouts, err := h.Checkouts(ctx, response.CheckoutId, response.Currency, response.Amount)
+	if err != nil {
+		return nil, err
+	}
+	checkout, err := checkouts.GetCheckout(response.Result.OrderToken)
+	if err != nil {
+		return nil, err
+	}
+	if !checkout.HasAmount(util.FormatAmount(response.Amount, response.Currency), response.Currency) {
+		return nil, errors.Errorf("Afterpay checkout %s is not for the amount authorized", checkout.Token)
+	}
+	return &Authorization{
+		Response: &response.AuthorizedPaymentResponse,
+		Contacts: buyte.NewAfterpayPaymentContacts(checkout),
+		Input:    buyte.NewAfterpayPaymentTokenInput(response, checkout),
+	}, nil
+}
+
+func (h *Afterpay) Decode(paymentToken *buyte.PaymentToken) (interface{}, error) {
+	return paymentToken.Afterpay()
+}
+
+func (h *Afterpay) Customer(paymentToken *buyte.PaymentToken) (*buyte.Customer, error) {
+	afterpayPaymentToken, err := paymentToken.Afterpay()
+	if err != nil {
+		return nil, err
+	}
+	return buyte.NewAfterpayCustomer(afterpayPaymentToken.Response), nil
+}
+
+// Afterpay payments are not made with a card the merchant may route on.
+func (h *Afterpay) CardNetwork(paymentToken *buyte.PaymentToken) (string, error) {
+	if _, err := paymentToken.Afterpay(); err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+// The checkout is captured by its token. Afterpay checkouts are only routed to Afterpay connections, which are always passthrough.
+func (h *Afterpay) GatewayToken(ctx context.Context, paymentToken *buyte.PaymentToken, passthrough bool) (*buyte.NetworkToken, string, error) {
+	afterpayPaymentToken, err := paymentToken.Afterpay()
+	if err != nil {
+		return nil, "", err
+	}
+	return nil, afterpayPaymentToken.Response.Token, nil
+}
@@ -0,0 +1,101 @@
+package paymentmethod
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/pkg/errors"
+
+	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/applepaymerchant"
+	"github.com/rsoury/buyte/pkg/applepaytoken"
+)
+
+// ApplePay handles Apple Pay payments. Payment data is decrypted with the processing certificates of the checkout's Apple Pay identity.
+type ApplePay struct {
+	Verifier *applepaytoken.Verifier
+	// Identity obtains the Apple Pay identity of a checkout.
+	Identity func(ctx context.Context, checkoutId string) (*applepaymerchant.Identity, error)
+}
+
+func (h *ApplePay) Name() string {
+	return buyte.APPLE_PAY
+}
+
+func (h *ApplePay) Path() string {
+	return "applepay"
+}
+
+func (h *ApplePay) DecodeAuthorization(ctx context.Context, body io.Reader) (*Authorization, error) {
+	response := &buyte.ApplePayAuthorizedPaymentResponse{}
+	if err := render.DecodeJSON(body, response); err != nil {
+		return nil, err
+	}
+	if response.Result == nil {
+		return nil, errors.New("Result is required")
+	}
+	input, err := buyte.NewApplePayPaymentTokenInput(response)
+	if err != nil {
+		return nil, err
+	}
+	return &Authorization{
+		Response: &response.AuthorizedPaymentResponse,
+		Contacts: buyte.NewApplePayPaymentContacts(response.Result),
+		Input:    input,
+	}, nil
+}
+
+func (h *ApplePay) Decode(paymentToken *buyte.PaymentToken) (interface{}, error) {
+	return paymentToken.ApplePay()
+}
+
+func (h *ApplePay) Customer(paymentToken *buyte.PaymentToken) (*buyte.Customer, error) {
+	applePayPaymentToken, err := paymentToken.ApplePay()
+	if err != nil {
+		return nil, err
+	}
+	return buyte.NewApplePayCustomer(applePayPaymentToken.Response), nil
+}
+
+func (h *ApplePay) CardNetwork(paymentToken *buyte.PaymentToken) (string, error) {
+	applePayPaymentToken, err := paymentToken.ApplePay()
+	if err != nil {
+		return "", err
+	}
+	return applePayPaymentToken.Response.Token.PaymentMethod.Network, nil
+}
+
+func (h *ApplePay) GatewayToken(ctx context.Context, paymentToken *buyte.PaymentToken, passthrough bool) (*buyte.NetworkToken, string, error) {
+	applePayPaymentToken, err := paymentToken.ApplePay()
+	if err != nil {
+		return nil, "", err
+	}
+	if passthrough {
+		if paymentToken.GatewayToken != "" {
+			return nil, paymentToken.GatewayToken, nil
+		}
+		// Gateway decrypts the Apple Pay payment data itself.
+		paymentData, err := json.Marshal(applePayPaymentToken.Response.Token.PaymentData)
+		if err != nil {
+			return nil, "", err
+		}
+		return nil, string(paymentData), nil
+	}
+	// Verify the token was signed by Apple before decrypting it.
+	if err := h.Verifier.Verify(&applePayPaymentToken.Response.Token, time.Now()); err != nil {
+		return nil, "", err
+	}
+	// Decrypt Apple Pay Token with the processing certificates of the checkout's Apple Pay identity
+	identity, err := h.Identity(ctx, paymentToken.Checkout.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	networkToken, err := identity.DecryptResponse(applePayPaymentToken.Response)
+	if err != nil {
+		return nil, "", err
+	}
+	return networkToken, "", nil
+}
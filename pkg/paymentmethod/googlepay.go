@@ -0,0 +1,85 @@
+package paymentmethod
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/pkg/errors"
+
+	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/googlepay"
+)
+
+// GooglePay handles Google Pay payments. Tokens are charged natively, unless tokenized DIRECT for Buyte's own merchant keys.
+type GooglePay struct {
+	Decryptor *googlepay.Decryptor
+}
+
+func (h *GooglePay) Name() string {
+	return buyte.GOOGLE_PAY
+}
+
+func (h *GooglePay) Path() string {
+	return "googlepay"
+}
+
+func (h *GooglePay) DecodeAuthorization(ctx context.Context, body io.Reader) (*Authorization, error) {
+	response := &buyte.GooglePayAuthorizedPaymentResponse{}
+	if err := render.DecodeJSON(body, response); err != nil {
+		return nil, err
+	}
+	if response.Result == nil {
+		return nil, errors.New("Result is required")
+	}
+	return &Authorization{
+		Response: &response.AuthorizedPaymentResponse,
+		Contacts: buyte.NewGooglePayPaymentContacts(response.Result),
+		Input:    buyte.NewGooglePayPaymentTokenInput(response),
+	}, nil
+}
+
+func (h *GooglePay) Decode(paymentToken *buyte.PaymentToken) (interface{}, error) {
+	return paymentToken.GooglePay()
+}
+
+func (h *GooglePay) Customer(paymentToken *buyte.PaymentToken) (*buyte.Customer, error) {
+	googlePayPaymentToken, err := paymentToken.GooglePay()
+	if err != nil {
+		return nil, err
+	}
+	return buyte.NewGooglePayCustomer(googlePayPaymentToken.Response), nil
+}
+
+func (h *GooglePay) CardNetwork(paymentToken *buyte.PaymentToken) (string, error) {
+	googlePayPaymentToken, err := paymentToken.GooglePay()
+	if err != nil {
+		return "", err
+	}
+	return googlePayPaymentToken.Response.PaymentMethodData.Info.CardNetwork, nil
+}
+
+func (h *GooglePay) GatewayToken(ctx context.Context, paymentToken *buyte.PaymentToken, passthrough bool) (*buyte.NetworkToken, string, error) {
+	googlePayPaymentToken, err := paymentToken.GooglePay()
+	if err != nil {
+		return nil, "", err
+	}
+	if passthrough && paymentToken.GatewayToken != "" {
+		return nil, paymentToken.GatewayToken, nil
+	}
+	tokenizationData := googlePayPaymentToken.Response.PaymentMethodData.TokenizationData
+	if tokenizationData.Type != googlepay.TokenizationTypeDirect {
+		return nil, tokenizationData.Token, nil
+	}
+	// DIRECT tokens are encrypted for Buyte's own Google merchant keys, so are charged as network tokens.
+	paymentData, err := h.Decryptor.Decrypt(tokenizationData.Token, time.Now())
+	if err != nil {
+		return nil, "", err
+	}
+	networkToken, err := buyte.NewGooglePayNetworkToken(paymentData)
+	if err != nil {
+		return nil, "", err
+	}
+	return networkToken, "", nil
+}
@@ -0,0 +1,90 @@
+// Package paymentmethod handles each wallet, and PayPal, payment method Buyte accepts.
+// Handlers read the authorized payments widgets post to the public process endpoints, and obtain the tokens gateways charge them with.
+package paymentmethod
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+
+	"github.com/rsoury/buyte/buyte"
+)
+
+// Handler handles a wallet payment method, from the authorized payment to the token a gateway charges.
+type Handler interface {
+	// Name of the payment method, as added with `payments add` and held by payment tokens. ie. Apple Pay
+	Name() string
+	// Path of the payment method's public endpoints. ie. applepay for /public/applepay/process
+	Path() string
+	// DecodeAuthorization reads the authorized payment a widget posts to the process endpoint.
+	DecodeAuthorization(ctx context.Context, body io.Reader) (*Authorization, error)
+	// Decode reads the wallet payment stored as the payment token's value. ie. *buyte.ApplePayPaymentToken
+	Decode(paymentToken *buyte.PaymentToken) (interface{}, error)
+	// Customer reads the customer's contact details returned with the payment.
+	Customer(paymentToken *buyte.PaymentToken) (*buyte.Customer, error)
+	// CardNetwork of the payment. ie. Visa, MasterCard
+	CardNetwork(paymentToken *buyte.PaymentToken) (string, error)
+	// GatewayToken obtains either the network token Buyte decrypted, or the native token a gateway decrypts itself.
+	// passthrough is whether the gateway decrypts the payment method's payment data itself. See paymentgateway.PassthroughProvider
+	GatewayToken(ctx context.Context, paymentToken *buyte.PaymentToken, passthrough bool) (*buyte.NetworkToken, string, error)
+}
+
+// Authorization is an authorized payment posted to a process endpoint.
+type Authorization struct {
+	Response *buyte.AuthorizedPaymentResponse
+	// Card network and contact details, checked against the checkout's requirements.
+	Contacts *buyte.PaymentContacts
+	// Input the payment token is created with.
+	Input *buyte.CreatePaymentTokenInput
+}
+
+var ErrUnknownPaymentMethod = errors.New("Payment method not supported")
+
+// Registry holds the handler of each payment method, in the order registered.
+type Registry struct {
+	handlers []Handler
+}
+
+func NewRegistry(handlers ...Handler) (*Registry, error) {
+	registry := &Registry{}
+	for _, handler := range handlers {
+		if err := registry.Register(handler); err != nil {
+			return nil, err
+		}
+	}
+	return registry, nil
+}
+
+// Register adds a payment method's handler. Names and paths must be unique.
+func (r *Registry) Register(handler Handler) error {
+	for _, registered := range r.handlers {
+		if registered.Name() == handler.Name() || registered.Path() == handler.Path() {
+			return errors.Errorf("Payment method %s is already registered", handler.Name())
+		}
+	}
+	r.handlers = append(r.handlers, handler)
+	return nil
+}
+
+func (r *Registry) Handlers() []Handler {
+	return r.handlers
+}
+
+// Get the handler of a payment method by name.
+func (r *Registry) Get(name string) (Handler, error) {
+	for _, handler := range r.handlers {
+		if handler.Name() == name {
+			return handler, nil
+		}
+	}
+	return nil, errors.Wrap(ErrUnknownPaymentMethod, name)
+}
+
+// ForPaymentToken gets the handler of the payment token's payment method.
+func (r *Registry) ForPaymentToken(paymentToken *buyte.PaymentToken) (Handler, error) {
+	if paymentToken.PaymentMethod == nil || paymentToken.PaymentMethod.Name == "" {
+		return nil, errors.New("PaymentMethod not present")
+	}
+	return r.Get(paymentToken.PaymentMethod.Name)
+}
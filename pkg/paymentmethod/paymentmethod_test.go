@@ -0,0 +1,324 @@
+package paymentmethod
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/afterpay"
+	"github.com/rsoury/buyte/pkg/paypal"
+	"github.com/rsoury/buyte/pkg/samsungpay"
+)
+
+const applePayValue = `{
+	"shippingContact": {
+		"givenName": "Jane",
+		"familyName": "Citizen",
+		"emailAddress": "jane@example.com",
+		"addressLines": ["1 George St"],
+		"countryCode": "AU"
+	},
+	"token": {
+		"paymentMethod": { "network": "MasterCard" },
+		"paymentData": { "version": "EC_v1", "data": "ZGF0YQ==" }
+	}
+}`
+
+const googlePayValue = `{
+	"email": "jane@example.com",
+	"shippingAddress": { "name": "Jane Citizen", "phoneNumber": "+61400000000", "countryCode": "AU" },
+	"paymentMethodData": {
+		"tokenizationData": { "type": "PAYMENT_GATEWAY", "token": "tok_visa" },
+		"info": { "cardNetwork": "VISA" }
+	}
+}`
+
+const samsungPayValue = `{
+	"paymentCredential": {
+		"method": "3DS",
+		"card_brand": "mastercard",
+		"card_last4digits": "7840",
+		"3DS": { "type": "S", "version": "100", "data": "aGVhZGVy.a2V5.aXY.Y2lwaGVydGV4dA.dGFn" }
+	},
+	"email": "jane@example.com",
+	"shippingAddress": { "addressee": "Jane Citizen", "addressLine1": "1 George St", "city": "Sydney", "countryCode": "AU" }
+}`
+
+const payPalValue = `{
+	"id": "5O190127TN364715T",
+	"status": "APPROVED",
+	"payer": {
+		"email_address": "jane@example.com",
+		"name": { "given_name": "Jane", "surname": "Citizen" }
+	},
+	"purchase_units": [{
+		"amount": { "currency_code": "AUD", "value": "10.00" },
+		"shipping": {
+			"name": { "full_name": "Jane Citizen" },
+			"address": { "address_line_1": "1 George St", "admin_area_2": "Sydney", "country_code": "AU" }
+		}
+	}]
+}`
+
+const afterpayValue = `{
+	"token": "002.xxx",
+	"amount": { "amount": "10.00", "currency": "AUD" },
+	"consumer": { "givenNames": "Jane", "surname": "Citizen", "email": "jane@example.com" },
+	"shipping": { "name": "Jane Citizen", "line1": "1 George St", "area1": "Sydney", "countryCode": "AU" }
+}`
+
+// Stands in for a checkout's Afterpay connection.
+type afterpayCheckouts map[string]string
+
+func (c afterpayCheckouts) GetCheckout(token string) (*afterpay.Checkout, error) {
+	value, ok := c[token]
+	if !ok {
+		return nil, errors.New("not_found")
+	}
+	checkout := &afterpay.Checkout{}
+	err := json.Unmarshal([]byte(value), checkout)
+	return checkout, err
+}
+
+// Stands in for a checkout's PayPal connection.
+type payPalOrders map[string]string
+
+func (o payPalOrders) GetOrder(orderId string) (*paypal.Order, error) {
+	value, ok := o[orderId]
+	if !ok {
+		return nil, errors.New("RESOURCE_NOT_FOUND")
+	}
+	order := &paypal.Order{}
+	err := json.Unmarshal([]byte(value), order)
+	return order, err
+}
+
+func paymentToken(name string, value string) *buyte.PaymentToken {
+	return &buyte.PaymentToken{
+		ID:            "token",
+		Value:         value,
+		PaymentMethod: &buyte.PaymentMethod{Name: name},
+		Checkout:      &buyte.PaymentTokenCheckout{},
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	assert := assert.New(t)
+	registry, err := NewRegistry(&ApplePay{}, &GooglePay{}, &SamsungPay{}, &PayPal{}, &Afterpay{})
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Len(registry.Handlers(), 5)
+
+	handler, err := registry.ForPaymentToken(paymentToken(buyte.GOOGLE_PAY, googlePayValue))
+	if assert.NoError(err) {
+		assert.Equal("googlepay", handler.Path())
+	}
+	_, err = registry.Get("Zip")
+	assert.Equal(ErrUnknownPaymentMethod, errors.Cause(err))
+	_, err = registry.ForPaymentToken(&buyte.PaymentToken{})
+	assert.Error(err)
+
+	assert.Error(registry.Register(&GooglePay{}))
+}
+
+func TestApplePay(t *testing.T) {
+	assert := assert.New(t)
+	handler := &ApplePay{}
+	token := paymentToken(buyte.APPLE_PAY, applePayValue)
+
+	network, err := handler.CardNetwork(token)
+	assert.NoError(err)
+	assert.Equal("MasterCard", network)
+
+	customer, err := handler.Customer(token)
+	if assert.NoError(err) {
+		assert.Equal("Jane Citizen", customer.Name)
+		assert.Equal("jane@example.com", customer.EmailAddress)
+	}
+
+	// Passthrough gateways are given the payment data to decrypt.
+	networkToken, nativeToken, err := handler.GatewayToken(context.Background(), token, true)
+	assert.NoError(err)
+	assert.Nil(networkToken)
+	assert.Contains(nativeToken, `"version":"EC_v1"`)
+
+	_, err = handler.Customer(paymentToken(buyte.GOOGLE_PAY, googlePayValue))
+	assert.Error(err)
+}
+
+func TestGooglePay(t *testing.T) {
+	assert := assert.New(t)
+	handler := &GooglePay{}
+	token := paymentToken(buyte.GOOGLE_PAY, googlePayValue)
+
+	network, err := handler.CardNetwork(token)
+	assert.NoError(err)
+	assert.Equal("VISA", network)
+
+	customer, err := handler.Customer(token)
+	if assert.NoError(err) {
+		assert.Equal("Jane", customer.GivenName)
+		assert.Equal("+61400000000", customer.PhoneNumber)
+	}
+
+	// Gateway tokenized payments are charged natively, whether or not the gateway is passthrough.
+	_, nativeToken, err := handler.GatewayToken(context.Background(), token, false)
+	assert.NoError(err)
+	assert.Equal("tok_visa", nativeToken)
+
+	token.GatewayToken = "cnon:wallet"
+	_, nativeToken, err = handler.GatewayToken(context.Background(), token, true)
+	assert.NoError(err)
+	assert.Equal("cnon:wallet", nativeToken)
+}
+
+func TestSamsungPay(t *testing.T) {
+	assert := assert.New(t)
+	handler := &SamsungPay{Decryptor: samsungpay.NewDecryptor(nil)}
+	token := paymentToken(buyte.SAMSUNG_PAY, samsungPayValue)
+
+	network, err := handler.CardNetwork(token)
+	assert.NoError(err)
+	assert.Equal("mastercard", network)
+
+	customer, err := handler.Customer(token)
+	if assert.NoError(err) {
+		assert.Equal("Citizen", customer.FamilyName)
+		assert.Equal("jane@example.com", customer.EmailAddress)
+	}
+
+	// Credentials are only charged as network tokens, once decrypted with a merchant key.
+	_, _, err = handler.GatewayToken(context.Background(), token, false)
+	assert.True(samsungpay.IsDecryptionError(err))
+}
+
+func TestPayPal(t *testing.T) {
+	assert := assert.New(t)
+	handler := &PayPal{}
+	token := paymentToken(buyte.PAYPAL, payPalValue)
+
+	network, err := handler.CardNetwork(token)
+	assert.NoError(err)
+	assert.Empty(network)
+
+	customer, err := handler.Customer(token)
+	if assert.NoError(err) {
+		assert.Equal("Jane Citizen", customer.Name)
+		assert.Equal("jane@example.com", customer.EmailAddress)
+		assert.Equal("Sydney", customer.ShippingAddress.Locality)
+	}
+
+	// Orders are captured by their ID.
+	networkToken, nativeToken, err := handler.GatewayToken(context.Background(), token, true)
+	assert.NoError(err)
+	assert.Nil(networkToken)
+	assert.Equal("5O190127TN364715T", nativeToken)
+}
+
+func TestDecodePayPalAuthorization(t *testing.T) {
+	assert := assert.New(t)
+	created := strings.Replace(payPalValue, "APPROVED", "CREATED", 1)
+	handler := &PayPal{
+		Orders: func(ctx context.Context, checkoutId string, currency string, amount int) (PayPalOrders, error) {
+			assert.Equal("checkout", checkoutId)
+			return payPalOrders{"approved": payPalValue, "created": created}, nil
+		},
+	}
+	body := func(orderId string, amount string) *strings.Reader {
+		return strings.NewReader(`{
+			"checkoutId": "checkout",
+			"paymentMethodId": "paypal",
+			"amount": ` + amount + `,
+			"currency": "aud",
+			"result": { "orderID": "` + orderId + `" }
+		}`)
+	}
+
+	authorization, err := handler.DecodeAuthorization(context.Background(), body("approved", "1000"))
+	if assert.NoError(err) {
+		assert.Equal("jane@example.com", authorization.Contacts.EmailAddress)
+		assert.Empty(authorization.Contacts.CardNetwork)
+		assert.Equal("5O190127TN364715T", authorization.Input.Value.(*paypal.Order).ID)
+	}
+
+	_, err = handler.DecodeAuthorization(context.Background(), body("approved", "2000"))
+	assert.Error(err, "The order must be for the amount authorized.")
+	_, err = handler.DecodeAuthorization(context.Background(), body("created", "1000"))
+	assert.Error(err, "The order must be approved.")
+	_, err = handler.DecodeAuthorization(context.Background(), body("unknown", "1000"))
+	assert.Error(err)
+}
+
+func TestAfterpay(t *testing.T) {
+	assert := assert.New(t)
+	handler := &Afterpay{}
+	token := paymentToken(buyte.AFTERPAY, afterpayValue)
+
+	customer, err := handler.Customer(token)
+	if assert.NoError(err) {
+		assert.Equal("Jane", customer.GivenName)
+		assert.Equal("Sydney", customer.ShippingAddress.Locality)
+	}
+
+	// Checkouts are captured by their token.
+	_, nativeToken, err := handler.GatewayToken(context.Background(), token, true)
+	assert.NoError(err)
+	assert.Equal("002.xxx", nativeToken)
+
+	_, err = handler.CardNetwork(paymentToken(buyte.PAYPAL, payPalValue))
+	assert.Error(err)
+}
+
+func TestDecodeAfterpayAuthorization(t *testing.T) {
+	assert := assert.New(t)
+	handler := &Afterpay{
+		Checkouts: func(ctx context.Context, checkoutId string, currency string, amount int) (AfterpayCheckouts, error) {
+			return afterpayCheckouts{"002.xxx": afterpayValue}, nil
+		},
+	}
+	body := func(status string, amount string) *strings.Reader {
+		return strings.NewReader(`{
+			"checkoutId": "checkout",
+			"paymentMethodId": "afterpay",
+			"amount": ` + amount + `,
+			"currency": "aud",
+			"result": { "orderToken": "002.xxx", "status": "` + status + `" }
+		}`)
+	}
+
+	authorization, err := handler.DecodeAuthorization(context.Background(), body(afterpay.StatusSuccess, "1000"))
+	if assert.NoError(err) {
+		assert.Equal("Jane Citizen", authorization.Contacts.BillingName)
+		assert.Equal("002.xxx", authorization.Input.Value.(*afterpay.Checkout).Token)
+	}
+
+	_, err = handler.DecodeAuthorization(context.Background(), body(afterpay.StatusCancelled, "1000"))
+	assert.Error(err, "Cancelled checkouts are not payments.")
+	_, err = handler.DecodeAuthorization(context.Background(), body(afterpay.StatusSuccess, "900"))
+	assert.Error(err, "The checkout must be for the amount authorized.")
+}
+
+func TestDecodeAuthorization(t *testing.T) {
+	assert := assert.New(t)
+	authorization, err := (&GooglePay{}).DecodeAuthorization(context.Background(), strings.NewReader(`{
+		"checkoutId": "checkout",
+		"paymentMethodId": "googlepay",
+		"amount": 1000,
+		"currency": "aud",
+		"result": `+googlePayValue+`
+	}`))
+	if assert.NoError(err) {
+		assert.Equal("checkout", authorization.Response.CheckoutId)
+		assert.Equal("VISA", authorization.Contacts.CardNetwork)
+		assert.Equal(1000, authorization.Input.Amount)
+	}
+
+	_, err = (&ApplePay{}).DecodeAuthorization(context.Background(), strings.NewReader(`{"checkoutId": "checkout"}`))
+	assert.Error(err)
+}
@@ -0,0 +1,91 @@
+package paymentmethod
+
+import (
+	"context"
+	"io"
+
+	"github.com/go-chi/render"
+	"github.com/pkg/errors"
+
+	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/paypal"
+	"github.com/rsoury/buyte/pkg/util"
+)
+
+// PayPalOrders gets the orders created through a PayPal connection. ie. *paypal.Gateway of pkg/paymentgateway
+type PayPalOrders interface {
+	GetOrder(orderId string) (*paypal.Order, error)
+}
+
+// PayPal handles PayPal payments. Orders are created by Buyte, approved with PayPal's buttons, and captured through the checkout's PayPal connection once charged.
+type PayPal struct {
+	// Orders obtains the PayPal connection a checkout's orders are created with.
+	Orders func(ctx context.Context, checkoutId string, currency string, amount int) (PayPalOrders, error)
+}
+
+func (h *PayPal) Name() string {
+	return buyte.PAYPAL
+}
+
+func (h *PayPal) Path() string {
+	return "paypal"
+}
+
+// The approval only names the order. The order itself is read from PayPal, so the payer and amount cannot be forged by the widget.
+func (h *PayPal) DecodeAuthorization(ctx context.Context, body io.Reader) (*Authorization, error) {
+	response := &buyte.PayPalAuthorizedPaymentResponse{}
+	if err := render.DecodeJSON(body, response); err != nil {
+		return nil, err
+	}
+	if response.Result == nil || response.Result.OrderID == "" {
+		return nil, errors.New("Result with an orderID is required")
+	}
+	orders, err := h.Orders(ctx, response.CheckoutId, response.Currency, response.Amount)
+	if err != nil {
+		return nil, err
+	}
+	order, err := orders.GetOrder(response.Result.OrderID)
+	if err != nil {
+		return nil, err
+	}
+	if order.Status != paypal.StatusApproved {
+		return nil, errors.Errorf("PayPal order %s has not been approved: %s", order.ID, order.Status)
+	}
+	if !order.HasAmount(util.FormatAmount(response.Amount, response.Currency), response.Currency) {
+		return nil, errors.Errorf("PayPal order %s is not for the amount authorized", order.ID)
+	}
+	return &Authorization{
+		Response: &response.AuthorizedPaymentResponse,
+		Contacts: buyte.NewPayPalPaymentContacts(order),
+		Input:    buyte.NewPayPalPaymentTokenInput(response, order),
+	}, nil
+}
+
+func (h *PayPal) Decode(paymentToken *buyte.PaymentToken) (interface{}, error) {
+	return paymentToken.PayPal()
+}
+
+func (h *PayPal) Customer(paymentToken *buyte.PaymentToken) (*buyte.Customer, error) {
+	payPalPaymentToken, err := paymentToken.PayPal()
+	if err != nil {
+		return nil, err
+	}
+	return buyte.NewPayPalCustomer(payPalPaymentToken.Response), nil
+}
+
+// PayPal payments are not made with a card.
+func (h *PayPal) CardNetwork(paymentToken *buyte.PaymentToken) (string, error) {
+	if _, err := paymentToken.PayPal(); err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+// The order is captured by its ID. PayPal orders are only routed to PayPal connections, which are always passthrough.
+func (h *PayPal) GatewayToken(ctx context.Context, paymentToken *buyte.PaymentToken, passthrough bool) (*buyte.NetworkToken, string, error) {
+	payPalPaymentToken, err := paymentToken.PayPal()
+	if err != nil {
+		return nil, "", err
+	}
+	return nil, payPalPaymentToken.Response.ID, nil
+}
@@ -0,0 +1,79 @@
+package paymentmethod
+
+import (
+	"context"
+	"io"
+
+	"github.com/go-chi/render"
+	"github.com/pkg/errors"
+
+	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/samsungpay"
+)
+
+// SamsungPay handles Samsung Pay payments. 3DS credentials are decrypted with Buyte's Samsung Pay merchant keys, and charged as network tokens.
+type SamsungPay struct {
+	Decryptor *samsungpay.Decryptor
+}
+
+func (h *SamsungPay) Name() string {
+	return buyte.SAMSUNG_PAY
+}
+
+func (h *SamsungPay) Path() string {
+	return "samsungpay"
+}
+
+func (h *SamsungPay) DecodeAuthorization(ctx context.Context, body io.Reader) (*Authorization, error) {
+	response := &buyte.SamsungPayAuthorizedPaymentResponse{}
+	if err := render.DecodeJSON(body, response); err != nil {
+		return nil, err
+	}
+	if response.Result == nil {
+		return nil, errors.New("Result is required")
+	}
+	return &Authorization{
+		Response: &response.AuthorizedPaymentResponse,
+		Contacts: buyte.NewSamsungPayPaymentContacts(response.Result),
+		Input:    buyte.NewSamsungPayPaymentTokenInput(response),
+	}, nil
+}
+
+func (h *SamsungPay) Decode(paymentToken *buyte.PaymentToken) (interface{}, error) {
+	return paymentToken.SamsungPay()
+}
+
+func (h *SamsungPay) Customer(paymentToken *buyte.PaymentToken) (*buyte.Customer, error) {
+	samsungPayPaymentToken, err := paymentToken.SamsungPay()
+	if err != nil {
+		return nil, err
+	}
+	return buyte.NewSamsungPayCustomer(samsungPayPaymentToken.Response), nil
+}
+
+func (h *SamsungPay) CardNetwork(paymentToken *buyte.PaymentToken) (string, error) {
+	samsungPayPaymentToken, err := paymentToken.SamsungPay()
+	if err != nil {
+		return "", err
+	}
+	return samsungPayPaymentToken.Response.PaymentCredential.CardBrand, nil
+}
+
+func (h *SamsungPay) GatewayToken(ctx context.Context, paymentToken *buyte.PaymentToken, passthrough bool) (*buyte.NetworkToken, string, error) {
+	samsungPayPaymentToken, err := paymentToken.SamsungPay()
+	if err != nil {
+		return nil, "", err
+	}
+	if passthrough && paymentToken.GatewayToken != "" {
+		return nil, paymentToken.GatewayToken, nil
+	}
+	payload, err := h.Decryptor.Decrypt(&samsungPayPaymentToken.Response.PaymentCredential)
+	if err != nil {
+		return nil, "", err
+	}
+	networkToken, err := buyte.NewSamsungPayNetworkToken(payload)
+	if err != nil {
+		return nil, "", err
+	}
+	return networkToken, "", nil
+}
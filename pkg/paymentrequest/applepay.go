@@ -0,0 +1,96 @@
+package paymentrequest
+
+import (
+	"strings"
+
+	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/util"
+)
+
+// ApplePayPaymentRequest is an ApplePayPaymentRequest, as passed to ApplePaySession.
+// See https://developer.apple.com/documentation/apple_pay_on_the_web/applepaypaymentrequest
+type ApplePayPaymentRequest struct {
+	CountryCode                   string                   `json:"countryCode"`
+	CurrencyCode                  string                   `json:"currencyCode"`
+	MerchantCapabilities          []string                 `json:"merchantCapabilities"`
+	SupportedNetworks             []string                 `json:"supportedNetworks"`
+	RequiredBillingContactFields  []string                 `json:"requiredBillingContactFields,omitempty"`
+	RequiredShippingContactFields []string                 `json:"requiredShippingContactFields,omitempty"`
+	ShippingMethods               []ApplePayShippingMethod `json:"shippingMethods,omitempty"`
+	LineItems                     []ApplePayLineItem       `json:"lineItems,omitempty"`
+	Total                         ApplePayLineItem         `json:"total"`
+}
+
+type ApplePayLineItem struct {
+	Label  string `json:"label"`
+	Amount string `json:"amount"`
+	Type   string `json:"type,omitempty"`
+}
+
+type ApplePayShippingMethod struct {
+	Identifier string `json:"identifier"`
+	Label      string `json:"label"`
+	Detail     string `json:"detail,omitempty"`
+	Amount     string `json:"amount"`
+}
+
+// ApplePay builds the Apple Pay payment request for a checkout.
+// The first shipping method available for the order is selected, and included in the total.
+func ApplePay(checkout *buyte.FullCheckout, input *Input) *ApplePayPaymentRequest {
+	currency := checkout.Currency
+	request := &ApplePayPaymentRequest{
+		CountryCode:          strings.ToUpper(checkout.Country),
+		CurrencyCode:         strings.ToUpper(currency),
+		MerchantCapabilities: []string{"supports3DS"},
+		SupportedNetworks:    networks(checkout),
+	}
+	required := requirements(checkout)
+	request.RequiredBillingContactFields = required.RequiredBillingFields
+	request.RequiredShippingContactFields = append([]string{}, required.RequiredShippingFields...)
+	// Apple Pay only returns the email address and phone number with the shipping contact.
+	if required.RequireEmail {
+		request.RequiredShippingContactFields = append(request.RequiredShippingContactFields, "email")
+	}
+	if required.RequirePhone {
+		request.RequiredShippingContactFields = append(request.RequiredShippingContactFields, "phone")
+	}
+	// EMV payment data is only issued to merchants in China, and only Adyen accepts it.
+	if strings.EqualFold(checkout.Country, "CN") && checkout.GatewayProvider.Type == buyte.ADYEN {
+		request.MerchantCapabilities = append(request.MerchantCapabilities, "supportsEMV")
+	}
+
+	for _, item := range input.Items {
+		request.LineItems = append(request.LineItems, ApplePayLineItem{
+			Label:  item.Label,
+			Amount: util.FormatAmount(item.Amount, currency),
+			Type:   "final",
+		})
+	}
+	total := input.Amount
+	methods := checkout.ShippingMethods
+	if len(methods) > 0 {
+		if !contains(request.RequiredShippingContactFields, buyte.CONTACT_FIELD_POSTAL_ADDRESS) {
+			request.RequiredShippingContactFields = append(request.RequiredShippingContactFields, buyte.CONTACT_FIELD_POSTAL_ADDRESS)
+		}
+		for _, method := range methods {
+			request.ShippingMethods = append(request.ShippingMethods, ApplePayShippingMethod{
+				Identifier: method.ID,
+				Label:      method.Name,
+				Detail:     shippingDetail(method),
+				Amount:     util.FormatAmount(method.Rate, currency),
+			})
+		}
+		request.LineItems = append(request.LineItems, ApplePayLineItem{
+			Label:  methods[0].Name,
+			Amount: util.FormatAmount(methods[0].Rate, currency),
+			Type:   "final",
+		})
+		total += methods[0].Rate
+	}
+	request.Total = ApplePayLineItem{
+		Label:  checkout.Merchant.StoreName,
+		Amount: util.FormatAmount(total, currency),
+		Type:   "final",
+	}
+	return request
+}
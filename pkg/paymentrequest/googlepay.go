@@ -0,0 +1,225 @@
+package paymentrequest
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/googlepay"
+	"github.com/rsoury/buyte/pkg/util"
+)
+
+// GooglePayPaymentDataRequest is a PaymentDataRequest, as passed to PaymentsClient.loadPaymentData
+// See https://developers.google.com/pay/api/web/reference/request-objects#PaymentDataRequest
+type GooglePayPaymentDataRequest struct {
+	ApiVersion                int                                 `json:"apiVersion"`
+	ApiVersionMinor           int                                 `json:"apiVersionMinor"`
+	MerchantInfo              GooglePayMerchantInfo               `json:"merchantInfo"`
+	AllowedPaymentMethods     []GooglePayPaymentMethod            `json:"allowedPaymentMethods"`
+	TransactionInfo           GooglePayTransactionInfo            `json:"transactionInfo"`
+	EmailRequired             bool                                `json:"emailRequired"`
+	ShippingAddressRequired   bool                                `json:"shippingAddressRequired"`
+	ShippingAddressParameters *GooglePayShippingAddressParameters `json:"shippingAddressParameters,omitempty"`
+	ShippingOptionRequired    bool                                `json:"shippingOptionRequired"`
+	ShippingOptionParameters  *GooglePayShippingOptionParameters  `json:"shippingOptionParameters,omitempty"`
+}
+
+type GooglePayMerchantInfo struct {
+	MerchantID   string `json:"merchantId"`
+	MerchantName string `json:"merchantName"`
+}
+
+type GooglePayPaymentMethod struct {
+	Type                      string                             `json:"type"`
+	Parameters                GooglePayCardParameters            `json:"parameters"`
+	TokenizationSpecification GooglePayTokenizationSpecification `json:"tokenizationSpecification"`
+}
+
+type GooglePayCardParameters struct {
+	AllowedAuthMethods       []string                           `json:"allowedAuthMethods"`
+	AllowedCardNetworks      []string                           `json:"allowedCardNetworks"`
+	BillingAddressRequired   bool                               `json:"billingAddressRequired"`
+	BillingAddressParameters *GooglePayBillingAddressParameters `json:"billingAddressParameters,omitempty"`
+}
+
+type GooglePayBillingAddressParameters struct {
+	Format              string `json:"format"`
+	PhoneNumberRequired bool   `json:"phoneNumberRequired"`
+}
+
+type GooglePayTokenizationSpecification struct {
+	Type       string            `json:"type"`
+	Parameters map[string]string `json:"parameters"`
+}
+
+type GooglePayTransactionInfo struct {
+	TotalPriceStatus string                 `json:"totalPriceStatus"`
+	TotalPrice       string                 `json:"totalPrice"`
+	TotalPriceLabel  string                 `json:"totalPriceLabel,omitempty"`
+	CurrencyCode     string                 `json:"currencyCode"`
+	CountryCode      string                 `json:"countryCode"`
+	DisplayItems     []GooglePayDisplayItem `json:"displayItems,omitempty"`
+}
+
+type GooglePayDisplayItem struct {
+	Label string `json:"label"`
+	Type  string `json:"type"`
+	Price string `json:"price"`
+}
+
+type GooglePayShippingAddressParameters struct {
+	PhoneNumberRequired bool `json:"phoneNumberRequired"`
+}
+
+type GooglePayShippingOptionParameters struct {
+	DefaultSelectedOptionID string                    `json:"defaultSelectedOptionId"`
+	ShippingOptions         []GooglePayShippingOption `json:"shippingOptions"`
+}
+
+type GooglePayShippingOption struct {
+	ID          string `json:"id"`
+	Label       string `json:"label"`
+	Description string `json:"description,omitempty"`
+}
+
+// Google Pay gateway identifiers. See https://developers.google.com/pay/api/web/guides/tutorial#tokenization
+var googlePayGateways = map[string]string{
+	buyte.STRIPE:      "stripe",
+	buyte.ADYEN:       "adyen",
+	buyte.BRAINTREE:   "braintree",
+	buyte.CHECKOUTCOM: "checkoutltd",
+}
+
+// GooglePay builds the Google Pay payment data request for a checkout's Google Pay option.
+// Tokens are produced for the checkout's gateway, unless the option is configured for DIRECT tokenization to Buyte.
+func GooglePay(checkout *buyte.FullCheckout, option *buyte.FullCheckoutOptionResponse, input *Input) (*GooglePayPaymentDataRequest, error) {
+	tokenization, err := tokenizationSpecification(checkout, option)
+	if err != nil {
+		return nil, err
+	}
+	authMethods := []string{googlepay.AuthMethodPANOnly, googlepay.AuthMethodCryptogram3DS}
+	if tokenization.Type == googlepay.TokenizationTypeDirect {
+		// Buyte only charges DIRECT tokens as network tokens. See buyte.NewGooglePayNetworkToken
+		authMethods = []string{googlepay.AuthMethodCryptogram3DS}
+	}
+
+	// Google Pay returns the name and phone number with an address, so requiring either requires the address.
+	required := requirements(checkout)
+	shippingRequired := len(required.RequiredShippingFields) > 0
+	billingRequired := len(required.RequiredBillingFields) > 0 || (required.RequirePhone && !shippingRequired)
+	var billingAddressParameters *GooglePayBillingAddressParameters
+	if billingRequired {
+		billingAddressParameters = &GooglePayBillingAddressParameters{Format: "MIN", PhoneNumberRequired: required.RequirePhone}
+		if contains(required.RequiredBillingFields, buyte.CONTACT_FIELD_POSTAL_ADDRESS) {
+			billingAddressParameters.Format = "FULL"
+		}
+	}
+
+	currency := checkout.Currency
+	request := &GooglePayPaymentDataRequest{
+		ApiVersion:      2,
+		ApiVersionMinor: 0,
+		MerchantInfo: GooglePayMerchantInfo{
+			MerchantID:   option.AdditionalData["merchantId"],
+			MerchantName: option.AdditionalData["merchantName"],
+		},
+		AllowedPaymentMethods: []GooglePayPaymentMethod{
+			{
+				Type: "CARD",
+				Parameters: GooglePayCardParameters{
+					AllowedAuthMethods:       authMethods,
+					AllowedCardNetworks:      googlePayNetworks(networks(checkout)),
+					BillingAddressRequired:   billingRequired,
+					BillingAddressParameters: billingAddressParameters,
+				},
+				TokenizationSpecification: *tokenization,
+			},
+		},
+		EmailRequired: required.RequireEmail,
+	}
+	if shippingRequired {
+		request.ShippingAddressRequired = true
+		request.ShippingAddressParameters = &GooglePayShippingAddressParameters{PhoneNumberRequired: required.RequirePhone}
+	}
+
+	var displayItems []GooglePayDisplayItem
+	for _, item := range input.Items {
+		displayItems = append(displayItems, GooglePayDisplayItem{
+			Label: item.Label,
+			Type:  "LINE_ITEM",
+			Price: util.FormatAmount(item.Amount, currency),
+		})
+	}
+	total := input.Amount
+	methods := checkout.ShippingMethods
+	if len(methods) > 0 {
+		request.ShippingAddressRequired = true
+		request.ShippingAddressParameters = &GooglePayShippingAddressParameters{PhoneNumberRequired: required.RequirePhone}
+		request.ShippingOptionRequired = true
+		request.ShippingOptionParameters = &GooglePayShippingOptionParameters{
+			DefaultSelectedOptionID: methods[0].ID,
+		}
+		for _, method := range methods {
+			request.ShippingOptionParameters.ShippingOptions = append(request.ShippingOptionParameters.ShippingOptions, GooglePayShippingOption{
+				ID:          method.ID,
+				Label:       util.FormatAmount(method.Rate, currency) + ": " + method.Name,
+				Description: shippingDetail(method),
+			})
+		}
+		displayItems = append(displayItems, GooglePayDisplayItem{
+			Label: methods[0].Name,
+			Type:  "LINE_ITEM",
+			Price: util.FormatAmount(methods[0].Rate, currency),
+		})
+		total += methods[0].Rate
+	}
+	request.TransactionInfo = GooglePayTransactionInfo{
+		TotalPriceStatus: "FINAL",
+		TotalPrice:       util.FormatAmount(total, currency),
+		TotalPriceLabel:  "Total",
+		CurrencyCode:     strings.ToUpper(currency),
+		CountryCode:      strings.ToUpper(checkout.Country),
+		DisplayItems:     displayItems,
+	}
+	return request, nil
+}
+
+func tokenizationSpecification(checkout *buyte.FullCheckout, option *buyte.FullCheckoutOptionResponse) (*GooglePayTokenizationSpecification, error) {
+	if option.AdditionalData["tokenizationType"] == googlepay.TokenizationTypeDirect {
+		return &GooglePayTokenizationSpecification{
+			Type: googlepay.TokenizationTypeDirect,
+			Parameters: map[string]string{
+				"protocolVersion": option.AdditionalData["protocolVersion"],
+				"publicKey":       option.AdditionalData["publicKey"],
+			},
+		}, nil
+	}
+
+	gateway := checkout.GatewayProvider
+	parameters := map[string]string{
+		"gateway": googlePayGateways[gateway.Type],
+	}
+	switch gateway.Type {
+	case buyte.STRIPE:
+		parameters["stripe:version"] = "2018-10-31"
+		parameters["stripe:publishableKey"] = gateway.PublicKey
+	case buyte.BRAINTREE:
+		// Widgets loading the Braintree client SDK add braintree:sdkVersion
+		parameters["braintree:apiVersion"] = "v1"
+		parameters["braintree:merchantId"] = gateway.AdditionalData["merchantId"]
+		parameters["braintree:clientKey"] = gateway.PublicKey
+	case buyte.ADYEN, buyte.CHECKOUTCOM:
+		// The public identifier of the checkout's account. The merchant account for Adyen, and the public key for Checkout.com
+		parameters["gatewayMerchantId"] = gateway.PublicKey
+	default:
+		return nil, ErrUnsupportedGateway
+	}
+	if gateway.PublicKey == "" {
+		return nil, errors.Errorf("Checkout's %s connection has no public key for Google Pay tokenization", gateway.Type)
+	}
+	return &GooglePayTokenizationSpecification{
+		Type:       googlepay.TokenizationTypeGateway,
+		Parameters: parameters,
+	}, nil
+}
@@ -0,0 +1,148 @@
+// Package paymentrequest builds the payment requests wallets are presented with, for a checkout.
+// Apple Pay's PaymentRequest and Google Pay's PaymentDataRequest depend on the checkout's gateway, so are built server side rather than by each widget.
+package paymentrequest
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/shipping"
+)
+
+// Input is the order a payment request is built for.
+type Input struct {
+	// Amount of the order before shipping, in the currency's minor unit. ie. cents
+	Amount int        `json:"amount"`
+	Items  []LineItem `json:"items,omitempty"`
+}
+
+type LineItem struct {
+	Label  string `json:"label"`
+	Amount int    `json:"amount"`
+	// Shipping rates may be priced by the number of items, and their weight in grams.
+	Quantity int `json:"quantity,omitempty"`
+	Weight   int `json:"weight,omitempty"`
+}
+
+// ShippingOrder is the order as shipped. See shipping.Order
+func (i *Input) ShippingOrder() *shipping.Order {
+	order := &shipping.Order{
+		Subtotal: i.Amount,
+	}
+	for _, item := range i.Items {
+		order.Items = append(order.Items, shipping.Item{
+			Quantity: item.Quantity,
+			Weight:   item.Weight,
+		})
+	}
+	return order
+}
+
+// Requests are the payment requests for each wallet the checkout offers.
+type Requests struct {
+	ApplePay  *ApplePayPaymentRequest      `json:"applePay,omitempty"`
+	GooglePay *GooglePayPaymentDataRequest `json:"googlePay,omitempty"`
+}
+
+// ErrUnsupportedGateway is returned for gateways that present wallets with their own client SDK. ie. Square
+var ErrUnsupportedGateway = errors.New("Checkout's gateway builds its own wallet payment requests")
+
+// Card networks each gateway accepts wallet payments for. Networks are named as Apple Pay names them.
+var gatewayNetworks = map[string][]string{
+	buyte.STRIPE:      {"visa", "masterCard", "amex", "discover", "jcb"},
+	buyte.ADYEN:       {"visa", "masterCard", "amex", "discover", "jcb", "maestro", "chinaUnionPay"},
+	buyte.BRAINTREE:   {"visa", "masterCard", "amex", "discover"},
+	buyte.CHECKOUTCOM: {"visa", "masterCard", "amex", "discover", "jcb"},
+}
+
+// Build builds the payment request of each wallet option of a checkout.
+// The checkout's shipping methods are expected to be those available for the order, priced for it. See buyte.FullCheckoutOptions
+func Build(checkout *buyte.FullCheckout, input *Input) (*Requests, error) {
+	if input.Amount <= 0 {
+		return nil, errors.New("Amount must be greater than 0")
+	}
+	if _, ok := gatewayNetworks[checkout.GatewayProvider.Type]; !ok {
+		return nil, ErrUnsupportedGateway
+	}
+	if len(networks(checkout)) == 0 {
+		return nil, errors.New("Checkout allows no card networks its gateway accepts")
+	}
+	requests := &Requests{}
+	for _, option := range checkout.Options {
+		switch option.Name {
+		case buyte.APPLE_PAY:
+			requests.ApplePay = ApplePay(checkout, input)
+		case buyte.GOOGLE_PAY:
+			request, err := GooglePay(checkout, &option, input)
+			if err != nil {
+				return nil, err
+			}
+			requests.GooglePay = request
+		}
+	}
+	return requests, nil
+}
+
+// Shipping methods are described with their estimated delivery, where the merchant gives one.
+func shippingDetail(method buyte.FullCheckoutShippingMethod) string {
+	delivery := method.Delivery.String()
+	if delivery == "" {
+		return method.Description
+	}
+	if method.Description == "" {
+		return delivery
+	}
+	return method.Description + " (" + delivery + ")"
+}
+
+// Networks the gateway accepts, limited to those the checkout allows.
+func networks(checkout *buyte.FullCheckout) []string {
+	if checkout.Requirements == nil {
+		return gatewayNetworks[checkout.GatewayProvider.Type]
+	}
+	var result []string
+	for _, network := range gatewayNetworks[checkout.GatewayProvider.Type] {
+		if checkout.Requirements.AllowsCardNetwork(network) {
+			result = append(result, network)
+		}
+	}
+	return result
+}
+
+// Requirements of checkouts without their own. Customer details are read from the contacts. See PaymentToken.Customer
+var defaultRequirements = &buyte.CheckoutRequirements{
+	RequiredBillingFields:  []string{buyte.CONTACT_FIELD_NAME, buyte.CONTACT_FIELD_POSTAL_ADDRESS},
+	RequiredShippingFields: []string{buyte.CONTACT_FIELD_NAME},
+	RequireEmail:           true,
+	RequirePhone:           true,
+}
+
+func requirements(checkout *buyte.FullCheckout) *buyte.CheckoutRequirements {
+	if checkout.Requirements == nil {
+		return defaultRequirements
+	}
+	return checkout.Requirements
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Google Pay names networks in upper case, and only supports a subset of Apple Pay's.
+func googlePayNetworks(networks []string) []string {
+	var result []string
+	for _, network := range networks {
+		switch network {
+		case "visa", "masterCard", "amex", "discover", "jcb", "interac":
+			result = append(result, strings.ToUpper(network))
+		}
+	}
+	return result
+}
@@ -0,0 +1,238 @@
+package paymentrequest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/googlepay"
+	"github.com/rsoury/buyte/pkg/shipping"
+	"github.com/rsoury/buyte/pkg/tax"
+)
+
+func testCheckout(gatewayType string, publicKey string) *buyte.FullCheckout {
+	return &buyte.FullCheckout{
+		ID:       "checkout",
+		Currency: "aud",
+		Country:  "AU",
+		Options: []buyte.FullCheckoutOptionResponse{
+			{Name: buyte.APPLE_PAY, AdditionalData: map[string]string{"merchantId": "merchant.com.buytecheckout", "merchantName": "Buyte"}},
+			{Name: buyte.GOOGLE_PAY, AdditionalData: map[string]string{"merchantId": "05174216476243863888", "merchantName": "Buyte"}},
+		},
+		ShippingMethods: []buyte.FullCheckoutShippingMethod{
+			buyte.NewFullCheckoutShippingMethod(testZone.Rates[0]),
+			buyte.NewFullCheckoutShippingMethod(testZone.Rates[1]),
+		},
+		GatewayProvider: buyte.FullCheckoutGatewayProvider{Type: gatewayType, PublicKey: publicKey},
+		Merchant:        buyte.FullCheckoutMerchant{StoreName: "Buyte Store"},
+	}
+}
+
+var testZone = &shipping.Zone{
+	Countries: []string{"AU"},
+	Rates: []shipping.Rate{
+		{ID: "standard", Label: "Standard", Rate: 1000, MaxOrder: 5000},
+		{ID: "free", Label: "Free", MinOrder: 5000, Delivery: &shipping.DeliveryWindow{MinDays: 3, MaxDays: 5}},
+	},
+}
+
+// The checkout as the store gets it for the order, with the shipping methods its zone has available for the order. See buyte.FullCheckoutOptions
+func forOrder(checkout *buyte.FullCheckout, input *Input) *buyte.FullCheckout {
+	checkout.ShippingMethods = nil
+	for _, rate := range testZone.Available(input.ShippingOrder()) {
+		checkout.ShippingMethods = append(checkout.ShippingMethods, buyte.NewFullCheckoutShippingMethod(rate))
+	}
+	return checkout
+}
+
+func TestBuildStripe(t *testing.T) {
+	assert := assert.New(t)
+	input := &Input{
+		Amount: 2500,
+		Items:  []LineItem{{Label: "T-Shirt", Amount: 2500}},
+	}
+	requests, err := Build(forOrder(testCheckout(buyte.STRIPE, "pk_test_123"), input), input)
+	if !assert.NoError(err) {
+		return
+	}
+
+	apple := requests.ApplePay
+	if assert.NotNil(apple) {
+		assert.Equal("AU", apple.CountryCode)
+		assert.Equal("AUD", apple.CurrencyCode)
+		assert.Equal([]string{"supports3DS"}, apple.MerchantCapabilities)
+		assert.Contains(apple.SupportedNetworks, "masterCard")
+		assert.Contains(apple.RequiredShippingContactFields, "postalAddress")
+		// Only the standard method is available under $50.
+		assert.Len(apple.ShippingMethods, 1)
+		assert.Equal([]ApplePayLineItem{
+			{Label: "T-Shirt", Amount: "25.00", Type: "final"},
+			{Label: "Standard", Amount: "10.00", Type: "final"},
+		}, apple.LineItems)
+		assert.Equal(ApplePayLineItem{Label: "Buyte Store", Amount: "35.00", Type: "final"}, apple.Total)
+	}
+
+	google := requests.GooglePay
+	if assert.NotNil(google) {
+		assert.Equal("05174216476243863888", google.MerchantInfo.MerchantID)
+		method := google.AllowedPaymentMethods[0]
+		assert.Equal(googlepay.TokenizationTypeGateway, method.TokenizationSpecification.Type)
+		assert.Equal(map[string]string{
+			"gateway":               "stripe",
+			"stripe:version":        "2018-10-31",
+			"stripe:publishableKey": "pk_test_123",
+		}, method.TokenizationSpecification.Parameters)
+		assert.Equal([]string{"PAN_ONLY", "CRYPTOGRAM_3DS"}, method.Parameters.AllowedAuthMethods)
+		assert.Equal([]string{"VISA", "MASTERCARD", "AMEX", "DISCOVER", "JCB"}, method.Parameters.AllowedCardNetworks)
+		assert.Equal("35.00", google.TransactionInfo.TotalPrice)
+		assert.Equal("AUD", google.TransactionInfo.CurrencyCode)
+		assert.Equal("standard", google.ShippingOptionParameters.DefaultSelectedOptionID)
+	}
+}
+
+func TestBuildAdyen(t *testing.T) {
+	assert := assert.New(t)
+	checkout := testCheckout(buyte.ADYEN, "BuyteECOM")
+	checkout.Country = "CN"
+	checkout.Currency = "cny"
+	checkout.ShippingMethods = nil
+	requests, err := Build(checkout, &Input{Amount: 10000})
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal([]string{"supports3DS", "supportsEMV"}, requests.ApplePay.MerchantCapabilities)
+	assert.Contains(requests.ApplePay.SupportedNetworks, "chinaUnionPay")
+	assert.Empty(requests.ApplePay.ShippingMethods)
+	assert.Equal("100.00", requests.ApplePay.Total.Amount)
+
+	method := requests.GooglePay.AllowedPaymentMethods[0]
+	assert.Equal("adyen", method.TokenizationSpecification.Parameters["gateway"])
+	assert.Equal("BuyteECOM", method.TokenizationSpecification.Parameters["gatewayMerchantId"])
+	assert.NotContains(method.Parameters.AllowedCardNetworks, "CHINAUNIONPAY")
+	assert.False(requests.GooglePay.ShippingOptionRequired)
+}
+
+func TestBuildDirect(t *testing.T) {
+	assert := assert.New(t)
+	checkout := testCheckout(buyte.CHECKOUTCOM, "pk_test_checkout")
+	checkout.Options[1].AdditionalData["tokenizationType"] = googlepay.TokenizationTypeDirect
+	checkout.Options[1].AdditionalData["protocolVersion"] = googlepay.ProtocolVersionECv2
+	checkout.Options[1].AdditionalData["publicKey"] = "BOdoXP+9Aq473SnGwg3JU1aiNpsd9vH2ognq4PtDtlLGa3Kj8TPf+jaQNPyDSkh3JUhiS0KyrrlWhAgNZKHYF2Y="
+	requests, err := Build(checkout, &Input{Amount: 1000})
+	if !assert.NoError(err) {
+		return
+	}
+	method := requests.GooglePay.AllowedPaymentMethods[0]
+	assert.Equal(googlepay.TokenizationTypeDirect, method.TokenizationSpecification.Type)
+	assert.Equal(googlepay.ProtocolVersionECv2, method.TokenizationSpecification.Parameters["protocolVersion"])
+	assert.Equal([]string{"CRYPTOGRAM_3DS"}, method.Parameters.AllowedAuthMethods)
+}
+
+func TestBuildRejected(t *testing.T) {
+	_, err := Build(testCheckout(buyte.SQUARE, "sq0idp-123"), &Input{Amount: 1000})
+	assert.Equal(t, ErrUnsupportedGateway, err)
+
+	_, err = Build(testCheckout(buyte.STRIPE, "pk_test_123"), &Input{})
+	assert.Error(t, err)
+
+	_, err = Build(testCheckout(buyte.STRIPE, ""), &Input{Amount: 1000})
+	assert.Error(t, err)
+}
+
+func TestBuildRequirements(t *testing.T) {
+	assert := assert.New(t)
+	checkout := testCheckout(buyte.STRIPE, "pk_test_123")
+	checkout.ShippingMethods = nil
+	checkout.Requirements = &buyte.CheckoutRequirements{
+		AllowedCardNetworks:   []string{"Visa", "MasterCard"},
+		RequiredBillingFields: []string{buyte.CONTACT_FIELD_NAME},
+		RequireEmail:          true,
+	}
+	requests, err := Build(checkout, &Input{Amount: 1000})
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal([]string{"visa", "masterCard"}, requests.ApplePay.SupportedNetworks)
+	assert.Equal([]string{"name"}, requests.ApplePay.RequiredBillingContactFields)
+	assert.Equal([]string{"email"}, requests.ApplePay.RequiredShippingContactFields)
+
+	google := requests.GooglePay
+	assert.Equal([]string{"VISA", "MASTERCARD"}, google.AllowedPaymentMethods[0].Parameters.AllowedCardNetworks)
+	assert.True(google.AllowedPaymentMethods[0].Parameters.BillingAddressRequired)
+	assert.Equal("MIN", google.AllowedPaymentMethods[0].Parameters.BillingAddressParameters.Format)
+	assert.True(google.EmailRequired)
+	assert.False(google.ShippingAddressRequired)
+
+	checkout.Requirements.AllowedCardNetworks = []string{"Interac"}
+	_, err = Build(checkout, &Input{Amount: 1000})
+	assert.Error(err)
+}
+
+func TestShipping(t *testing.T) {
+	assert := assert.New(t)
+	checkout := testCheckout(buyte.STRIPE, "pk_test_123")
+	input := &ShippingInput{
+		Input:                  Input{Amount: 6000, Items: []LineItem{{Label: "Jacket", Amount: 6000}}},
+		Address:                ShippingAddress{CountryCode: "AU", AdministrativeArea: "NSW", PostalCode: "2000"},
+		SelectedShippingMethod: "free",
+	}
+	update, err := Shipping(context.Background(), forOrder(checkout, &input.Input), input, nil)
+	assert.NoError(err)
+	// Only the free method is available over $50.
+	assert.Len(update.ShippingMethods, 1)
+	assert.Equal("free", update.SelectedShippingMethod)
+	if assert.NotNil(update.ApplePay) {
+		assert.Equal("free", update.ApplePay.NewShippingMethods[0].Identifier)
+		assert.Equal("3-5 days", update.ApplePay.NewShippingMethods[0].Detail)
+		assert.Equal(ApplePayLineItem{Label: "Buyte Store", Amount: "60.00", Type: "final"}, update.ApplePay.NewTotal)
+		assert.Empty(update.ApplePay.Errors)
+	}
+	if assert.NotNil(update.GooglePay) {
+		assert.Equal("free", update.GooglePay.NewShippingOptionParameters.DefaultSelectedOptionID)
+		assert.Equal("60.00", update.GooglePay.NewTransactionInfo.TotalPrice)
+		assert.Nil(update.GooglePay.Error)
+	}
+
+	input.Amount = 2500
+	update, _ = Shipping(context.Background(), forOrder(checkout, &input.Input), input, nil)
+	assert.Equal("standard", update.SelectedShippingMethod, "Methods no longer available are deselected.")
+	assert.Equal("35.00", update.ApplePay.NewTotal.Amount)
+	assert.Equal("35.00", update.GooglePay.NewTransactionInfo.TotalPrice)
+
+	checkout.ShippingMethods = nil
+	update, _ = Shipping(context.Background(), checkout, input, nil)
+	assert.Empty(update.ShippingMethods)
+	assert.Equal("addressUnserviceable", update.ApplePay.Errors[0].Code)
+	assert.Equal("25.00", update.ApplePay.NewTotal.Amount)
+	assert.Equal("SHIPPING_ADDRESS_UNSERVICEABLE", update.GooglePay.Error.Reason)
+}
+
+func TestShippingTax(t *testing.T) {
+	assert := assert.New(t)
+	checkout := testCheckout(buyte.STRIPE, "pk_test_123")
+	input := &ShippingInput{
+		Input:   Input{Amount: 2500},
+		Address: ShippingAddress{CountryCode: "AU", AdministrativeArea: "NSW"},
+	}
+	gst, _ := tax.NewTable(tax.DefaultRates)
+	update, err := Shipping(context.Background(), checkout, input, gst)
+	if !assert.NoError(err) {
+		return
+	}
+	if assert.NotNil(update.Tax) {
+		assert.Equal(318, update.Tax.Included)
+	}
+	assert.Equal(ApplePayLineItem{Label: "Includes GST", Amount: "3.18", Type: "final"}, update.ApplePay.NewLineItems[1])
+	assert.Equal("35.00", update.ApplePay.NewTotal.Amount, "Tax included in prices does not change the total.")
+
+	salesTax, _ := tax.NewTable([]tax.Rate{{Country: "AU", Name: "Sales Tax", Rate: 10}})
+	update, err = Shipping(context.Background(), checkout, input, salesTax)
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Equal(GooglePayDisplayItem{Label: "Sales Tax", Type: "TAX", Price: "2.50"}, update.GooglePay.NewTransactionInfo.DisplayItems[1])
+	assert.Equal("37.50", update.GooglePay.NewTransactionInfo.TotalPrice)
+	assert.Equal("37.50", update.ApplePay.NewTotal.Amount)
+}
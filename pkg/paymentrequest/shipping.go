@@ -0,0 +1,253 @@
+package paymentrequest
+
+import (
+	"context"
+	"strings"
+
+	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/tax"
+	"github.com/rsoury/buyte/pkg/util"
+)
+
+// ShippingAddress is the redacted address wallets share while their payment sheet is open.
+// Apple Pay's redacted ApplePayPaymentContact, and Google Pay's IntermediateAddress, both include these fields.
+type ShippingAddress struct {
+	CountryCode        string `json:"countryCode"`
+	AdministrativeArea string `json:"administrativeArea,omitempty"`
+	Locality           string `json:"locality,omitempty"`
+	PostalCode         string `json:"postalCode,omitempty"`
+}
+
+// ShippingInput is the order shipping is recalculated for, once the customer selects an address or shipping method.
+type ShippingInput struct {
+	Input
+	Address ShippingAddress `json:"address"`
+	// The shipping method selected on the payment sheet. The first shipping method available is selected when it is not, or is no longer, available.
+	SelectedShippingMethod string `json:"selectedShippingMethod,omitempty"`
+}
+
+// ShippingUpdate is the shipping methods available for an address, and the order's updated total for each wallet the checkout offers.
+type ShippingUpdate struct {
+	ShippingMethods []buyte.FullCheckoutShippingMethod `json:"shippingMethods"`
+	// The shipping method the totals include.
+	SelectedShippingMethod string `json:"selectedShippingMethod,omitempty"`
+	// Tax of the order with the selected shipping method, where tax is calculated.
+	Tax       *tax.Breakdown                     `json:"tax,omitempty"`
+	ApplePay  *ApplePayShippingUpdate            `json:"applePay,omitempty"`
+	GooglePay *GooglePayPaymentDataRequestUpdate `json:"googlePay,omitempty"`
+}
+
+// ApplePayShippingUpdate is an ApplePayShippingContactUpdate, as passed to completeShippingContactSelection and completeShippingMethodSelection.
+// See https://developer.apple.com/documentation/apple_pay_on_the_web/applepayshippingcontactupdate
+type ApplePayShippingUpdate struct {
+	NewTotal           ApplePayLineItem         `json:"newTotal"`
+	NewLineItems       []ApplePayLineItem       `json:"newLineItems"`
+	NewShippingMethods []ApplePayShippingMethod `json:"newShippingMethods"`
+	Errors             []ApplePayError          `json:"errors,omitempty"`
+}
+
+type ApplePayError struct {
+	Code         string `json:"code"`
+	ContactField string `json:"contactField,omitempty"`
+	Message      string `json:"message"`
+}
+
+// GooglePayPaymentDataRequestUpdate is a PaymentDataRequestUpdate, as returned by onPaymentDataChanged.
+// See https://developers.google.com/pay/api/web/reference/response-objects#PaymentDataRequestUpdate
+type GooglePayPaymentDataRequestUpdate struct {
+	NewShippingOptionParameters *GooglePayShippingOptionParameters `json:"newShippingOptionParameters,omitempty"`
+	NewTransactionInfo          GooglePayTransactionInfo           `json:"newTransactionInfo"`
+	Error                       *GooglePayPaymentDataError         `json:"error,omitempty"`
+}
+
+type GooglePayPaymentDataError struct {
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+	Intent  string `json:"intent"`
+}
+
+// Shown on the payment sheet when no shipping method is available for the address.
+const unserviceableMessage = "We do not ship to this address."
+
+// Shipping recalculates the shipping methods available for the address and order amount, and the order's total with the selected shipping method.
+// The checkout's shipping methods are expected to be those the zone the address is in has available for the order, priced for it. See buyte.FullCheckoutOptions
+// Tax is included in the totals when a calculator is given.
+func Shipping(ctx context.Context, checkout *buyte.FullCheckout, input *ShippingInput, calculator tax.Calculator) (*ShippingUpdate, error) {
+	methods := checkout.ShippingMethods
+	update := &ShippingUpdate{
+		ShippingMethods: methods,
+	}
+	if update.ShippingMethods == nil {
+		update.ShippingMethods = []buyte.FullCheckoutShippingMethod{}
+	}
+	selected := selectedShippingMethod(methods, input.SelectedShippingMethod)
+	shippingAmount := 0
+	if selected != nil {
+		update.SelectedShippingMethod = selected.ID
+		shippingAmount = selected.Rate
+	}
+	breakdown := &tax.Breakdown{}
+	if calculator != nil {
+		var err error
+		breakdown, err = calculator.Calculate(ctx, &tax.Input{
+			Origin: checkout.Country,
+			Destination: tax.Location{
+				Country: input.Address.CountryCode,
+				Region:  input.Address.AdministrativeArea,
+			},
+			Amount:   input.Amount,
+			Shipping: shippingAmount,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !breakdown.IsEmpty() {
+			update.Tax = breakdown
+		}
+	}
+	for _, option := range checkout.Options {
+		switch option.Name {
+		case buyte.APPLE_PAY:
+			update.ApplePay = applePayShippingUpdate(checkout, input, methods, selected, breakdown)
+		case buyte.GOOGLE_PAY:
+			update.GooglePay = googlePayShippingUpdate(checkout, input, methods, selected, breakdown)
+		}
+	}
+	return update, nil
+}
+
+// Tax included in prices is shown for information, as it does not change the total. ie. "Includes GST"
+func taxLabel(line tax.Line) string {
+	if line.Inclusive {
+		return "Includes " + line.Name
+	}
+	return line.Name
+}
+
+func selectedShippingMethod(methods []buyte.FullCheckoutShippingMethod, id string) *buyte.FullCheckoutShippingMethod {
+	if len(methods) == 0 {
+		return nil
+	}
+	for i, method := range methods {
+		if method.ID == id {
+			return &methods[i]
+		}
+	}
+	return &methods[0]
+}
+
+// Apple Pay presents the selected shipping method first.
+func applePayShippingUpdate(checkout *buyte.FullCheckout, input *ShippingInput, methods []buyte.FullCheckoutShippingMethod, selected *buyte.FullCheckoutShippingMethod, breakdown *tax.Breakdown) *ApplePayShippingUpdate {
+	currency := checkout.Currency
+	update := &ApplePayShippingUpdate{
+		NewLineItems:       []ApplePayLineItem{},
+		NewShippingMethods: []ApplePayShippingMethod{},
+	}
+	for _, item := range input.Items {
+		update.NewLineItems = append(update.NewLineItems, ApplePayLineItem{
+			Label:  item.Label,
+			Amount: util.FormatAmount(item.Amount, currency),
+			Type:   "final",
+		})
+	}
+	total := input.Amount
+	if selected == nil {
+		update.Errors = []ApplePayError{
+			{
+				Code:         "addressUnserviceable",
+				ContactField: buyte.CONTACT_FIELD_POSTAL_ADDRESS,
+				Message:      unserviceableMessage,
+			},
+		}
+	} else {
+		ordered := []buyte.FullCheckoutShippingMethod{*selected}
+		for _, method := range methods {
+			if method.ID != selected.ID {
+				ordered = append(ordered, method)
+			}
+		}
+		for _, method := range ordered {
+			update.NewShippingMethods = append(update.NewShippingMethods, ApplePayShippingMethod{
+				Identifier: method.ID,
+				Label:      method.Name,
+				Detail:     shippingDetail(method),
+				Amount:     util.FormatAmount(method.Rate, currency),
+			})
+		}
+		update.NewLineItems = append(update.NewLineItems, ApplePayLineItem{
+			Label:  selected.Name,
+			Amount: util.FormatAmount(selected.Rate, currency),
+			Type:   "final",
+		})
+		total += selected.Rate
+	}
+	for _, line := range breakdown.Lines {
+		update.NewLineItems = append(update.NewLineItems, ApplePayLineItem{
+			Label:  taxLabel(line),
+			Amount: util.FormatAmount(line.Amount, currency),
+			Type:   "final",
+		})
+	}
+	total += breakdown.Added
+	update.NewTotal = ApplePayLineItem{
+		Label:  checkout.Merchant.StoreName,
+		Amount: util.FormatAmount(total, currency),
+		Type:   "final",
+	}
+	return update
+}
+
+func googlePayShippingUpdate(checkout *buyte.FullCheckout, input *ShippingInput, methods []buyte.FullCheckoutShippingMethod, selected *buyte.FullCheckoutShippingMethod, breakdown *tax.Breakdown) *GooglePayPaymentDataRequestUpdate {
+	currency := checkout.Currency
+	update := &GooglePayPaymentDataRequestUpdate{}
+	var displayItems []GooglePayDisplayItem
+	for _, item := range input.Items {
+		displayItems = append(displayItems, GooglePayDisplayItem{
+			Label: item.Label,
+			Type:  "LINE_ITEM",
+			Price: util.FormatAmount(item.Amount, currency),
+		})
+	}
+	total := input.Amount
+	if selected == nil {
+		update.Error = &GooglePayPaymentDataError{
+			Reason:  "SHIPPING_ADDRESS_UNSERVICEABLE",
+			Message: unserviceableMessage,
+			Intent:  "SHIPPING_ADDRESS",
+		}
+	} else {
+		update.NewShippingOptionParameters = &GooglePayShippingOptionParameters{
+			DefaultSelectedOptionID: selected.ID,
+		}
+		for _, method := range methods {
+			update.NewShippingOptionParameters.ShippingOptions = append(update.NewShippingOptionParameters.ShippingOptions, GooglePayShippingOption{
+				ID:          method.ID,
+				Label:       util.FormatAmount(method.Rate, currency) + ": " + method.Name,
+				Description: shippingDetail(method),
+			})
+		}
+		displayItems = append(displayItems, GooglePayDisplayItem{
+			Label: selected.Name,
+			Type:  "LINE_ITEM",
+			Price: util.FormatAmount(selected.Rate, currency),
+		})
+		total += selected.Rate
+	}
+	for _, line := range breakdown.Lines {
+		displayItems = append(displayItems, GooglePayDisplayItem{
+			Label: taxLabel(line),
+			Type:  "TAX",
+			Price: util.FormatAmount(line.Amount, currency),
+		})
+	}
+	total += breakdown.Added
+	update.NewTransactionInfo = GooglePayTransactionInfo{
+		TotalPriceStatus: "FINAL",
+		TotalPrice:       util.FormatAmount(total, currency),
+		TotalPriceLabel:  "Total",
+		CurrencyCode:     strings.ToUpper(currency),
+		CountryCode:      strings.ToUpper(checkout.Country),
+		DisplayItems:     displayItems,
+	}
+	return update
+}
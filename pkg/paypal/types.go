@@ -0,0 +1,136 @@
+// Package paypal holds the PayPal Orders API resources a PayPal payment is recorded with.
+// See https://developer.paypal.com/docs/api/orders/v2/
+package paypal
+
+import (
+	"strings"
+)
+
+// Order intents. Orders are captured once the merchant creates a charge.
+const (
+	IntentCapture = "CAPTURE"
+)
+
+// Order and capture statuses.
+const (
+	StatusCreated   = "CREATED"
+	StatusApproved  = "APPROVED"
+	StatusCompleted = "COMPLETED"
+	StatusPending   = "PENDING"
+	StatusDeclined  = "DECLINED"
+)
+
+// Approval is the data PayPal's buttons return once the customer approves an order.
+type Approval struct {
+	OrderID string `json:"orderID"`
+	PayerID string `json:"payerID,omitempty"`
+}
+
+// Order is a PayPal order, as created by Buyte and approved by the customer in the PayPal popup.
+type Order struct {
+	ID            string         `json:"id"`
+	Status        string         `json:"status"`
+	Intent        string         `json:"intent,omitempty"`
+	Payer         *Payer         `json:"payer,omitempty"`
+	PurchaseUnits []PurchaseUnit `json:"purchase_units,omitempty"`
+}
+
+type Payer struct {
+	PayerID      string   `json:"payer_id,omitempty"`
+	EmailAddress string   `json:"email_address,omitempty"`
+	Name         Name     `json:"name,omitempty"`
+	Phone        *Phone   `json:"phone,omitempty"`
+	Address      *Address `json:"address,omitempty"`
+}
+
+type Name struct {
+	GivenName string `json:"given_name,omitempty"`
+	Surname   string `json:"surname,omitempty"`
+	// Shipping names are only given in full.
+	FullName string `json:"full_name,omitempty"`
+}
+
+type Phone struct {
+	PhoneNumber struct {
+		NationalNumber string `json:"national_number"`
+	} `json:"phone_number"`
+}
+
+type Address struct {
+	AddressLine1 string `json:"address_line_1,omitempty"`
+	AddressLine2 string `json:"address_line_2,omitempty"`
+	// City, town or village
+	AdminArea2 string `json:"admin_area_2,omitempty"`
+	// State, province or region
+	AdminArea1  string `json:"admin_area_1,omitempty"`
+	PostalCode  string `json:"postal_code,omitempty"`
+	CountryCode string `json:"country_code,omitempty"`
+}
+
+type PurchaseUnit struct {
+	ReferenceID string    `json:"reference_id,omitempty"`
+	CustomID    string    `json:"custom_id,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Amount      Amount    `json:"amount"`
+	Shipping    *Shipping `json:"shipping,omitempty"`
+	Payments    *Payments `json:"payments,omitempty"`
+}
+
+// Amount is a decimal value in the currency's major unit. ie. "10.00"
+type Amount struct {
+	CurrencyCode string `json:"currency_code"`
+	Value        string `json:"value"`
+}
+
+type Shipping struct {
+	Name    *Name    `json:"name,omitempty"`
+	Address *Address `json:"address,omitempty"`
+}
+
+type Payments struct {
+	Captures []Capture `json:"captures,omitempty"`
+}
+
+type Capture struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Amount Amount `json:"amount"`
+}
+
+// PurchaseUnit returns the order's only purchase unit. Buyte creates orders with a single purchase unit.
+func (o *Order) PurchaseUnit() *PurchaseUnit {
+	if len(o.PurchaseUnits) == 0 {
+		return &PurchaseUnit{}
+	}
+	return &o.PurchaseUnits[0]
+}
+
+// PayerName returns the payer's full name.
+func (o *Order) PayerName() string {
+	if o.Payer == nil {
+		return ""
+	}
+	return strings.TrimSpace(o.Payer.Name.GivenName + " " + o.Payer.Name.Surname)
+}
+
+// Shipping returns where the customer chose to have the order shipped, if anywhere.
+func (o *Order) Shipping() *Shipping {
+	if shipping := o.PurchaseUnit().Shipping; shipping != nil {
+		return shipping
+	}
+	return &Shipping{}
+}
+
+// Capture returns the capture of a completed order.
+func (o *Order) Capture() *Capture {
+	if payments := o.PurchaseUnit().Payments; payments != nil && len(payments.Captures) > 0 {
+		return &payments.Captures[0]
+	}
+	return &Capture{}
+}
+
+// HasAmount checks the order is for the amount given as formatted for PayPal, in the currency.
+func (o *Order) HasAmount(value string, currency string) bool {
+	amount := o.PurchaseUnit().Amount
+	return amount.Value == value && strings.EqualFold(amount.CurrencyCode, currency)
+}
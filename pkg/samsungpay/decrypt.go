@@ -0,0 +1,141 @@
+// Package samsungpay decrypts Samsung Pay payment credentials with the merchant's private keys.
+// 3DS credentials hold a JWE, encrypted for the key of the CSR the merchant uploaded to the Samsung Pay partner portal.
+// See https://developer.samsung.com/pay/web/payment-credential.html
+package samsungpay
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"hash"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Payload is the decrypted form of a 3DS credential's data.
+type Payload struct {
+	// Amount of the payment, in the currency's minor unit
+	Amount       string `json:"amount"`
+	CurrencyCode string `json:"currency_code"`
+	// Time of the payment, in milliseconds since the epoch
+	UTC          string `json:"utc"`
+	EciIndicator string `json:"eci_indicator"`
+	TokenPAN     string `json:"tokenPAN"`
+	// MMYY
+	TokenPANExpiration string `json:"tokenPanExpiration"`
+	// Base64 encoded 3-D Secure cryptogram
+	Cryptogram string `json:"cryptogram"`
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Enc string `json:"enc"`
+}
+
+// Decryptor decrypts 3DS payment credentials encrypted for any of the merchant's keys.
+type Decryptor struct {
+	privateKeys []*rsa.PrivateKey
+}
+
+func NewDecryptor(privateKeys []*rsa.PrivateKey) *Decryptor {
+	return &Decryptor{
+		privateKeys: privateKeys,
+	}
+}
+
+// Decrypt decrypts a payment credential's 3DS data.
+func (d *Decryptor) Decrypt(credential *Credential) (*Payload, error) {
+	if credential.Method != MethodThreeDS {
+		return nil, newError(ReasonMethod, errors.Errorf("Unsupported credential method %q", credential.Method))
+	}
+	plaintext, err := d.decryptJWE(credential.ThreeDS.Data)
+	if err != nil {
+		return nil, err
+	}
+	payload := &Payload{}
+	if err := json.Unmarshal(plaintext, payload); err != nil {
+		return nil, newError(ReasonMalformed, errors.Wrap(err, "Could not read decrypted payload"))
+	}
+	if payload.TokenPAN == "" || payload.Cryptogram == "" {
+		return nil, newError(ReasonMalformed, errors.New("Decrypted payload has no token PAN or cryptogram"))
+	}
+	return payload, nil
+}
+
+// Decrypts a JWE in compact serialization. The content key is wrapped with RSA, and the content encrypted with AES GCM.
+func (d *Decryptor) decryptJWE(data string) ([]byte, error) {
+	parts := strings.Split(data, ".")
+	if len(parts) != 5 {
+		return nil, newError(ReasonMalformed, errors.New("Data is not a JWE in compact serialization"))
+	}
+	var decoded [5][]byte
+	for i, part := range parts {
+		value, err := base64.RawURLEncoding.DecodeString(part)
+		if err != nil {
+			return nil, newError(ReasonMalformed, errors.Wrap(err, "Could not decode JWE"))
+		}
+		decoded[i] = value
+	}
+	h := &header{}
+	if err := json.Unmarshal(decoded[0], h); err != nil {
+		return nil, newError(ReasonMalformed, errors.Wrap(err, "Could not read JWE header"))
+	}
+	var keySize int
+	switch h.Enc {
+	case "A128GCM":
+		keySize = 16
+	case "A256GCM":
+		keySize = 32
+	default:
+		return nil, newError(ReasonAlgorithm, errors.Errorf("Unsupported content encryption %q", h.Enc))
+	}
+	var oaepHash hash.Hash
+	switch h.Alg {
+	case "RSA1_5":
+	case "RSA-OAEP":
+		oaepHash = sha1.New()
+	case "RSA-OAEP-256":
+		oaepHash = sha256.New()
+	default:
+		return nil, newError(ReasonAlgorithm, errors.Errorf("Unsupported key encryption %q", h.Alg))
+	}
+
+	encryptedKey, iv := decoded[1], decoded[2]
+	if len(iv) == 0 {
+		return nil, newError(ReasonMalformed, errors.New("JWE has no initialization vector"))
+	}
+	sealed := append(decoded[3], decoded[4]...)
+	// The header, as encoded, is authenticated with the content.
+	additionalData := []byte(parts[0])
+	for _, privateKey := range d.privateKeys {
+		var cek []byte
+		var err error
+		if oaepHash == nil {
+			cek, err = rsa.DecryptPKCS1v15(nil, privateKey, encryptedKey)
+		} else {
+			cek, err = rsa.DecryptOAEP(oaepHash, nil, privateKey, encryptedKey, nil)
+		}
+		if err != nil || len(cek) != keySize {
+			continue
+		}
+		block, err := aes.NewCipher(cek)
+		if err != nil {
+			return nil, err
+		}
+		gcm, err := cipher.NewGCMWithNonceSize(block, len(iv))
+		if err != nil {
+			return nil, newError(ReasonMalformed, err)
+		}
+		plaintext, err := gcm.Open(nil, iv, sealed, additionalData)
+		if err != nil {
+			continue
+		}
+		return plaintext, nil
+	}
+	return nil, newError(ReasonUnknownPrivateKey, errors.New("Credential was not encrypted for any of the merchant's keys"))
+}
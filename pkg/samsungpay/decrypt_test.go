@@ -0,0 +1,136 @@
+package samsungpay
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testPayload = `{"amount":"1000","currency_code":"AUD","utc":"1490266732173","eci_indicator":"5","tokenPAN":"4111111111111111","tokenPanExpiration":"0430","cryptogram":"AK+zkbPMCORcABCD3AGRAoABFA=="}`
+
+// Encrypts the payload for the key as Samsung Pay would, as a JWE in compact serialization.
+func createCredential(t *testing.T, key *rsa.PublicKey, alg string, payload string) *Credential {
+	encode := base64.RawURLEncoding.EncodeToString
+	header := encode([]byte(`{"alg":"` + alg + `","enc":"A128GCM","kid":"merchant"}`))
+	cek := make([]byte, 16)
+	iv := make([]byte, 12)
+	_, _ = rand.Read(cek)
+	_, _ = rand.Read(iv)
+	var encryptedKey []byte
+	var err error
+	if alg == "RSA1_5" {
+		encryptedKey, err = rsa.EncryptPKCS1v15(rand.Reader, key, cek)
+	} else {
+		encryptedKey, err = rsa.EncryptOAEP(sha1.New(), rand.Reader, key, cek, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	sealed := gcm.Seal(nil, iv, []byte(payload), []byte(header))
+	ciphertext, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+	return &Credential{
+		Method:    MethodThreeDS,
+		CardBrand: "visa",
+		ThreeDS: ThreeDS{
+			Type:    "S",
+			Version: "100",
+			Data:    header + "." + encode(encryptedKey) + "." + encode(iv) + "." + encode(ciphertext) + "." + encode(tag),
+		},
+	}
+}
+
+func loadTestKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	dir, err := ioutil.TempDir("", "samsungpay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "samsung-merchant-key.pem")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadPrivateKeys(filepath.Join(dir, "samsung-merchant*-key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys[0]
+}
+
+func TestDecrypt(t *testing.T) {
+	assert := assert.New(t)
+	key := loadTestKey(t)
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	decryptor := NewDecryptor([]*rsa.PrivateKey{other, key})
+
+	for _, alg := range []string{"RSA1_5", "RSA-OAEP"} {
+		payload, err := decryptor.Decrypt(createCredential(t, &key.PublicKey, alg, testPayload))
+		if assert.NoError(err, alg) {
+			assert.Equal("4111111111111111", payload.TokenPAN)
+			assert.Equal("0430", payload.TokenPANExpiration)
+			assert.Equal("5", payload.EciIndicator)
+		}
+	}
+}
+
+func TestDecryptRejected(t *testing.T) {
+	key := loadTestKey(t)
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	decryptor := NewDecryptor([]*rsa.PrivateKey{key})
+
+	reason := func(err error) Reason {
+		if !IsDecryptionError(err) {
+			return ""
+		}
+		return err.(*DecryptionError).Reason
+	}
+
+	t.Run("another key", func(t *testing.T) {
+		_, err := decryptor.Decrypt(createCredential(t, &other.PublicKey, "RSA-OAEP", testPayload))
+		assert.Equal(t, ReasonUnknownPrivateKey, reason(err))
+	})
+	t.Run("tampered", func(t *testing.T) {
+		credential := createCredential(t, &key.PublicKey, "RSA-OAEP", testPayload)
+		// Swap the header, which is authenticated with the content.
+		header, _ := json.Marshal(map[string]string{"alg": "RSA-OAEP", "enc": "A128GCM"})
+		data := credential.ThreeDS.Data
+		for i := range data {
+			if data[i] == '.' {
+				credential.ThreeDS.Data = base64.RawURLEncoding.EncodeToString(header) + data[i:]
+				break
+			}
+		}
+		_, err := decryptor.Decrypt(credential)
+		assert.Equal(t, ReasonUnknownPrivateKey, reason(err))
+	})
+	t.Run("method", func(t *testing.T) {
+		credential := createCredential(t, &key.PublicKey, "RSA-OAEP", testPayload)
+		credential.Method = "PAN"
+		_, err := decryptor.Decrypt(credential)
+		assert.Equal(t, ReasonMethod, reason(err))
+	})
+	t.Run("malformed", func(t *testing.T) {
+		_, err := decryptor.Decrypt(&Credential{Method: MethodThreeDS, ThreeDS: ThreeDS{Data: "abc.def"}})
+		assert.Equal(t, ReasonMalformed, reason(err))
+	})
+	t.Run("payload", func(t *testing.T) {
+		_, err := decryptor.Decrypt(createCredential(t, &key.PublicKey, "RSA-OAEP", `{"amount":"1000"}`))
+		assert.Equal(t, ReasonMalformed, reason(err))
+	})
+}
@@ -0,0 +1,46 @@
+package samsungpay
+
+import (
+	"github.com/pkg/errors"
+)
+
+type Reason string
+
+// Reasons a payment credential could not be decrypted.
+const (
+	ReasonMethod            Reason = "unsupported_method"
+	ReasonMalformed         Reason = "malformed_credential"
+	ReasonAlgorithm         Reason = "unsupported_algorithm"
+	ReasonUnknownPrivateKey Reason = "unknown_private_key"
+)
+
+// DecryptionError is returned when a payment credential cannot be decrypted with the merchant's keys.
+type DecryptionError struct {
+	Reason Reason
+	Err    error
+}
+
+func newError(reason Reason, err error) error {
+	return &DecryptionError{
+		Reason: reason,
+		Err:    err,
+	}
+}
+
+func (e *DecryptionError) Error() string {
+	return "Samsung Pay credential decryption failed (" + string(e.Reason) + "): " + e.Err.Error()
+}
+
+func (e *DecryptionError) Unwrap() error {
+	return e.Err
+}
+
+func (e *DecryptionError) Cause() error {
+	return e.Err
+}
+
+// IsDecryptionError checks whether the credential was rejected, as opposed to a failure on Buyte's end.
+func IsDecryptionError(err error) bool {
+	var decryptionErr *DecryptionError
+	return errors.As(err, &decryptionErr)
+}
@@ -0,0 +1,62 @@
+package samsungpay
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// LoadPrivateKeys loads every merchant private key matching the glob, in path order.
+// Keys are rotated by uploading a new CSR to the Samsung Pay partner portal while the previous key is still loaded.
+func LoadPrivateKeys(pattern string) ([]*rsa.PrivateKey, error) {
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid Samsung Pay private key pattern")
+	}
+	sort.Strings(paths)
+	var keys []*rsa.PrivateKey
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "Could not read Samsung Pay private key "+path)
+		}
+		key, err := ParsePrivateKey(data)
+		if err != nil {
+			return nil, errors.Wrap(err, "Could not load Samsung Pay private key "+path)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("No Samsung Pay private keys match " + pattern)
+	}
+	return keys, nil
+}
+
+// ParsePrivateKey parses a PEM encoded RSA private key, in PKCS #8 or PKCS #1 form.
+// ie. As generated with the CSR by `openssl req -newkey rsa:2048 -nodes`
+func ParsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("No private key found")
+	}
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("Private key is not an RSA key")
+		}
+		return rsaKey, nil
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	return nil, errors.New("Unsupported private key type " + block.Type)
+}
@@ -0,0 +1,42 @@
+package samsungpay
+
+// Payment credential methods. Only 3DS credentials carry an encrypted network token.
+const (
+	MethodThreeDS = "3DS"
+)
+
+// Response is the payment a Samsung Pay widget authorized, with the contact details it collected.
+type Response struct {
+	PaymentCredential Credential `json:"paymentCredential"`
+	Email             string     `json:"email,omitempty"`
+	BillingAddress    Address    `json:"billingAddress,omitempty"`
+	ShippingAddress   Address    `json:"shippingAddress,omitempty"`
+}
+
+// Credential is the payment credential Samsung Pay returns once the customer authorizes a payment.
+// See https://developer.samsung.com/pay/web/payment-credential.html
+type Credential struct {
+	Method           string  `json:"method"`
+	CardBrand        string  `json:"card_brand"`
+	CardLast4Digits  string  `json:"card_last4digits"`
+	ThreeDS          ThreeDS `json:"3DS"`
+	RecurringPayment bool    `json:"recurring_payment"`
+}
+
+type ThreeDS struct {
+	Type    string `json:"type"`
+	Version string `json:"version"`
+	// JWE compact serialization of the Payload, encrypted for the merchant's key
+	Data string `json:"data"`
+}
+
+type Address struct {
+	Addressee    string `json:"addressee,omitempty"`
+	AddressLine1 string `json:"addressLine1,omitempty"`
+	AddressLine2 string `json:"addressLine2,omitempty"`
+	City         string `json:"city,omitempty"`
+	State        string `json:"state,omitempty"`
+	CountryCode  string `json:"countryCode,omitempty"`
+	PostalCode   string `json:"postalCode,omitempty"`
+	PhoneNumber  string `json:"phoneNumber,omitempty"`
+}
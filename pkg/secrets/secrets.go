@@ -0,0 +1,88 @@
+// Package secrets reads merchant owned secrets, such as Apple Pay certificates, from a secrets store.
+package secrets
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/pkg/errors"
+	config "github.com/spf13/viper"
+)
+
+// ErrNotFound is returned when a secret does not exist.
+var ErrNotFound = errors.New("Secret not found")
+
+type Store interface {
+	Get(ctx context.Context, name string) ([]byte, error)
+}
+
+// New creates the secrets store set by "secrets.type"
+func New() (Store, error) {
+	switch config.GetString("secrets.type") {
+	case "secretsmanager":
+		sess, err := session.NewSession(
+			&aws.Config{Region: aws.String(config.GetString("secrets.region"))},
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "Could not create AWS session")
+		}
+		return &SecretsManager{
+			Client: secretsmanager.New(sess),
+			Prefix: config.GetString("secrets.prefix"),
+		}, nil
+	case "file":
+		return &FileStore{
+			Dir: config.GetString("secrets.dir"),
+		}, nil
+	}
+	return nil, errors.New("Invalid 'secrets.type'")
+}
+
+// SecretsManager reads secrets from AWS Secrets Manager.
+type SecretsManager struct {
+	Client *secretsmanager.SecretsManager
+	// Prepended to every secret name. ie. buyte/
+	Prefix string
+}
+
+func (s *SecretsManager) Get(ctx context.Context, name string) ([]byte, error) {
+	output, err := s.Client.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(s.Prefix + name),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == secretsmanager.ErrCodeResourceNotFoundException {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "Could not get secret "+name)
+	}
+	if output.SecretBinary != nil {
+		return output.SecretBinary, nil
+	}
+	return []byte(aws.StringValue(output.SecretString)), nil
+}
+
+// FileStore reads secrets from files in a directory. For development.
+type FileStore struct {
+	Dir string
+}
+
+func (s *FileStore) Get(ctx context.Context, name string) ([]byte, error) {
+	if name == "" || strings.Contains(name, "..") {
+		return nil, errors.New("Invalid secret name " + name)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(s.Dir, filepath.FromSlash(name)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "Could not read secret "+name)
+	}
+	return data, nil
+}
@@ -0,0 +1,212 @@
+// Package shipping matches a customer's address to the merchant's shipping zones, and prices each of the zone's rates for an order.
+package shipping
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Location is where an order ships to. Wallets redact the address until payment is authorized, so the region and postcode may be empty or partial.
+type Location struct {
+	Country    string
+	Region     string
+	PostalCode string
+}
+
+// Order is what is shipped. Rates are only filtered by price when the order's items are not known.
+type Order struct {
+	// Amount of the order before shipping, in the currency's minor unit. ie. cents
+	Subtotal int
+	Items    []Item
+}
+
+type Item struct {
+	// Items without a quantity count as one.
+	Quantity int
+	// Weight of each unit, in grams.
+	Weight int
+}
+
+// ItemCount is the number of units in the order.
+func (o *Order) ItemCount() int {
+	count := 0
+	for _, item := range o.Items {
+		count += item.quantity()
+	}
+	return count
+}
+
+// Weight is the order's total weight, in grams.
+func (o *Order) Weight() int {
+	weight := 0
+	for _, item := range o.Items {
+		weight += item.Weight * item.quantity()
+	}
+	return weight
+}
+
+func (i Item) quantity() int {
+	if i.Quantity <= 0 {
+		return 1
+	}
+	return i.Quantity
+}
+
+// Zone is a set of locations sharing shipping rates. Zones are limited to countries, and optionally to regions and postcodes within them.
+type Zone struct {
+	ID        string
+	Name      string
+	Countries []string
+	// State or province codes. ie. NSW
+	Regions []string
+	// Each a postcode, a prefix ending in "*", or an inclusive range of numeric postcodes. ie. 2000, 2* or 2000-2999
+	Postcodes []string
+	Rates     []Rate
+}
+
+// Rate is a shipping method of a zone. Maximums of zero are unbounded.
+type Rate struct {
+	ID          string
+	Label       string
+	Description string
+	// The rate charged, before per item and per kilogram amounts.
+	Rate        int
+	MinOrder    int
+	MaxOrder    int
+	MinItems    int
+	MaxItems    int
+	MinWeight   int
+	MaxWeight   int
+	PerItem     int
+	PerKilogram int
+	// Orders whose subtotal reaches the threshold ship for free.
+	FreeShippingThreshold int
+	Delivery              *DeliveryWindow
+}
+
+// DeliveryWindow is the estimated number of days an order takes to be delivered.
+type DeliveryWindow struct {
+	MinDays int `json:"minDays"`
+	MaxDays int `json:"maxDays"`
+}
+
+// String describes the window as shown on payment sheets. ie. "2-5 days"
+func (w *DeliveryWindow) String() string {
+	if w == nil || (w.MinDays <= 0 && w.MaxDays <= 0) {
+		return ""
+	}
+	if w.MaxDays <= w.MinDays {
+		if w.MinDays == 1 {
+			return "1 day"
+		}
+		return strconv.Itoa(w.MinDays) + " days"
+	}
+	return strconv.Itoa(w.MinDays) + "-" + strconv.Itoa(w.MaxDays) + " days"
+}
+
+// Match returns the first zone shipping to the location, in the order the merchant lists them. Returns nil when no zone ships to it.
+func Match(zones []Zone, location *Location) *Zone {
+	for i := range zones {
+		if zones[i].Matches(location) {
+			return &zones[i]
+		}
+	}
+	return nil
+}
+
+// Matches checks whether the zone ships to the location. Region and postcode rules only apply when the location includes them.
+func (z *Zone) Matches(location *Location) bool {
+	countryMatches := false
+	for _, country := range z.Countries {
+		if strings.EqualFold(country, location.Country) {
+			countryMatches = true
+			break
+		}
+	}
+	if !countryMatches {
+		return false
+	}
+	if len(z.Regions) > 0 && location.Region != "" {
+		regionMatches := false
+		for _, region := range z.Regions {
+			if strings.EqualFold(strings.TrimSpace(region), strings.TrimSpace(location.Region)) {
+				regionMatches = true
+				break
+			}
+		}
+		if !regionMatches {
+			return false
+		}
+	}
+	if len(z.Postcodes) > 0 && location.PostalCode != "" {
+		postcode := normalisePostcode(location.PostalCode)
+		for _, pattern := range z.Postcodes {
+			if postcodeMatches(normalisePostcode(pattern), postcode) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func postcodeMatches(pattern string, postcode string) bool {
+	if prefix := strings.TrimSuffix(pattern, "*"); prefix != pattern {
+		// Wallets redact postcodes before payment is authorized, ie. Apple Pay gives "SW1A" for "SW1A 1AA", so either may be a prefix of the other.
+		return strings.HasPrefix(postcode, prefix) || strings.HasPrefix(prefix, postcode)
+	}
+	if bounds := strings.SplitN(pattern, "-", 2); len(bounds) == 2 {
+		from, fromErr := strconv.Atoi(bounds[0])
+		to, toErr := strconv.Atoi(bounds[1])
+		value, err := strconv.Atoi(postcode)
+		if fromErr != nil || toErr != nil || err != nil || len(postcode) != len(bounds[0]) {
+			return false
+		}
+		return value >= from && value <= to
+	}
+	return pattern == postcode
+}
+
+func normalisePostcode(postcode string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(postcode), " ", ""))
+}
+
+// Applies checks whether the rate may ship the order. Item and weight limits only apply when the order's items are known.
+func (r *Rate) Applies(order *Order) bool {
+	if !within(order.Subtotal, r.MinOrder, r.MaxOrder) {
+		return false
+	}
+	if len(order.Items) == 0 {
+		return true
+	}
+	return within(order.ItemCount(), r.MinItems, r.MaxItems) && within(order.Weight(), r.MinWeight, r.MaxWeight)
+}
+
+// Price is the amount charged to ship the order at the rate. Part kilograms are charged as a whole kilogram.
+func (r *Rate) Price(order *Order) int {
+	if r.FreeShippingThreshold > 0 && order.Subtotal >= r.FreeShippingThreshold {
+		return 0
+	}
+	price := r.Rate + r.PerItem*order.ItemCount()
+	if r.PerKilogram > 0 {
+		price += r.PerKilogram * ((order.Weight() + 999) / 1000)
+	}
+	return price
+}
+
+// Available returns the zone's rates that may ship the order, priced for it.
+func (z *Zone) Available(order *Order) []Rate {
+	var rates []Rate
+	for _, rate := range z.Rates {
+		if !rate.Applies(order) {
+			continue
+		}
+		rate.Rate = rate.Price(order)
+		rates = append(rates, rate)
+	}
+	return rates
+}
+
+func within(value int, min int, max int) bool {
+	return value >= min && (max <= 0 || value <= max)
+}
@@ -0,0 +1,77 @@
+package shipping
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var zones = []Zone{
+	{ID: "sydney", Countries: []string{"AU"}, Regions: []string{"NSW"}, Postcodes: []string{"2000-2234"}},
+	{ID: "nsw", Countries: []string{"AU"}, Regions: []string{"NSW", "ACT"}},
+	{ID: "london", Countries: []string{"GB"}, Postcodes: []string{"SW1A *", "EC*"}},
+	{ID: "australia", Countries: []string{"au"}},
+}
+
+func TestMatch(t *testing.T) {
+	assert := assert.New(t)
+	match := func(location Location) string {
+		if zone := Match(zones, &location); zone != nil {
+			return zone.ID
+		}
+		return ""
+	}
+	assert.Equal("sydney", match(Location{Country: "AU", Region: "NSW", PostalCode: "2000"}))
+	assert.Equal("nsw", match(Location{Country: "AU", Region: "nsw", PostalCode: "2640"}), "Postcodes outside the range are not matched.")
+	assert.Equal("nsw", match(Location{Country: "AU", Region: "ACT", PostalCode: "2600"}))
+	assert.Equal("australia", match(Location{Country: "AU", Region: "VIC", PostalCode: "3000"}))
+	assert.Equal("sydney", match(Location{Country: "AU"}), "Region and postcode rules only apply when the location includes them.")
+	assert.Equal("london", match(Location{Country: "GB", PostalCode: "SW1A"}), "Redacted postcodes match by prefix.")
+	assert.Equal("london", match(Location{Country: "GB", PostalCode: "ec1a 1bb"}))
+	assert.Equal("", match(Location{Country: "GB", PostalCode: "M1 1AE"}))
+	assert.Equal("", match(Location{Country: "NZ"}))
+}
+
+func TestAvailable(t *testing.T) {
+	assert := assert.New(t)
+	zone := &Zone{
+		Rates: []Rate{
+			{ID: "standard", Rate: 800, PerKilogram: 200, MaxWeight: 20000, FreeShippingThreshold: 10000, Delivery: &DeliveryWindow{MinDays: 3, MaxDays: 7}},
+			{ID: "express", Rate: 1500, PerItem: 100, MaxItems: 5, MinOrder: 2000},
+			{ID: "freight", Rate: 5000, MinWeight: 20001},
+		},
+	}
+	ids := func(rates []Rate) []string {
+		var result []string
+		for _, rate := range rates {
+			result = append(result, rate.ID)
+		}
+		return result
+	}
+
+	order := &Order{Subtotal: 3000, Items: []Item{{Quantity: 2, Weight: 1200}, {Weight: 300}}}
+	assert.Equal(3, order.ItemCount())
+	assert.Equal(2700, order.Weight())
+	rates := zone.Available(order)
+	assert.Equal([]string{"standard", "express"}, ids(rates))
+	assert.Equal(800+3*200, rates[0].Rate, "Part kilograms are charged as a whole kilogram.")
+	assert.Equal(1500+3*100, rates[1].Rate)
+	assert.Equal("3-7 days", rates[0].Delivery.String())
+
+	order.Subtotal = 12000
+	assert.Equal(0, zone.Available(order)[0].Rate, "Orders over the threshold ship for free.")
+
+	order = &Order{Subtotal: 3000, Items: []Item{{Quantity: 6, Weight: 4000}}}
+	assert.Equal([]string{"freight"}, ids(zone.Available(order)))
+
+	order = &Order{Subtotal: 1000}
+	assert.Equal([]string{"standard", "freight"}, ids(zone.Available(order)), "Item and weight limits only apply when the items are known.")
+}
+
+func TestDeliveryWindow(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("", (*DeliveryWindow)(nil).String())
+	assert.Equal("1 day", (&DeliveryWindow{MinDays: 1, MaxDays: 1}).String())
+	assert.Equal("2 days", (&DeliveryWindow{MinDays: 2}).String())
+	assert.Equal("1-3 days", (&DeliveryWindow{MinDays: 1, MaxDays: 3}).String())
+}
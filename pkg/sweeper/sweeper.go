@@ -0,0 +1,69 @@
+// Package sweeper purges the payment data of expired payment tokens.
+// The tokens themselves are kept, as charges made against them refer to their metadata.
+package sweeper
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/rsoury/buyte/buyte"
+)
+
+// Store lists and purges expired payment tokens. ie. *graphql.Client of store
+type Store interface {
+	ListExpiredPaymentTokens(ctx context.Context, before time.Time, limit int, nextToken string) ([]*buyte.PaymentToken, string, error)
+	PurgePaymentToken(ctx context.Context, paymentTokenId string, purgedAt time.Time) error
+}
+
+type Sweeper struct {
+	store  Store
+	logger *zap.SugaredLogger
+	// Number of tokens listed per page
+	batch int
+	now   func() time.Time
+}
+
+func New(store Store, batch int) *Sweeper {
+	return &Sweeper{
+		store:  store,
+		logger: zap.S().With("package", "sweeper"),
+		batch:  batch,
+		now:    time.Now,
+	}
+}
+
+// Sweep purges every token expired as of now, returning the number purged.
+// A token that cannot be purged does not stop the sweep. It is left for the next one.
+func (s *Sweeper) Sweep(ctx context.Context) (int, error) {
+	now := s.now()
+	purged, failed := 0, 0
+	nextToken := ""
+	for {
+		paymentTokens, next, err := s.store.ListExpiredPaymentTokens(ctx, now, s.batch, nextToken)
+		if err != nil {
+			return purged, errors.Wrap(err, "Could not list expired payment tokens")
+		}
+		for _, paymentToken := range paymentTokens {
+			if err := s.store.PurgePaymentToken(ctx, paymentToken.ID, now); err != nil {
+				s.logger.Warnw("Sweep", "message", "Could not purge payment token", "token", paymentToken.ID, "error", err)
+				failed++
+				continue
+			}
+			purged++
+		}
+		if next == "" {
+			break
+		}
+		nextToken = next
+	}
+
+	s.logger.Infow("Sweep", "purged", purged, "failed", failed)
+
+	if failed > 0 {
+		return purged, errors.Errorf("Could not purge %d expired payment tokens", failed)
+	}
+	return purged, nil
+}
@@ -0,0 +1,80 @@
+package sweeper
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rsoury/buyte/buyte"
+)
+
+// Stands in for the store, paging through expired tokens.
+type standIn struct {
+	expired []*buyte.PaymentToken
+	purged  map[string]time.Time
+	failing map[string]bool
+	before  time.Time
+	pages   int
+}
+
+func (s *standIn) ListExpiredPaymentTokens(ctx context.Context, before time.Time, limit int, nextToken string) ([]*buyte.PaymentToken, string, error) {
+	s.before = before
+	s.pages++
+	start, _ := strconv.Atoi(nextToken)
+	end := start + limit
+	if end >= len(s.expired) {
+		return s.expired[start:], "", nil
+	}
+	return s.expired[start:end], strconv.Itoa(end), nil
+}
+
+func (s *standIn) PurgePaymentToken(ctx context.Context, paymentTokenId string, purgedAt time.Time) error {
+	if s.failing[paymentTokenId] {
+		return errors.New("Not Authorized")
+	}
+	s.purged[paymentTokenId] = purgedAt
+	return nil
+}
+
+func newStandIn(count int) *standIn {
+	store := &standIn{
+		purged:  map[string]time.Time{},
+		failing: map[string]bool{},
+	}
+	for i := 0; i < count; i++ {
+		store.expired = append(store.expired, &buyte.PaymentToken{ID: "tok_" + strconv.Itoa(i)})
+	}
+	return store
+}
+
+func TestSweep(t *testing.T) {
+	assert := assert.New(t)
+	store := newStandIn(5)
+	sweeper := New(store, 2)
+	now := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	sweeper.now = func() time.Time { return now }
+
+	purged, err := sweeper.Sweep(context.Background())
+	assert.NoError(err)
+	assert.Equal(5, purged)
+	assert.Equal(3, store.pages, "Every page of expired tokens should be swept.")
+	assert.Equal(now, store.before)
+	assert.Equal(now, store.purged["tok_4"])
+}
+
+func TestSweepContinuesPastFailures(t *testing.T) {
+	assert := assert.New(t)
+	store := newStandIn(3)
+	store.failing["tok_1"] = true
+	sweeper := New(store, 10)
+
+	purged, err := sweeper.Sweep(context.Background())
+	assert.Error(err)
+	assert.Equal(2, purged)
+	assert.Contains(store.purged, "tok_2", "Tokens after a failure should still be purged.")
+	assert.NotContains(store.purged, "tok_1")
+}
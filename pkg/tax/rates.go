@@ -0,0 +1,37 @@
+package tax
+
+// Member states of the European Union. Merchants in any member state collect the VAT of the member state an order ships to, under the One Stop Shop.
+var euMemberStates = []string{
+	"AT", "BE", "BG", "HR", "CY", "CZ", "DK", "EE", "FI", "FR", "DE", "GR", "HU", "IE",
+	"IT", "LV", "LT", "LU", "MT", "NL", "PL", "PT", "RO", "SK", "SI", "ES", "SE",
+}
+
+// Standard VAT rates of each member state, as a percentage. Reduced rates are set with "tax.rates".
+var euVATRates = map[string]float64{
+	"AT": 20, "BE": 21, "BG": 20, "HR": 25, "CY": 19, "CZ": 21, "DK": 25, "EE": 24, "FI": 25.5,
+	"FR": 20, "DE": 19, "GR": 24, "HU": 27, "IE": 23, "IT": 22, "LV": 21, "LT": 21, "LU": 17,
+	"MT": 18, "NL": 21, "PL": 23, "PT": 23, "RO": 21, "SK": 23, "SI": 22, "ES": 21, "SE": 25,
+}
+
+// DefaultRates are the standard rates of GST in Australia and New Zealand, and of VAT in the United Kingdom and the EU.
+// Prices include them, and shipping is taxed at the same rate as the order's items.
+var DefaultRates = defaultRates()
+
+func defaultRates() []Rate {
+	rates := []Rate{
+		{Country: "AU", Origins: []string{"AU"}, Name: "GST", Rate: 10, Inclusive: true, Shipping: true},
+		{Country: "NZ", Origins: []string{"NZ"}, Name: "GST", Rate: 15, Inclusive: true, Shipping: true},
+		{Country: "GB", Origins: []string{"GB"}, Name: "VAT", Rate: 20, Inclusive: true, Shipping: true},
+	}
+	for _, country := range euMemberStates {
+		rates = append(rates, Rate{
+			Country:   country,
+			Origins:   euMemberStates,
+			Name:      "VAT",
+			Rate:      euVATRates[country],
+			Inclusive: true,
+			Shipping:  true,
+		})
+	}
+	return rates
+}
@@ -0,0 +1,246 @@
+// Package tax calculates the tax charged on an order, by the jurisdictions of the merchant and the address the order ships to.
+package tax
+
+import (
+	"context"
+	"math/big"
+	"strings"
+
+	"github.com/pkg/errors"
+	config "github.com/spf13/viper"
+)
+
+// Rounding modes, applied to each tax line.
+const (
+	RoundHalfUp   = "half_up"
+	RoundHalfEven = "half_even"
+	RoundUp       = "up"
+	RoundDown     = "down"
+)
+
+// Calculator calculates the tax of an order. Orders in jurisdictions without tax have an empty breakdown.
+type Calculator interface {
+	Calculate(ctx context.Context, input *Input) (*Breakdown, error)
+}
+
+// Location is a tax jurisdiction. ie. AU, or CA and BC
+type Location struct {
+	Country string
+	// State or province code, for jurisdictions taxing by region.
+	Region string
+}
+
+// Input is the order tax is calculated for.
+type Input struct {
+	// The merchant's country. Merchants only collect the taxes of the jurisdictions they are registered in.
+	Origin      string
+	Destination Location
+	// Amount of the order before shipping, in the currency's minor unit, as priced. ie. Including tax where prices are tax inclusive
+	Amount   int
+	Shipping int
+}
+
+// Breakdown is the tax of an order, by jurisdiction.
+type Breakdown struct {
+	Lines []Line `json:"lines" mapstructure:"lines"`
+	// Tax already included in the order's prices.
+	Included int `json:"included" mapstructure:"included"`
+	// Tax added to the order's prices.
+	Added int `json:"added" mapstructure:"added"`
+}
+
+type Line struct {
+	Name         string `json:"name" mapstructure:"name"`
+	Jurisdiction string `json:"jurisdiction" mapstructure:"jurisdiction"`
+	// Percentage. ie. 10 for 10%
+	Rate          float64 `json:"rate" mapstructure:"rate"`
+	Inclusive     bool    `json:"inclusive" mapstructure:"inclusive"`
+	TaxableAmount int     `json:"taxableAmount" mapstructure:"taxableAmount"`
+	Amount        int     `json:"amount" mapstructure:"amount"`
+}
+
+// Amount is the total tax of the order.
+func (b *Breakdown) Amount() int {
+	return b.Included + b.Added
+}
+
+// Total is the order's total with tax, for the amount and shipping the breakdown was calculated for.
+func (b *Breakdown) Total(amount int, shipping int) int {
+	return amount + shipping + b.Added
+}
+
+// IsEmpty checks whether any tax applies to the order.
+func (b *Breakdown) IsEmpty() bool {
+	return len(b.Lines) == 0
+}
+
+// Rate is the tax of a jurisdiction.
+type Rate struct {
+	Country string `mapstructure:"country"`
+	// Rates without a region apply to the whole country. Regional rates apply in addition to them. ie. Canadian GST and PST
+	Region string `mapstructure:"region"`
+	// Countries of the merchants collecting the tax. Every merchant when empty.
+	Origins []string `mapstructure:"origins"`
+	Name    string   `mapstructure:"name"`
+	// Percentage. ie. 10 for 10%
+	Rate float64 `mapstructure:"rate"`
+	// Prices include the tax. ie. GST in Australia, and VAT in the EU
+	Inclusive bool `mapstructure:"inclusive"`
+	// Shipping is taxed at the same rate as the order's items.
+	Shipping bool `mapstructure:"shipping"`
+	// How part minor units are rounded. Defaults to half_up.
+	Rounding string `mapstructure:"rounding"`
+}
+
+func (r *Rate) appliesTo(input *Input) bool {
+	if !strings.EqualFold(r.Country, input.Destination.Country) {
+		return false
+	}
+	if r.Region != "" && !strings.EqualFold(r.Region, strings.TrimSpace(input.Destination.Region)) {
+		return false
+	}
+	if len(r.Origins) == 0 {
+		return true
+	}
+	for _, origin := range r.Origins {
+		if strings.EqualFold(origin, input.Origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Rates are in hundredths of a percent, so are exact.
+func (r *Rate) basisPoints() int64 {
+	return int64(r.Rate*100 + 0.5)
+}
+
+func (r *Rate) jurisdiction() string {
+	if r.Region == "" {
+		return strings.ToUpper(r.Country)
+	}
+	return strings.ToUpper(r.Country) + "-" + strings.ToUpper(r.Region)
+}
+
+// Table calculates tax from a table of rates per jurisdiction.
+type Table struct {
+	Rates []Rate
+}
+
+// New creates the calculator configured by "tax". Returns nil when tax is not calculated.
+// Rates set by "tax.rates" replace the built-in rates. See DefaultRates
+func New() (*Table, error) {
+	if !config.GetBool("tax.enabled") {
+		return nil, nil
+	}
+	if !config.IsSet("tax.rates") {
+		return NewTable(DefaultRates)
+	}
+	var rates []Rate
+	if err := config.UnmarshalKey("tax.rates", &rates); err != nil {
+		return nil, errors.Wrap(err, "Invalid 'tax.rates'")
+	}
+	return NewTable(rates)
+}
+
+func NewTable(rates []Rate) (*Table, error) {
+	for _, rate := range rates {
+		if rate.Country == "" || rate.Name == "" {
+			return nil, errors.New("Tax rates require a country and name")
+		}
+		if rate.Rate < 0 {
+			return nil, errors.Errorf("Tax rate %s of %s is negative", rate.Name, rate.jurisdiction())
+		}
+		switch rate.Rounding {
+		case "", RoundHalfUp, RoundHalfEven, RoundUp, RoundDown:
+		default:
+			return nil, errors.Errorf("Tax rate %s of %s has an invalid rounding %q", rate.Name, rate.jurisdiction(), rate.Rounding)
+		}
+	}
+	return &Table{
+		Rates: rates,
+	}, nil
+}
+
+// Calculate calculates the tax of each rate applying to the order.
+// Inclusive tax is extracted from the amounts as priced. Exclusive tax is calculated on the amounts as priced, so jurisdictions should not mix both.
+func (t *Table) Calculate(ctx context.Context, input *Input) (*Breakdown, error) {
+	if input.Amount < 0 || input.Shipping < 0 {
+		return nil, errors.New("Taxable amounts must not be negative")
+	}
+	var rates []*Rate
+	for i := range t.Rates {
+		if t.Rates[i].appliesTo(input) {
+			rates = append(rates, &t.Rates[i])
+		}
+	}
+
+	// Prices including several taxes include each of them. ie. 10% and 5% inclusive taxes are 10/115 and 5/115 of the price.
+	var amountDivisor, shippingDivisor int64 = 10000, 10000
+	for _, rate := range rates {
+		if rate.Inclusive {
+			amountDivisor += rate.basisPoints()
+			if rate.Shipping {
+				shippingDivisor += rate.basisPoints()
+			}
+		}
+	}
+
+	breakdown := &Breakdown{
+		Lines: []Line{},
+	}
+	for _, rate := range rates {
+		amountDivisor, shippingDivisor := amountDivisor, shippingDivisor
+		if !rate.Inclusive {
+			amountDivisor, shippingDivisor = 10000, 10000
+		}
+		tax := big.NewRat(int64(input.Amount)*rate.basisPoints(), amountDivisor)
+		taxable := input.Amount
+		if rate.Shipping {
+			tax.Add(tax, big.NewRat(int64(input.Shipping)*rate.basisPoints(), shippingDivisor))
+			taxable += input.Shipping
+		}
+		line := Line{
+			Name:          rate.Name,
+			Jurisdiction:  rate.jurisdiction(),
+			Rate:          rate.Rate,
+			Inclusive:     rate.Inclusive,
+			TaxableAmount: taxable,
+			Amount:        round(tax, rate.Rounding),
+		}
+		breakdown.Lines = append(breakdown.Lines, line)
+		if line.Inclusive {
+			breakdown.Included += line.Amount
+		} else {
+			breakdown.Added += line.Amount
+		}
+	}
+	return breakdown, nil
+}
+
+// Rounds a non-negative amount to a whole minor unit.
+func round(amount *big.Rat, mode string) int {
+	quotient, remainder := new(big.Int).QuoRem(amount.Num(), amount.Denom(), new(big.Int))
+	result := int(quotient.Int64())
+	if remainder.Sign() == 0 {
+		return result
+	}
+	// Compare the remainder to half of the denominator.
+	half := new(big.Int).Mul(remainder, big.NewInt(2)).Cmp(amount.Denom())
+	switch mode {
+	case RoundDown:
+		return result
+	case RoundUp:
+		return result + 1
+	case RoundHalfEven:
+		if half > 0 || (half == 0 && result%2 == 1) {
+			return result + 1
+		}
+		return result
+	default:
+		if half >= 0 {
+			return result + 1
+		}
+		return result
+	}
+}
@@ -0,0 +1,83 @@
+package tax
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalculateInclusive(t *testing.T) {
+	assert := assert.New(t)
+	table, err := NewTable(DefaultRates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	breakdown, err := table.Calculate(ctx, &Input{Origin: "AU", Destination: Location{Country: "AU", Region: "NSW"}, Amount: 10000, Shipping: 1100})
+	assert.NoError(err)
+	if assert.Len(breakdown.Lines, 1) {
+		assert.Equal(Line{Name: "GST", Jurisdiction: "AU", Rate: 10, Inclusive: true, TaxableAmount: 11100, Amount: 1009}, breakdown.Lines[0])
+	}
+	assert.Equal(1009, breakdown.Included)
+	assert.Equal(11100, breakdown.Total(10000, 1100), "Inclusive tax does not change the total.")
+
+	breakdown, err = table.Calculate(ctx, &Input{Origin: "AU", Destination: Location{Country: "NZ"}, Amount: 10000})
+	assert.NoError(err)
+	assert.True(breakdown.IsEmpty(), "Merchants only collect the taxes of their own jurisdiction.")
+
+	breakdown, err = table.Calculate(ctx, &Input{Origin: "FR", Destination: Location{Country: "DE"}, Amount: 11900})
+	assert.NoError(err)
+	assert.Equal("VAT", breakdown.Lines[0].Name)
+	assert.Equal(1900, breakdown.Amount(), "EU merchants collect the VAT of the member state shipped to.")
+}
+
+func TestCalculateExclusive(t *testing.T) {
+	assert := assert.New(t)
+	table, err := NewTable([]Rate{
+		{Country: "CA", Name: "GST", Rate: 5},
+		{Country: "CA", Region: "BC", Name: "PST", Rate: 7, Rounding: RoundDown},
+		{Country: "CA", Region: "ON", Name: "HST", Rate: 13, Shipping: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	breakdown, err := table.Calculate(ctx, &Input{Origin: "CA", Destination: Location{Country: "CA", Region: "bc"}, Amount: 1999, Shipping: 500})
+	assert.NoError(err)
+	if assert.Len(breakdown.Lines, 2) {
+		assert.Equal(100, breakdown.Lines[0].Amount, "Shipping is only taxed where the rate taxes it.")
+		assert.Equal(1999, breakdown.Lines[0].TaxableAmount)
+		assert.Equal("CA-BC", breakdown.Lines[1].Jurisdiction)
+		assert.Equal(139, breakdown.Lines[1].Amount)
+	}
+	assert.Equal(239, breakdown.Added)
+	assert.Equal(1999+500+239, breakdown.Total(1999, 500))
+
+	breakdown, err = table.Calculate(ctx, &Input{Destination: Location{Country: "CA", Region: "ON"}, Amount: 1000, Shipping: 500})
+	assert.NoError(err)
+	assert.Equal(50+195, breakdown.Added)
+}
+
+func TestRound(t *testing.T) {
+	assert := assert.New(t)
+	half := big.NewRat(5, 2)
+	assert.Equal(3, round(half, RoundHalfUp))
+	assert.Equal(3, round(half, ""))
+	assert.Equal(2, round(half, RoundHalfEven))
+	assert.Equal(4, round(big.NewRat(7, 2), RoundHalfEven))
+	assert.Equal(2, round(big.NewRat(21, 10), RoundHalfUp))
+	assert.Equal(3, round(big.NewRat(21, 10), RoundUp))
+	assert.Equal(2, round(big.NewRat(29, 10), RoundDown))
+	assert.Equal(4, round(big.NewRat(4, 1), RoundUp))
+}
+
+func TestNewTable(t *testing.T) {
+	_, err := NewTable([]Rate{{Country: "AU", Name: "GST", Rate: 10, Rounding: "nearest"}})
+	assert.Error(t, err)
+	_, err = NewTable([]Rate{{Name: "GST", Rate: 10}})
+	assert.Error(t, err)
+}
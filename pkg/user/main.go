@@ -86,6 +86,12 @@ func FromContext(ctx context.Context) *User {
 	return ctx.Value("user").(*User)
 }
 
+// FromContextOk returns the user, and whether the request is made by a user at all. ie. Apple fetching a domain association file.
+func FromContextOk(ctx context.Context) (*User, bool) {
+	u, ok := ctx.Value("user").(*User)
+	return u, ok
+}
+
 func (u *User) WithContext(ctx context.Context) context.Context {
 	return context.WithValue(ctx, "user", u)
 }
@@ -0,0 +1,79 @@
+package util
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Currencies that have no minor unit, as per ISO 4217.
+var zeroDecimalCurrencies = map[string]bool{
+	"bif": true,
+	"clp": true,
+	"djf": true,
+	"gnf": true,
+	"jpy": true,
+	"kmf": true,
+	"krw": true,
+	"mga": true,
+	"pyg": true,
+	"rwf": true,
+	"ugx": true,
+	"vnd": true,
+	"vuv": true,
+	"xaf": true,
+	"xof": true,
+	"xpf": true,
+}
+
+// IsZeroDecimalCurrency reports whether amounts in the currency are already in whole units.
+func IsZeroDecimalCurrency(currency string) bool {
+	return zeroDecimalCurrencies[strings.ToLower(currency)]
+}
+
+// FormatAmount converts an amount in the currency's minor unit (ie. cents) to a decimal string. ie. 1250 AUD -> "12.50"
+func FormatAmount(amount int, currency string) string {
+	if IsZeroDecimalCurrency(currency) {
+		return strconv.Itoa(amount)
+	}
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	return sign + strconv.Itoa(amount/100) + "." + Rjust(strconv.Itoa(amount%100), 2, "0")
+}
+
+// ParseAmount converts a decimal string to an amount in the currency's minor unit. ie. "12.50" AUD -> 1250
+func ParseAmount(value string, currency string) (int, error) {
+	value = strings.TrimSpace(value)
+	if IsZeroDecimalCurrency(currency) {
+		return strconv.Atoi(value)
+	}
+	negative := strings.HasPrefix(value, "-")
+	value = strings.TrimPrefix(value, "-")
+	whole, fraction := value, ""
+	if i := strings.Index(value, "."); i >= 0 {
+		whole, fraction = value[:i], value[i+1:]
+	}
+	if len(fraction) > 2 {
+		return 0, errors.New("Amount " + value + " has more than two decimal places")
+	}
+	units, err := strconv.Atoi(whole)
+	if err != nil {
+		return 0, err
+	}
+	cents := 0
+	if fraction != "" {
+		cents, err = strconv.Atoi(fraction + strings.Repeat("0", 2-len(fraction)))
+		if err != nil {
+			return 0, err
+		}
+	}
+	amount := units*100 + cents
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
@@ -0,0 +1,37 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatAmount(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("12.50", FormatAmount(1250, "aud"), "Minor units should be formatted with two decimal places.")
+	assert.Equal("0.05", FormatAmount(5, "AUD"), "Amounts less than a dollar should be left padded.")
+	assert.Equal("1250", FormatAmount(1250, "jpy"), "Zero decimal currencies should not be divided.")
+}
+
+func TestParseAmount(t *testing.T) {
+	assert := assert.New(t)
+
+	amount, err := ParseAmount("12.50", "AUD")
+	assert.NoError(err)
+	assert.Equal(1250, amount)
+	amount, err = ParseAmount("2000", "aud")
+	assert.NoError(err)
+	assert.Equal(200000, amount, "Whole amounts should be converted to the minor unit.")
+	amount, err = ParseAmount("0.5", "aud")
+	assert.NoError(err)
+	assert.Equal(50, amount)
+	amount, err = ParseAmount("1250", "jpy")
+	assert.NoError(err)
+	assert.Equal(1250, amount, "Zero decimal currencies should not be multiplied.")
+
+	_, err = ParseAmount("1.005", "aud")
+	assert.Error(err)
+	_, err = ParseAmount("abc", "aud")
+	assert.Error(err)
+}
@@ -0,0 +1,85 @@
+package view
+
+import (
+	"github.com/rsoury/buyte/buyte"
+)
+
+// Nested objects a charge may be expanded with.
+const (
+	ExpandSource = "source"
+)
+
+// ChargeExpandable are the nested objects charges may be expanded with.
+var ChargeExpandable = []string{ExpandSource}
+
+// Charge is a charge, without the credentials its gateway stored for later merchant initiated charges.
+type Charge struct {
+	ID             string                  `json:"id"`
+	Object         string                  `json:"object"`
+	Source         *ChargeSource           `json:"source"`
+	Amount         int                     `json:"amount"`
+	FeeAmount      int                     `json:"feeAmount"`
+	Currency       string                  `json:"currency"`
+	Captured       bool                    `json:"captured"`
+	ProviderCharge *ProviderCharge         `json:"providerCharge,omitempty"`
+	Description    string                  `json:"description"`
+	Customer       *buyte.Customer         `json:"customer"`
+	Metadata       map[string]interface{}  `json:"metadata"`
+	Order          *buyte.ChargeOrder      `json:"order,omitempty"`
+	Agreement      *buyte.PaymentAgreement `json:"agreement,omitempty"`
+	InitialCharge  string                  `json:"initialCharge,omitempty"`
+	CreatedAt      string                  `json:"createdAt"`
+}
+
+// ChargeSource is the payment token charged. Only its ID, unless expanded.
+type ChargeSource struct {
+	ID             string                      `json:"id"`
+	PaymentMethod  *PaymentMethod              `json:"paymentMethod,omitempty"`
+	ShippingMethod *buyte.PaymentTokenShipping `json:"shippingMethod,omitempty"`
+	Checkout       *CheckoutSummary            `json:"checkout,omitempty"`
+}
+
+// ProviderCharge is the gateway's record of the charge.
+type ProviderCharge struct {
+	Reference    string `json:"reference"`
+	Type         string `json:"type"`
+	ConnectionId string `json:"connectionId,omitempty"`
+}
+
+func NewCharge(charge *buyte.Charge, expand Expand) *Charge {
+	view := &Charge{
+		ID:            charge.ID,
+		Object:        charge.Object,
+		Amount:        charge.Amount,
+		FeeAmount:     charge.FeeAmount,
+		Currency:      charge.Currency,
+		Captured:      charge.Captured,
+		Description:   charge.Description,
+		Customer:      charge.Customer,
+		Metadata:      charge.Metadata,
+		Order:         charge.Order,
+		Agreement:     charge.Agreement,
+		InitialCharge: charge.InitialCharge,
+		CreatedAt:     charge.CreatedAt,
+	}
+	if charge.Source != nil {
+		view.Source = &ChargeSource{
+			ID: charge.Source.ID,
+		}
+		if expand.Has(ExpandSource) {
+			view.Source.PaymentMethod = newPaymentMethod(charge.Source.PaymentMethod)
+			view.Source.ShippingMethod = charge.Source.ShippingMethod
+			if charge.Source.Checkout != nil {
+				view.Source.Checkout = newCheckoutSummary(charge.Source.Checkout, true)
+			}
+		}
+	}
+	if charge.ProviderCharge != nil {
+		view.ProviderCharge = &ProviderCharge{
+			Reference:    charge.ProviderCharge.Reference,
+			Type:         charge.ProviderCharge.Type,
+			ConnectionId: charge.ProviderCharge.ConnectionId,
+		}
+	}
+	return view
+}
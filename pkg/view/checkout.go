@@ -0,0 +1,68 @@
+package view
+
+import (
+	"github.com/rsoury/buyte/buyte"
+)
+
+// CheckoutSummary is the checkout a token or charge was made with. Only its ID, unless expanded.
+type CheckoutSummary struct {
+	ID          string `json:"id"`
+	Label       string `json:"label,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Checkout is a checkout as its widget sees it. The gateway is only represented by its publishable key.
+type Checkout struct {
+	ID              string                             `json:"id"`
+	Object          string                             `json:"object"`
+	Options         []buyte.FullCheckoutOptionResponse `json:"options"`
+	ShippingMethods []buyte.FullCheckoutShippingMethod `json:"shippingMethods"`
+	GatewayProvider GatewayProvider                    `json:"gatewayProvider"`
+	Currency        string                             `json:"currency"`
+	Country         string                             `json:"country"`
+	Merchant        buyte.FullCheckoutMerchant         `json:"merchant"`
+	CustomCSS       string                             `json:"customCss"`
+	Requirements    *buyte.CheckoutRequirements        `json:"requirements,omitempty"`
+}
+
+type GatewayProvider struct {
+	ID             string            `json:"id"`
+	Type           string            `json:"type"`
+	Name           string            `json:"name"`
+	PublicKey      string            `json:"publicKey"`
+	IsTest         bool              `json:"isTest"`
+	AdditionalData map[string]string `json:"additionalData,omitempty"`
+}
+
+func NewCheckout(checkout *buyte.FullCheckout) *Checkout {
+	return &Checkout{
+		ID:              checkout.ID,
+		Object:          checkout.Object,
+		Options:         checkout.Options,
+		ShippingMethods: checkout.ShippingMethods,
+		GatewayProvider: GatewayProvider{
+			ID:             checkout.GatewayProvider.ID,
+			Type:           checkout.GatewayProvider.Type,
+			Name:           checkout.GatewayProvider.Name,
+			PublicKey:      checkout.GatewayProvider.PublicKey,
+			IsTest:         checkout.GatewayProvider.IsTest,
+			AdditionalData: checkout.GatewayProvider.AdditionalData,
+		},
+		Currency:     checkout.Currency,
+		Country:      checkout.Country,
+		Merchant:     checkout.Merchant,
+		CustomCSS:    checkout.CustomCSS,
+		Requirements: checkout.Requirements,
+	}
+}
+
+func newCheckoutSummary(checkout *buyte.PaymentTokenBaseCheckout, expanded bool) *CheckoutSummary {
+	summary := &CheckoutSummary{
+		ID: checkout.ID,
+	}
+	if expanded {
+		summary.Label = checkout.Label
+		summary.Description = checkout.Description
+	}
+	return summary
+}
@@ -0,0 +1,93 @@
+package view
+
+import (
+	"github.com/rsoury/buyte/buyte"
+)
+
+// Nested objects a token or charge may be expanded with.
+const (
+	ExpandCheckout  = "checkout"
+	ExpandCustomer  = "customer"
+	ExpandAgreement = "agreement"
+)
+
+// TokenExpandable are the nested objects GET /token/{id} may be expanded with.
+var TokenExpandable = []string{ExpandCheckout, ExpandCustomer, ExpandAgreement}
+
+// Token is a payment token, without the wallet's payment data.
+type Token struct {
+	ID             string                      `json:"id"`
+	Object         string                      `json:"object"`
+	Amount         int                         `json:"amount"`
+	Currency       string                      `json:"currency"`
+	Status         string                      `json:"status"`
+	ExpiresAt      string                      `json:"expiresAt,omitempty"`
+	PaymentMethod  *PaymentMethod              `json:"paymentMethod,omitempty"`
+	ShippingMethod *buyte.PaymentTokenShipping `json:"shippingMethod,omitempty"`
+	// Only the checkout's ID, unless expanded.
+	Checkout  *CheckoutSummary        `json:"checkout,omitempty"`
+	Customer  *buyte.Customer         `json:"customer,omitempty"`
+	Agreement *buyte.PaymentAgreement `json:"agreement,omitempty"`
+}
+
+// PublicToken is a payment token as the customer's browser sees it, once their payment is processed.
+type PublicToken struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"`
+	Amount    int    `json:"amount"`
+	Currency  string `json:"currency"`
+	Status    string `json:"status"`
+	ExpiresAt string `json:"expiresAt,omitempty"`
+}
+
+type PaymentMethod struct {
+	Name string `json:"name"`
+}
+
+// NewToken represents the token. The customer is only read from the token's payment data when expanded, so is given by the caller.
+func NewToken(paymentToken *buyte.PaymentToken, customer *buyte.Customer, expand Expand) *Token {
+	token := &Token{
+		ID:             paymentToken.ID,
+		Object:         paymentToken.Object,
+		Amount:         paymentToken.Amount,
+		Currency:       paymentToken.Currency,
+		Status:         paymentToken.Status,
+		ExpiresAt:      paymentToken.ExpiresAt,
+		PaymentMethod:  newPaymentMethod(paymentToken.PaymentMethod),
+		ShippingMethod: paymentToken.ShippingMethod,
+	}
+	if paymentToken.Checkout != nil {
+		token.Checkout = newCheckoutSummary(&buyte.PaymentTokenBaseCheckout{
+			ID:          paymentToken.Checkout.ID,
+			Label:       paymentToken.Checkout.Label,
+			Description: paymentToken.Checkout.Description,
+		}, expand.Has(ExpandCheckout))
+	}
+	if expand.Has(ExpandCustomer) {
+		token.Customer = customer
+	}
+	if expand.Has(ExpandAgreement) {
+		token.Agreement = paymentToken.Agreement
+	}
+	return token
+}
+
+func NewPublicToken(paymentToken *buyte.PaymentToken) *PublicToken {
+	return &PublicToken{
+		ID:        paymentToken.ID,
+		Object:    paymentToken.Object,
+		Amount:    paymentToken.Amount,
+		Currency:  paymentToken.Currency,
+		Status:    paymentToken.Status,
+		ExpiresAt: paymentToken.ExpiresAt,
+	}
+}
+
+func newPaymentMethod(paymentMethod *buyte.PaymentMethod) *PaymentMethod {
+	if paymentMethod == nil {
+		return nil
+	}
+	return &PaymentMethod{
+		Name: paymentMethod.Name,
+	}
+}
@@ -0,0 +1,47 @@
+// Package view holds the representations API responses are rendered with.
+// Views never include secrets, such as a connection's credentials, or the raw payment data of a wallet. Nested objects are only included when requested.
+package view
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Expand is the set of nested objects a request opts in to. ie. ?expand[]=checkout&expand[]=customer
+type Expand map[string]bool
+
+// ParseExpand reads the nested objects requested, rejecting any the view cannot include.
+func ParseExpand(query url.Values, allowed ...string) (Expand, error) {
+	expand := Expand{}
+	for _, key := range []string{"expand[]", "expand"} {
+		for _, value := range query[key] {
+			for _, field := range strings.Split(value, ",") {
+				field = strings.TrimSpace(field)
+				if field == "" {
+					continue
+				}
+				if !contains(allowed, field) {
+					return nil, errors.Errorf("Cannot expand %s. Expandable fields are: %s", field, strings.Join(allowed, ", "))
+				}
+				expand[field] = true
+			}
+		}
+	}
+	return expand, nil
+}
+
+// Has checks whether the nested object was requested.
+func (e Expand) Has(field string) bool {
+	return e[field]
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
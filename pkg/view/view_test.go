@@ -0,0 +1,207 @@
+package view
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rsoury/buyte/buyte"
+)
+
+// Every response type. Views added to the package must be added here, or TestResponsesAreChecked fails.
+var responses = []interface{}{
+	Token{},
+	PublicToken{},
+	Charge{},
+	Checkout{},
+}
+
+// Fields that hold secrets or raw payment data, by name or JSON name, in lower case.
+var credentialFields = []string{
+	"credentials",
+	"value",
+	"rawpaymentrequest",
+	"gatewaytoken",
+	"secret",
+	"secretkey",
+	"password",
+	"accesstoken",
+	"apikey",
+	"privatekey",
+	"customerreference",
+	"paymentmethodreference",
+}
+
+// Types that hold secrets or raw payment data, and so must never be rendered.
+var credentialTypes = []reflect.Type{
+	reflect.TypeOf(buyte.PaymentToken{}),
+	reflect.TypeOf(buyte.ProviderCheckoutConnection{}),
+	reflect.TypeOf(buyte.PaymentTokenCheckout{}),
+	reflect.TypeOf(buyte.GatewayCharge{}),
+}
+
+// Walks every type reachable from t, calling visit with the path to each struct field.
+func walk(t reflect.Type, path string, seen map[reflect.Type]bool, visit func(path string, t reflect.Type, field reflect.StructField)) {
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || seen[t] {
+		return
+	}
+	seen[t] = true
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		fieldPath := path + "." + field.Name
+		visit(fieldPath, t, field)
+		walk(field.Type, fieldPath, seen, visit)
+	}
+}
+
+func TestResponsesHaveNoCredentialFields(t *testing.T) {
+	for _, response := range responses {
+		root := reflect.TypeOf(response)
+		walk(root, root.Name(), map[reflect.Type]bool{}, func(path string, parent reflect.Type, field reflect.StructField) {
+			jsonName := strings.Split(field.Tag.Get("json"), ",")[0]
+			for _, name := range []string{field.Name, jsonName} {
+				for _, credential := range credentialFields {
+					if strings.ToLower(name) == credential {
+						t.Errorf("%s renders credential field %q", path, name)
+					}
+				}
+			}
+			fieldType := field.Type
+			for fieldType.Kind() == reflect.Ptr || fieldType.Kind() == reflect.Slice {
+				fieldType = fieldType.Elem()
+			}
+			for _, credentialType := range credentialTypes {
+				if fieldType == credentialType {
+					t.Errorf("%s renders %s", path, credentialType)
+				}
+			}
+		})
+	}
+}
+
+// Every exported struct declared by the package should be reachable from a response, so none escape the check above.
+func TestResponsesAreChecked(t *testing.T) {
+	reachable := map[string]bool{}
+	for _, response := range responses {
+		root := reflect.TypeOf(response)
+		reachable[root.Name()] = true
+		walk(root, root.Name(), map[reflect.Type]bool{}, func(path string, parent reflect.Type, field reflect.StructField) {
+			reachable[parent.Name()] = true
+			fieldType := field.Type
+			for fieldType.Kind() == reflect.Ptr || fieldType.Kind() == reflect.Slice {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.PkgPath() == root.PkgPath() {
+				reachable[fieldType.Name()] = true
+			}
+		})
+	}
+
+	packages, err := parser.ParseDir(token.NewFileSet(), ".", func(info os.FileInfo) bool {
+		return !strings.HasSuffix(info.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pkg := range packages {
+		ast.Inspect(pkg, func(node ast.Node) bool {
+			spec, ok := node.(*ast.TypeSpec)
+			if !ok {
+				return true
+			}
+			if _, isStruct := spec.Type.(*ast.StructType); isStruct && spec.Name.IsExported() {
+				assert.True(t, reachable[spec.Name.Name], "%s is not checked for credential fields. Add it to responses.", spec.Name.Name)
+			}
+			return true
+		})
+	}
+}
+
+func TestNewToken(t *testing.T) {
+	assert := assert.New(t)
+	paymentToken := &buyte.PaymentToken{
+		ID:            "tok_xxx",
+		Object:        buyte.PAYMENT_TOKEN,
+		Value:         `{"token":"chargeable"}`,
+		GatewayToken:  "nonce_xxx",
+		Amount:        1000,
+		Currency:      "aud",
+		Status:        buyte.PAYMENT_TOKEN_ACTIVE,
+		PaymentMethod: &buyte.PaymentMethod{Name: buyte.GOOGLE_PAY},
+		Agreement:     &buyte.PaymentAgreement{Type: "recurring"},
+		Checkout: &buyte.PaymentTokenCheckout{
+			ID:    "checkout_xxx",
+			Label: "Store",
+			Connection: &buyte.ProviderCheckoutConnection{
+				Credentials: `{"secretKey":"sk_live_xxx"}`,
+			},
+		},
+	}
+	customer := &buyte.Customer{Name: "Jane Citizen"}
+
+	token := NewToken(paymentToken, customer, Expand{})
+	assert.Equal(&CheckoutSummary{ID: "checkout_xxx"}, token.Checkout)
+	assert.Nil(token.Customer, "Nested objects are only included when expanded.")
+	assert.Nil(token.Agreement)
+
+	token = NewToken(paymentToken, customer, Expand{ExpandCheckout: true, ExpandCustomer: true, ExpandAgreement: true})
+	assert.Equal("Store", token.Checkout.Label)
+	assert.Equal(customer, token.Customer)
+	assert.Equal("recurring", token.Agreement.Type)
+}
+
+func TestNewCharge(t *testing.T) {
+	assert := assert.New(t)
+	charge := &buyte.Charge{
+		ID: "ch_xxx",
+		Source: &buyte.ChargeSource{
+			ID:            "tok_xxx",
+			PaymentMethod: &buyte.PaymentMethod{Name: buyte.APPLE_PAY},
+			Checkout:      &buyte.PaymentTokenBaseCheckout{ID: "checkout_xxx", Label: "Store"},
+		},
+		ProviderCharge: &buyte.GatewayCharge{
+			Reference:              "pi_xxx",
+			Type:                   buyte.STRIPE,
+			CustomerReference:      "cus_xxx",
+			PaymentMethodReference: "pm_xxx",
+		},
+	}
+
+	view := NewCharge(charge, Expand{})
+	assert.Equal(&ChargeSource{ID: "tok_xxx"}, view.Source)
+	assert.Equal(&ProviderCharge{Reference: "pi_xxx", Type: buyte.STRIPE}, view.ProviderCharge)
+
+	view = NewCharge(charge, Expand{ExpandSource: true})
+	assert.Equal(buyte.APPLE_PAY, view.Source.PaymentMethod.Name)
+	assert.Equal("Store", view.Source.Checkout.Label)
+}
+
+func TestParseExpand(t *testing.T) {
+	assert := assert.New(t)
+	expand, err := ParseExpand(url.Values{"expand[]": {"checkout", "customer"}}, TokenExpandable...)
+	assert.NoError(err)
+	assert.True(expand.Has(ExpandCheckout))
+	assert.True(expand.Has(ExpandCustomer))
+	assert.False(expand.Has(ExpandAgreement))
+
+	expand, err = ParseExpand(url.Values{"expand": {"agreement,checkout"}}, TokenExpandable...)
+	assert.NoError(err)
+	assert.True(expand.Has(ExpandAgreement))
+
+	_, err = ParseExpand(url.Values{"expand[]": {"checkout.connection"}}, TokenExpandable...)
+	assert.Error(err, "Only the view's expandable fields may be requested.")
+
+	expand, err = ParseExpand(url.Values{})
+	assert.NoError(err)
+	assert.Empty(expand)
+}
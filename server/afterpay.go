@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/go-chi/render"
+	"github.com/pkg/errors"
+	config "github.com/spf13/viper"
+
+	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/afterpay"
+	afterpaygateway "github.com/rsoury/buyte/pkg/paymentgateway/afterpay"
+	"github.com/rsoury/buyte/pkg/paymentmethod"
+	"github.com/rsoury/buyte/store"
+)
+
+type CreateAfterpayCheckoutInput struct {
+	CheckoutId string `json:"checkoutId"`
+	Amount     int    `json:"amount"`
+	Currency   string `json:"currency"`
+	// Where Afterpay returns the customer to with the checkout's orderToken and status, once confirmed.
+	ReturnURL string `json:"returnUrl"`
+	// Where Afterpay returns the customer to if they cancel. Defaults to the return URL.
+	CancelURL string             `json:"cancelUrl,omitempty"`
+	Consumer  *afterpay.Consumer `json:"consumer,omitempty"`
+	Shipping  *afterpay.Contact  `json:"shipping,omitempty"`
+	Reference string             `json:"reference,omitempty"`
+}
+type AfterpayCheckoutResponse struct {
+	Token               string `json:"token"`
+	Expires             string `json:"expires"`
+	RedirectCheckoutURL string `json:"redirectCheckoutUrl"`
+}
+
+// CreateAfterpayCheckout creates the checkout the customer is redirected to Afterpay to confirm.
+// Afterpay returns the customer to the return URL, from where the widget processes the result at /public/afterpay/process.
+func (s *Server) CreateAfterpayCheckout() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		input := &CreateAfterpayCheckoutInput{}
+		if err := render.DecodeJSON(r.Body, input); err != nil {
+			_ = render.Render(w, r, s.ErrInvalidRequest(err))
+			return
+		}
+		if input.CheckoutId == "" || input.Currency == "" || input.Amount <= 0 {
+			_ = render.Render(w, r, s.ErrInvalidRequest(errors.New("Checkout ID, currency and a positive amount are required")))
+			return
+		}
+		if input.CancelURL == "" {
+			input.CancelURL = input.ReturnURL
+		}
+		for _, returnURL := range []string{input.ReturnURL, input.CancelURL} {
+			if err := validateReturnURL(returnURL); err != nil {
+				_ = render.Render(w, r, s.ErrInvalidRequest(err))
+				return
+			}
+		}
+
+		gateway, err := s.afterpayGateway(r.Context(), input.CheckoutId, input.Currency, input.Amount)
+		if err != nil {
+			if store.IsConnectionUnauthorized(err) || err == store.ErrNotFound {
+				_ = render.Render(w, r, ErrNotFound)
+			} else if err == ErrNoConnection {
+				_ = render.Render(w, r, s.ErrInvalidRequestWithMessage(err))
+			} else {
+				_ = render.Render(w, r, s.ErrInternalServer(err))
+			}
+			return
+		}
+		limits, err := gateway.Limits()
+		if err != nil {
+			if buyte.IsGatewayUnavailable(err) {
+				_ = render.Render(w, r, s.ErrGatewayUnavailable(err))
+			} else {
+				_ = render.Render(w, r, s.ErrRequestFailed(err))
+			}
+			return
+		}
+		if !limits.Allows(input.Amount, input.Currency) {
+			_ = render.Render(w, r, s.ErrInvalidRequestWithMessage(errors.New("Order total is not eligible for Afterpay")))
+			return
+		}
+
+		params := afterpaygateway.NewCheckoutParams(input.Amount, input.Currency, input.ReturnURL, input.CancelURL)
+		params.Consumer = input.Consumer
+		params.Shipping = input.Shipping
+		params.MerchantReference = input.Reference
+		checkout, err := gateway.CreateCheckout(params)
+		if err != nil {
+			if buyte.IsGatewayUnavailable(err) {
+				_ = render.Render(w, r, s.ErrGatewayUnavailable(err))
+			} else {
+				_ = render.Render(w, r, s.ErrRequestFailed(err))
+			}
+			return
+		}
+
+		s.logger.Infow("Create Afterpay Checkout", "checkout", input.CheckoutId, "token", checkout.Token, "connection", gateway.ConnectionId)
+
+		render.JSON(w, r, &AfterpayCheckoutResponse{
+			Token:               checkout.Token,
+			Expires:             checkout.Expires,
+			RedirectCheckoutURL: checkout.RedirectCheckoutURL,
+		})
+	}
+}
+
+// Customers may only be returned to absolute URLs, served over HTTPS in production.
+func validateReturnURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil || !u.IsAbs() || u.Host == "" {
+		return errors.New("An absolute returnUrl is required")
+	}
+	if u.Scheme != "https" && (config.GetBool("server.production") || u.Scheme != "http") {
+		return errors.Errorf("returnUrl must be served over HTTPS: %s", value)
+	}
+	return nil
+}
+
+// The Afterpay connection a checkout's Afterpay checkouts are created, read and captured through.
+func (s *Server) afterpayGateway(ctx context.Context, checkoutId string, currency string, amount int) (*afterpaygateway.Gateway, error) {
+	connection, err := s.routedConnection(ctx, checkoutId, buyte.AFTERPAY, currency, amount)
+	if err != nil {
+		return nil, err
+	}
+	return afterpaygateway.New(ctx, connection)
+}
+
+// The order totals the checkout's Afterpay connection accepts in the currency.
+// Routed without an amount, as the total is not known until the customer pays.
+func (s *Server) afterpayLimits(ctx context.Context, checkoutId string, currency string) (*afterpay.Limits, error) {
+	gateway, err := s.afterpayGateway(ctx, checkoutId, currency, 0)
+	if err != nil {
+		return nil, err
+	}
+	return gateway.Limits()
+}
+
+// Read confirmed checkouts through the checkout's Afterpay connection. See paymentmethod.Afterpay
+func (s *Server) afterpayCheckouts(ctx context.Context, checkoutId string, currency string, amount int) (paymentmethod.AfterpayCheckouts, error) {
+	gateway, err := s.afterpayGateway(ctx, checkoutId, currency, amount)
+	if err != nil {
+		return nil, err
+	}
+	return gateway, nil
+}
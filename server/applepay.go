@@ -1,12 +1,21 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"path"
+	"strings"
 
 	"github.com/go-chi/render"
+	"github.com/pkg/errors"
+	config "github.com/spf13/viper"
 
 	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/applepaydomain"
+	"github.com/rsoury/buyte/pkg/applepaymerchant"
+	"github.com/rsoury/buyte/pkg/user"
+	"github.com/rsoury/buyte/pkg/util"
 	"github.com/rsoury/buyte/store"
 )
 
@@ -25,16 +34,53 @@ type ApplePaySessionResponse struct {
 func (s *Server) GetApplePaySession() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		appleData := &struct {
-			URL string `json:"url"`
+			URL        string `json:"url"`
+			CheckoutId string `json:"checkoutId"`
+			Domain     string `json:"domain"`
 		}{}
 		if err := render.DecodeJSON(r.Body, appleData); err != nil {
 			_ = render.Render(w, r, s.ErrInvalidRequest(err))
 			return
 		}
 
-		payload, err := s.applepay.Session(appleData.URL)
+		// The URL comes from the browser. Only Apple's merchant validation hosts may receive a request signed with the merchant certificate.
+		sessionURL, err := s.sessionURLs.Validate(r.Context(), appleData.URL)
 		if err != nil {
-			_ = render.Render(w, r, s.ErrInternalServer(err))
+			_ = render.Render(w, r, s.ErrInvalidSessionURL(err))
+			return
+		}
+
+		identity, err := s.applePayIdentity(r.Context(), appleData.CheckoutId)
+		if err != nil {
+			if store.IsConnectionUnauthorized(err) {
+				_ = render.Render(w, r, ErrNotFound)
+			} else {
+				_ = render.Render(w, r, s.ErrInternalServer(err))
+			}
+			return
+		}
+		// Buyte's identity may start sessions on merchant domains registered with Apple.
+		if identity == s.applePayMerchants.Default && appleData.Domain != "" {
+			if _, err := identity.Domain(appleData.Domain); err == applepaymerchant.ErrDomainNotAllowed {
+				registered, err := s.store.GetApplePayDomain(r.Context(), strings.ToLower(appleData.Domain))
+				if err != nil {
+					_ = render.Render(w, r, s.ErrInternalServer(err))
+					return
+				}
+				if registered.Status == buyte.APPLE_PAY_DOMAIN_REGISTERED {
+					identity = identity.WithDomains(registered.Domain)
+				}
+			}
+		}
+		payload, err := identity.Session(r.Context(), sessionURL, appleData.Domain)
+		if err != nil {
+			if err == applepaymerchant.ErrDomainNotAllowed {
+				_ = render.Render(w, r, s.ErrInvalidRequest(err))
+			} else if applepaymerchant.IsInvalidSessionURL(err) {
+				_ = render.Render(w, r, s.ErrInvalidSessionURL(err))
+			} else {
+				_ = render.Render(w, r, s.ErrInternalServer(err))
+			}
 			return
 		}
 
@@ -51,32 +97,163 @@ func (s *Server) GetApplePaySession() http.HandlerFunc {
 	}
 }
 
-func (s *Server) ProcessApplePayResponse() http.HandlerFunc {
+// Obtain the Apple Pay identity for a checkout. Without a checkout, Buyte's identity is used.
+func (s *Server) applePayIdentity(ctx context.Context, checkoutId string) (*applepaymerchant.Identity, error) {
+	if checkoutId == "" {
+		return s.applePayMerchants.Default, nil
+	}
+	merchant, err := s.store.GetApplePayMerchant(ctx, checkoutId)
+	if err != nil {
+		return nil, err
+	}
+	return s.applePayMerchants.Resolve(ctx, merchant)
+}
+
+// Expose processing certificate expiry dates, so that they may be rotated before tokens start failing.
+func (s *Server) GetApplePayCertificates() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		response := &buyte.ApplePayAuthorizedPaymentResponse{}
-		if err := render.DecodeJSON(r.Body, response); err != nil {
+		render.JSON(w, r, s.keyring.Keys())
+	}
+}
+
+func (s *Server) CreateApplePayDomain() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		input := &struct {
+			Domain string `json:"domain"`
+			// Set when the domain is for the merchant's own Apple Pay identity, along with their association file.
+			ApplePayMerchantId string `json:"applePayMerchantId"`
+			AssociationFile    string `json:"associationFile"`
+		}{}
+		if err := render.DecodeJSON(r.Body, input); err != nil {
 			_ = render.Render(w, r, s.ErrInvalidRequest(err))
 			return
 		}
+		domain, err := applepaydomain.Normalise(input.Domain)
+		if err != nil {
+			_ = render.Render(w, r, s.ErrInvalidRequestWithMessage(err))
+			return
+		}
 
-		input := buyte.NewApplePayPaymentTokenInput(response)
-		paymentToken, err := s.store.CreatePaymentToken(r.Context(), input)
+		// Domains may only be registered by one merchant. Registering a domain twice returns the existing registration.
+		// Checked up front to avoid registering with Apple again. The store refuses to create a domain twice.
+		existing, err := s.store.GetApplePayDomain(r.Context(), domain)
 		if err != nil {
-			if store.IsConnectionInvalid(err) {
-				_ = render.Render(w, r, s.ErrInvalidRequest(err))
-			} else {
-				s.logger.Errorw("Create Payment Token from Apple Pay", "Params", input)
+			_ = render.Render(w, r, s.ErrInternalServer(err))
+			return
+		}
+		if existing.ID != "" {
+			s.renderExistingApplePayDomain(w, r, existing)
+			return
+		}
+
+		u := user.FromContext(r.Context())
+		params := &buyte.CreateApplePayDomainInput{
+			Domain: domain,
+		}
+		if input.ApplePayMerchantId != "" {
+			// The merchant registers the domain with Apple themselves. We host their association file, once they prove they own the domain.
+			if input.AssociationFile == "" {
+				_ = render.Render(w, r, s.ErrInvalidRequestWithMessage(errors.New("An association file is required for domains using your own Apple Pay identity")))
+				return
+			}
+			merchant, err := s.store.GetUserApplePayMerchant(r.Context(), input.ApplePayMerchantId)
+			if err != nil {
 				_ = render.Render(w, r, s.ErrInternalServer(err))
+				return
+			}
+			if merchant.ID == "" {
+				_ = render.Render(w, r, s.ErrInvalidRequestWithMessage(errors.Errorf("Apple Pay merchant %s not found", input.ApplePayMerchantId)))
+				return
 			}
+			token := applepaydomain.VerificationToken(u.ID, domain)
+			if err := s.domainVerifier.Verify(r.Context(), domain, token); err != nil {
+				_ = render.Render(w, r, s.ErrInvalidRequestWithMessage(errors.Wrapf(err, "Please serve %s at https://%s%s", token, domain, applepaydomain.VerificationPath)))
+				return
+			}
+			params.Status = buyte.APPLE_PAY_DOMAIN_HOSTED
+			params.ApplePayMerchantId = merchant.ID
+			params.AssociationFile = input.AssociationFile
+		} else {
+			// Apple verifies the domain forwards its association file request to Buyte.
+			err := s.registrar.Register(r.Context(), &applepaydomain.Registration{
+				Domains:                   []string{domain},
+				PartnerMerchantIdentifier: u.ID,
+				PartnerMerchantName:       u.UserAttributes.StoreName,
+				MerchantURL:               u.UserAttributes.Website,
+				EncryptTo:                 config.GetString("apple.merchant.id"),
+			})
+			if err != nil {
+				_ = render.Render(w, r, s.ErrInvalidRequestWithMessage(errors.Wrap(err, "Apple could not register the domain. Please ensure "+applepaydomain.AssociationPath+" is forwarded to Buyte")))
+				return
+			}
+			params.Status = buyte.APPLE_PAY_DOMAIN_REGISTERED
+		}
+
+		applePayDomain, err := s.store.CreateApplePayDomain(r.Context(), params)
+		if err == store.ErrAlreadyExists {
+			// Registered concurrently.
+			existing, err := s.store.GetApplePayDomain(r.Context(), domain)
+			if err != nil {
+				_ = render.Render(w, r, s.ErrInternalServer(err))
+				return
+			}
+			s.renderExistingApplePayDomain(w, r, existing)
+			return
+		}
+		if err != nil {
+			_ = render.Render(w, r, s.ErrInternalServer(err))
+			return
+		}
+		render.Status(r, http.StatusCreated)
+		render.JSON(w, r, applePayDomain)
+	}
+}
+
+// Render a domain's existing registration to the merchant registering it, unless it is registered by another merchant.
+func (s *Server) renderExistingApplePayDomain(w http.ResponseWriter, r *http.Request, existing *buyte.ApplePayDomain) {
+	owned, err := s.store.GetUserApplePayDomain(r.Context(), existing.Domain)
+	if err != nil {
+		_ = render.Render(w, r, s.ErrInternalServer(err))
+		return
+	}
+	if owned.ID != existing.ID {
+		_ = render.Render(w, r, s.ErrInvalidRequestWithMessage(errors.Errorf("%s is registered by another merchant", existing.Domain)))
+		return
+	}
+	render.JSON(w, r, existing)
+}
+
+// Serve the domain association file for the requested Host, so that Apple can verify merchant domains.
+func (s *Server) GetApplePayDomainAssociation() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		domain, err := applepaydomain.Normalise(r.Host)
+		if err != nil {
+			_ = render.Render(w, r, ErrNotFound)
+			return
+		}
+		applePayDomain, err := s.store.GetApplePayDomain(r.Context(), domain)
+		if err != nil {
+			_ = render.Render(w, r, s.ErrInternalServer(err))
 			return
 		}
 
-		// We now have the payment data.
-		render.JSON(w, r, &buyte.PublicPaymentToken{
-			ID:       paymentToken.ID,
-			Object:   paymentToken.Object,
-			Amount:   paymentToken.Amount,
-			Currency: paymentToken.Currency,
-		})
+		var association []byte
+		switch {
+		case applePayDomain.Status == buyte.APPLE_PAY_DOMAIN_HOSTED:
+			association = []byte(applePayDomain.AssociationFile)
+		case applePayDomain.Status == buyte.APPLE_PAY_DOMAIN_REGISTERED, strings.EqualFold(domain, config.GetString("apple.merchant.domain")):
+			association = s.domainAssociation
+		}
+		if len(association) == 0 {
+			if !config.GetBool("server.production") {
+				// Serve the development association file
+				http.ServeFile(w, r, path.Join(util.DirName(), "../examples/applepay", applepaydomain.AssociationPath+".txt"))
+				return
+			}
+			_ = render.Render(w, r, ErrNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write(association)
 	}
 }
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	config "github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/applepaydomain"
+	"github.com/rsoury/buyte/pkg/user"
+	"github.com/rsoury/buyte/store"
+)
+
+// Stands in for the store's Apple Pay domains and merchants, registered by merchant.
+type domainStore struct {
+	buyte.Store
+	domains   map[string]*buyte.ApplePayDomain
+	owners    map[string]string
+	merchants map[string]string
+}
+
+func (d *domainStore) CreateApplePayDomain(ctx context.Context, input *buyte.CreateApplePayDomainInput) (*buyte.ApplePayDomain, error) {
+	if _, ok := d.domains[input.Domain]; ok {
+		return &buyte.ApplePayDomain{}, store.ErrAlreadyExists
+	}
+	domain := &buyte.ApplePayDomain{
+		ID:                 "apd_" + input.Domain,
+		Object:             buyte.APPLE_PAY_DOMAIN,
+		Domain:             input.Domain,
+		Status:             input.Status,
+		ApplePayMerchantId: input.ApplePayMerchantId,
+		AssociationFile:    input.AssociationFile,
+	}
+	d.domains[input.Domain] = domain
+	d.owners[input.Domain] = user.FromContext(ctx).ID
+	return domain, nil
+}
+
+func (d *domainStore) GetApplePayDomain(ctx context.Context, domain string) (*buyte.ApplePayDomain, error) {
+	if registered, ok := d.domains[domain]; ok {
+		return registered, nil
+	}
+	return &buyte.ApplePayDomain{}, nil
+}
+
+func (d *domainStore) GetUserApplePayDomain(ctx context.Context, domain string) (*buyte.ApplePayDomain, error) {
+	if d.owners[domain] != user.FromContext(ctx).ID {
+		return &buyte.ApplePayDomain{}, nil
+	}
+	return d.GetApplePayDomain(ctx, domain)
+}
+
+func (d *domainStore) GetUserApplePayMerchant(ctx context.Context, id string) (*buyte.ApplePayMerchant, error) {
+	if d.merchants[id] != user.FromContext(ctx).ID {
+		return &buyte.ApplePayMerchant{}, nil
+	}
+	return &buyte.ApplePayMerchant{ID: id}, nil
+}
+
+// Stands in for merchant domains, serving the given verification tokens.
+type domainVerifier map[string]string
+
+func (v domainVerifier) Verify(ctx context.Context, domain string, token string) error {
+	if v[domain] != token {
+		return applepaydomain.ErrUnverified
+	}
+	return nil
+}
+
+func newDomainServer() (*Server, *applepaydomain.LocalRegistrar, domainVerifier) {
+	registrar := applepaydomain.NewLocalRegistrar()
+	verifier := domainVerifier{}
+	return &Server{
+		logger: zap.S(),
+		store: &domainStore{
+			domains:   map[string]*buyte.ApplePayDomain{},
+			owners:    map[string]string{},
+			merchants: map[string]string{"apm_a": "merchant_a", "apm_b": "merchant_b"},
+		},
+		registrar:      registrar,
+		domainVerifier: verifier,
+	}, registrar, verifier
+}
+
+func createApplePayDomain(s *Server, merchant string) *httptest.ResponseRecorder {
+	return createApplePayDomainWithBody(s, merchant, `{"domain":"shop.example.com"}`)
+}
+
+func createApplePayDomainWithBody(s *Server, merchant string, body string) *httptest.ResponseRecorder {
+	u := &user.User{ID: merchant, UserAttributes: &user.UserAttributes{StoreName: merchant}}
+	r := httptest.NewRequest(http.MethodPost, "/v1/applepay/domains", strings.NewReader(body))
+	r = r.WithContext(u.WithContext(r.Context()))
+	w := httptest.NewRecorder()
+	s.CreateApplePayDomain()(w, r)
+	return w
+}
+
+func TestCreateApplePayDomainRegisteredByAnotherMerchant(t *testing.T) {
+	assert := assert.New(t)
+	s, registrar, _ := newDomainServer()
+
+	assert.Equal(http.StatusCreated, createApplePayDomain(s, "merchant_a").Code)
+	assert.Equal(http.StatusOK, createApplePayDomain(s, "merchant_a").Code, "Registering a domain twice returns the existing registration.")
+
+	w := createApplePayDomain(s, "merchant_b")
+	assert.Equal(http.StatusBadRequest, w.Code)
+	assert.Contains(w.Body.String(), "registered by another merchant")
+	registration, _ := registrar.Registered("shop.example.com")
+	assert.Equal("merchant_a", registration.PartnerMerchantIdentifier, "The domain should not be registered with Apple for another merchant.")
+}
+
+func getApplePayDomainAssociation(s *Server) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, applepaydomain.AssociationPath, nil)
+	r.Host = "shop.example.com"
+	w := httptest.NewRecorder()
+	s.GetApplePayDomainAssociation()(w, r)
+	return w
+}
+
+func TestCreateHostedApplePayDomain(t *testing.T) {
+	assert := assert.New(t)
+	production := config.GetBool("server.production")
+	defer config.Set("server.production", production)
+	config.Set("server.production", true)
+	s, registrar, verifier := newDomainServer()
+	body := `{"domain":"shop.example.com","applePayMerchantId":"apm_b","associationFile":"association_b"}`
+
+	w := createApplePayDomainWithBody(s, "merchant_a", body)
+	assert.Equal(http.StatusBadRequest, w.Code)
+	assert.Contains(w.Body.String(), "Apple Pay merchant apm_b not found", "Domains may only be hosted for the merchant's own identities.")
+
+	w = createApplePayDomainWithBody(s, "merchant_b", body)
+	assert.Equal(http.StatusBadRequest, w.Code)
+	assert.Contains(w.Body.String(), applepaydomain.VerificationToken("merchant_b", "shop.example.com"))
+	assert.Equal(http.StatusNotFound, getApplePayDomainAssociation(s).Code, "The association file should not be served before the merchant proves they own the domain.")
+
+	verifier["shop.example.com"] = applepaydomain.VerificationToken("merchant_b", "shop.example.com")
+	assert.Equal(http.StatusCreated, createApplePayDomainWithBody(s, "merchant_b", body).Code)
+	w = getApplePayDomainAssociation(s)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("association_b", w.Body.String())
+	_, registered := registrar.Registered("shop.example.com")
+	assert.False(registered, "Hosted domains are registered by the merchant themselves.")
+}
+
+func TestCreateApplePayDomainCreatedConcurrently(t *testing.T) {
+	assert := assert.New(t)
+	s, _, _ := newDomainServer()
+	assert.Equal(http.StatusCreated, createApplePayDomain(s, "merchant_a").Code)
+
+	// merchant_b's lookup ran before merchant_a's domain was created.
+	domains := s.store.(*domainStore)
+	s.store = &staleDomainStore{domainStore: domains}
+	w := createApplePayDomain(s, "merchant_b")
+	assert.Equal(http.StatusBadRequest, w.Code, "The store should refuse to create the domain twice.")
+	assert.Contains(w.Body.String(), "registered by another merchant")
+}
+
+// Misses the first lookup of a domain, as though it were created after the lookup.
+type staleDomainStore struct {
+	*domainStore
+	looked bool
+}
+
+func (d *staleDomainStore) GetApplePayDomain(ctx context.Context, domain string) (*buyte.ApplePayDomain, error) {
+	if !d.looked {
+		d.looked = true
+		return &buyte.ApplePayDomain{}, nil
+	}
+	return d.domainStore.GetApplePayDomain(ctx, domain)
+}
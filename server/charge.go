@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"net/http"
 	"strings"
 	"time"
@@ -9,13 +10,25 @@ import (
 	"github.com/go-chi/render"
 	"github.com/pkg/errors"
 	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/applepaytoken"
+	"github.com/rsoury/buyte/pkg/googlepay"
 	"github.com/rsoury/buyte/pkg/paymentgateway"
+	"github.com/rsoury/buyte/pkg/paymentmethod"
+	"github.com/rsoury/buyte/pkg/samsungpay"
+	"github.com/rsoury/buyte/pkg/tax"
 	"github.com/rsoury/buyte/pkg/user"
+	"github.com/rsoury/buyte/pkg/view"
 	"github.com/rsoury/buyte/store"
 )
 
 func (s *Server) CreateCharge() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		expand, err := view.ParseExpand(r.URL.Query(), view.ChargeExpandable...)
+		if err != nil {
+			_ = render.Render(w, r, s.ErrInvalidRequestWithMessage(err))
+			return
+		}
+
 		// Decode input
 		input := &buyte.CreateChargeInput{}
 		if err := render.DecodeJSON(r.Body, input); err != nil {
@@ -44,10 +57,24 @@ func (s *Server) CreateCharge() http.HandlerFunc {
 			return
 		}
 
-		// Validate amount in input
+		// Merchant initiated charges are made against the payment agreement set up by an initial charge.
+		// They are not made with the token's payment data, so may be made once the token has expired.
+		var initialCharge *buyte.Charge
+		if input.InitialCharge != "" {
+			initialCharge, err = s.initialCharge(r.Context(), input, paymentToken)
+			if err != nil {
+				_ = render.Render(w, r, s.ErrInvalidRequestWithMessage(err))
+				return
+			}
+		} else if paymentToken.IsExpired(time.Now()) {
+			_ = render.Render(w, r, s.ErrTokenExpired(errors.Errorf("Payment token %s expired at %s", paymentToken.ID, paymentToken.ExpiresAt)))
+			return
+		}
+
+		// Validate amount in input. Merchant initiated charges may differ in amount to the authorized payment, as the agreement allows.
 		// In the future, you'd allow for partial payments... ie input.Amount <= paymentToken.Amount
 		// TODO: Add a way to include meaningful error message for production...
-		if input.Amount != paymentToken.Amount {
+		if initialCharge == nil && input.Amount != paymentToken.Amount {
 			_ = render.Render(w, r, s.ErrInvalidRequestWithMessage(errors.New("Amount does not equal amount in authorized payment.")))
 			return
 		}
@@ -55,6 +82,19 @@ func (s *Server) CreateCharge() http.HandlerFunc {
 			_ = render.Render(w, r, s.ErrInvalidRequestWithMessage(errors.New("Currency does not equal currency in authorized payment.")))
 			return
 		}
+		// The shipping method selected on the payment sheet must ship the order charged for.
+		if initialCharge == nil && paymentToken.ShippingMethod != nil {
+			rate := paymentToken.ShippingMethod.ShippingRate()
+			// Without a subtotal, the amount is taken to include any tax.
+			subtotal := input.Order.Subtotal
+			if subtotal == 0 {
+				subtotal = input.Amount - rate.Rate
+			}
+			if !rate.Applies(input.Order.ShippingOrder(subtotal)) {
+				_ = render.Render(w, r, s.ErrInvalidRequestWithMessage(errors.New("Shipping method is not available for the order.")))
+				return
+			}
+		}
 
 		s.logger.Infow("Create Charge", "token", paymentToken.ID, "message", "Passed validation")
 
@@ -62,16 +102,45 @@ func (s *Server) CreateCharge() http.HandlerFunc {
 		u := user.FromContext(r.Context())
 
 		// Create the charge params
-		customer := paymentToken.Customer()
+		paymentMethod, err := s.paymentMethods.ForPaymentToken(paymentToken)
+		if err != nil {
+			_ = render.Render(w, r, s.ErrInvalidRequestWithMessage(err))
+			return
+		}
+		// The payment data of an expired token may have been purged, so merchant initiated charges are made for the initial charge's customer.
+		var customer *buyte.Customer
+		if initialCharge != nil {
+			customer = initialCharge.Customer
+		} else {
+			customer, err = paymentMethod.Customer(paymentToken)
+			if err != nil {
+				_ = render.Render(w, r, s.ErrInternalServer(err))
+				return
+			}
+		}
+		// Tax is calculated for where the order ships to, and must be included in the amount charged.
+		// Merchant initiated charges are for amounts the agreement allows, so are not recalculated.
+		if initialCharge == nil && s.tax != nil {
+			breakdown, err := s.chargeTax(r.Context(), input, paymentToken, customer, u.UserAttributes.Country)
+			if err != nil {
+				_ = render.Render(w, r, s.ErrInvalidRequestWithMessage(err))
+				return
+			}
+			input.Order.Tax = breakdown
+		}
 		params := &buyte.CreateChargeParams{
 			Source:      paymentToken.ID,
 			Amount:      input.Amount,
 			Currency:    input.Currency,
-			Captured:    true,
 			Description: input.Description,
 			Customer:    customer,
+			Agreement:   paymentToken.Agreement,
 			CreatedAt:   time.Now().Format(time.RFC3339),
 		}
+		if initialCharge != nil {
+			params.Agreement = initialCharge.Agreement
+			params.InitialCharge = initialCharge.ID
+		}
 		err = params.SetMetadata(input.Metadata)
 		if err != nil {
 			_ = render.Render(w, r, s.ErrInternalServer(err))
@@ -86,78 +155,99 @@ func (s *Server) CreateCharge() http.HandlerFunc {
 		s.logger.Infow("Create Charge", "token", paymentToken.ID, "message", "Charge params created.")
 		s.logger.Debugw("Create Charge", "params", params)
 
-		var networkToken *buyte.NetworkToken
-		var nativeToken string
-		if paymentToken.IsApplePay() {
-			// Decrypt Apple Pay Token
-			applePayPaymentToken, err := paymentToken.ApplePay()
+		// Get the Payment Provider connections the checkout routes this charge through, in order of preference.
+		// Merchant initiated charges may only be made through the connection holding the stored payment method.
+		var connections []*buyte.ProviderCheckoutConnection
+		if initialCharge != nil {
+			if connection := paymentgateway.Connection(paymentToken.Checkout, initialCharge.ProviderCharge.ConnectionId); connection != nil {
+				connections = append(connections, connection)
+			}
+		} else {
+			cardNetwork, err := paymentMethod.CardNetwork(paymentToken)
 			if err != nil {
 				_ = render.Render(w, r, s.ErrInternalServer(err))
 				return
 			}
-			applePayNetworkToken, err := s.applepay.DecryptResponse(applePayPaymentToken.Response)
+			connections = paymentgateway.Route(paymentToken.Checkout, paymentgateway.NewRoutingCriteria(input, paymentToken, cardNetwork))
+		}
+		if len(connections) == 0 {
+			_ = render.Render(w, r, s.ErrInternalServer(errors.New("Checkout has no provider connection")))
+			return
+		}
+
+		// Execute Charge on Payment Provider, failing over to the next connection only when the charge never reached a gateway.
+		var paymentProvider *paymentgateway.Provider
+		var decryptedToken *buyte.NetworkToken
+		result, connection, err := paymentgateway.Charge(connections, func(connection *buyte.ProviderCheckoutConnection) (*buyte.GatewayCharge, error) {
+			var err error
+			paymentProvider, err = paymentgateway.New(r.Context(), connection)
 			if err != nil {
-				if paymentToken.IsRejectedSigningTimeDelta(err) {
-					_ = render.Render(w, r, s.ErrRequestFailed(err))
-					return
-				} else {
-					_ = render.Render(w, r, s.ErrInternalServer(err))
-					return
-				}
+				return nil, err
 			}
-			networkToken = &buyte.NetworkToken{
-				applePayNetworkToken,
+
+			if initialCharge != nil {
+				result, err := paymentProvider.ChargeMerchantInitiated(input, initialCharge, paymentToken)
+				if err != nil {
+					return nil, err
+				}
+				s.logger.Infow("Create Charge", "message", "Gateway charge executed successfully", "type", "merchant_initiated", "connection", connection.ID)
+				return result, nil
 			}
-		} else if paymentToken.IsGooglePay() {
-			// Extract Native token
-			googlePayPaymentToken, err := paymentToken.GooglePay()
+
+			networkToken, nativeToken, err := s.gatewayTokens(r.Context(), paymentMethod, paymentToken, paymentProvider, &decryptedToken)
 			if err != nil {
-				_ = render.Render(w, r, s.ErrInternalServer(err))
-				return
+				return nil, err
 			}
-			nativeToken = googlePayPaymentToken.Response.PaymentMethodData.TokenizationData.Token
-		} else {
-			_ = render.Render(w, r, s.ErrInternalServer(errors.New("Payment Token type not valid")))
-			return
-		}
 
-		s.logger.Infow("Create Charge", "message", "Network/Native token attained.")
+			s.logger.Infow("Create Charge", "message", "Network/Native token attained.", "connection", connection.ID)
 
-		// Get Payment Provider from used Checkout
-		// s.logger.Debugw("Create Charge", "Token", paymentToken)
-		paymentProvider, err := paymentgateway.New(r.Context(), paymentToken.Checkout.Connection)
-		if err != nil {
-			_ = render.Render(w, r, s.ErrInternalServer(err))
-			return
-		}
-
-		// Check if provider is connect or not. Connect is now the keyword for our locally used Payment Provider.
-		if paymentProvider.Gateway.IsConnect() {
-			// Set fee amount
-			params.SetFee(u.UserAttributes.FeeMultiplier, u.UserAttributes.Country)
-			input.SetFee(u.UserAttributes.FeeMultiplier, u.UserAttributes.Country)
+			// Check if provider is connect or not. Connect is now the keyword for our locally used Payment Provider.
+			params.FeeAmount = 0
+			input.FeeAmount = 0
+			if paymentProvider.Gateway.IsConnect() {
+				// Set fee amount
+				params.SetFee(u.UserAttributes.FeeMultiplier, u.UserAttributes.Country)
+				input.SetFee(u.UserAttributes.FeeMultiplier, u.UserAttributes.Country)
 
-			s.logger.Infow("Create Charge", "message", "Fee applied")
-		}
+				s.logger.Infow("Create Charge", "message", "Fee applied")
+			}
 
-		// Execute Charge on Payment Provider
-		var result *buyte.GatewayCharge
-		if nativeToken != "" {
-			result, err = paymentProvider.Gateway.ChargeNative(input, nativeToken, paymentToken)
-			if err != nil {
-				_ = render.Render(w, r, s.ErrInternalServer(err))
-				return
+			chargeType := "network"
+			var result *buyte.GatewayCharge
+			if nativeToken != "" {
+				chargeType = "native"
+				result, err = paymentProvider.Gateway.ChargeNative(input, nativeToken, paymentToken)
+			} else {
+				result, err = paymentProvider.Gateway.Charge(input, networkToken, paymentToken)
 			}
-			s.logger.Infow("Create Charge", "message", "Gateway charge executed successfully", "type", "native")
-		} else {
-			result, err = paymentProvider.Gateway.Charge(input, networkToken, paymentToken)
 			if err != nil {
+				return nil, err
+			}
+			s.logger.Infow("Create Charge", "message", "Gateway charge executed successfully", "type", chargeType, "connection", connection.ID)
+			return result, nil
+		})
+		if err != nil {
+			if applepaytoken.IsVerificationError(err) || googlepay.IsVerificationError(err) || samsungpay.IsDecryptionError(err) {
+				_ = render.Render(w, r, s.ErrRequestFailed(err))
+			} else if buyte.IsGatewayUnavailable(err) {
+				// The gateway may have charged the payment, so it is not charged through another connection.
+				s.logger.Warnw("Create Charge", "message", "Gateway unavailable", "connection", connection.ID, "type", connection.Type, "error", err)
+				_ = render.Render(w, r, s.ErrGatewayUnavailable(err))
+			} else if buyte.IsUnsupportedPaymentData(err) {
+				_ = render.Render(w, r, s.ErrUnsupportedPaymentData(err))
+			} else {
 				_ = render.Render(w, r, s.ErrInternalServer(err))
-				return
 			}
-			s.logger.Infow("Create Charge", "message", "Gateway charge executed successfully", "type", "network")
+			return
 		}
+		result.ConnectionId = connection.ID
 		params.SetProviderCharge(result)
+		// The merchant token issued for the agreement is only known once the payment data is decrypted.
+		if params.Agreement != nil && decryptedToken != nil && decryptedToken.MerchantTokenIdentifier != "" {
+			agreement := *params.Agreement
+			agreement.MerchantTokenIdentifier = decryptedToken.MerchantTokenIdentifier
+			params.Agreement = &agreement
+		}
 
 		// Return Charge
 		charge, err := s.store.CreateCharge(r.Context(), params)
@@ -183,12 +273,99 @@ func (s *Server) CreateCharge() http.HandlerFunc {
 		s.logger.Infow("Create Charge", "Charge", charge.ID, "Gateway Charge", result.Reference, "Payment Token", paymentToken.ID)
 
 		// Return Charge
-		render.JSON(w, r, charge)
+		render.JSON(w, r, view.NewCharge(charge, expand))
+	}
+}
+
+// Get the charge that set up the payment agreement a merchant initiated charge is made against.
+func (s *Server) initialCharge(ctx context.Context, input *buyte.CreateChargeInput, paymentToken *buyte.PaymentToken) (*buyte.Charge, error) {
+	if paymentToken.Agreement == nil {
+		return nil, errors.New("Source has no payment agreement to charge.")
 	}
+	initialCharge, err := s.store.GetCharge(ctx, input.InitialCharge)
+	if err != nil || initialCharge.ID == "" {
+		return nil, errors.New("Initial charge not found.")
+	}
+	if initialCharge.Source == nil || initialCharge.Source.ID != paymentToken.ID {
+		return nil, errors.New("Initial charge was not made against the source.")
+	}
+	if initialCharge.IsMerchantInitiated() || initialCharge.Agreement == nil || initialCharge.ProviderCharge == nil || initialCharge.ProviderCharge.PaymentMethodReference == "" {
+		return nil, errors.New("Initial charge did not set up the payment agreement.")
+	}
+	return initialCharge, nil
+}
+
+// The tax of an order, for the customer's shipping address, otherwise their billing address. Returns nil when no tax applies.
+// Errors when the customer has no address to calculate tax for, or the amount charged is not the order's total with tax.
+func (s *Server) chargeTax(ctx context.Context, input *buyte.CreateChargeInput, paymentToken *buyte.PaymentToken, customer *buyte.Customer, origin string) (*tax.Breakdown, error) {
+	var address *buyte.CustomerAddress
+	if customer != nil {
+		address = customer.ShippingAddress
+		if address == nil {
+			address = customer.BillingAddress
+		}
+	}
+	if address == nil || address.CountryCode == "" {
+		return nil, errors.New("A shipping or billing address country is required to calculate tax.")
+	}
+	shippingAmount := 0
+	if paymentToken.ShippingMethod != nil {
+		shippingAmount = paymentToken.ShippingMethod.Rate
+	}
+	// Without a subtotal, the amount is taken to include any tax.
+	amount := input.Order.Subtotal
+	if amount == 0 {
+		amount = input.Amount - shippingAmount
+	}
+	breakdown, err := s.tax.Calculate(ctx, &tax.Input{
+		Origin: origin,
+		Destination: tax.Location{
+			Country: address.CountryCode,
+			Region:  address.AdministrativeArea,
+		},
+		Amount:   amount,
+		Shipping: shippingAmount,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if breakdown.IsEmpty() {
+		return nil, nil
+	}
+	if breakdown.Added > 0 && input.Order.Subtotal == 0 {
+		return nil, errors.New("Order subtotal is required to charge tax not included in prices.")
+	}
+	if total := breakdown.Total(amount, shippingAmount); total != input.Amount {
+		return nil, errors.Errorf("Amount does not equal the order's total of %d with tax.", total)
+	}
+	return breakdown, nil
+}
+
+// Obtain the network token or native token a gateway charges with.
+// Payment data is decrypted at most once, regardless of how many connections are attempted.
+func (s *Server) gatewayTokens(ctx context.Context, paymentMethod paymentmethod.Handler, paymentToken *buyte.PaymentToken, paymentProvider *paymentgateway.Provider, decryptedToken **buyte.NetworkToken) (*buyte.NetworkToken, string, error) {
+	passthrough := paymentProvider.IsPassthrough(paymentMethod.Name())
+	if *decryptedToken != nil && !passthrough {
+		return *decryptedToken, "", nil
+	}
+	networkToken, nativeToken, err := paymentMethod.GatewayToken(ctx, paymentToken, passthrough)
+	if err != nil {
+		return nil, "", err
+	}
+	if networkToken != nil {
+		*decryptedToken = networkToken
+		s.logger.Infow("Create Charge", "token", paymentToken.ID, "paymentDataType", networkToken.DataType())
+	}
+	return networkToken, nativeToken, nil
 }
 
 func (s *Server) GetCharge() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		expand, err := view.ParseExpand(r.URL.Query(), view.ChargeExpandable...)
+		if err != nil {
+			_ = render.Render(w, r, s.ErrInvalidRequestWithMessage(err))
+			return
+		}
 		chargeId := chi.URLParam(r, "id")
 		charge, err := s.store.GetCharge(r.Context(), chargeId)
 		if err != nil {
@@ -206,6 +383,6 @@ func (s *Server) GetCharge() http.HandlerFunc {
 
 		s.logger.Infow("Get Charge", "Charge", charge.ID)
 
-		render.JSON(w, r, charge)
+		render.JSON(w, r, view.NewCharge(charge, expand))
 	}
 }
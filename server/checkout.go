@@ -1,15 +1,25 @@
 package server
 
 import (
+	"context"
 	"errors"
 	"net/http"
+	"strconv"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
+	config "github.com/spf13/viper"
+
 	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/googlepay"
+	"github.com/rsoury/buyte/pkg/paymentgateway"
+	"github.com/rsoury/buyte/pkg/paymentrequest"
+	"github.com/rsoury/buyte/pkg/view"
 	"github.com/rsoury/buyte/store"
 )
 
+var ErrNoConnection = errors.New("Checkout has no connection for this payment method")
+
 func (s *Server) GetFullCheckout() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		checkoutWidgetId := chi.URLParam(r, "id")
@@ -21,7 +31,7 @@ func (s *Server) GetFullCheckout() http.HandlerFunc {
 			_ = render.Render(w, r, s.ErrInvalidRequest(errors.New("User Country Code is a required query parameter eg. ?user_country_code=AU")))
 			return
 		}
-		checkout, err := s.store.GetFullCheckout(r.Context(), checkoutWidgetId, &buyte.FullCheckoutOptions{
+		checkout, err := s.fullCheckout(r.Context(), checkoutWidgetId, &buyte.FullCheckoutOptions{
 			UserCountryCode: userCountryCode,
 		})
 		if err != nil {
@@ -37,6 +47,216 @@ func (s *Server) GetFullCheckout() http.HandlerFunc {
 			return
 		}
 
-		render.JSON(w, r, checkout)
+		render.JSON(w, r, view.NewCheckout(checkout))
+	}
+}
+
+type WalletPaymentRequestsInput struct {
+	paymentrequest.Input
+	UserCountryCode string `json:"userCountryCode,omitempty"`
+}
+
+// GetWalletPaymentRequests builds the Apple Pay and Google Pay payment requests for a checkout, so widgets need not assemble them.
+func (s *Server) GetWalletPaymentRequests() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		input := &WalletPaymentRequestsInput{}
+		if err := render.DecodeJSON(r.Body, input); err != nil {
+			_ = render.Render(w, r, s.ErrInvalidRequest(err))
+			return
+		}
+		if input.UserCountryCode == "" {
+			input.UserCountryCode = r.Header.Get("Cloudfront-Viewer-Country")
+		}
+		if input.UserCountryCode == "" {
+			_ = render.Render(w, r, s.ErrInvalidRequest(errors.New("User Country Code is required")))
+			return
+		}
+		checkout, err := s.fullCheckout(r.Context(), chi.URLParam(r, "id"), &buyte.FullCheckoutOptions{
+			UserCountryCode: input.UserCountryCode,
+			Order:           input.ShippingOrder(),
+		})
+		if err != nil {
+			if store.IsConnectionUnauthorized(err) {
+				_ = render.Render(w, r, ErrNotFound)
+			} else {
+				_ = render.Render(w, r, s.ErrInternalServer(err))
+			}
+			return
+		}
+		if checkout.ID == "" {
+			_ = render.Render(w, r, ErrNotFound)
+			return
+		}
+
+		requests, err := paymentrequest.Build(checkout, &input.Input)
+		if err != nil {
+			_ = render.Render(w, r, s.ErrInvalidRequestWithMessage(err))
+			return
+		}
+
+		s.logger.Infow("Wallet Payment Requests", "checkout", checkout.ID, "gateway", checkout.GatewayProvider.Type)
+
+		render.JSON(w, r, requests)
+	}
+}
+
+// RecalculateShipping returns the shipping methods available for the address a customer selects on a wallet's payment sheet, and the order's updated totals.
+// Called from Apple Pay's onshippingcontactselected and onshippingmethodselected, and Google Pay's onPaymentDataChanged.
+func (s *Server) RecalculateShipping() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		input := &paymentrequest.ShippingInput{}
+		if err := render.DecodeJSON(r.Body, input); err != nil {
+			_ = render.Render(w, r, s.ErrInvalidRequest(err))
+			return
+		}
+		if input.Address.CountryCode == "" {
+			_ = render.Render(w, r, s.ErrInvalidRequest(errors.New("Address Country Code is required")))
+			return
+		}
+		if input.Amount <= 0 {
+			_ = render.Render(w, r, s.ErrInvalidRequest(errors.New("Amount must be greater than 0")))
+			return
+		}
+		checkout, err := s.store.GetFullCheckout(r.Context(), chi.URLParam(r, "id"), &buyte.FullCheckoutOptions{
+			UserCountryCode: input.Address.CountryCode,
+			UserRegion:      input.Address.AdministrativeArea,
+			UserPostalCode:  input.Address.PostalCode,
+			Order:           input.ShippingOrder(),
+		})
+		if err != nil {
+			if store.IsConnectionUnauthorized(err) {
+				_ = render.Render(w, r, ErrNotFound)
+			} else {
+				_ = render.Render(w, r, s.ErrInternalServer(err))
+			}
+			return
+		}
+		if checkout.ID == "" {
+			_ = render.Render(w, r, ErrNotFound)
+			return
+		}
+
+		update, err := paymentrequest.Shipping(r.Context(), checkout, input, s.tax)
+		if err != nil {
+			_ = render.Render(w, r, s.ErrInternalServer(err))
+			return
+		}
+
+		s.logger.Infow("Recalculate Shipping", "checkout", checkout.ID, "country", input.Address.CountryCode, "shippingMethods", len(update.ShippingMethods))
+
+		render.JSON(w, r, update)
+	}
+}
+
+// Get a checkout as presented to widgets, with each wallet option configured for the checkout.
+func (s *Server) fullCheckout(ctx context.Context, checkoutId string, options *buyte.FullCheckoutOptions) (*buyte.FullCheckout, error) {
+	checkout, err := s.store.GetFullCheckout(ctx, checkoutId, options)
+	if err != nil || checkout.ID == "" {
+		return checkout, err
+	}
+
+	ineligible := map[int]bool{}
+	for i, option := range checkout.Options {
+		// Afterpay is only offered for order totals within the limits of the checkout's Afterpay account.
+		if option.Name == buyte.AFTERPAY {
+			limits, err := s.afterpayLimits(ctx, checkout.ID, checkout.Currency)
+			if err != nil {
+				s.logger.Warnw("Full Checkout", "message", "Afterpay is not available", "checkout", checkout.ID, "error", err)
+				ineligible[i] = true
+				continue
+			}
+			checkout.Options[i].AdditionalData = map[string]string{
+				"currency":      limits.Currency,
+				"minimumAmount": strconv.Itoa(limits.MinimumAmount),
+				"maximumAmount": strconv.Itoa(limits.MaximumAmount),
+			}
+			continue
+		}
+		// PayPal's buttons are loaded with the client ID of the checkout's PayPal connection.
+		if option.Name == buyte.PAYPAL {
+			gateway, err := s.payPalGateway(ctx, checkout.ID, checkout.Currency, 0)
+			if err == ErrNoConnection {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			checkout.Options[i].AdditionalData = map[string]string{
+				"clientId": gateway.PayPalCredentials().ClientId,
+				"intent":   "capture",
+			}
+			continue
+		}
+		// Have Google Pay encrypt tokens for Buyte's own merchant key, rather than the gateway's.
+		if option.Name == buyte.GOOGLE_PAY && config.GetBool("google.tokenization.direct") {
+			if publicKey := s.googlePay.PublicKey(); publicKey != "" {
+				additionalData := map[string]string{}
+				for key, value := range option.AdditionalData {
+					additionalData[key] = value
+				}
+				additionalData["tokenizationType"] = googlepay.TokenizationTypeDirect
+				additionalData["protocolVersion"] = googlepay.ProtocolVersionECv2
+				additionalData["publicKey"] = publicKey
+				checkout.Options[i].AdditionalData = additionalData
+			}
+			continue
+		}
+		// Present Apple Pay with the merchant's own identity, where they have one.
+		if option.Name != buyte.APPLE_PAY {
+			continue
+		}
+		merchant, err := s.store.GetApplePayMerchant(ctx, checkout.ID)
+		if err != nil {
+			return nil, err
+		}
+		if merchant.ID != "" {
+			checkout.Options[i].AdditionalData = map[string]string{
+				"merchantId":   merchant.MerchantIdentifier,
+				"merchantName": merchant.DisplayName,
+			}
+		}
+	}
+	if len(ineligible) > 0 {
+		options := make([]buyte.FullCheckoutOptionResponse, 0, len(checkout.Options))
+		for i, option := range checkout.Options {
+			if !ineligible[i] {
+				options = append(options, option)
+			}
+		}
+		checkout.Options = options
+	}
+	return checkout, nil
+}
+
+// The connection a checkout's payments of a dedicated payment method are made through. ie. PayPal orders and Afterpay checkouts
+// Routed as the charge capturing the payment will be.
+func (s *Server) routedConnection(ctx context.Context, checkoutId string, paymentMethod string, currency string, amount int) (*buyte.ProviderCheckoutConnection, error) {
+	checkout, err := s.store.GetCheckoutConnections(ctx, checkoutId)
+	if err != nil {
+		return nil, err
+	}
+	connections := paymentgateway.Route(checkout, &paymentgateway.RoutingCriteria{
+		Currency:      currency,
+		PaymentMethod: paymentMethod,
+		Amount:        amount,
+	})
+	if len(connections) == 0 {
+		return nil, ErrNoConnection
+	}
+	return connections[0], nil
+}
+
+// Reject wallet payments whose card network or contact details do not meet their checkout's requirements.
+// Unknown checkouts are left to be rejected when the payment token is created.
+func (s *Server) checkRequirements(ctx context.Context, response *buyte.AuthorizedPaymentResponse, contacts *buyte.PaymentContacts) error {
+	checkout, err := s.store.GetFullCheckout(ctx, response.CheckoutId, &buyte.FullCheckoutOptions{
+		UserCountryCode: response.Country,
+	})
+	if err != nil {
+		return err
+	}
+	if checkout.ID == "" || checkout.Requirements == nil {
+		return nil
 	}
+	return checkout.Requirements.Check(contacts)
 }
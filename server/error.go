@@ -5,7 +5,10 @@ import (
 
 	"github.com/getsentry/raven-go"
 	"github.com/go-chi/render"
+	"github.com/pkg/errors"
 	config "github.com/spf13/viper"
+
+	"github.com/rsoury/buyte/pkg/applepaymerchant"
 )
 
 // ErrResponse is a generic struct for returning a standard error document
@@ -64,6 +67,76 @@ func ErrInvalidRequest(err error) render.Renderer {
 	}
 }
 
+// (*Server) ErrInvalidSessionURL will log an error (as a warning, as it may be an attempt to reach internal hosts) and return an invalid request error to the user
+func (s *Server) ErrInvalidSessionURL(err error) render.Renderer {
+	s.logger.Warnw("Invalid Apple Pay Session URL", "error", err)
+	return ErrInvalidSessionURL(err)
+}
+
+// ErrInvalidSessionURL is used to indicate that an Apple Pay merchant validation URL is not one of Apple's
+func ErrInvalidSessionURL(err error) render.Renderer {
+	reason := "invalid_session_url"
+	var urlErr *applepaymerchant.SessionURLError
+	if errors.As(err, &urlErr) {
+		reason += ":" + urlErr.Reason
+	}
+	return &ErrResponse{
+		Err:        err,
+		StatusCode: 400,
+		Message:    "Invalid request: Apple Pay validation URL is not permitted.",
+		ErrorText:  reason,
+	}
+}
+
+// (*Server) ErrUnsupportedPaymentData will log an error (as a warning) and return a request failed error to the user
+func (s *Server) ErrUnsupportedPaymentData(err error) render.Renderer {
+	s.logger.Warnw("Unsupported Payment Data", "error", err)
+	return ErrUnsupportedPaymentData(err)
+}
+
+// ErrUnsupportedPaymentData is used to indicate that none of the checkout's payment gateways accept the payment data, ie. EMV Apple Pay tokens
+func ErrUnsupportedPaymentData(err error) render.Renderer {
+	return &ErrResponse{
+		Err:        err,
+		StatusCode: 402,
+		Message:    "Request failed: The payment gateway does not support this payment method's data.",
+		ErrorText:  "unsupported_payment_data",
+	}
+}
+
+// (*Server) ErrRequirementsNotMet will log an error (as a debug log) and return an invalid request error to the user
+func (s *Server) ErrRequirementsNotMet(err error) render.Renderer {
+	s.logger.Debugw("Checkout Requirements Not Met", "error", err)
+	return ErrRequirementsNotMet(err)
+}
+
+// ErrRequirementsNotMet is used to indicate that a wallet payment's card network or contact details are not accepted by the checkout.
+// The message lists each requirement not met, so the customer can correct it.
+func ErrRequirementsNotMet(err error) render.Renderer {
+	return &ErrResponse{
+		Err:        err,
+		StatusCode: 400,
+		Message:    "Invalid request: " + err.Error() + ".",
+		ErrorText:  "checkout_requirements_not_met",
+	}
+}
+
+// (*Server) ErrTokenExpired will log an error (as a debug log) and return an invalid request error to the user
+func (s *Server) ErrTokenExpired(err error) render.Renderer {
+	s.logger.Debugw("Payment Token Expired", "error", err)
+	return ErrTokenExpired(err)
+}
+
+// ErrTokenExpired is used to indicate that a payment token may no longer be charged. The customer must authorize the payment again.
+func ErrTokenExpired(err error) render.Renderer {
+	return &ErrResponse{
+		Err:        err,
+		StatusCode: 400,
+		Message:    "Invalid request: Payment token has expired.",
+		ErrorText:  "token_expired",
+	}
+}
+
 // (*Server) ErrRequestFailed will log an error (as a debug log) and return an request failed error to the user
 func (s *Server) ErrRequestFailed(err error) render.Renderer {
 	s.logger.Debugw("Request Failed", "error", err)
@@ -126,3 +199,21 @@ func ErrInternalServer(err error) render.Renderer {
 		Message:    "Something went wrong. Please contact Buyte Support.",
 	}
 }
+
+// (*Server) ErrGatewayUnavailable will log an error and return a service unavailable error to the user
+func (s *Server) ErrGatewayUnavailable(err error) render.Renderer {
+	s.logger.Errorw("Gateway Unavailable", "error", err)
+	return ErrGatewayUnavailable(err)
+}
+
+// ErrGatewayUnavailable is used to indicate that no payment gateway could process the request. The request may be retried.
+func ErrGatewayUnavailable(err error) render.Renderer {
+	raven.CaptureError(err, map[string]string{
+		"type": "Gateway Unavailable",
+	})
+	return &ErrResponse{
+		Err:        err,
+		StatusCode: http.StatusServiceUnavailable,
+		Message:    "Payment gateway unavailable. Please try again.",
+	}
+}
@@ -2,14 +2,78 @@ package server
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
+	config "github.com/spf13/viper"
+
+	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/paymentmethod"
+	"github.com/rsoury/buyte/pkg/view"
 	"github.com/rsoury/buyte/store"
 )
 
+// ProcessPaymentMethodResponse creates a payment token from a wallet's authorized payment, once it meets the checkout's requirements.
+func (s *Server) ProcessPaymentMethodResponse(paymentMethod paymentmethod.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authorization, err := paymentMethod.DecodeAuthorization(r.Context(), r.Body)
+		if err != nil {
+			// Some payment methods are read from their provider. ie. PayPal orders
+			if buyte.IsGatewayUnavailable(err) {
+				_ = render.Render(w, r, s.ErrGatewayUnavailable(err))
+			} else if store.IsConnectionUnauthorized(err) || err == store.ErrNotFound {
+				_ = render.Render(w, r, ErrNotFound)
+			} else {
+				_ = render.Render(w, r, s.ErrInvalidRequestWithMessage(err))
+			}
+			return
+		}
+		if err := s.checkRequirements(r.Context(), authorization.Response, authorization.Contacts); err != nil {
+			if buyte.IsRequirementsError(err) {
+				_ = render.Render(w, r, s.ErrRequirementsNotMet(err))
+			} else if store.IsConnectionUnauthorized(err) {
+				_ = render.Render(w, r, ErrNotFound)
+			} else {
+				_ = render.Render(w, r, s.ErrInternalServer(err))
+			}
+			return
+		}
+
+		authorization.Input.ExpiresAt = time.Now().Add(paymentTokenTTL(paymentMethod)).UTC().Format(time.RFC3339)
+
+		paymentToken, err := s.store.CreatePaymentToken(r.Context(), authorization.Input)
+		if err != nil {
+			if store.IsConnectionInvalid(err) {
+				_ = render.Render(w, r, s.ErrInvalidRequest(err))
+			} else {
+				s.logger.Errorw("Create Payment Token from "+paymentMethod.Name(), "Params", authorization.Input)
+				_ = render.Render(w, r, s.ErrInternalServer(err))
+			}
+			return
+		}
+
+		// We now have the payment data.
+		render.JSON(w, r, view.NewPublicToken(paymentToken))
+	}
+}
+
+// How long the payment method's tokens may be charged for. See tokens.ttl
+func paymentTokenTTL(paymentMethod paymentmethod.Handler) time.Duration {
+	key := "tokens.ttl." + paymentMethod.Path()
+	if config.IsSet(key) {
+		return config.GetDuration(key)
+	}
+	return config.GetDuration("tokens.ttl.default")
+}
+
 func (s *Server) GetPaymentToken() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		expand, err := view.ParseExpand(r.URL.Query(), view.TokenExpandable...)
+		if err != nil {
+			_ = render.Render(w, r, s.ErrInvalidRequestWithMessage(err))
+			return
+		}
 		paymentTokenId := chi.URLParam(r, "id")
 		paymentToken, err := s.store.GetPaymentToken(r.Context(), paymentTokenId)
 		if err != nil {
@@ -25,12 +89,26 @@ func (s *Server) GetPaymentToken() http.HandlerFunc {
 			return
 		}
 
-		// formattedToken, err := paymentToken.Format()
-		// if err != nil {
-		// 	_ = render.Render(w, r, s.ErrInternalServer(err))
-		// 	return
-		// }
+		var customer *buyte.Customer
+		if expand.Has(view.ExpandCustomer) {
+			customer = s.paymentTokenCustomer(paymentToken)
+		}
 
-		render.JSON(w, r, paymentToken)
+		render.JSON(w, r, view.NewToken(paymentToken, customer, expand))
+	}
+}
+
+// The customer is read from the token's payment data. Tokens whose payment data is expired or purged have none.
+func (s *Server) paymentTokenCustomer(paymentToken *buyte.PaymentToken) *buyte.Customer {
+	paymentMethod, err := s.paymentMethods.ForPaymentToken(paymentToken)
+	if err != nil {
+		s.logger.Warnw("Get Payment Token", "token", paymentToken.ID, "error", err)
+		return nil
+	}
+	customer, err := paymentMethod.Customer(paymentToken)
+	if err != nil {
+		s.logger.Warnw("Get Payment Token", "token", paymentToken.ID, "error", err)
+		return nil
 	}
+	return customer
 }
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-chi/render"
+	"github.com/pkg/errors"
+
+	"github.com/rsoury/buyte/buyte"
+	paypalgateway "github.com/rsoury/buyte/pkg/paymentgateway/paypal"
+	"github.com/rsoury/buyte/pkg/paymentmethod"
+	"github.com/rsoury/buyte/store"
+)
+
+type CreatePayPalOrderInput struct {
+	CheckoutId string `json:"checkoutId"`
+	Amount     int    `json:"amount"`
+	Currency   string `json:"currency"`
+}
+type PayPalOrderResponse struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// CreatePayPalOrder creates the order PayPal's buttons ask the customer to approve.
+// Orders are created by Buyte rather than the widget, so that they are created with the checkout's own PayPal connection.
+func (s *Server) CreatePayPalOrder() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		input := &CreatePayPalOrderInput{}
+		if err := render.DecodeJSON(r.Body, input); err != nil {
+			_ = render.Render(w, r, s.ErrInvalidRequest(err))
+			return
+		}
+		if input.CheckoutId == "" || input.Currency == "" || input.Amount <= 0 {
+			_ = render.Render(w, r, s.ErrInvalidRequest(errors.New("Checkout ID, currency and a positive amount are required")))
+			return
+		}
+
+		gateway, err := s.payPalGateway(r.Context(), input.CheckoutId, input.Currency, input.Amount)
+		if err != nil {
+			if store.IsConnectionUnauthorized(err) || err == store.ErrNotFound {
+				_ = render.Render(w, r, ErrNotFound)
+			} else if err == ErrNoConnection {
+				_ = render.Render(w, r, s.ErrInvalidRequestWithMessage(err))
+			} else {
+				_ = render.Render(w, r, s.ErrInternalServer(err))
+			}
+			return
+		}
+		order, err := gateway.CreateOrder(input.Amount, input.Currency, input.CheckoutId)
+		if err != nil {
+			if buyte.IsGatewayUnavailable(err) {
+				_ = render.Render(w, r, s.ErrGatewayUnavailable(err))
+			} else {
+				_ = render.Render(w, r, s.ErrRequestFailed(err))
+			}
+			return
+		}
+
+		s.logger.Infow("Create PayPal Order", "checkout", input.CheckoutId, "order", order.ID, "connection", gateway.ConnectionId)
+
+		render.JSON(w, r, &PayPalOrderResponse{
+			ID:     order.ID,
+			Status: order.Status,
+		})
+	}
+}
+
+// The PayPal connection a checkout's orders are created, read and captured through.
+func (s *Server) payPalGateway(ctx context.Context, checkoutId string, currency string, amount int) (*paypalgateway.Gateway, error) {
+	connection, err := s.routedConnection(ctx, checkoutId, buyte.PAYPAL, currency, amount)
+	if err != nil {
+		return nil, err
+	}
+	return paypalgateway.New(ctx, connection)
+}
+
+// Read approved orders through the checkout's PayPal connection. See paymentmethod.PayPal
+func (s *Server) payPalOrders(ctx context.Context, checkoutId string, currency string, amount int) (paymentmethod.PayPalOrders, error) {
+	gateway, err := s.payPalGateway(ctx, checkoutId, currency, amount)
+	if err != nil {
+		return nil, err
+	}
+	return gateway, nil
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/go-chi/render"
 
 	"github.com/go-chi/chi"
+	config "github.com/spf13/viper"
 
 	"github.com/rsoury/buyte/conf"
 	"github.com/rsoury/buyte/pkg/util"
@@ -31,6 +32,14 @@ func (s *Server) SetupRoutes() {
 	s.router.Get("/", func(w http.ResponseWriter, r *http.Request) {
 		render.NoContent(w, r)
 	})
+	// Requested by Apple to verify merchant domains
+	s.router.Route("/.well-known", func(r chi.Router) {
+		r.Get("/apple-developer-merchantid-domain-association", s.GetApplePayDomainAssociation())
+		if !config.GetBool("server.production") {
+			fs := http.FileServer(http.Dir(path.Join(util.DirName(), "../examples/applepay")))
+			r.Get("/*", fs.ServeHTTP)
+		}
+	})
 	s.router.Route("/v"+major, func(r chi.Router) {
 		r.Post("/charges", s.CreateCharge())
 		r.Get("/charges/{id}", s.GetCharge())
@@ -39,19 +48,24 @@ func (s *Server) SetupRoutes() {
 
 		r.Get("/token/{id}", s.GetPaymentToken())
 
+		r.Get("/applepay/certificates", s.GetApplePayCertificates())
+		r.Post("/applepay/domains", s.CreateApplePayDomain())
+
 		// Wrap all routes accessable using the Public Key with a /public route.
 		r.Route("/public", func(r chi.Router) {
 			// Once it passes the authroizer which basically asks if it is a public key and if so, are you hitting a public endpoint, we need to obtain the public key and the checkout_id and then try to get the checkout details for the given user's checkout.
 			r.Route("/checkout", func(r chi.Router) {
 				r.Get("/{id}", s.GetFullCheckout())
+				r.Post("/{id}/payment-requests", s.GetWalletPaymentRequests())
+				r.Post("/{id}/shipping", s.RecalculateShipping())
 			})
-			r.Route("/applepay", func(r chi.Router) {
-				r.Post("/session", s.GetApplePaySession())
-				r.Post("/process", s.ProcessApplePayResponse())
-			})
-			r.Route("/googlepay", func(r chi.Router) {
-				r.Post("/process", s.ProcessGooglePayResponse())
-			})
+			r.Post("/applepay/session", s.GetApplePaySession())
+			r.Post("/paypal/orders", s.CreatePayPalOrder())
+			r.Post("/afterpay/checkouts", s.CreateAfterpayCheckout())
+			// Authorized payments are processed into payment tokens at /public/{method}/process
+			for _, handler := range s.paymentMethods.Handlers() {
+				r.Post("/"+handler.Path()+"/process", s.ProcessPaymentMethodResponse(handler))
+			}
 		})
 	})
 }
@@ -63,11 +77,6 @@ func (s *Server) SetupDevRoutes() {
 	prefix := "/dev/applepay"
 	fs := http.FileServer(root)
 	sFs := http.StripPrefix(prefix, fs)
-	s.router.Route("/.well-known", func(r chi.Router) {
-		r.Get("/*", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			fs.ServeHTTP(w, r)
-		}))
-	})
 	s.router.Route(prefix, func(r chi.Router) {
 		r.Get("/*", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			sFs.ServeHTTP(w, r)
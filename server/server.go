@@ -3,6 +3,7 @@ package server
 import (
 	"crypto/tls"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"path"
@@ -20,9 +21,16 @@ import (
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 
-	"github.com/rsoury/applepay"
 	"github.com/rsoury/buyte/buyte"
 	"github.com/rsoury/buyte/conf"
+	"github.com/rsoury/buyte/pkg/applepaydomain"
+	"github.com/rsoury/buyte/pkg/applepaymerchant"
+	"github.com/rsoury/buyte/pkg/applepaytoken"
+	"github.com/rsoury/buyte/pkg/googlepay"
+	"github.com/rsoury/buyte/pkg/paymentmethod"
+	"github.com/rsoury/buyte/pkg/samsungpay"
+	"github.com/rsoury/buyte/pkg/secrets"
+	"github.com/rsoury/buyte/pkg/tax"
 	"github.com/rsoury/buyte/pkg/user"
 	"github.com/rsoury/buyte/pkg/util"
 	"github.com/rsoury/buyte/test"
@@ -34,12 +42,27 @@ type Server struct {
 	router   chi.Router
 	server   *http.Server
 	store    buyte.Store
-	applepay *applepay.Merchant
+	verifier *applepaytoken.Verifier
+	keyring  *applepaytoken.Keyring
+	// Decrypts Google Pay DIRECT tokenization tokens
+	googlePay *googlepay.Decryptor
+	// Handlers of each wallet, PayPal and Afterpay payment method, with a public process endpoint each.
+	paymentMethods *paymentmethod.Registry
+	// Calculates the tax of orders. Nil when tax is not calculated.
+	tax tax.Calculator
+
+	applePayMerchants *applepaymerchant.Resolver
+	sessionURLs       *applepaymerchant.SessionURLValidator
+	registrar         applepaydomain.Registrar
+	domainVerifier    applepaydomain.Verifier
+	domainAssociation []byte
 }
 
 var (
 	devRequestGlob    = glob.MustCompile("/{dev,.well-known,favicon}*")
 	publicRequestGlob = glob.MustCompile("/v*/public/**")
+	// Requested by Apple, without authentication.
+	wellKnownRequestGlob = glob.MustCompile("/.well-known/**")
 )
 
 func isDevRequest(uri string) bool {
@@ -48,12 +71,30 @@ func isDevRequest(uri string) bool {
 func isPublicRequest(uri string) bool {
 	return publicRequestGlob.Match(uri)
 }
+func isWellKnownRequest(uri string) bool {
+	return wellKnownRequestGlob.Match(uri)
+}
+
+func requestUserId(r *http.Request) string {
+	if u, ok := user.FromContextOk(r.Context()); ok {
+		return u.ID
+	}
+	return ""
+}
 
 // apiStrictMiddleware exists because their is a is a /dev endpoint for development.
 // This wraps middleware and only applies the middleware strictly for API requests
 func apiStrictMiddleware(middleware func(next http.Handler) http.Handler) func(next http.Handler) http.Handler {
 	if config.GetBool("server.production") {
-		return middleware
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if isWellKnownRequest(r.RequestURI) {
+					next.ServeHTTP(w, r)
+				} else {
+					middleware(next).ServeHTTP(w, r)
+				}
+			})
+		}
 	} else {
 		return func(next http.Handler) http.Handler {
 			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -149,7 +190,7 @@ func New(store buyte.Store) (*Server, error) {
 						zap.String("request", r.RequestURI),
 						zap.String("method", r.Method),
 						zap.String("referrer", r.Referer()),
-						zap.String("user", user.FromContext(r.Context()).ID),
+						zap.String("user", requestUserId(r)),
 						zap.String("package", "server.request"),
 					}
 					if requestID != "" {
@@ -174,30 +215,142 @@ func New(store buyte.Store) (*Server, error) {
 	if certRoot == "" {
 		certRoot = path.Join(util.DirName(), "/../")
 	}
-	applepay.UnsafeSignatureVerification = true
-	ap, err := applepay.New(
-		config.GetString("apple.merchant.id"),
-		applepay.MerchantDisplayName(config.GetString("apple.merchant.name")),
-		applepay.MerchantDomainName(config.GetString("apple.merchant.domain")),
-		applepay.MerchantCertificateLocation(
-			path.Join(certRoot, "/certs/cert-merchant.crt"),
-			path.Join(certRoot, "/certs/cert-merchant-key.pem"),
-		),
-		applepay.ProcessingCertificateLocation(
-			path.Join(certRoot, "/certs/cert-processing.crt"),
-			path.Join(certRoot, "/certs/cert-processing-key.pem"),
-		),
+	rootPath := config.GetString("apple.root.path")
+	if rootPath == "" {
+		rootPath = path.Join(certRoot, "/certs/AppleRootCA-G3.crt")
+	}
+	root, err := applepaytoken.LoadRootCertificate(rootPath, config.GetString("apple.root.fingerprint"))
+	if err != nil {
+		zap.L().Warn("Cannot load the Apple root certificate. Apple Pay payments will be rejected.", zap.Error(err))
+	}
+	verifier := applepaytoken.New(root, config.GetDuration("apple.verification.window"), config.GetBool("apple.verification.sandbox"))
+	if verifier.Sandbox {
+		zap.L().Warn("Apple Pay sandbox mode. The token signing time window is not enforced.")
+	}
+
+	var merchantCertificate *tls.Certificate
+	cert, err := tls.LoadX509KeyPair(
+		path.Join(certRoot, "/certs/cert-merchant.crt"),
+		path.Join(certRoot, "/certs/cert-merchant-key.pem"),
 	)
 	if err != nil {
 		zap.L().Warn("Cannot find Apple Pay Certificates. Running API without Apple Pay authority.", zap.Error(err))
+	} else {
+		merchantCertificate = &cert
+	}
+	processingCerts := config.GetString("apple.processing.certs")
+	if processingCerts == "" {
+		processingCerts = path.Join(certRoot, "/certs/cert-processing*.crt")
+	}
+	keyring := applepaytoken.NewKeyring(config.GetString("apple.merchant.id"), processingCerts)
+
+	// Merchants may use their own Apple Pay identity, with certificates in the secrets store. Otherwise, Buyte's is used.
+	var secretStore secrets.Store
+	if config.GetString("secrets.type") != "" {
+		secretStore, err = secrets.New()
+		if err != nil {
+			return nil, err
+		}
+	}
+	applePayMerchants := applepaymerchant.NewResolver(
+		applepaymerchant.NewIdentity(
+			"",
+			config.GetString("apple.merchant.id"),
+			config.GetString("apple.merchant.name"),
+			[]string{config.GetString("apple.merchant.domain")},
+			merchantCertificate,
+			keyring,
+		),
+		secretStore,
+		config.GetDuration("apple.merchants.cache_ttl"),
+	)
+
+	sessionHosts := config.GetStringSlice("apple.session.hosts")
+	if len(sessionHosts) == 0 {
+		sessionHosts = applepaymerchant.SessionHosts
+	}
+
+	// Merchant domains are registered with Apple against Buyte's identity, verified by Buyte's domain association file.
+	registrar, err := applepaydomain.NewRegistrar(merchantCertificate)
+	if err != nil {
+		return nil, err
+	}
+	if config.GetString("apple.registrar.type") == "local" {
+		zap.L().Warn("Apple Pay domains are registered with a local stand-in, rather than Apple.")
+	}
+	associationPath := config.GetString("apple.merchant.domain_association")
+	if associationPath == "" {
+		associationPath = path.Join(certRoot, "/certs/apple-developer-merchantid-domain-association")
+	}
+	domainAssociation, err := ioutil.ReadFile(associationPath)
+	if err != nil {
+		zap.L().Warn("Cannot find the Apple Pay domain association file. Merchant domains cannot be verified.", zap.Error(err))
+	}
+
+	// Setup Google Pay DIRECT tokenization. Tokens are encrypted for Buyte's own Google merchant keys, rather than for a gateway.
+	googleRootPath := config.GetString("google.root.path")
+	if googleRootPath == "" {
+		googleRootPath = path.Join(certRoot, "/certs/google-root-signing-keys.json")
+	}
+	googleMerchantKeys := config.GetString("google.merchant.keys")
+	if googleMerchantKeys == "" {
+		googleMerchantKeys = path.Join(certRoot, "/certs/google-merchant*-key.pem")
+	}
+	googleRootKeys, err := googlepay.LoadRootKeys(googleRootPath)
+	if err != nil && config.GetBool("google.tokenization.direct") {
+		zap.L().Warn("Cannot load the Google root signing keys. Google Pay DIRECT tokens will be rejected.", zap.Error(err))
+	}
+	googlePrivateKeys, err := googlepay.LoadPrivateKeys(googleMerchantKeys)
+	if err != nil && config.GetBool("google.tokenization.direct") {
+		zap.L().Warn("Cannot find Google Pay merchant keys. Google Pay will use gateway tokenization.", zap.Error(err))
+	}
+	googlePay := googlepay.NewDecryptor(config.GetString("google.merchant.id"), googleRootKeys, googlePrivateKeys)
+
+	// Setup Samsung Pay. 3DS credentials are encrypted for the keys of the CSRs uploaded to the Samsung Pay partner portal.
+	samsungMerchantKeys := config.GetString("samsung.merchant.keys")
+	if samsungMerchantKeys == "" {
+		samsungMerchantKeys = path.Join(certRoot, "/certs/samsung-merchant*-key.pem")
+	}
+	samsungPrivateKeys, err := samsungpay.LoadPrivateKeys(samsungMerchantKeys)
+	if err != nil {
+		zap.L().Warn("Cannot find Samsung Pay merchant keys. Samsung Pay payments will be rejected.", zap.Error(err))
+	}
+
+	// Setup tax
+	taxTable, err := tax.New()
+	if err != nil {
+		return nil, err
 	}
 
 	s := &Server{
-		logger:   zap.S().With("package", "server"),
-		router:   r,
-		store:    store,
-		applepay: ap,
+		logger:            zap.S().With("package", "server"),
+		router:            r,
+		store:             store,
+		applePayMerchants: applePayMerchants,
+		sessionURLs:       applepaymerchant.NewSessionURLValidator(sessionHosts),
+		registrar:         registrar,
+		domainVerifier:    applepaydomain.NewHTTPVerifier(),
+		domainAssociation: domainAssociation,
+		verifier:          verifier,
+		keyring:           keyring,
+		googlePay:         googlePay,
+	}
+	if taxTable != nil {
+		s.tax = taxTable
+	}
+	s.paymentMethods, err = paymentmethod.NewRegistry(
+		&paymentmethod.ApplePay{Verifier: verifier, Identity: s.applePayIdentity},
+		&paymentmethod.GooglePay{Decryptor: googlePay},
+		&paymentmethod.SamsungPay{Decryptor: samsungpay.NewDecryptor(samsungPrivateKeys)},
+		&paymentmethod.PayPal{Orders: s.payPalOrders},
+		&paymentmethod.Afterpay{Checkouts: s.afterpayCheckouts},
+	)
+	if err != nil {
+		return nil, err
 	}
+	s.LoadProcessingKeys()
+	conf.OnReload(s.LoadProcessingKeys)
+	conf.OnReload(s.applePayMerchants.Flush)
 	s.SetupRoutes()
 
 	// Keep all "server.production" options in one place.
@@ -209,6 +362,27 @@ func New(store buyte.Store) (*Server, error) {
 
 }
 
+// LoadProcessingKeys (re)loads the Apple Pay Payment Processing Certificates, logging when each expires.
+func (s *Server) LoadProcessingKeys() {
+	keys, errs := s.keyring.Load()
+	for _, err := range errs {
+		s.logger.Warnw("Cannot load Apple Pay processing certificate.", "error", err)
+	}
+	warning := config.GetDuration("apple.processing.expiry_warning")
+	now := time.Now()
+	for _, key := range keys {
+		fields := []interface{}{"publicKeyHash", key.PublicKeyHash, "path", key.CertificatePath, "notAfter", key.NotAfter}
+		switch {
+		case key.Expired(now):
+			s.logger.Errorw("Apple Pay processing certificate has expired.", fields...)
+		case key.ExpiresWithin(warning, now):
+			s.logger.Warnw("Apple Pay processing certificate expires soon. Please rotate it.", fields...)
+		default:
+			s.logger.Infow("Apple Pay processing certificate loaded.", fields...)
+		}
+	}
+}
+
 // ListenAndServe will listen for requests
 func (s *Server) ListenAndServe() error {
 
@@ -2,17 +2,18 @@ package main
 
 import (
 	"context"
-	"errors"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	httpadapter "github.com/awslabs/aws-lambda-go-api-proxy/httpadapter"
+	"github.com/pkg/errors"
 	config "github.com/spf13/viper"
 	"go.uber.org/zap"
 
 	"github.com/rsoury/buyte/buyte"
 	"github.com/rsoury/buyte/cmd"
 	"github.com/rsoury/buyte/conf"
+	"github.com/rsoury/buyte/pkg/envelope"
 	"github.com/rsoury/buyte/server"
 	"github.com/rsoury/buyte/store/graphql"
 )
@@ -45,7 +46,19 @@ func init() {
 			)
 		}
 		// Despite being the Graphql Client type, it satisfies the store interface
-		store = graphql.New()
+		client := graphql.New()
+		sealer, err := envelope.New()
+		if err != nil {
+			logger.Fatalw("Could not start server",
+				"error", errors.Wrap(err, "Could not setup payment token encryption"),
+			)
+		}
+		if sealer != nil {
+			client.EncryptPaymentTokens(sealer)
+		} else if config.GetBool("server.production") {
+			logger.Warnw("Payment token values are stored unencrypted. Set 'tokens.encryption.provider' to encrypt them.")
+		}
+		store = client
 	default:
 		logger.Fatalw("Could not start server",
 			"error", errors.New("Invalid 'storage.type'"),
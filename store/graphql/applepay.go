@@ -0,0 +1,210 @@
+package graphql
+
+import (
+	"context"
+
+	"github.com/machinebox/graphql"
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+	config "github.com/spf13/viper"
+
+	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/user"
+	"github.com/rsoury/buyte/store"
+)
+
+const applePayMerchantFields = `
+	id
+	merchantIdentifier
+	displayName
+	domains
+	certificatesSecret
+	isDefault
+`
+
+// GetApplePayMerchant gets the Apple Pay identity set against the checkout, otherwise the user's default.
+// Returns an empty ApplePayMerchant when the merchant uses Buyte's identity.
+func (c *Client) GetApplePayMerchant(ctx context.Context, checkoutId string) (*buyte.ApplePayMerchant, error) {
+	u := user.FromContext(ctx)
+	auth := u.AccessToken
+
+	req := graphql.NewRequest(`
+		query GetApplePayMerchant($id: ID!) {
+			getCheckout(id: $id) {
+				applePayMerchant {` + applePayMerchantFields + `}
+			}
+			listApplePayMerchants(filter: { isDefault: { eq: true } }) {
+				items {` + applePayMerchantFields + `}
+			}
+		}
+	`)
+	req.Var("id", checkoutId)
+	req.Header.Set("Authorization", auth)
+
+	var respData struct {
+		GetCheckout struct {
+			ApplePayMerchant *buyte.ApplePayMerchant
+		}
+		ListApplePayMerchants struct {
+			Items []buyte.ApplePayMerchant
+		}
+	}
+	var resp map[string]interface{}
+	if err := c.Run(ctx, req, &resp); err != nil {
+		return &buyte.ApplePayMerchant{}, err
+	}
+	c.logger.Debugw("Graphql: Get Apple Pay Merchant", "Response Data", resp)
+	if err := mapstructure.Decode(resp, &respData); err != nil {
+		return &buyte.ApplePayMerchant{}, err
+	}
+
+	if merchant := respData.GetCheckout.ApplePayMerchant; merchant != nil && merchant.ID != "" {
+		return merchant, nil
+	}
+	if len(respData.ListApplePayMerchants.Items) > 0 {
+		return &respData.ListApplePayMerchants.Items[0], nil
+	}
+	return &buyte.ApplePayMerchant{}, nil
+}
+
+// GetUserApplePayMerchant gets one of the user's Apple Pay identities.
+// Returns an empty ApplePayMerchant when the user has no identity by the id.
+func (c *Client) GetUserApplePayMerchant(ctx context.Context, id string) (*buyte.ApplePayMerchant, error) {
+	u := user.FromContext(ctx)
+	auth := u.AccessToken
+
+	req := graphql.NewRequest(`
+		query GetUserApplePayMerchant($id: ID!) {
+			getApplePayMerchant(id: $id) {` + applePayMerchantFields + `}
+		}
+	`)
+	req.Var("id", id)
+	req.Header.Set("Authorization", auth)
+
+	var respData map[string]interface{}
+	if err := c.Run(ctx, req, &respData); err != nil {
+		if store.IsConnectionUnauthorized(err) {
+			return &buyte.ApplePayMerchant{}, nil
+		}
+		return &buyte.ApplePayMerchant{}, err
+	}
+	merchant := &buyte.ApplePayMerchant{}
+	if err := mapstructure.Decode(respData["getApplePayMerchant"], merchant); err != nil {
+		return &buyte.ApplePayMerchant{}, err
+	}
+	return merchant, nil
+}
+
+const applePayDomainQLModel = `
+	id
+	domain
+	status
+	associationFile
+	applePayMerchant {
+		id
+	}
+`
+
+type applePayDomainData struct {
+	ID               string
+	Domain           string
+	Status           string
+	AssociationFile  string
+	ApplePayMerchant *struct {
+		ID string
+	}
+}
+
+func (d *applePayDomainData) format() *buyte.ApplePayDomain {
+	domain := &buyte.ApplePayDomain{
+		ID:              d.ID,
+		Object:          buyte.APPLE_PAY_DOMAIN,
+		Domain:          d.Domain,
+		Status:          d.Status,
+		AssociationFile: d.AssociationFile,
+	}
+	if d.ApplePayMerchant != nil {
+		domain.ApplePayMerchantId = d.ApplePayMerchant.ID
+	}
+	return domain
+}
+
+// CreateApplePayDomain creates a domain, keyed on the domain itself.
+// Creates are conditional on the id not existing, so a domain registered by another merchant returns store.ErrAlreadyExists.
+func (c *Client) CreateApplePayDomain(ctx context.Context, input *buyte.CreateApplePayDomainInput) (*buyte.ApplePayDomain, error) {
+	u := user.FromContext(ctx)
+	auth := u.AccessToken
+
+	input.ID = "apd_" + input.Domain
+
+	req := graphql.NewRequest(`
+		mutation CreateApplePayDomain($input: CreateApplePayDomainInput!) {
+			createApplePayDomain(input: $input) {
+				` + applePayDomainQLModel + `
+			}
+		}
+	`)
+	req.Var("input", input)
+	req.Header.Set("Authorization", auth)
+
+	var respData map[string]interface{}
+	if err := c.Run(ctx, req, &respData); err != nil {
+		if store.IsConditionalCheckFailed(err) {
+			return &buyte.ApplePayDomain{}, store.ErrAlreadyExists
+		}
+		return &buyte.ApplePayDomain{}, err
+	}
+	data := &applePayDomainData{}
+	if err := mapstructure.Decode(respData["createApplePayDomain"], data); err != nil {
+		return &buyte.ApplePayDomain{}, err
+	}
+
+	c.logger.Infow("Apple Pay Domain", "action", "create", "id", input.ID, "domain", input.Domain)
+
+	return data.format(), nil
+}
+
+// GetApplePayDomain gets a domain as registered by any merchant, using the API key. ie. Apple fetching the domain association file
+// Domains may only be registered by one merchant, so more than one registration is an error.
+func (c *Client) GetApplePayDomain(ctx context.Context, domain string) (*buyte.ApplePayDomain, error) {
+	return c.getApplePayDomain(ctx, domain, "x-api-key", config.GetString("storage.api_key"))
+}
+
+// GetUserApplePayDomain gets a domain as registered by the user.
+func (c *Client) GetUserApplePayDomain(ctx context.Context, domain string) (*buyte.ApplePayDomain, error) {
+	u := user.FromContext(ctx)
+	return c.getApplePayDomain(ctx, domain, "Authorization", u.AccessToken)
+}
+
+func (c *Client) getApplePayDomain(ctx context.Context, domain string, authHeader string, auth string) (*buyte.ApplePayDomain, error) {
+	req := graphql.NewRequest(`
+		query ApplePayDomainsByDomain($domain: String!) {
+			applePayDomainsByDomain(domain: $domain) {
+				items {
+					` + applePayDomainQLModel + `
+				}
+			}
+		}
+	`)
+	req.Var("domain", domain)
+	req.Header.Set(authHeader, auth)
+
+	var respData map[string]interface{}
+	if err := c.Run(ctx, req, &respData); err != nil {
+		return &buyte.ApplePayDomain{}, err
+	}
+	var domains struct {
+		Items []applePayDomainData
+	}
+	if err := mapstructure.Decode(respData["applePayDomainsByDomain"], &domains); err != nil {
+		return &buyte.ApplePayDomain{}, err
+	}
+	switch len(domains.Items) {
+	case 0:
+		return &buyte.ApplePayDomain{}, nil
+	case 1:
+		return domains.Items[0].format(), nil
+	default:
+		return &buyte.ApplePayDomain{}, errors.Errorf("Apple Pay domain %s is registered %d times", domain, len(domains.Items))
+	}
+}
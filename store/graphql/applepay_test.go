@@ -0,0 +1,73 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	config "github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/user"
+	"github.com/rsoury/buyte/store"
+)
+
+// Stands in for the GraphQL API, responding with the given domain registrations.
+func applePayDomainsServer(t *testing.T, items string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "api_key_xxx", r.Header.Get("x-api-key"), "Domains should be looked up across every merchant.")
+		_, _ = w.Write([]byte(`{"data":{"applePayDomainsByDomain":{"items":` + items + `}}}`))
+	}))
+}
+
+func TestGetApplePayDomain(t *testing.T) {
+	assert := assert.New(t)
+	endpoint, apiKey := config.GetString("storage.endpoint"), config.GetString("storage.api_key")
+	defer func() {
+		config.Set("storage.endpoint", endpoint)
+		config.Set("storage.api_key", apiKey)
+	}()
+	config.Set("storage.api_key", "api_key_xxx")
+
+	server := applePayDomainsServer(t, `[{"id":"apd_xxx","domain":"shop.example.com","status":"registered"}]`)
+	defer server.Close()
+	config.Set("storage.endpoint", server.URL)
+	domain, err := New().GetApplePayDomain(context.Background(), "shop.example.com")
+	assert.NoError(err)
+	assert.Equal("apd_xxx", domain.ID)
+
+	server = applePayDomainsServer(t, `[{"id":"apd_xxx","domain":"shop.example.com"},{"id":"apd_yyy","domain":"shop.example.com"}]`)
+	defer server.Close()
+	config.Set("storage.endpoint", server.URL)
+	_, err = New().GetApplePayDomain(context.Background(), "shop.example.com")
+	assert.Error(err, "A domain registered by two merchants should not be served for either.")
+}
+
+func TestCreateApplePayDomainAlreadyExists(t *testing.T) {
+	assert := assert.New(t)
+	endpoint := config.GetString("storage.endpoint")
+	defer config.Set("storage.endpoint", endpoint)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			Variables struct {
+				Input map[string]interface{}
+			}
+		}
+		_ = json.NewDecoder(r.Body).Decode(&request)
+		assert.Equal("apd_shop.example.com", request.Variables.Input["id"], "Domains should be keyed on the domain, so that they are only created once.")
+		_, _ = w.Write([]byte(`{"data":{"createApplePayDomain":null},"errors":[{"errorType":"DynamoDB:ConditionalCheckFailedException","message":"The conditional request failed (Service: AmazonDynamoDBv2; Status Code: 400)"}]}`))
+	}))
+	defer server.Close()
+	config.Set("storage.endpoint", server.URL)
+
+	u := &user.User{ID: "user_xxx", AccessToken: "access_token_xxx"}
+	_, err := New().CreateApplePayDomain(u.WithContext(context.Background()), &buyte.CreateApplePayDomainInput{
+		Domain: "shop.example.com",
+		Status: buyte.APPLE_PAY_DOMAIN_REGISTERED,
+	})
+	assert.Equal(store.ErrAlreadyExists, err)
+}
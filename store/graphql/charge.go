@@ -9,6 +9,7 @@ import (
 	"github.com/mitchellh/mapstructure"
 	"github.com/pkg/errors"
 	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/tax"
 	"github.com/rsoury/buyte/pkg/user"
 )
 
@@ -53,6 +54,17 @@ const chargeQLModel = `
 	captured
 	description
 	metadata
+	providerCharge {
+		reference
+		type
+		connectionId
+		customerReference
+		paymentMethodReference
+	}
+	agreement {
+		` + agreementQLModel + `
+	}
+	initialCharge
 	customer {
 		name
 		givenName
@@ -72,6 +84,8 @@ const chargeQLModel = `
 		items
 		shipping
 		customer
+		subtotal
+		tax
 	}
 	createdAt
 `
@@ -82,8 +96,8 @@ func responseToChargeDecodeHook() mapstructure.DecodeHookFunc {
 		t reflect.Type,
 		data interface{},
 	) (interface{}, error) {
-		// String to Map JSON
-		if f.Kind() == reflect.String && t.Kind() == reflect.Map {
+		// String to Map JSON. Structs are decoded from the map. ie. The order's tax breakdown
+		if f.Kind() == reflect.String && (t.Kind() == reflect.Map || t == reflect.TypeOf(tax.Breakdown{})) {
 			// Convert JSON string to map[string]interface{}
 			var result map[string]interface{}
 			err := json.Unmarshal([]byte(data.(string)), &result)
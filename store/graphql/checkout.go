@@ -3,7 +3,6 @@ package graphql
 import (
 	"context"
 	"errors"
-	"strings"
 
 	"github.com/buger/jsonparser"
 	"github.com/machinebox/graphql"
@@ -11,7 +10,9 @@ import (
 	config "github.com/spf13/viper"
 
 	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/shipping"
 	"github.com/rsoury/buyte/pkg/user"
+	"github.com/rsoury/buyte/store"
 )
 
 type (
@@ -22,14 +23,25 @@ type (
 			Iso  string `json:"iso"`
 			Name string `json:"name"`
 		} `json:"countries"`
+		Regions    []string `json:"regions"`
+		Postcodes  []string `json:"postcodes"`
 		PriceRates struct {
 			Items []struct {
-				ID            string      `json:"id"`
-				Label         string      `json:"label"`
-				Description   string      `json:"description"`
-				MinOrderPrice int         `json:"minOrderPrice"`
-				MaxOrderPrice interface{} `json:"maxOrderPrice"`
-				Rate          int         `json:"rate"`
+				ID                    string      `json:"id"`
+				Label                 string      `json:"label"`
+				Description           string      `json:"description"`
+				MinOrderPrice         int         `json:"minOrderPrice"`
+				MaxOrderPrice         interface{} `json:"maxOrderPrice"`
+				Rate                  int         `json:"rate"`
+				MinItems              int         `json:"minItems"`
+				MaxItems              int         `json:"maxItems"`
+				MinWeight             int         `json:"minWeight"`
+				MaxWeight             int         `json:"maxWeight"`
+				PerItem               int         `json:"perItem"`
+				PerKilogram           int         `json:"perKilogram"`
+				FreeShippingThreshold int         `json:"freeShippingThreshold"`
+				MinDeliveryDays       int         `json:"minDeliveryDays"`
+				MaxDeliveryDays       int         `json:"maxDeliveryDays"`
 			}
 		}
 	}
@@ -54,10 +66,60 @@ type (
 				} `json:"paymentOption"`
 			} `json:"items"`
 		} `json:"paymentOptions"`
-		ShippingZone *ShippingZone `json:"-"`
+		AllowedCardNetworks    []string       `json:"allowedCardNetworks"`
+		RequiredBillingFields  []string       `json:"requiredBillingFields"`
+		RequiredShippingFields []string       `json:"requiredShippingFields"`
+		RequireEmail           bool           `json:"requireEmail"`
+		RequirePhone           bool           `json:"requirePhone"`
+		ShippingZone           *shipping.Zone `json:"-"`
 	}
 )
 
+// Zone converts the zone for matching and pricing. See shipping.Zone
+func (z *ShippingZone) Zone() shipping.Zone {
+	zone := shipping.Zone{
+		ID:        z.ID,
+		Name:      z.Name,
+		Regions:   z.Regions,
+		Postcodes: z.Postcodes,
+	}
+	for _, country := range z.Countries {
+		zone.Countries = append(zone.Countries, country.Iso)
+	}
+	for _, rate := range z.PriceRates.Items {
+		maxOrder := 0
+		switch value := rate.MaxOrderPrice.(type) {
+		case int:
+			maxOrder = value
+		case float64:
+			maxOrder = int(value)
+		}
+		shippingRate := shipping.Rate{
+			ID:                    rate.ID,
+			Label:                 rate.Label,
+			Description:           rate.Description,
+			Rate:                  rate.Rate,
+			MinOrder:              rate.MinOrderPrice,
+			MaxOrder:              maxOrder,
+			MinItems:              rate.MinItems,
+			MaxItems:              rate.MaxItems,
+			MinWeight:             rate.MinWeight,
+			MaxWeight:             rate.MaxWeight,
+			PerItem:               rate.PerItem,
+			PerKilogram:           rate.PerKilogram,
+			FreeShippingThreshold: rate.FreeShippingThreshold,
+		}
+		if rate.MinDeliveryDays > 0 || rate.MaxDeliveryDays > 0 {
+			shippingRate.Delivery = &shipping.DeliveryWindow{
+				MinDays: rate.MinDeliveryDays,
+				MaxDays: rate.MaxDeliveryDays,
+			}
+		}
+		zone.Rates = append(zone.Rates, shippingRate)
+	}
+	return zone
+}
+
 // Using Main User Struct
 // SUGGEST: Filter the shipping zones within the Graphql Query...
 // --> Current Fix: Filtering in Go
@@ -79,6 +141,8 @@ func (c *Client) GetFullCheckout(ctx context.Context, checkoutId string, options
 						iso
 						name
 					}
+					regions
+					postcodes
 					priceRates {
 						items {
 							id
@@ -87,6 +151,15 @@ func (c *Client) GetFullCheckout(ctx context.Context, checkoutId string, options
 							minOrderPrice
 							maxOrderPrice
 							rate
+							minItems
+							maxItems
+							minWeight
+							maxWeight
+							perItem
+							perKilogram
+							freeShippingThreshold
+							minDeliveryDays
+							maxDeliveryDays
 						}
 					}
 				}
@@ -115,6 +188,11 @@ func (c *Client) GetFullCheckout(ctx context.Context, checkoutId string, options
 						}
 					}
 				}
+				allowedCardNetworks
+				requiredBillingFields
+				requiredShippingFields
+				requireEmail
+				requirePhone
 				isArchived
 			}
 			` + shippingZoneQuery + `
@@ -139,8 +217,8 @@ func (c *Client) GetFullCheckout(ctx context.Context, checkoutId string, options
 		return &buyte.FullCheckout{}, errors.New("graphql: Not Authorized")
 	}
 
-	// Configure shipping zones, filter by provided country code.
-	filteredZone := &ShippingZone{}
+	// Configure shipping zones, filter by the user's location.
+	filteredZone := &shipping.Zone{}
 	if userAttributes.ShippingModule == 1 {
 		shippingZones := map[string][]ShippingZone{
 			"items": []ShippingZone{},
@@ -148,16 +226,12 @@ func (c *Client) GetFullCheckout(ctx context.Context, checkoutId string, options
 		if err := mapstructure.Decode(respData["listShippingZones"], &shippingZones); err != nil {
 			return &buyte.FullCheckout{}, err
 		}
+		zones := make([]shipping.Zone, 0, len(shippingZones["items"]))
 		for _, zone := range shippingZones["items"] {
-			for _, country := range zone.Countries {
-				if strings.EqualFold(country.Iso, options.UserCountryCode) {
-					filteredZone = &zone
-					break
-				}
-			}
-			if filteredZone.ID != "" {
-				break
-			}
+			zones = append(zones, zone.Zone())
+		}
+		if zone := shipping.Match(zones, options.Location()); zone != nil {
+			filteredZone = zone
 		}
 		c.logger.Debugw("Graphql: Get Checkout", "Shipping Zone", filteredZone)
 	}
@@ -186,21 +260,18 @@ func (c *Client) GetFullCheckout(ctx context.Context, checkoutId string, options
 		}
 		checkoutOptions = append(checkoutOptions, option)
 	}
-	// Format Shipping Methods
+	// Format Shipping Methods. Rates are priced for the order, where known.
+	rates := checkout.ShippingZone.Rates
+	if options.Order != nil {
+		rates = checkout.ShippingZone.Available(options.Order)
+	}
 	var shippingMethods []buyte.FullCheckoutShippingMethod
-	for _, rate := range checkout.ShippingZone.PriceRates.Items {
-		method := buyte.FullCheckoutShippingMethod{
-			ID:          rate.ID,
-			Name:        rate.Label,
-			Description: rate.Description,
-			Rate:        rate.Rate,
-			MinOrder:    rate.MinOrderPrice,
-			MaxOrder:    rate.MaxOrderPrice,
-		}
-		shippingMethods = append(shippingMethods, method)
+	for _, rate := range rates {
+		shippingMethods = append(shippingMethods, buyte.NewFullCheckoutShippingMethod(rate))
 	}
 
 	var publicKeyBytes []byte
+	var gatewayAdditionalData map[string]string
 	switch checkout.Connection.Type {
 	case buyte.STRIPE:
 		isConnect, _ := jsonparser.GetBoolean([]byte(checkout.Connection.Credentials), "isConnect")
@@ -215,13 +286,42 @@ func (c *Client) GetFullCheckout(ctx context.Context, checkoutId string, options
 		}
 	case buyte.ADYEN:
 		publicKeyBytes, _, _, _ = jsonparser.Get([]byte(checkout.Connection.Credentials), "merchantAccount")
+	case buyte.BRAINTREE:
+		publicKeyBytes, _, _, _ = jsonparser.Get([]byte(checkout.Connection.Credentials), "tokenizationKey")
+		merchantId, _ := jsonparser.GetString([]byte(checkout.Connection.Credentials), "merchantId")
+		gatewayAdditionalData = map[string]string{
+			"merchantId": merchantId,
+		}
+	case buyte.CHECKOUTCOM:
+		publicKeyBytes, _, _, _ = jsonparser.Get([]byte(checkout.Connection.Credentials), "publicKey")
+	case buyte.SQUARE:
+		publicKeyBytes, _, _, _ = jsonparser.Get([]byte(checkout.Connection.Credentials), "applicationId")
+		locationId, _ := jsonparser.GetString([]byte(checkout.Connection.Credentials), "locationId")
+		gatewayAdditionalData = map[string]string{
+			"locationId": locationId,
+		}
+	case buyte.PAYPAL_CHECKOUT:
+		publicKeyBytes, _, _, _ = jsonparser.Get([]byte(checkout.Connection.Credentials), "clientId")
 	default:
 	}
 	gatewayProvider := buyte.FullCheckoutGatewayProvider{
-		ID:        checkout.Connection.Provider.ID,
-		Name:      checkout.Connection.Provider.Name,
-		PublicKey: string(publicKeyBytes),
-		IsTest:    checkout.Connection.IsTest,
+		ID:             checkout.Connection.Provider.ID,
+		Type:           checkout.Connection.Type,
+		Name:           checkout.Connection.Provider.Name,
+		PublicKey:      string(publicKeyBytes),
+		IsTest:         checkout.Connection.IsTest,
+		AdditionalData: gatewayAdditionalData,
+	}
+
+	var requirements *buyte.CheckoutRequirements
+	if checkoutRequirements := (&buyte.CheckoutRequirements{
+		AllowedCardNetworks:    checkout.AllowedCardNetworks,
+		RequiredBillingFields:  checkout.RequiredBillingFields,
+		RequiredShippingFields: checkout.RequiredShippingFields,
+		RequireEmail:           checkout.RequireEmail,
+		RequirePhone:           checkout.RequirePhone,
+	}); !checkoutRequirements.IsEmpty() {
+		requirements = checkoutRequirements
 	}
 
 	return &buyte.FullCheckout{
@@ -238,6 +338,62 @@ func (c *Client) GetFullCheckout(ctx context.Context, checkoutId string, options
 			Logo:       userAttributes.Logo,
 			CoverImage: userAttributes.CoverImage,
 		},
-		CustomCSS: userAttributes.CustomCSS,
+		CustomCSS:    userAttributes.CustomCSS,
+		Requirements: requirements,
 	}, nil
 }
+
+func (c *Client) GetCheckoutConnections(ctx context.Context, checkoutId string) (*buyte.PaymentTokenCheckout, error) {
+	u := user.FromContext(ctx)
+	auth := u.AccessToken
+
+	req := graphql.NewRequest(`
+		query GetCheckoutConnections($id: ID!) {
+			getCheckout(id: $id) {
+				id
+				label
+				description
+				connection {
+					` + connectionQLModel + `
+				}
+				connections {
+					items {
+						id
+						priority
+						rules
+						connection {
+							` + connectionQLModel + `
+						}
+					}
+				}
+				isArchived
+			}
+		}
+	`)
+	req.Var("id", checkoutId)
+	req.Header.Set("Authorization", auth)
+
+	var respData map[string]interface{}
+	if err := c.Run(ctx, req, &respData); err != nil {
+		return &buyte.PaymentTokenCheckout{}, err
+	}
+
+	getCheckout, ok := respData["getCheckout"].(map[string]interface{})
+	if !ok {
+		return &buyte.PaymentTokenCheckout{}, store.ErrNotFound
+	}
+
+	// if requesting an archived checkout, throw unauthorized.
+	if isArchived, _ := getCheckout["isArchived"].(bool); isArchived {
+		return &buyte.PaymentTokenCheckout{}, errors.New("graphql: Not Authorized")
+	}
+
+	checkout := &buyte.PaymentTokenCheckout{}
+	if err := mapstructure.Decode(getCheckout, checkout); err != nil {
+		return &buyte.PaymentTokenCheckout{}, err
+	}
+
+	c.logger.Infow("Checkout Connections", "action", "get", "id", checkoutId)
+
+	return checkout, nil
+}
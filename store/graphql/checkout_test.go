@@ -0,0 +1,29 @@
+package graphql
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	config "github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rsoury/buyte/pkg/user"
+	"github.com/rsoury/buyte/store"
+)
+
+func TestGetCheckoutConnectionsNotFound(t *testing.T) {
+	endpoint := config.GetString("storage.endpoint")
+	defer config.Set("storage.endpoint", endpoint)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data":{"getCheckout":null}}`))
+	}))
+	defer server.Close()
+	config.Set("storage.endpoint", server.URL)
+
+	u := &user.User{ID: "user_xxx", AccessToken: "access_token_xxx"}
+	_, err := New().GetCheckoutConnections(u.WithContext(context.Background()), "checkout_unknown")
+	assert.Equal(t, store.ErrNotFound, err)
+}
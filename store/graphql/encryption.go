@@ -0,0 +1,189 @@
+package graphql
+
+import (
+	"context"
+
+	"github.com/machinebox/graphql"
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+
+	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/user"
+)
+
+// Payment token values, gateway tokens and raw payment requests hold the customer's payment data. ie. A Google Pay gateway token is directly chargeable
+// Each is sealed with its own data key, bound to the token's ID.
+func (c *Client) sealPaymentTokenInput(ctx context.Context, input *buyte.CreatePaymentTokenInput) error {
+	if c.sealer == nil {
+		return nil
+	}
+	plainValue, ok := input.Value.(string)
+	if !ok {
+		return errors.Errorf("Could not encrypt Value of type %T", input.Value)
+	}
+	value, err := c.sealer.Seal(ctx, input.ID, plainValue)
+	if err != nil {
+		return errors.Wrap(err, "Could not encrypt Value")
+	}
+	input.Value = value
+	if input.GatewayToken != "" {
+		input.GatewayToken, err = c.sealer.Seal(ctx, input.ID, input.GatewayToken)
+		if err != nil {
+			return errors.Wrap(err, "Could not encrypt GatewayToken")
+		}
+	}
+	if rawPaymentRequest, ok := input.RawPaymentRequest.(string); ok && rawPaymentRequest != "null" {
+		rawPaymentRequest, err = c.sealer.Seal(ctx, input.ID, rawPaymentRequest)
+		if err != nil {
+			return errors.Wrap(err, "Could not encrypt RawPaymentRequest")
+		}
+		input.RawPaymentRequest = rawPaymentRequest
+	}
+	return nil
+}
+
+func (c *Client) openPaymentToken(ctx context.Context, paymentToken *buyte.PaymentToken) error {
+	if c.sealer == nil {
+		return nil
+	}
+	value, err := c.sealer.Open(ctx, paymentToken.ID, paymentToken.Value)
+	if err != nil {
+		return errors.Wrap(err, "Could not decrypt Value")
+	}
+	paymentToken.Value = value
+	if paymentToken.GatewayToken != "" {
+		paymentToken.GatewayToken, err = c.sealer.Open(ctx, paymentToken.ID, paymentToken.GatewayToken)
+		if err != nil {
+			return errors.Wrap(err, "Could not decrypt GatewayToken")
+		}
+	}
+	return nil
+}
+
+type paymentTokenData struct {
+	ID                string
+	Value             string
+	GatewayToken      string
+	RawPaymentRequest string
+}
+
+// RotatePaymentTokens re-wraps the data keys of every payment token with the key provider's current key, and encrypts values stored before encryption was enabled.
+// Only super users may rotate the tokens of every user. Returns the number of tokens rotated.
+func (c *Client) RotatePaymentTokens(ctx context.Context, limit int) (int, error) {
+	if c.sealer == nil {
+		return 0, errors.New("Payment token encryption is not configured")
+	}
+	rotated := 0
+	nextToken := ""
+	for {
+		paymentTokens, next, err := c.listPaymentTokenData(ctx, limit, nextToken)
+		if err != nil {
+			return rotated, errors.Wrap(err, "Could not list payment tokens")
+		}
+		for _, data := range paymentTokens {
+			value, valueChanged, err := c.sealer.Rotate(ctx, data.ID, data.Value)
+			if err != nil {
+				return rotated, errors.Wrap(err, "Could not rotate Value of "+data.ID)
+			}
+			gatewayToken, gatewayTokenChanged := data.GatewayToken, false
+			if gatewayToken != "" {
+				gatewayToken, gatewayTokenChanged, err = c.sealer.Rotate(ctx, data.ID, gatewayToken)
+				if err != nil {
+					return rotated, errors.Wrap(err, "Could not rotate GatewayToken of "+data.ID)
+				}
+			}
+			rawPaymentRequest, rawChanged := data.RawPaymentRequest, false
+			if rawPaymentRequest != "" && rawPaymentRequest != "null" {
+				rawPaymentRequest, rawChanged, err = c.sealer.Rotate(ctx, data.ID, rawPaymentRequest)
+				if err != nil {
+					return rotated, errors.Wrap(err, "Could not rotate RawPaymentRequest of "+data.ID)
+				}
+			}
+			if !valueChanged && !gatewayTokenChanged && !rawChanged {
+				continue
+			}
+			if err := c.updatePaymentTokenData(ctx, &paymentTokenData{
+				ID:                data.ID,
+				Value:             value,
+				GatewayToken:      gatewayToken,
+				RawPaymentRequest: rawPaymentRequest,
+			}); err != nil {
+				return rotated, errors.Wrap(err, "Could not update "+data.ID)
+			}
+			rotated++
+		}
+		if next == "" {
+			break
+		}
+		nextToken = next
+	}
+
+	c.logger.Infow("Payment Token", "action", "rotate", "rotated", rotated)
+
+	return rotated, nil
+}
+
+func (c *Client) listPaymentTokenData(ctx context.Context, limit int, nextToken string) ([]*paymentTokenData, string, error) {
+	u := user.FromContext(ctx)
+	auth := u.AccessToken
+
+	req := graphql.NewRequest(`
+		query ListPaymentTokenData($limit: Int, $nextToken: String) {
+			listPaymentTokens(limit: $limit, nextToken: $nextToken) {
+				items {
+					id
+					value
+					gatewayToken
+					rawPaymentRequest
+				}
+				nextToken
+			}
+		}
+	`)
+	req.Var("limit", limit)
+	if nextToken != "" {
+		req.Var("nextToken", nextToken)
+	}
+	req.Header.Set("Authorization", auth)
+
+	var respData map[string]interface{}
+	if err := c.Run(ctx, req, &respData); err != nil {
+		return nil, "", err
+	}
+	var page struct {
+		Items     []*paymentTokenData
+		NextToken string
+	}
+	if err := mapstructure.Decode(respData["listPaymentTokens"], &page); err != nil {
+		return nil, "", err
+	}
+	return page.Items, page.NextToken, nil
+}
+
+func (c *Client) updatePaymentTokenData(ctx context.Context, data *paymentTokenData) error {
+	u := user.FromContext(ctx)
+	auth := u.AccessToken
+
+	req := graphql.NewRequest(`
+		mutation UpdatePaymentTokenData($input: UpdatePaymentTokenInput!) {
+			updatePaymentToken(input: $input) {
+				id
+			}
+		}
+	`)
+	input := map[string]interface{}{
+		"id":    data.ID,
+		"value": data.Value,
+	}
+	if data.GatewayToken != "" {
+		input["gatewayToken"] = data.GatewayToken
+	}
+	if data.RawPaymentRequest != "" {
+		input["rawPaymentRequest"] = data.RawPaymentRequest
+	}
+	req.Var("input", input)
+	req.Header.Set("Authorization", auth)
+
+	var respData map[string]interface{}
+	return c.Run(ctx, req, &respData)
+}
@@ -0,0 +1,54 @@
+package graphql
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/rsoury/buyte/buyte"
+	"github.com/rsoury/buyte/pkg/envelope"
+)
+
+func sealingClient(t *testing.T) *Client {
+	dir := t.TempDir()
+	key := make([]byte, 32)
+	_, _ = rand.Read(key)
+	if err := ioutil.WriteFile(filepath.Join(dir, "token-key-1.key"), []byte(base64.StdEncoding.EncodeToString(key)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	provider, err := envelope.LoadFileKeyProvider(filepath.Join(dir, "token-key*.key"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := New()
+	c.EncryptPaymentTokens(envelope.NewSealer(provider))
+	return c
+}
+
+func TestSealPaymentTokenInput(t *testing.T) {
+	assert := assert.New(t)
+	c := sealingClient(t)
+	ctx := context.Background()
+
+	input := &buyte.CreatePaymentTokenInput{ID: "tok_xxx", Value: "value_xxx", GatewayToken: "tok_chargeable"}
+	if err := c.sealPaymentTokenInput(ctx, input); err != nil {
+		t.Fatal(err)
+	}
+	assert.True(envelope.IsSealed(input.Value.(string)))
+	assert.True(envelope.IsSealed(input.GatewayToken), "Gateway tokens are directly chargeable, so should be encrypted.")
+
+	paymentToken := &buyte.PaymentToken{ID: "tok_xxx", Value: input.Value.(string), GatewayToken: input.GatewayToken}
+	if err := c.openPaymentToken(ctx, paymentToken); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal("value_xxx", paymentToken.Value)
+	assert.Equal("tok_chargeable", paymentToken.GatewayToken)
+
+	err := c.sealPaymentTokenInput(ctx, &buyte.CreatePaymentTokenInput{ID: "tok_yyy", Value: map[string]interface{}{}})
+	assert.Error(err, "Values that are not formatted should not be stored.")
+}
@@ -5,12 +5,16 @@ import (
 	"github.com/rs/xid"
 	config "github.com/spf13/viper"
 	"go.uber.org/zap"
+
+	"github.com/rsoury/buyte/pkg/envelope"
 )
 
 type Client struct {
 	*graphql.Client
 	logger *zap.SugaredLogger
 	newID  func(descriptor string) string
+	// Encrypts payment token values at rest. Values are stored as they are when nil.
+	sealer *envelope.Sealer
 }
 
 func New() *Client {
@@ -24,12 +28,17 @@ func New() *Client {
 	)
 
 	c := &Client{
-		graphqlClient,
-		logger,
-		func(descriptor string) string {
+		Client: graphqlClient,
+		logger: logger,
+		newID: func(descriptor string) string {
 			return descriptor + "_" + xid.New().String()
 		},
 	}
 
 	return c
 }
+
+// EncryptPaymentTokens encrypts the values of payment tokens created from now on, and decrypts the values of those read.
+func (c *Client) EncryptPaymentTokens(sealer *envelope.Sealer) {
+	c.sealer = sealer
+}
@@ -2,6 +2,7 @@ package graphql
 
 import (
 	"context"
+	"time"
 
 	"github.com/machinebox/graphql"
 	"github.com/mitchellh/mapstructure"
@@ -30,20 +31,48 @@ const paymentQLModel = `
 	paymentMethod {
 		name
 	}
+	gatewayToken
+	expiresAt
+	purgedAt
+	agreement {
+		` + agreementQLModel + `
+	}
 	checkout{
 		id
 		label
 		description
 		connection {
-			type
-			isTest
-			credentials
-			provider {
-				name
+			` + connectionQLModel + `
+		}
+		connections {
+			items {
+				id
+				priority
+				rules
+				connection {
+					` + connectionQLModel + `
+				}
 			}
 		}
 	}
 `
+const agreementQLModel = `
+	type
+	description
+	billingAgreement
+	managementURL
+	tokenNotificationURL
+	merchantTokenIdentifier
+`
+const connectionQLModel = `
+	id
+	type
+	isTest
+	credentials
+	provider {
+		name
+	}
+`
 
 func (c *Client) CreatePaymentToken(ctx context.Context, paymentTokenInput *buyte.CreatePaymentTokenInput) (*buyte.PaymentToken, error) {
 	u := user.FromContext(ctx)
@@ -65,6 +94,9 @@ func (c *Client) CreatePaymentToken(ctx context.Context, paymentTokenInput *buyt
 	if err := paymentTokenInput.Format(); err != nil {
 		return &buyte.PaymentToken{}, err
 	}
+	if err := c.sealPaymentTokenInput(ctx, paymentTokenInput); err != nil {
+		return &buyte.PaymentToken{}, err
+	}
 
 	req.Var("input", paymentTokenInput)
 	req.Header.Set("Authorization", auth)
@@ -78,6 +110,9 @@ func (c *Client) CreatePaymentToken(ctx context.Context, paymentTokenInput *buyt
 	if err := mapstructure.Decode(respData["createPaymentToken"], paymentToken); err != nil {
 		return &buyte.PaymentToken{}, err
 	}
+	if err := c.openPaymentToken(ctx, paymentToken); err != nil {
+		return &buyte.PaymentToken{}, err
+	}
 	paymentToken.Object = buyte.PAYMENT_TOKEN
 
 	// Clean output shipping method.
@@ -86,6 +121,7 @@ func (c *Client) CreatePaymentToken(ctx context.Context, paymentTokenInput *buyt
 		paymentToken.ShippingMethod = buyte.CopySelectedShippingMethodToShippingMethod(paymentToken.SelectedShippingMethod)
 	}
 	paymentToken.SelectedShippingMethod = nil
+	paymentToken.SetStatus(time.Now())
 
 	c.logger.Infow("Payment Token", "action", "create", "id", paymentTokenInput.ID)
 
@@ -117,6 +153,9 @@ func (c *Client) GetPaymentToken(ctx context.Context, paymentTokenId string) (*b
 	if err := mapstructure.Decode(respData["getPaymentToken"], paymentToken); err != nil {
 		return &buyte.PaymentToken{}, err
 	}
+	if err := c.openPaymentToken(ctx, paymentToken); err != nil {
+		return &buyte.PaymentToken{}, err
+	}
 
 	paymentToken.Object = buyte.PAYMENT_TOKEN
 
@@ -126,8 +165,82 @@ func (c *Client) GetPaymentToken(ctx context.Context, paymentTokenId string) (*b
 		paymentToken.ShippingMethod = buyte.CopySelectedShippingMethodToShippingMethod(paymentToken.SelectedShippingMethod)
 	}
 	paymentToken.SelectedShippingMethod = nil
+	paymentToken.SetStatus(time.Now())
 
 	c.logger.Infow("Payment Token", "action", "get", "id", paymentTokenId)
 
 	return paymentToken, nil
 }
+
+// ListExpiredPaymentTokens lists a page of the tokens that expired before the time given, and have yet to be purged.
+// Only super users may list the tokens of every user.
+func (c *Client) ListExpiredPaymentTokens(ctx context.Context, before time.Time, limit int, nextToken string) ([]*buyte.PaymentToken, string, error) {
+	u := user.FromContext(ctx)
+	auth := u.AccessToken
+
+	req := graphql.NewRequest(`
+		query ListExpiredPaymentTokens($before: String!, $limit: Int, $nextToken: String) {
+			listPaymentTokens(
+				filter: { expiresAt: { lt: $before }, purgedAt: { attributeExists: false } }
+				limit: $limit
+				nextToken: $nextToken
+			) {
+				items {
+					id
+					expiresAt
+				}
+				nextToken
+			}
+		}
+	`)
+	req.Var("before", before.UTC().Format(time.RFC3339))
+	req.Var("limit", limit)
+	if nextToken != "" {
+		req.Var("nextToken", nextToken)
+	}
+	req.Header.Set("Authorization", auth)
+
+	var respData map[string]interface{}
+	if err := c.Run(ctx, req, &respData); err != nil {
+		return nil, "", err
+	}
+	var page struct {
+		Items     []*buyte.PaymentToken
+		NextToken string
+	}
+	if err := mapstructure.Decode(respData["listPaymentTokens"], &page); err != nil {
+		return nil, "", err
+	}
+	return page.Items, page.NextToken, nil
+}
+
+// PurgePaymentToken removes the token's payment data, keeping the metadata charges made against it refer to.
+func (c *Client) PurgePaymentToken(ctx context.Context, paymentTokenId string, purgedAt time.Time) error {
+	u := user.FromContext(ctx)
+	auth := u.AccessToken
+
+	req := graphql.NewRequest(`
+		mutation PurgePaymentToken($input: UpdatePaymentTokenInput!) {
+			updatePaymentToken(input: $input) {
+				id
+			}
+		}
+	`)
+	req.Var("input", map[string]interface{}{
+		"id":                paymentTokenId,
+		"value":             "{}",
+		"rawPaymentRequest": nil,
+		"gatewayToken":      nil,
+		"purgedAt":          purgedAt.UTC().Format(time.RFC3339),
+	})
+	req.Header.Set("Authorization", auth)
+
+	var respData map[string]interface{}
+	if err := c.Run(ctx, req, &respData); err != nil {
+		return err
+	}
+
+	c.logger.Infow("Payment Token", "action", "purge", "id", paymentTokenId)
+
+	return nil
+}
@@ -8,9 +8,15 @@ import (
 func IsConnectionUnauthorized(err error) bool {
 	return strings.Contains(err.Error(), "graphql: Not Authorized")
 }
+func IsConditionalCheckFailed(err error) bool {
+	return strings.Contains(err.Error(), "The conditional request failed")
+}
 func IsConnectionInvalid(err error) bool {
 	return strings.Contains(err.Error(), "graphql: One or more parameter values were invalid")
 }
 
 // ErrNotFound is a standard no found error
 var ErrNotFound = errors.New("Not Found")
+
+// ErrAlreadyExists is returned when creating a record that must be unique
+var ErrAlreadyExists = errors.New("Already Exists")